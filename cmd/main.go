@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"log"
 
 	"gin-mongo-aws/internal/config"
@@ -8,11 +9,40 @@ import (
 )
 
 func main() {
+	rotateKeys := flag.Bool("rotate-keys", false, "Rotate the managed token signing key and exit")
+	immediate := flag.Bool("immediate", false, "With -rotate-keys, sign with the new key at once and retire the keys it replaces")
+	verifyAudit := flag.Bool("verify-audit", false, "Verify the audit log hash chain and exit")
+	auditCheckpoints := flag.String("audit-checkpoints", "", "File of signed audit checkpoints for -verify-audit to check, one per line")
+	exportCheckpoint := flag.Bool("export-audit-checkpoint", false, "Print a signed checkpoint of the audit log and exit")
+	flag.Parse()
+
 	cfg, err := config.LoadConfig()
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
 	s := server.NewServer(cfg)
+
+	if *rotateKeys {
+		if err := s.RotateSigningKeys(*immediate); err != nil {
+			log.Fatalf("Failed to rotate signing keys: %v", err)
+		}
+		return
+	}
+
+	if *verifyAudit {
+		if err := s.VerifyAudit(*auditCheckpoints); err != nil {
+			log.Fatalf("Audit verification failed: %v", err)
+		}
+		return
+	}
+
+	if *exportCheckpoint {
+		if err := s.ExportAuditCheckpoint(); err != nil {
+			log.Fatalf("Failed to export audit checkpoint: %v", err)
+		}
+		return
+	}
+
 	s.Run()
 }
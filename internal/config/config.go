@@ -1,19 +1,34 @@
 package config
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/spf13/viper"
 )
 
 type Config struct {
-	Server   ServerConfig
-	MongoDB  MongoDBConfig
-	Redis    RedisConfig
-	AWS      AWSConfig
+	Server            ServerConfig
+	MongoDB           MongoDBConfig
+	Redis             RedisConfig
+	AWS               AWSConfig
+	JWT               JWTConfig
+	Auth              AuthConfig
+	Mail              MailConfig
+	OAuth             OAuthConfig
+	SCIM              SCIMConfig
+	SAML              SAMLConfig
+	Audit             AuditConfig
+	Tenancy           TenancyConfig
+	IdentityProviders map[string]IdentityProviderConfig `mapstructure:"identity_providers"` // keyed by provider name
 }
 
 type ServerConfig struct {
-	Port string
-	Mode string // debug, release, test
+	Port           string
+	Mode           string   // debug, release, test
+	TrustedProxies []string `mapstructure:"trusted_proxies"` // IPs or CIDRs whose X-Forwarded-For is believed; empty trusts none
+	AllowedOrigins []string `mapstructure:"allowed_origins"` // browser origins allowed to call the API with credentials; empty allows none
 }
 
 type MongoDBConfig struct {
@@ -31,21 +46,298 @@ type AWSConfig struct {
 	Region string
 }
 
+type JWTConfig struct {
+	Algorithm        string        // HS256, RS256, ES256, EdDSA
+	Secret           string        // HMAC secret for HS256
+	PrivateKeyFile   string        `mapstructure:"private_key_file"` // PEM key for RS256/ES256/EdDSA; empty to use managed keys
+	AccessTokenTTL   time.Duration `mapstructure:"access_token_ttl"`
+	RefreshTokenTTL  time.Duration `mapstructure:"refresh_token_ttl"`
+	ImpersonationTTL time.Duration `mapstructure:"impersonation_token_ttl"` // lifetime of tokens issued to admins impersonating a user
+	Issuer           string
+	Audience         string
+	KeyEncryptionKey string        `mapstructure:"key_encryption_key"` // base64 AES-256 key protecting managed keys
+	RotationInterval time.Duration `mapstructure:"rotation_interval"`
+	ActivationDelay  time.Duration `mapstructure:"activation_delay"` // how long a new key is published before use
+	RetirementGrace  time.Duration `mapstructure:"retirement_grace"` // how long a replaced key still verifies tokens
+}
+
+type AuthConfig struct {
+	Mode                 string // jwt, session
+	Session              SessionConfig
+	LinkSecret           string        `mapstructure:"link_secret"` // HMAC secret for emailed links
+	RequireVerifiedEmail bool          `mapstructure:"require_verified_email"`
+	EmailVerificationURL string        `mapstructure:"email_verification_url"`
+	EmailVerificationTTL time.Duration `mapstructure:"email_verification_ttl"`
+	PasswordResetURL     string        `mapstructure:"password_reset_url"`
+	PasswordResetTTL     time.Duration `mapstructure:"password_reset_ttl"`
+	MagicLinkURL         string        `mapstructure:"magic_link_url"`
+	MagicLinkTTL         time.Duration `mapstructure:"magic_link_ttl"`
+	MagicLinkAutoCreate  bool          `mapstructure:"magic_link_auto_create"` // sign up unknown emails
+	MFAIssuer            string        `mapstructure:"mfa_issuer"`
+	MFAChallengeTTL      time.Duration `mapstructure:"mfa_challenge_ttl"`
+	Lockout              LockoutConfig
+	Authenticators       []string // checked in order at login: password, ldap
+	LDAP                 LDAPConfig
+	WebAuthn             WebAuthnConfig
+}
+
+// WebAuthnConfig identifies this service as a WebAuthn relying party. Passkeys
+// are bound to RPID, which must be the site's domain or a parent of it.
+type WebAuthnConfig struct {
+	RPID          string        `mapstructure:"rp_id"`
+	RPDisplayName string        `mapstructure:"rp_display_name"`
+	RPOrigins     []string      `mapstructure:"rp_origins"` // origins the browser may run the ceremonies on
+	ChallengeTTL  time.Duration `mapstructure:"challenge_ttl"`
+}
+
+type LockoutConfig struct {
+	MaxAttempts   int           `mapstructure:"max_attempts"`    // per account
+	IPMaxAttempts int           `mapstructure:"ip_max_attempts"` // per client IP
+	Window        time.Duration // failures older than this are forgotten
+	Duration      time.Duration // how long a lock lasts
+	BaseDelay     time.Duration `mapstructure:"base_delay"`
+	MaxDelay      time.Duration `mapstructure:"max_delay"`
+}
+
+// LDAPConfig configures login against an LDAP directory or Active Directory.
+// The service account finds the user with UserFilter, where {login} is
+// replaced by the escaped login, and the user's own bind checks the password.
+type LDAPConfig struct {
+	URL                string           // ldap://host:389 or ldaps://host:636
+	StartTLS           bool             `mapstructure:"start_tls"`
+	InsecureSkipVerify bool             `mapstructure:"insecure_skip_verify"`
+	BindDN             string           `mapstructure:"bind_dn"`
+	BindPassword       string           `mapstructure:"bind_password"`
+	BaseDN             string           `mapstructure:"base_dn"`
+	UserFilter         string           `mapstructure:"user_filter"`
+	EmailAttribute     string           `mapstructure:"email_attribute"`
+	NameAttribute      string           `mapstructure:"name_attribute"`
+	GroupAttribute     string           `mapstructure:"group_attribute"`
+	GroupRoles         []LDAPGroupRoles `mapstructure:"group_roles"`
+	DefaultRoles       []string         `mapstructure:"default_roles"` // granted to every directory user
+	Organization       string           // slug of the organization directory users belong to; the default organization when empty
+	Timeout            time.Duration
+}
+
+// LDAPGroupRoles grants roles to the members of a directory group
+type LDAPGroupRoles struct {
+	Group string // group DN
+	Roles []string
+}
+
+type OAuthConfig struct {
+	CodeTTL    time.Duration `mapstructure:"code_ttl"`
+	ConsentTTL time.Duration `mapstructure:"consent_ttl"`
+}
+
+// SCIMConfig enables the SCIM 2.0 provisioning API when a token is set. Each
+// token provisions the one organization it is configured for.
+type SCIMConfig struct {
+	Token      string            // bearer token of the default organization's provisioning client
+	Tokens     map[string]string // bearer tokens keyed by organization slug
+	MaxResults int               `mapstructure:"max_results"`
+}
+
+// OrganizationTokens returns the bearer token of every organization with SCIM
+// enabled, keyed by slug. Token belongs to the default organization. A token
+// shared by two organizations could not tell them apart, so it is refused.
+func (c SCIMConfig) OrganizationTokens(defaultOrganization string) (map[string]string, error) {
+	tokens := map[string]string{}
+	for slug, token := range c.Tokens {
+		if token != "" {
+			tokens[strings.ToLower(slug)] = token
+		}
+	}
+	if c.Token != "" {
+		if existing, ok := tokens[defaultOrganization]; ok && existing != c.Token {
+			return nil, fmt.Errorf("scim.token and scim.tokens.%s are both set", defaultOrganization)
+		}
+		tokens[defaultOrganization] = c.Token
+	}
+
+	seen := map[string]string{}
+	for slug, token := range tokens {
+		if other, ok := seen[token]; ok {
+			return nil, fmt.Errorf("organizations %s and %s share a SCIM token", other, slug)
+		}
+		seen[token] = slug
+	}
+	return tokens, nil
+}
+
+// AuditConfig holds the key audit log checkpoints are signed with
+type AuditConfig struct {
+	CheckpointKeyFile string `mapstructure:"checkpoint_key_file"` // PEM Ed25519 private key; empty disables checkpoints
+}
+
+// TenancyConfig controls how a request is matched to an organization. The
+// header takes precedence over the subdomain; requests naming neither act in
+// the default organization, which also owns deployment-wide settings.
+type TenancyConfig struct {
+	DefaultOrganization string `mapstructure:"default_organization"` // slug
+	Header              string // carries an organization slug
+	BaseDomain          string `mapstructure:"base_domain"` // acme.<base_domain> acts in "acme"; empty disables subdomains
+}
+
+// IdentityProviderConfig describes an external provider users can sign in
+// with. OpenID Connect providers only need an issuer; plain OAuth2 providers
+// such as GitHub set the endpoints and the userinfo field holding the user ID.
+type IdentityProviderConfig struct {
+	DisplayName  string `mapstructure:"display_name"`
+	Issuer       string // OIDC discovery URL; empty for plain OAuth2
+	ClientID     string `mapstructure:"client_id"`
+	ClientSecret string `mapstructure:"client_secret"`
+	RedirectURL  string `mapstructure:"redirect_url"`
+	Scopes       []string
+	AuthURL      string `mapstructure:"auth_url"`      // plain OAuth2 only
+	TokenURL     string `mapstructure:"token_url"`     // plain OAuth2 only
+	UserInfoURL  string `mapstructure:"userinfo_url"`  // plain OAuth2 only
+	EmailsURL    string `mapstructure:"emails_url"`    // GitHub-style list of emails with their verified flag
+	SubjectClaim string `mapstructure:"subject_claim"` // userinfo field with the account ID, default "sub"
+	AllowSignup  bool   `mapstructure:"allow_signup"`  // create accounts for new verified emails
+}
+
+// SAMLConfig holds the key pair this service provider signs authentication
+// requests and decrypts assertions with, and the identity providers SAML
+// logins are accepted from
+type SAMLConfig struct {
+	CertificateFile string                        `mapstructure:"certificate_file"` // PEM certificate published in the SP metadata
+	KeyFile         string                        `mapstructure:"key_file"`         // PEM RSA private key
+	Providers       map[string]SAMLProviderConfig // keyed by provider name
+}
+
+// SAMLProviderConfig describes a SAML identity provider. Attribute names are
+// matched against each attribute's Name and FriendlyName; when unset, common
+// names used by Okta, Entra ID and ADFS are tried.
+type SAMLProviderConfig struct {
+	DisplayName         string `mapstructure:"display_name"`
+	MetadataURL         string `mapstructure:"metadata_url"`  // IdP metadata, fetched on first use
+	MetadataFile        string `mapstructure:"metadata_file"` // or a local copy of it
+	EmailAttribute      string `mapstructure:"email_attribute"`
+	NameAttribute       string `mapstructure:"name_attribute"`
+	GivenNameAttribute  string `mapstructure:"given_name_attribute"`
+	FamilyNameAttribute string `mapstructure:"family_name_attribute"`
+	AllowSignup         bool   `mapstructure:"allow_signup"` // provision accounts on first login
+	// AllowedDomains are the email domains the IdP may sign in. Accounts are
+	// matched by email, so an IdP must not vouch for domains it does not own.
+	AllowedDomains []string `mapstructure:"allowed_domains"`
+}
+
+type SessionConfig struct {
+	CookieName  string `mapstructure:"cookie_name"`
+	Domain      string
+	Path        string
+	Secure      bool
+	SameSite    string        `mapstructure:"same_site"` // lax, strict, none
+	IdleTTL     time.Duration `mapstructure:"idle_ttl"`
+	AbsoluteTTL time.Duration `mapstructure:"absolute_ttl"`
+}
+
+type MailConfig struct {
+	Driver    string // smtp, log
+	From      string
+	OutputDir string `mapstructure:"output_dir"` // log driver only
+	SMTP      SMTPConfig
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+}
+
 func LoadConfig() (*Config, error) {
 	viper.SetConfigName("config")
 	viper.SetConfigType("yaml")
 	viper.AddConfigPath("./configs")
 	viper.AddConfigPath(".")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	viper.AutomaticEnv()
 
 	// Set defaults
 	viper.SetDefault("server.port", "3080")
 	viper.SetDefault("server.mode", "debug")
+	viper.SetDefault("server.trusted_proxies", []string{})
+	viper.SetDefault("server.allowed_origins", []string{})
 	viper.SetDefault("mongodb.uri", "mongodb://localhost:27017")
 	viper.SetDefault("mongodb.database", "app_db")
 	viper.SetDefault("redis.addr", "localhost:6379")
 	viper.SetDefault("redis.db", 0)
 	viper.SetDefault("aws.region", "us-east-1")
+	viper.SetDefault("jwt.algorithm", "HS256")
+	viper.SetDefault("jwt.secret", "")
+	viper.SetDefault("jwt.private_key_file", "")
+	viper.SetDefault("jwt.access_token_ttl", "15m")
+	viper.SetDefault("jwt.refresh_token_ttl", "720h")
+	viper.SetDefault("jwt.impersonation_token_ttl", "10m")
+	viper.SetDefault("jwt.issuer", "http://localhost:3080")
+	viper.SetDefault("jwt.audience", "gin-mongo-aws")
+	viper.SetDefault("jwt.key_encryption_key", "")
+	viper.SetDefault("jwt.rotation_interval", "720h")
+	viper.SetDefault("jwt.activation_delay", "1h")
+	viper.SetDefault("jwt.retirement_grace", "24h")
+	viper.SetDefault("auth.mode", "jwt")
+	viper.SetDefault("auth.session.cookie_name", "session_id")
+	viper.SetDefault("auth.session.domain", "")
+	viper.SetDefault("auth.session.path", "/")
+	viper.SetDefault("auth.session.secure", true)
+	viper.SetDefault("auth.session.same_site", "lax")
+	viper.SetDefault("auth.session.idle_ttl", "30m")
+	viper.SetDefault("auth.session.absolute_ttl", "24h")
+	viper.SetDefault("auth.link_secret", "")
+	viper.SetDefault("auth.require_verified_email", false)
+	viper.SetDefault("auth.email_verification_url", "http://localhost:3080/verify-email")
+	viper.SetDefault("auth.email_verification_ttl", "24h")
+	viper.SetDefault("auth.password_reset_url", "http://localhost:3080/reset-password")
+	viper.SetDefault("auth.password_reset_ttl", "1h")
+	viper.SetDefault("auth.magic_link_url", "http://localhost:3080/api/v1/auth/magic-link/callback")
+	viper.SetDefault("auth.magic_link_ttl", "15m")
+	viper.SetDefault("auth.magic_link_auto_create", false)
+	viper.SetDefault("auth.mfa_issuer", "gin-mongo-aws")
+	viper.SetDefault("auth.mfa_challenge_ttl", "5m")
+	viper.SetDefault("auth.lockout.max_attempts", 5)
+	viper.SetDefault("auth.lockout.ip_max_attempts", 50)
+	viper.SetDefault("auth.lockout.window", "15m")
+	viper.SetDefault("auth.lockout.duration", "15m")
+	viper.SetDefault("auth.lockout.base_delay", "1s")
+	viper.SetDefault("auth.lockout.max_delay", "30s")
+	viper.SetDefault("auth.authenticators", []string{"password"})
+	viper.SetDefault("auth.ldap.url", "")
+	viper.SetDefault("auth.ldap.start_tls", false)
+	viper.SetDefault("auth.ldap.insecure_skip_verify", false)
+	viper.SetDefault("auth.ldap.bind_dn", "")
+	viper.SetDefault("auth.ldap.bind_password", "")
+	viper.SetDefault("auth.ldap.base_dn", "")
+	viper.SetDefault("auth.ldap.user_filter", "(&(objectClass=person)(|(uid={login})(mail={login})))")
+	viper.SetDefault("auth.ldap.email_attribute", "mail")
+	viper.SetDefault("auth.ldap.name_attribute", "cn")
+	viper.SetDefault("auth.ldap.group_attribute", "memberOf")
+	viper.SetDefault("auth.ldap.default_roles", []string{"user"})
+	viper.SetDefault("auth.ldap.organization", "")
+	viper.SetDefault("auth.ldap.timeout", "10s")
+	viper.SetDefault("auth.webauthn.rp_id", "localhost")
+	viper.SetDefault("auth.webauthn.rp_display_name", "gin-mongo-aws")
+	viper.SetDefault("auth.webauthn.rp_origins", []string{"http://localhost:3080"})
+	viper.SetDefault("auth.webauthn.challenge_ttl", "5m")
+	viper.SetDefault("oauth.code_ttl", "1m")
+	viper.SetDefault("oauth.consent_ttl", "10m")
+	viper.SetDefault("scim.token", "")
+	viper.SetDefault("scim.tokens", map[string]string{})
+	viper.SetDefault("scim.max_results", 100)
+	viper.SetDefault("saml.certificate_file", "")
+	viper.SetDefault("saml.key_file", "")
+	viper.SetDefault("audit.checkpoint_key_file", "")
+	viper.SetDefault("tenancy.default_organization", "default")
+	viper.SetDefault("tenancy.header", "X-Organization")
+	viper.SetDefault("tenancy.base_domain", "")
+	viper.SetDefault("mail.driver", "log")
+	viper.SetDefault("mail.from", "no-reply@localhost")
+	viper.SetDefault("mail.output_dir", "")
+	viper.SetDefault("mail.smtp.host", "localhost")
+	viper.SetDefault("mail.smtp.port", 587)
+	viper.SetDefault("mail.smtp.username", "")
+	viper.SetDefault("mail.smtp.password", "")
 
 	if err := viper.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); !ok {
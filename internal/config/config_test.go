@@ -0,0 +1,20 @@
+package config
+
+import "testing"
+
+func TestSCIMOrganizationTokens(t *testing.T) {
+	tokens, err := SCIMConfig{Token: "default-token", Tokens: map[string]string{"Acme": "acme-token", "globex": ""}}.OrganizationTokens("default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 || tokens["default"] != "default-token" || tokens["acme"] != "acme-token" {
+		t.Errorf("tokens = %v, want the default organization's and acme's", tokens)
+	}
+
+	if _, err := (SCIMConfig{Tokens: map[string]string{"acme": "shared", "globex": "shared"}}).OrganizationTokens("default"); err == nil {
+		t.Error("a token shared by two organizations was accepted")
+	}
+	if _, err := (SCIMConfig{Token: "one", Tokens: map[string]string{"default": "two"}}).OrganizationTokens("default"); err == nil {
+		t.Error("two tokens for the default organization were accepted")
+	}
+}
@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"gin-mongo-aws/internal/middleware"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// AdminHandler serves operator endpoints under /api/v1/admin
+type AdminHandler struct {
+	auth          service.AuthService
+	keys          service.SigningKeyService
+	impersonation service.ImpersonationService
+	audit         service.AuditService
+}
+
+func NewAdminHandler(auth service.AuthService, keys service.SigningKeyService, impersonation service.ImpersonationService, audit service.AuditService) *AdminHandler {
+	return &AdminHandler{auth: auth, keys: keys, impersonation: impersonation, audit: audit}
+}
+
+func (h *AdminHandler) UnlockUser(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.auth.UnlockUser(c.Request.Context(), id); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User unlocked successfully"})
+}
+
+// LinkDirectory lets the directory account with the user's email sign in to
+// the existing local account
+func (h *AdminHandler) LinkDirectory(c *gin.Context) {
+	if err := h.auth.LinkDirectory(c.Request.Context(), c.Param("id")); err != nil {
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		case errors.Is(err, service.ErrDirectoryNotConfigured):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "User linked to the directory"})
+}
+
+// ImpersonateUser returns a short-lived access token for acting as the user.
+// The token cannot be refreshed and is refused by destructive routes.
+func (h *AdminHandler) ImpersonateUser(c *gin.Context) {
+	token, err := h.impersonation.Start(c.Request.Context(), middleware.CurrentUserID(c), c.Param("id"), clientInfo(c))
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+			return
+		}
+		if errors.Is(err, service.ErrCannotImpersonate) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, token)
+}
+
+// ListAuditEvents pages through the audit log, newest first
+func (h *AdminHandler) ListAuditEvents(c *gin.Context) {
+	var query models.AuditQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := h.audit.List(c.Request.Context(), &query)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// AuditCheckpoint signs the head of the audit chain. Store the token away
+// from this deployment; -verify-audit checks the chain still matches it.
+func (h *AdminHandler) AuditCheckpoint(c *gin.Context) {
+	checkpoint, err := h.audit.Checkpoint(c.Request.Context())
+	if err != nil {
+		if errors.Is(err, service.ErrAuditCheckpointsDisabled) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrAuditLogEmpty) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, checkpoint)
+}
+
+func (h *AdminHandler) ListSigningKeys(c *gin.Context) {
+	keys, err := h.keys.List(c.Request.Context())
+	if err != nil {
+		h.respondWithKeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+// RotateSigningKeys publishes a new signing key that replaces the current one
+// after the activation delay. Tokens signed with the previous key stay valid
+// until it retires. ?immediate=true replaces and retires it right away.
+func (h *AdminHandler) RotateSigningKeys(c *gin.Context) {
+	immediate := c.Query("immediate") == "true"
+	key, err := h.keys.Rotate(c.Request.Context(), immediate)
+	if err != nil {
+		h.respondWithKeyError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, key)
+}
+
+func (h *AdminHandler) respondWithKeyError(c *gin.Context, err error) {
+	if errors.Is(err, service.ErrKeysNotManaged) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
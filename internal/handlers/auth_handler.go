@@ -0,0 +1,644 @@
+package handlers
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/middleware"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/service"
+	"gin-mongo-aws/internal/tenant"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// externalStateCookie binds an external login to the browser that started it
+	externalStateCookie = "external_login_state"
+	externalStateMaxAge = 10 * time.Minute
+	// samlStateCookie binds a SAML login to the browser that started it
+	samlStateCookie = "saml_login_state"
+)
+
+type AuthHandler struct {
+	service       service.AuthService
+	tokens        service.TokenService
+	refresh       service.RefreshTokenService
+	sessions      service.SessionService
+	mfa           service.MFAService
+	external      service.ExternalLoginService
+	saml          service.SAMLService
+	passkeys      service.PasskeyService
+	impersonation service.ImpersonationService
+	cfg           config.AuthConfig
+}
+
+func NewAuthHandler(service service.AuthService, tokens service.TokenService, refresh service.RefreshTokenService, sessions service.SessionService, mfa service.MFAService, external service.ExternalLoginService, saml service.SAMLService, passkeys service.PasskeyService, impersonation service.ImpersonationService, cfg config.AuthConfig) *AuthHandler {
+	return &AuthHandler{service: service, tokens: tokens, refresh: refresh, sessions: sessions, mfa: mfa, external: external, saml: saml, passkeys: passkeys, impersonation: impersonation, cfg: cfg}
+}
+
+func (h *AuthHandler) Register(c *gin.Context) {
+	var req models.RegisterRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.service.Register(c.Request.Context(), &req)
+	if err != nil {
+		if errors.Is(err, service.ErrEmailTaken) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, user)
+}
+
+func (h *AuthHandler) Login(c *gin.Context) {
+	var req models.LoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.service.Login(c.Request.Context(), &req, c.ClientIP())
+	if err != nil {
+		var lockErr *service.LockedOutError
+		if errors.As(err, &lockErr) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(lockErr.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrInvalidCredentials) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrEmailNotVerified) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrDirectoryLinkRequired) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrDirectoryUnavailable) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.completeLogin(c, user)
+}
+
+func (h *AuthHandler) VerifyMFA(c *gin.Context) {
+	var req models.MFAVerifyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.mfa.VerifyChallenge(c.Request.Context(), &req, c.ClientIP())
+	if err != nil {
+		var lockErr *service.LockedOutError
+		if errors.As(err, &lockErr) {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(lockErr.RetryAfter.Seconds()))))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrInvalidMFAChallenge) || errors.Is(err, service.ErrInvalidMFACode) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": service.ErrAccountDisabled.Error()})
+		return
+	}
+
+	h.issueTokens(c, user)
+}
+
+func (h *AuthHandler) Refresh(c *gin.Context) {
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	family, refreshToken, err := h.refresh.Rotate(c.Request.Context(), req.RefreshToken, "", clientInfo(c))
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidRefreshToken) || errors.Is(err, service.ErrRefreshTokenReused) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The family belongs to the organization it was issued in, whichever
+	// organization the request named
+	ctx, err := tenant.WithOrgHex(c.Request.Context(), family.OrgID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": service.ErrInvalidRefreshToken.Error()})
+		return
+	}
+	c.Request = c.Request.WithContext(ctx)
+
+	user, err := h.service.GetUser(ctx, family.UserID)
+	if err != nil || user.Disabled {
+		h.refresh.RevokeFamily(ctx, family.ID)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": service.ErrInvalidRefreshToken.Error()})
+		return
+	}
+
+	h.respondWithTokens(c, user, family.ID, refreshToken)
+}
+
+func (h *AuthHandler) Logout(c *gin.Context) {
+	// A bearer access token presented with the logout stops working now
+	// rather than when it expires
+	if bearer, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); ok {
+		if claims, err := h.tokens.ParseAccessToken(c.Request.Context(), strings.TrimSpace(bearer)); err == nil && !claims.IsOAuth() {
+			// Impersonation tokens have no session; logging out ends the impersonation
+			if claims.IsImpersonation() {
+				h.stopImpersonation(c, claims)
+				return
+			}
+			if err := h.tokens.RevokeAccessToken(c.Request.Context(), claims); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+	}
+
+	if cookie, err := c.Cookie(h.cfg.Session.CookieName); err == nil && cookie != "" {
+		if err := h.sessions.Revoke(c.Request.Context(), cookie); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		h.setSessionCookie(c, "", -1)
+		c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+		return
+	}
+
+	var req models.RefreshRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.refresh.Revoke(c.Request.Context(), req.RefreshToken); err != nil && !errors.Is(err, service.ErrInvalidRefreshToken) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Logged out successfully"})
+}
+
+// StopImpersonation revokes the impersonation token the request was made with
+func (h *AuthHandler) StopImpersonation(c *gin.Context) {
+	h.stopImpersonation(c, middleware.CurrentClaims(c))
+}
+
+func (h *AuthHandler) stopImpersonation(c *gin.Context, claims *service.Claims) {
+	if err := h.impersonation.Stop(c.Request.Context(), claims, clientInfo(c)); err != nil {
+		if errors.Is(err, service.ErrNotImpersonating) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Impersonation stopped"})
+}
+
+func (h *AuthHandler) VerifyEmail(c *gin.Context) {
+	var req models.VerifyEmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.VerifyEmail(c.Request.Context(), req.Token); err != nil {
+		if errors.Is(err, service.ErrInvalidLink) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Email verified successfully"})
+}
+
+func (h *AuthHandler) ResendVerificationEmail(c *gin.Context) {
+	var req models.EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Respond the same way whether or not the account exists
+	if err := h.service.ResendVerificationEmail(c.Request.Context(), req.Email); err != nil {
+		c.Error(err)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists and is unverified, a verification email has been sent"})
+}
+
+func (h *AuthHandler) ForgotPassword(c *gin.Context) {
+	var req models.EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Respond the same way whether or not the account exists
+	if err := h.service.ForgotPassword(c.Request.Context(), req.Email); err != nil {
+		c.Error(err)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the account exists, a password reset email has been sent"})
+}
+
+func (h *AuthHandler) ResetPassword(c *gin.Context) {
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.service.ResetPassword(c.Request.Context(), &req); err != nil {
+		if errors.Is(err, service.ErrInvalidResetToken) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Password reset successfully"})
+}
+
+func (h *AuthHandler) SendMagicLink(c *gin.Context) {
+	var req models.EmailRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Respond the same way whether or not the account exists
+	if err := h.service.SendMagicLink(c.Request.Context(), req.Email); err != nil {
+		c.Error(err)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "If the email can sign in, a sign-in link has been sent"})
+}
+
+// MagicLinkCallback signs the user in with the token from a magic link
+func (h *AuthHandler) MagicLinkCallback(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+		return
+	}
+
+	user, err := h.service.MagicLinkLogin(c.Request.Context(), token)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidLink) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.completeLogin(c, user)
+}
+
+// BeginPasskeyLogin returns the options to pass to navigator.credentials.get()
+func (h *AuthHandler) BeginPasskeyLogin(c *gin.Context) {
+	ceremony, err := h.passkeys.BeginLogin(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, ceremony)
+}
+
+func (h *AuthHandler) FinishPasskeyLogin(c *gin.Context) {
+	var req models.FinishPasskeyLoginRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	user, err := h.passkeys.FinishLogin(c.Request.Context(), &req)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidPasskeyCeremony) || errors.Is(err, service.ErrInvalidPasskey) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrPasskeysNotAllowed) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": service.ErrAccountDisabled.Error()})
+		return
+	}
+
+	// The passkey is possession plus user verification, so no TOTP is asked for
+	h.issueTokens(c, user)
+}
+
+func (h *AuthHandler) ListProviders(c *gin.Context) {
+	c.JSON(http.StatusOK, append(h.external.Providers(), h.saml.Providers()...))
+}
+
+// ExternalLogin redirects the browser to an external identity provider
+func (h *AuthHandler) ExternalLogin(c *gin.Context) {
+	authURL, ok := h.startExternalLogin(c, "")
+	if !ok {
+		return
+	}
+
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// LinkProvider starts linking an external account to the signed-in user.
+// The browser should be sent to the returned URL.
+func (h *AuthHandler) LinkProvider(c *gin.Context) {
+	authURL, ok := h.startExternalLogin(c, middleware.CurrentUserID(c))
+	if !ok {
+		return
+	}
+
+	c.JSON(http.StatusOK, models.ExternalAuthURL{AuthorizationURL: authURL})
+}
+
+// ExternalCallback completes an external login, or the linking of an
+// external account, when the provider sends the browser back
+func (h *AuthHandler) ExternalCallback(c *gin.Context) {
+	if providerErr := c.Query("error"); providerErr != "" {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": service.ErrExternalLoginFailed.Error(), "provider_error": providerErr})
+		return
+	}
+
+	state := c.Query("state")
+	cookie, err := c.Cookie(externalStateCookie)
+	if state == "" || err != nil || cookie != state {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": service.ErrInvalidProviderState.Error()})
+		return
+	}
+	h.setExternalStateCookie(c, "", -1)
+
+	user, linked, err := h.external.Complete(c.Request.Context(), c.Param("provider"), state, c.Query("code"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUnknownProvider):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInvalidProviderState), errors.Is(err, service.ErrExternalLoginFailed):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrProviderEmailUnverified), errors.Is(err, service.ErrExternalSignupDisabled):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrAccountLinkRequired), errors.Is(err, service.ErrIdentityAlreadyLinked),
+			errors.Is(err, service.ErrProviderAlreadyLinked):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	if linked {
+		c.JSON(http.StatusOK, gin.H{"message": "Identity linked successfully", "user": user})
+		return
+	}
+	h.completeLogin(c, user)
+}
+
+func (h *AuthHandler) startExternalLogin(c *gin.Context, linkUserID string) (string, bool) {
+	authURL, state, err := h.external.Start(c.Request.Context(), c.Param("provider"), linkUserID)
+	if err != nil {
+		if errors.Is(err, service.ErrUnknownProvider) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return "", false
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return "", false
+	}
+
+	h.setExternalStateCookie(c, state, int(externalStateMaxAge.Seconds()))
+	return authURL, true
+}
+
+// setExternalStateCookie always uses SameSite=Lax, since the provider sends
+// the browser back with a cross-site redirect
+func (h *AuthHandler) setExternalStateCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     externalStateCookie,
+		Value:    value,
+		Path:     "/",
+		Domain:   h.cfg.Session.Domain,
+		MaxAge:   maxAge,
+		Secure:   h.cfg.Session.Secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// SAMLMetadata serves the service provider metadata to register with the IdP
+func (h *AuthHandler) SAMLMetadata(c *gin.Context) {
+	metadata, err := h.saml.Metadata(c.Request.Context(), c.Param("provider"))
+	if err != nil {
+		if errors.Is(err, service.ErrUnknownProvider) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Data(http.StatusOK, "application/samlmetadata+xml", metadata)
+}
+
+// SAMLLogin redirects the browser to the IdP with an authentication request
+func (h *AuthHandler) SAMLLogin(c *gin.Context) {
+	redirectURL, relayState, err := h.saml.Start(c.Request.Context(), c.Param("provider"))
+	if err != nil {
+		if errors.Is(err, service.ErrUnknownProvider) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.setSAMLStateCookie(c, relayState, int(externalStateMaxAge.Seconds()))
+	c.Redirect(http.StatusFound, redirectURL)
+}
+
+// SAMLAssertionConsumer completes a SAML login when the IdP posts the
+// signed response back through the browser
+func (h *AuthHandler) SAMLAssertionConsumer(c *gin.Context) {
+	relayState := c.PostForm("RelayState")
+	cookie, err := c.Cookie(samlStateCookie)
+	if relayState == "" || err != nil || cookie != relayState {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": service.ErrInvalidSAMLState.Error()})
+		return
+	}
+	h.setSAMLStateCookie(c, "", -1)
+
+	user, err := h.saml.Complete(c.Request.Context(), c.Param("provider"), relayState, c.PostForm("SAMLResponse"))
+	if err != nil {
+		switch {
+		case errors.Is(err, service.ErrUnknownProvider):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrInvalidSAMLState), errors.Is(err, service.ErrSAMLLoginFailed):
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrSAMLEmailMissing), errors.Is(err, service.ErrSAMLEmailDomain),
+			errors.Is(err, service.ErrExternalSignupDisabled):
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		case errors.Is(err, service.ErrAccountLinkRequired), errors.Is(err, service.ErrIdentityAlreadyLinked),
+			errors.Is(err, service.ErrProviderAlreadyLinked):
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	h.completeLogin(c, user)
+}
+
+// setSAMLStateCookie uses SameSite=None, since the IdP posts the response
+// cross-site and a Lax cookie would not be sent. Browsers only accept such
+// cookies when they are Secure; localhost counts as secure.
+func (h *AuthHandler) setSAMLStateCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     samlStateCookie,
+		Value:    value,
+		Path:     "/",
+		Domain:   h.cfg.Session.Domain,
+		MaxAge:   maxAge,
+		Secure:   true,
+		HttpOnly: true,
+		SameSite: http.SameSiteNoneMode,
+	})
+}
+
+// completeLogin finishes a successful first-factor login, asking for a
+// second factor when the account has MFA enabled
+func (h *AuthHandler) completeLogin(c *gin.Context, user *models.User) {
+	if user.Disabled {
+		c.JSON(http.StatusForbidden, gin.H{"error": service.ErrAccountDisabled.Error()})
+		return
+	}
+
+	if user.MFAEnabled {
+		mfaToken, err := h.mfa.StartChallenge(c.Request.Context(), user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, models.MFAChallengeResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+			ExpiresIn:   int64(h.cfg.MFAChallengeTTL.Seconds()),
+		})
+		return
+	}
+
+	h.issueTokens(c, user)
+}
+
+// issueTokens signs the user in, either with a session cookie or with an
+// access and refresh token pair depending on auth.mode
+func (h *AuthHandler) issueTokens(c *gin.Context, user *models.User) {
+	if h.cfg.Mode == "session" {
+		h.startSession(c, user)
+		return
+	}
+
+	family, refreshToken, err := h.refresh.Issue(c.Request.Context(), user, clientInfo(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.respondWithTokens(c, user, family.ID, refreshToken)
+}
+
+func (h *AuthHandler) startSession(c *gin.Context, user *models.User) {
+	token, _, err := h.sessions.Create(c.Request.Context(), user, clientInfo(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.setSessionCookie(c, token, int(h.cfg.Session.AbsoluteTTL.Seconds()))
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+func (h *AuthHandler) setSessionCookie(c *gin.Context, value string, maxAge int) {
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     h.cfg.Session.CookieName,
+		Value:    value,
+		Path:     h.cfg.Session.Path,
+		Domain:   h.cfg.Session.Domain,
+		MaxAge:   maxAge,
+		Secure:   h.cfg.Session.Secure,
+		HttpOnly: true,
+		SameSite: sameSiteMode(h.cfg.Session.SameSite),
+	})
+}
+
+func clientInfo(c *gin.Context) service.ClientInfo {
+	return service.ClientInfo{IP: c.ClientIP(), UserAgent: c.Request.UserAgent()}
+}
+
+func sameSiteMode(mode string) http.SameSite {
+	switch strings.ToLower(mode) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	default:
+		return http.SameSiteLaxMode
+	}
+}
+
+func (h *AuthHandler) respondWithTokens(c *gin.Context, user *models.User, sessionID, refreshToken string) {
+	accessToken, expiresAt, err := h.tokens.IssueAccessToken(user, sessionID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.TokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(time.Until(expiresAt).Seconds()),
+		RefreshToken: refreshToken,
+		User:         user,
+	})
+}
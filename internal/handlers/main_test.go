@@ -0,0 +1,15 @@
+package handlers
+
+import (
+	"os"
+	"testing"
+
+	"gin-mongo-aws/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	logger.Log = zap.NewNop()
+	os.Exit(m.Run())
+}
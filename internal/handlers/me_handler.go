@@ -0,0 +1,215 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"gin-mongo-aws/internal/middleware"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// MeHandler serves self-service endpoints for the authenticated user
+type MeHandler struct {
+	sessions service.ActiveSessionService
+	apiKeys  service.APIKeyService
+	external service.ExternalLoginService
+	passkeys service.PasskeyService
+}
+
+func NewMeHandler(sessions service.ActiveSessionService, apiKeys service.APIKeyService, external service.ExternalLoginService, passkeys service.PasskeyService) *MeHandler {
+	return &MeHandler{sessions: sessions, apiKeys: apiKeys, external: external, passkeys: passkeys}
+}
+
+func (h *MeHandler) ListSessions(c *gin.Context) {
+	sessions, err := h.sessions.List(c.Request.Context(), middleware.CurrentUserID(c), currentSessionID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, sessions)
+}
+
+func (h *MeHandler) RevokeSession(c *gin.Context) {
+	id := c.Param("id")
+	if err := h.sessions.Revoke(c.Request.Context(), middleware.CurrentUserID(c), id); err != nil {
+		if errors.Is(err, service.ErrSessionNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Session revoked successfully"})
+}
+
+// RevokeOtherSessions signs out every session except the one making the request
+func (h *MeHandler) RevokeOtherSessions(c *gin.Context) {
+	revoked, err := h.sessions.RevokeOthers(c.Request.Context(), middleware.CurrentUserID(c), currentSessionID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Other sessions revoked successfully", "revoked": revoked})
+}
+
+// CreateAPIKey returns the full key once; only its prefix is shown afterwards
+func (h *MeHandler) CreateAPIKey(c *gin.Context) {
+	var req models.CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	created, err := h.apiKeys.Create(c.Request.Context(), middleware.CurrentUserID(c), &req)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidAPIKeyRequest) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, created)
+}
+
+func (h *MeHandler) ListAPIKeys(c *gin.Context) {
+	keys, err := h.apiKeys.List(c.Request.Context(), middleware.CurrentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, keys)
+}
+
+func (h *MeHandler) RevokeAPIKey(c *gin.Context) {
+	if err := h.apiKeys.Revoke(c.Request.Context(), middleware.CurrentUserID(c), c.Param("id")); err != nil {
+		if errors.Is(err, service.ErrAPIKeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "API key revoked successfully"})
+}
+
+func (h *MeHandler) ListIdentities(c *gin.Context) {
+	identities, err := h.external.ListIdentities(c.Request.Context(), middleware.CurrentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, identities)
+}
+
+func (h *MeHandler) UnlinkIdentity(c *gin.Context) {
+	if err := h.external.Unlink(c.Request.Context(), middleware.CurrentUserID(c), c.Param("provider")); err != nil {
+		if errors.Is(err, service.ErrIdentityNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Identity unlinked successfully"})
+}
+
+// BeginPasskeyRegistration returns the options to pass to
+// navigator.credentials.create()
+func (h *MeHandler) BeginPasskeyRegistration(c *gin.Context) {
+	ceremony, err := h.passkeys.BeginRegistration(c.Request.Context(), middleware.CurrentUserID(c))
+	if err != nil {
+		if errors.Is(err, service.ErrPasskeysNotAllowed) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, ceremony)
+}
+
+func (h *MeHandler) FinishPasskeyRegistration(c *gin.Context) {
+	var req models.FinishPasskeyRegistrationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	passkey, err := h.passkeys.FinishRegistration(c.Request.Context(), middleware.CurrentUserID(c), &req)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidPasskeyCeremony) || errors.Is(err, service.ErrInvalidPasskey) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if errors.Is(err, service.ErrPasskeyAlreadyExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, passkey)
+}
+
+func (h *MeHandler) ListPasskeys(c *gin.Context) {
+	passkeys, err := h.passkeys.List(c.Request.Context(), middleware.CurrentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, passkeys)
+}
+
+func (h *MeHandler) RenamePasskey(c *gin.Context) {
+	var req models.RenamePasskeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := h.passkeys.Rename(c.Request.Context(), middleware.CurrentUserID(c), c.Param("id"), req.Name); err != nil {
+		if errors.Is(err, service.ErrPasskeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Passkey renamed successfully"})
+}
+
+func (h *MeHandler) DeletePasskey(c *gin.Context) {
+	if err := h.passkeys.Delete(c.Request.Context(), middleware.CurrentUserID(c), c.Param("id")); err != nil {
+		if errors.Is(err, service.ErrPasskeyNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Passkey deleted successfully"})
+}
+
+func currentSessionID(c *gin.Context) string {
+	if claims := middleware.CurrentClaims(c); claims != nil {
+		return claims.SessionID
+	}
+	return ""
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"gin-mongo-aws/internal/middleware"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/service"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// OAuthHandler serves the OAuth2 authorization server endpoints and the
+// admin API for registering clients
+type OAuthHandler struct {
+	service service.OAuthService
+}
+
+func NewOAuthHandler(service service.OAuthService) *OAuthHandler {
+	return &OAuthHandler{service: service}
+}
+
+func (h *OAuthHandler) CreateClient(c *gin.Context) {
+	var req models.CreateOAuthClientRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	creds, err := h.service.RegisterClient(c.Request.Context(), &req)
+	if err != nil {
+		if errors.Is(err, service.ErrInvalidClientMetadata) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, creds)
+}
+
+func (h *OAuthHandler) ListClients(c *gin.Context) {
+	clients, err := h.service.ListClients(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, clients)
+}
+
+func (h *OAuthHandler) DeleteClient(c *gin.Context) {
+	if err := h.service.DeleteClient(c.Request.Context(), c.Param("client_id")); err != nil {
+		if errors.Is(err, service.ErrOAuthClientNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Client deleted successfully"})
+}
+
+// Authorize validates an authorization request for the signed-in user and
+// returns the consent prompt to show them
+func (h *OAuthHandler) Authorize(c *gin.Context) {
+	var req models.AuthorizeRequest
+	if err := c.ShouldBindQuery(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+
+	var authTime time.Time
+	if claims := middleware.CurrentClaims(c); claims != nil && claims.IssuedAt != nil {
+		authTime = claims.IssuedAt.Time
+	}
+
+	prompt, err := h.service.Authorize(c.Request.Context(), middleware.CurrentUserID(c), authTime, &req)
+	if err != nil {
+		var redirectErr *service.RedirectError
+		if errors.As(err, &redirectErr) {
+			c.Redirect(http.StatusFound, redirectErr.Location())
+			return
+		}
+		respondWithOAuthError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, prompt)
+}
+
+// Consent approves or denies a pending authorization request. The response
+// carries the client URI the user agent should be sent to.
+func (h *OAuthHandler) Consent(c *gin.Context) {
+	var req models.ConsentRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+
+	location, err := h.service.Consent(c.Request.Context(), middleware.CurrentUserID(c), &req)
+	if err != nil {
+		respondWithOAuthError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"redirect_uri": location})
+}
+
+func (h *OAuthHandler) Token(c *gin.Context) {
+	var req models.TokenRequest
+	if err := c.ShouldBindWith(&req, binding.Form); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": err.Error()})
+		return
+	}
+
+	client, ok := h.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	resp, err := h.service.Exchange(c.Request.Context(), client, &req, clientInfo(c))
+	if err != nil {
+		respondWithOAuthError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.Header("Pragma", "no-cache")
+	c.JSON(http.StatusOK, resp)
+}
+
+// Revoke implements RFC 7009 token revocation
+func (h *OAuthHandler) Revoke(c *gin.Context) {
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "token is required"})
+		return
+	}
+
+	client, ok := h.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	if err := h.service.Revoke(c.Request.Context(), client, token, c.PostForm("token_type_hint")); err != nil {
+		respondWithOAuthError(c, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+// Introspect implements RFC 7662 token introspection
+func (h *OAuthHandler) Introspect(c *gin.Context) {
+	token := c.PostForm("token")
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid_request", "error_description": "token is required"})
+		return
+	}
+
+	client, ok := h.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	resp, err := h.service.Introspect(c.Request.Context(), client, token, c.PostForm("token_type_hint"))
+	if err != nil {
+		respondWithOAuthError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, resp)
+}
+
+// RevokedTokens returns the JTIs of access tokens revoked before expiry
+func (h *OAuthHandler) RevokedTokens(c *gin.Context) {
+	client, ok := h.authenticateClient(c)
+	if !ok {
+		return
+	}
+
+	revoked, err := h.service.RevokedTokens(c.Request.Context(), client)
+	if err != nil {
+		respondWithOAuthError(c, err)
+		return
+	}
+
+	c.Header("Cache-Control", "no-store")
+	c.JSON(http.StatusOK, gin.H{"revoked": revoked})
+}
+
+// authenticateClient reads client credentials from HTTP Basic auth or the
+// form body and responds with invalid_client when they do not check out
+func (h *OAuthHandler) authenticateClient(c *gin.Context) (*models.OAuthClient, bool) {
+	clientID, secret, basic := c.Request.BasicAuth()
+	if basic {
+		// RFC 6749 section 2.3.1 form-encodes the credentials before Basic auth
+		clientID, _ = url.QueryUnescape(clientID)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		clientID, secret = c.PostForm("client_id"), c.PostForm("client_secret")
+	}
+
+	client, err := h.service.AuthenticateClient(c.Request.Context(), clientID, secret)
+	if err != nil {
+		if basic {
+			c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+		}
+		respondWithOAuthError(c, err)
+		return nil, false
+	}
+	return client, true
+}
+
+func respondWithOAuthError(c *gin.Context, err error) {
+	var oauthErr *service.OAuthError
+	if !errors.As(err, &oauthErr) {
+		c.Error(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "server_error"})
+		return
+	}
+
+	status := http.StatusBadRequest
+	if oauthErr.Code == "invalid_client" {
+		status = http.StatusUnauthorized
+	}
+	c.JSON(status, gin.H{"error": oauthErr.Code, "error_description": oauthErr.Description})
+}
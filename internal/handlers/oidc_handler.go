@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"gin-mongo-aws/internal/middleware"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// OIDCHandler serves the OpenID Connect discovery, JWKS and userinfo endpoints
+type OIDCHandler struct {
+	oauth  service.OAuthService
+	tokens service.TokenService
+	issuer string
+}
+
+func NewOIDCHandler(oauth service.OAuthService, tokens service.TokenService, issuer string) *OIDCHandler {
+	return &OIDCHandler{oauth: oauth, tokens: tokens, issuer: strings.TrimSuffix(issuer, "/")}
+}
+
+func (h *OIDCHandler) Discovery(c *gin.Context) {
+	c.JSON(http.StatusOK, models.OpenIDConfiguration{
+		Issuer:                            h.issuer,
+		AuthorizationEndpoint:             h.issuer + "/oauth/authorize",
+		TokenEndpoint:                     h.issuer + "/oauth/token",
+		UserInfoEndpoint:                  h.issuer + "/userinfo",
+		JWKSURI:                           h.issuer + "/.well-known/jwks.json",
+		RevocationEndpoint:                h.issuer + "/oauth/revoke",
+		IntrospectionEndpoint:             h.issuer + "/oauth/introspect",
+		ScopesSupported:                   []string{models.ScopeOpenID, models.ScopeProfile, models.ScopeEmail},
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{models.GrantAuthorizationCode, models.GrantRefreshToken, models.GrantClientCredentials},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  h.tokens.Algorithms(),
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{"S256"},
+		ClaimsSupported:                   []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "email", "email_verified", "name"},
+	})
+}
+
+func (h *OIDCHandler) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, h.tokens.JWKS())
+}
+
+func (h *OIDCHandler) UserInfo(c *gin.Context) {
+	info, err := h.oauth.UserInfo(c.Request.Context(), middleware.CurrentClaims(c))
+	if err != nil {
+		var oauthErr *service.OAuthError
+		if errors.As(err, &oauthErr) {
+			status := http.StatusUnauthorized
+			if oauthErr.Code == "insufficient_scope" {
+				status = http.StatusForbidden
+			}
+			c.Header("WWW-Authenticate", `Bearer error="`+oauthErr.Code+`"`)
+			c.JSON(status, gin.H{"error": oauthErr.Code, "error_description": oauthErr.Description})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, info)
+}
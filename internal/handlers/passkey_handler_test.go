@@ -0,0 +1,304 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/middleware"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/service"
+	"gin-mongo-aws/internal/tenant"
+	"gin-mongo-aws/internal/testutil"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-webauthn/webauthn/protocol"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+// passkeyServer mounts the passkey handlers at their routes in route.go. Every
+// request acts in one organization, and the signed-in user of /me requests
+// comes from the X-User-ID header.
+type passkeyServer struct {
+	ctx      context.Context
+	router   *gin.Engine
+	users    *testutil.UserRepository
+	sessions service.SessionService
+	audit    *auditLog
+}
+
+// auditLog keeps the actions recorded in the audit log
+type auditLog struct {
+	mu      sync.Mutex
+	actions []string
+}
+
+func (a *auditLog) Record(ctx context.Context, action, targetType, targetID string, before, after interface{}) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.actions = append(a.actions, action)
+}
+
+func (a *auditLog) List(ctx context.Context, query *models.AuditQuery) (*models.AuditEventPage, error) {
+	return &models.AuditEventPage{}, nil
+}
+
+func (a *auditLog) Verify(ctx context.Context, checkpoints []string) (*models.AuditVerification, error) {
+	return &models.AuditVerification{}, nil
+}
+
+func (a *auditLog) Checkpoint(ctx context.Context) (*models.AuditCheckpoint, error) {
+	return &models.AuditCheckpoint{}, nil
+}
+
+func newPasskeyServer(t *testing.T) *passkeyServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	testutil.StartRedis(t)
+
+	users := testutil.NewUserRepository()
+	audit := &auditLog{}
+	passkeys, err := service.NewPasskeyService(users, testutil.NewWebAuthnCredentialRepository(), audit, config.WebAuthnConfig{
+		RPID:          testRPID,
+		RPDisplayName: "Example",
+		RPOrigins:     []string{testOrigin},
+		ChallengeTTL:  5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	authCfg := config.AuthConfig{
+		Mode:    "session",
+		Session: config.SessionConfig{CookieName: "session", Path: "/", IdleTTL: time.Hour, AbsoluteTTL: 24 * time.Hour},
+	}
+	sessions := service.NewSessionService(authCfg.Session)
+	authHandler := NewAuthHandler(nil, nil, nil, sessions, nil, nil, nil, passkeys, nil, authCfg)
+	meHandler := NewMeHandler(nil, nil, nil, passkeys)
+
+	orgID := primitive.NewObjectID()
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(tenant.WithOrgID(c.Request.Context(), orgID))
+	})
+	v1 := r.Group("/api/v1")
+	auth := v1.Group("/auth")
+	auth.POST("/passkeys/login/begin", authHandler.BeginPasskeyLogin)
+	auth.POST("/passkeys/login/finish", authHandler.FinishPasskeyLogin)
+	me := v1.Group("/me", func(c *gin.Context) {
+		c.Set(middleware.ContextUserIDKey, c.GetHeader("X-User-ID"))
+	})
+	me.POST("/passkeys/register/begin", meHandler.BeginPasskeyRegistration)
+	me.POST("/passkeys/register/finish", meHandler.FinishPasskeyRegistration)
+	me.GET("/passkeys", meHandler.ListPasskeys)
+	me.PATCH("/passkeys/:id", meHandler.RenamePasskey)
+	me.DELETE("/passkeys/:id", meHandler.DeletePasskey)
+
+	return &passkeyServer{ctx: tenant.WithOrgID(context.Background(), orgID), router: r, users: users, sessions: sessions, audit: audit}
+}
+
+func (s *passkeyServer) createUser(t *testing.T, email string) *models.User {
+	t.Helper()
+
+	user := &models.User{Name: "Passkey User", Email: email, EmailVerified: true}
+	if err := s.users.Create(s.ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// do sends a JSON request as userID, or signed out when it is empty
+func (s *passkeyServer) do(t *testing.T, method, path, userID string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &payload)
+	req.Header.Set("Content-Type", "application/json")
+	if userID != "" {
+		req.Header.Set("X-User-ID", userID)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// ceremony decodes a begin response, whose options go to the authenticator
+// the way a browser passes them to navigator.credentials
+func ceremony(t *testing.T, w *httptest.ResponseRecorder, options interface{}) string {
+	t.Helper()
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("begin: status = %d: %s", w.Code, w.Body.String())
+	}
+	var body struct {
+		CeremonyID string          `json:"ceremony_id"`
+		Options    json.RawMessage `json:"options"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(body.Options, options); err != nil {
+		t.Fatal(err)
+	}
+	return body.CeremonyID
+}
+
+// register runs the registration ceremony over HTTP
+func (s *passkeyServer) register(t *testing.T, user *models.User, authenticator *testutil.SoftAuthenticator) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var options protocol.CredentialCreation
+	id := ceremony(t, s.do(t, http.MethodPost, "/api/v1/me/passkeys/register/begin", user.ID.Hex(), nil), &options)
+	return s.do(t, http.MethodPost, "/api/v1/me/passkeys/register/finish", user.ID.Hex(), gin.H{
+		"ceremony_id": id,
+		"name":        "Laptop",
+		"credential":  authenticator.Create(t, &options),
+	})
+}
+
+// login runs the login ceremony over HTTP
+func (s *passkeyServer) login(t *testing.T, authenticator *testutil.SoftAuthenticator) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var options protocol.CredentialAssertion
+	id := ceremony(t, s.do(t, http.MethodPost, "/api/v1/auth/passkeys/login/begin", "", nil), &options)
+	return s.do(t, http.MethodPost, "/api/v1/auth/passkeys/login/finish", "", gin.H{
+		"ceremony_id": id,
+		"credential":  authenticator.Get(t, &options),
+	})
+}
+
+func TestPasskeyEndpoints(t *testing.T) {
+	s := newPasskeyServer(t)
+	user := s.createUser(t, "passkey@example.com")
+	authenticator := testutil.NewSoftAuthenticator(t, testRPID, testOrigin)
+
+	w := s.register(t, user, authenticator)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("register: status = %d: %s", w.Code, w.Body.String())
+	}
+	var passkey models.WebAuthnCredential
+	if err := json.Unmarshal(w.Body.Bytes(), &passkey); err != nil {
+		t.Fatal(err)
+	}
+	if passkey.UserID != user.ID || passkey.Name != "Laptop" {
+		t.Errorf("registered %+v", passkey)
+	}
+
+	// The login starts a session for the passkey's owner
+	w = s.login(t, authenticator)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login: status = %d: %s", w.Code, w.Body.String())
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 || cookies[0].Name != "session" || !cookies[0].HttpOnly {
+		t.Fatalf("login set cookies %v, want the session cookie", cookies)
+	}
+	session, err := s.sessions.Get(s.ctx, cookies[0].Value)
+	if err != nil {
+		t.Fatalf("session of the login: %v", err)
+	}
+	if session.UserID != user.ID.Hex() {
+		t.Errorf("session for %s, want %s", session.UserID, user.ID.Hex())
+	}
+
+	w = s.do(t, http.MethodPatch, "/api/v1/me/passkeys/"+passkey.ID.Hex(), user.ID.Hex(), gin.H{"name": "Phone"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("rename: status = %d: %s", w.Code, w.Body.String())
+	}
+	w = s.do(t, http.MethodGet, "/api/v1/me/passkeys", user.ID.Hex(), nil)
+	var passkeys []models.WebAuthnCredential
+	if err := json.Unmarshal(w.Body.Bytes(), &passkeys); err != nil {
+		t.Fatal(err)
+	}
+	if len(passkeys) != 1 || passkeys[0].Name != "Phone" || passkeys[0].LastUsedAt == nil {
+		t.Errorf("passkeys = %+v, want the renamed and used passkey", passkeys)
+	}
+
+	w = s.do(t, http.MethodDelete, "/api/v1/me/passkeys/"+passkey.ID.Hex(), user.ID.Hex(), nil)
+	if w.Code != http.StatusOK {
+		t.Fatalf("delete: status = %d: %s", w.Code, w.Body.String())
+	}
+	if w := s.login(t, authenticator); w.Code != http.StatusUnauthorized {
+		t.Errorf("login with a removed passkey: status = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+
+	if actions := s.audit.actions; len(actions) != 2 || actions[0] != models.AuditPasskeyCreate || actions[1] != models.AuditPasskeyDelete {
+		t.Errorf("audited %v, want the registration and the removal", actions)
+	}
+}
+
+func TestPasskeyEndpointsRejectBadCeremonies(t *testing.T) {
+	s := newPasskeyServer(t)
+	user := s.createUser(t, "passkey@example.com")
+	other := s.createUser(t, "other@example.com")
+	authenticator := testutil.NewSoftAuthenticator(t, testRPID, testOrigin)
+	if w := s.register(t, user, authenticator); w.Code != http.StatusCreated {
+		t.Fatalf("register: status = %d: %s", w.Code, w.Body.String())
+	}
+
+	t.Run("registered twice", func(t *testing.T) {
+		if w := s.register(t, user, authenticator); w.Code != http.StatusConflict {
+			t.Errorf("status = %d, want %d: %s", w.Code, http.StatusConflict, w.Body.String())
+		}
+	})
+
+	t.Run("wrong origin", func(t *testing.T) {
+		phished := testutil.NewSoftAuthenticator(t, testRPID, "https://evil.example.net")
+		if w := s.register(t, user, phished); w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body.String())
+		}
+	})
+
+	t.Run("another user's ceremony", func(t *testing.T) {
+		var options protocol.CredentialCreation
+		id := ceremony(t, s.do(t, http.MethodPost, "/api/v1/me/passkeys/register/begin", user.ID.Hex(), nil), &options)
+		w := s.do(t, http.MethodPost, "/api/v1/me/passkeys/register/finish", other.ID.Hex(), gin.H{
+			"ceremony_id": id,
+			"name":        "Laptop",
+			"credential":  testutil.NewSoftAuthenticator(t, testRPID, testOrigin).Create(t, &options),
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status = %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body.String())
+		}
+	})
+
+	t.Run("replayed login", func(t *testing.T) {
+		var options protocol.CredentialAssertion
+		id := ceremony(t, s.do(t, http.MethodPost, "/api/v1/auth/passkeys/login/begin", "", nil), &options)
+		finish := gin.H{"ceremony_id": id, "credential": authenticator.Get(t, &options)}
+		if w := s.do(t, http.MethodPost, "/api/v1/auth/passkeys/login/finish", "", finish); w.Code != http.StatusOK {
+			t.Fatalf("login: status = %d: %s", w.Code, w.Body.String())
+		}
+		if w := s.do(t, http.MethodPost, "/api/v1/auth/passkeys/login/finish", "", finish); w.Code != http.StatusUnauthorized {
+			t.Errorf("replay: status = %d, want %d", w.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("another user's passkey", func(t *testing.T) {
+		var passkeys []models.WebAuthnCredential
+		w := s.do(t, http.MethodGet, "/api/v1/me/passkeys", user.ID.Hex(), nil)
+		if err := json.Unmarshal(w.Body.Bytes(), &passkeys); err != nil || len(passkeys) != 1 {
+			t.Fatalf("passkeys = %s (err %v)", w.Body.String(), err)
+		}
+		path := "/api/v1/me/passkeys/" + passkeys[0].ID.Hex()
+		if w := s.do(t, http.MethodDelete, path, other.ID.Hex(), nil); w.Code != http.StatusNotFound {
+			t.Errorf("delete: status = %d, want %d", w.Code, http.StatusNotFound)
+		}
+	})
+}
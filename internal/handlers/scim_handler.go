@@ -0,0 +1,243 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+const scimContentType = "application/scim+json"
+
+// SCIMHandler serves the SCIM 2.0 provisioning API under /scim/v2
+type SCIMHandler struct {
+	service service.SCIMService
+}
+
+func NewSCIMHandler(service service.SCIMService) *SCIMHandler {
+	return &SCIMHandler{service: service}
+}
+
+func (h *SCIMHandler) ListUsers(c *gin.Context) {
+	var query models.SCIMListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+
+	users, err := h.service.ListUsers(c.Request.Context(), &query)
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+	scimJSON(c, http.StatusOK, users)
+}
+
+func (h *SCIMHandler) GetUser(c *gin.Context) {
+	user, err := h.service.GetUser(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+	scimJSON(c, http.StatusOK, user)
+}
+
+func (h *SCIMHandler) CreateUser(c *gin.Context) {
+	var req models.SCIMUser
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	user, err := h.service.CreateUser(c.Request.Context(), &req)
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+	c.Header("Location", user.Meta.Location)
+	scimJSON(c, http.StatusCreated, user)
+}
+
+func (h *SCIMHandler) ReplaceUser(c *gin.Context) {
+	var req models.SCIMUser
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	user, err := h.service.ReplaceUser(c.Request.Context(), c.Param("id"), &req)
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+	scimJSON(c, http.StatusOK, user)
+}
+
+func (h *SCIMHandler) PatchUser(c *gin.Context) {
+	var req models.SCIMPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	user, err := h.service.PatchUser(c.Request.Context(), c.Param("id"), &req)
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+	scimJSON(c, http.StatusOK, user)
+}
+
+func (h *SCIMHandler) DeleteUser(c *gin.Context) {
+	if err := h.service.DeleteUser(c.Request.Context(), c.Param("id")); err != nil {
+		scimServiceError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// ListGroups leaves out members when the client asks with
+// excludedAttributes=members, which keeps listings of large groups cheap
+func (h *SCIMHandler) ListGroups(c *gin.Context) {
+	var query models.SCIMListQuery
+	if err := c.ShouldBindQuery(&query); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+		return
+	}
+
+	withMembers := true
+	for _, attr := range strings.Split(c.Query("excludedAttributes"), ",") {
+		if strings.EqualFold(strings.TrimSpace(attr), "members") {
+			withMembers = false
+		}
+	}
+
+	groups, err := h.service.ListGroups(c.Request.Context(), &query, withMembers)
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+	scimJSON(c, http.StatusOK, groups)
+}
+
+func (h *SCIMHandler) GetGroup(c *gin.Context) {
+	group, err := h.service.GetGroup(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+	scimJSON(c, http.StatusOK, group)
+}
+
+func (h *SCIMHandler) CreateGroup(c *gin.Context) {
+	var req models.SCIMGroup
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	group, err := h.service.CreateGroup(c.Request.Context(), &req)
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+	c.Header("Location", group.Meta.Location)
+	scimJSON(c, http.StatusCreated, group)
+}
+
+func (h *SCIMHandler) ReplaceGroup(c *gin.Context) {
+	var req models.SCIMGroup
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	group, err := h.service.ReplaceGroup(c.Request.Context(), c.Param("id"), &req)
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+	scimJSON(c, http.StatusOK, group)
+}
+
+func (h *SCIMHandler) PatchGroup(c *gin.Context) {
+	var req models.SCIMPatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		scimError(c, http.StatusBadRequest, "invalidSyntax", err.Error())
+		return
+	}
+
+	group, err := h.service.PatchGroup(c.Request.Context(), c.Param("id"), &req)
+	if err != nil {
+		scimServiceError(c, err)
+		return
+	}
+	scimJSON(c, http.StatusOK, group)
+}
+
+func (h *SCIMHandler) DeleteGroup(c *gin.Context) {
+	if err := h.service.DeleteGroup(c.Request.Context(), c.Param("id")); err != nil {
+		scimServiceError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func (h *SCIMHandler) ServiceProviderConfig(c *gin.Context) {
+	scimJSON(c, http.StatusOK, h.service.ServiceProviderConfig())
+}
+
+func (h *SCIMHandler) ResourceTypes(c *gin.Context) {
+	scimJSON(c, http.StatusOK, scimList(h.service.ResourceTypes()))
+}
+
+func (h *SCIMHandler) Schemas(c *gin.Context) {
+	scimJSON(c, http.StatusOK, scimList(h.service.Schemas()))
+}
+
+func scimList(resources []map[string]interface{}) *models.SCIMListResponse {
+	return &models.SCIMListResponse{
+		Schemas:      []string{models.SCIMSchemaListResponse},
+		TotalResults: int64(len(resources)),
+		StartIndex:   1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	}
+}
+
+func scimJSON(c *gin.Context, status int, body interface{}) {
+	c.Header("Content-Type", scimContentType)
+	c.JSON(status, body)
+}
+
+func scimServiceError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, service.ErrSCIMNotFound):
+		scimError(c, http.StatusNotFound, "", err.Error())
+	case errors.Is(err, service.ErrSCIMInvalidFilter):
+		scimError(c, http.StatusBadRequest, "invalidFilter", err.Error())
+	case errors.Is(err, service.ErrSCIMInvalidPath):
+		scimError(c, http.StatusBadRequest, "invalidPath", err.Error())
+	case errors.Is(err, service.ErrSCIMInvalidValue):
+		scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+	case errors.Is(err, service.ErrSCIMUniqueness):
+		scimError(c, http.StatusConflict, "uniqueness", err.Error())
+	default:
+		scimError(c, http.StatusInternalServerError, "", err.Error())
+	}
+}
+
+func scimError(c *gin.Context, status int, scimType, detail string) {
+	c.Header("Content-Type", scimContentType)
+	c.AbortWithStatusJSON(status, models.SCIMError{
+		Schemas:  []string{models.SCIMSchemaError},
+		Status:   strconv.Itoa(status),
+		SCIMType: scimType,
+		Detail:   detail,
+	})
+}
@@ -0,0 +1,53 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/logger"
+
+	"go.uber.org/zap"
+)
+
+// LogMailer writes outgoing email to the log and, when an output directory
+// is configured, to .eml files for local development
+type LogMailer struct {
+	from      string
+	outputDir string
+}
+
+func NewLogMailer(cfg config.MailConfig) *LogMailer {
+	return &LogMailer{from: cfg.From, outputDir: cfg.OutputDir}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	logger.Log.Info("Email sent",
+		zap.String("to", msg.To),
+		zap.String("subject", msg.Subject),
+		zap.String("body", msg.Body),
+	)
+
+	if m.outputDir == "" {
+		return nil
+	}
+
+	if err := os.MkdirAll(m.outputDir, 0o755); err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), sanitizeFilename(msg.To))
+	return os.WriteFile(filepath.Join(m.outputDir, name), buildMessage(m.from, msg), 0o644)
+}
+
+func sanitizeFilename(s string) string {
+	out := []rune(s)
+	for i, r := range out {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_') {
+			out[i] = '_'
+		}
+	}
+	return string(out)
+}
@@ -0,0 +1,278 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/service"
+	"gin-mongo-aws/internal/tenant"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var (
+	errMissingCredentials = errors.New("missing bearer token, api key or session cookie")
+	errOAuthToken         = errors.New("tokens issued to OAuth clients are not accepted by this API")
+	errAPIKeyNotAllowed   = errors.New("api keys cannot be used for this endpoint")
+	errImpersonating      = errors.New("this action is not allowed while impersonating a user")
+	errCSRFHeader         = errors.New("requests signed in with the session cookie must send the " + CSRFHeader + " header")
+)
+
+const (
+	ContextUserIDKey = "userID"
+	ContextClaimsKey = "claims"
+)
+
+// CSRFHeader must be sent with state-changing requests that authenticate with
+// the session cookie. Browsers attach the cookie to requests from any site,
+// but only let a page set a custom header on a cross-origin request after a
+// CORS preflight, which CORSMiddleware answers for the allowed origins only.
+const CSRFHeader = "X-Requested-With"
+
+// AuthRequired authenticates the request with a bearer access token, an
+// "ApiKey" authorization header or, when no Authorization header is sent, a
+// session cookie. The subject and claims are stored in the gin context and
+// the request acts in the organization the credentials were issued in.
+// State-changing requests signed in with the cookie must send CSRFHeader.
+func AuthRequired(tokens service.TokenService, sessions service.SessionService, apiKeys service.APIKeyService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := checkCSRFHeader(c, sessions); err != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+
+		claims, err := authenticate(c, tokens, sessions, apiKeys)
+		if err == nil {
+			err = bindTokenOrganization(c, claims)
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Set(ContextUserIDKey, claims.Subject)
+		c.Set(ContextClaimsKey, claims)
+		setAuditActor(c, service.AuditActorFromClaims(claims))
+		c.Next()
+	}
+}
+
+// RequireCSRFHeader rejects state-changing requests that carry the session
+// cookie without CSRFHeader, for endpoints such as logout that read the
+// cookie without AuthRequired
+func RequireCSRFHeader(sessions service.SessionService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := checkCSRFHeader(c, sessions); err != nil {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		c.Next()
+	}
+}
+
+// DenyAPIKeys rejects callers that authenticated with an API key. It guards
+// account management routes that must only be reachable by a person.
+func DenyAPIKeys() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims := CurrentClaims(c); claims != nil && claims.APIKeyID != "" {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": errAPIKeyNotAllowed.Error()})
+			return
+		}
+		c.Next()
+	}
+}
+
+// DenyImpersonation rejects callers using an impersonation token. It guards
+// destructive and account security routes that support staff must not use
+// on a customer's behalf.
+func DenyImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if claims := CurrentClaims(c); claims != nil && claims.IsImpersonation() {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": errImpersonating.Error()})
+			return
+		}
+		c.Next()
+	}
+}
+
+func authenticate(c *gin.Context, tokens service.TokenService, sessions service.SessionService, apiKeys service.APIKeyService) (*service.Claims, error) {
+	if header := c.GetHeader("Authorization"); header != "" {
+		if key, ok := authorizationCredentials(header, "ApiKey"); ok {
+			return apiKeys.Authenticate(c.Request.Context(), key, c.ClientIP())
+		}
+
+		token, ok := bearerToken(header)
+		if !ok {
+			return nil, errMissingCredentials
+		}
+		claims, err := tokens.ParseAccessToken(c.Request.Context(), token)
+		if err != nil {
+			return nil, err
+		}
+		// OAuth access tokens are meant for the client's resource servers
+		if claims.IsOAuth() {
+			return nil, errOAuthToken
+		}
+		return claims, nil
+	}
+
+	cookie, err := c.Cookie(sessions.CookieName())
+	if err != nil || cookie == "" {
+		return nil, errMissingCredentials
+	}
+
+	session, err := sessions.Get(c.Request.Context(), cookie)
+	if err != nil {
+		return nil, service.ErrInvalidSession
+	}
+	return sessionClaims(session), nil
+}
+
+// checkCSRFHeader returns errCSRFHeader for a state-changing request that
+// would be authenticated by the session cookie alone
+func checkCSRFHeader(c *gin.Context, sessions service.SessionService) error {
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return nil
+	}
+	if c.GetHeader("Authorization") != "" || c.GetHeader(CSRFHeader) != "" {
+		return nil
+	}
+	if cookie, err := c.Cookie(sessions.CookieName()); err != nil || cookie == "" {
+		return nil
+	}
+	return errCSRFHeader
+}
+
+// OAuthTokenRequired authenticates the request with an access token issued
+// to an OAuth client, reporting failures as RFC 6750 bearer token errors
+func OAuthTokenRequired(tokens service.TokenService) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token, ok := bearerToken(c.GetHeader("Authorization"))
+		if !ok {
+			c.Header("WWW-Authenticate", `Bearer`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_request", "error_description": errMissingCredentials.Error()})
+			return
+		}
+
+		claims, err := tokens.ParseAccessToken(c.Request.Context(), token)
+		if err == nil && !claims.IsOAuth() {
+			err = service.ErrInvalidToken
+		}
+		// Client credentials tokens have no user and so no organization
+		if err == nil && claims.OrgID != "" {
+			err = bindTokenOrganization(c, claims)
+		}
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid_token", "error_description": err.Error()})
+			return
+		}
+
+		c.Set(ContextUserIDKey, claims.Subject)
+		c.Set(ContextClaimsKey, claims)
+		c.Next()
+	}
+}
+
+// SCIMTokenRequired authenticates SCIM clients with the bearer tokens from
+// scim.tokens, keyed by organization slug, and reports failures as SCIM
+// errors. A token provisions only its own organization, whatever organization
+// the request names with the header or subdomain.
+func SCIMTokenRequired(orgs service.OrganizationService, tokens map[string]string) gin.HandlerFunc {
+	digests := make(map[string][sha256.Size]byte, len(tokens))
+	for slug, token := range tokens {
+		digests[slug] = sha256.Sum256([]byte(token))
+	}
+	return func(c *gin.Context) {
+		presented, ok := bearerToken(c.GetHeader("Authorization"))
+		// Comparing digests keeps the comparison constant-time in the token
+		// length, and every token is compared so the match is not revealed
+		digest := sha256.Sum256([]byte(presented))
+		slug := ""
+		for candidate, expected := range digests {
+			if subtle.ConstantTimeCompare(digest[:], expected[:]) == 1 {
+				slug = candidate
+			}
+		}
+		if !ok || slug == "" {
+			c.Header("WWW-Authenticate", `Bearer`)
+			abortSCIM(c, http.StatusUnauthorized, "invalid or missing SCIM bearer token")
+			return
+		}
+
+		org, err := orgs.Resolve(c.Request.Context(), slug)
+		if err != nil {
+			abortSCIM(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		c.Request = c.Request.WithContext(tenant.WithOrgID(c.Request.Context(), org.ID))
+		setAuditActor(c, models.AuditActor{Type: models.AuditActorSCIM})
+		c.Next()
+	}
+}
+
+func abortSCIM(c *gin.Context, status int, detail string) {
+	c.AbortWithStatusJSON(status, models.SCIMError{
+		Schemas: []string{models.SCIMSchemaError},
+		Status:  strconv.Itoa(status),
+		Detail:  detail,
+	})
+}
+
+// sessionClaims presents a server-side session as access token claims so
+// downstream middleware does not need to know how the caller authenticated
+func sessionClaims(session *service.Session) *service.Claims {
+	return &service.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       session.ID,
+			Subject:  session.UserID,
+			IssuedAt: jwt.NewNumericDate(session.CreatedAt),
+		},
+		Email:     session.Email,
+		Roles:     session.Roles,
+		SessionID: session.ID,
+		OrgID:     session.OrgID,
+	}
+}
+
+// setAuditActor names the caller in the request metadata changes are audited with
+func setAuditActor(c *gin.Context, actor models.AuditActor) {
+	c.Request = c.Request.WithContext(service.WithAuditActor(c.Request.Context(), actor))
+}
+
+// CurrentUserID returns the authenticated subject set by AuthRequired
+func CurrentUserID(c *gin.Context) string {
+	return c.GetString(ContextUserIDKey)
+}
+
+// CurrentClaims returns the validated token claims set by AuthRequired
+func CurrentClaims(c *gin.Context) *service.Claims {
+	if v, ok := c.Get(ContextClaimsKey); ok {
+		if claims, ok := v.(*service.Claims); ok {
+			return claims
+		}
+	}
+	return nil
+}
+
+func bearerToken(header string) (string, bool) {
+	return authorizationCredentials(header, "Bearer")
+}
+
+// authorizationCredentials returns the credentials of an Authorization header
+// that uses the given scheme
+func authorizationCredentials(header, scheme string) (string, bool) {
+	got, credentials, found := strings.Cut(header, " ")
+	if !found || !strings.EqualFold(got, scheme) {
+		return "", false
+	}
+	credentials = strings.TrimSpace(credentials)
+	return credentials, credentials != ""
+}
@@ -0,0 +1,123 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/service"
+	"gin-mongo-aws/internal/tenant"
+	"gin-mongo-aws/internal/testutil"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestSCIMTokenActsInItsOrganization(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	orgs := newFakeOrganizations("default", "acme", "globex")
+
+	r := gin.New()
+	r.Use(Tenant(orgs, config.TenancyConfig{DefaultOrganization: "default", Header: "X-Organization"}))
+	r.GET("/scim/v2/Users", SCIMTokenRequired(orgs, map[string]string{"acme": "acme-token", "globex": "globex-token"}), func(c *gin.Context) {
+		orgID, err := tenant.OrgID(c.Request.Context())
+		if err != nil {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.String(http.StatusOK, orgID.Hex())
+	})
+
+	tests := []struct {
+		name       string
+		token      string
+		header     string
+		wantStatus int
+		wantOrg    string
+	}{
+		{name: "token alone", token: "acme-token", wantStatus: http.StatusOK, wantOrg: "acme"},
+		{name: "header naming another organization", token: "acme-token", header: "globex", wantStatus: http.StatusOK, wantOrg: "acme"},
+		{name: "other organization's token", token: "globex-token", header: "acme", wantStatus: http.StatusOK, wantOrg: "globex"},
+		{name: "unknown token", token: "default-token", wantStatus: http.StatusUnauthorized},
+		{name: "no token", header: "acme", wantStatus: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/scim/v2/Users", nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			if tt.header != "" {
+				req.Header.Set("X-Organization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantOrg != "" && w.Body.String() != orgs[tt.wantOrg].ID.Hex() {
+				t.Errorf("acted in %s, want %s (%s)", w.Body.String(), tt.wantOrg, orgs[tt.wantOrg].ID.Hex())
+			}
+		})
+	}
+}
+
+func TestSessionCookieRequestsNeedTheCSRFHeader(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	testutil.StartRedis(t)
+	sessions := service.NewSessionService(config.SessionConfig{CookieName: "session", IdleTTL: time.Hour, AbsoluteTTL: time.Hour})
+	user := &models.User{ID: primitive.NewObjectID(), Email: "user@example.com", OrgID: primitive.NewObjectID()}
+	cookie, _, err := sessions.Create(context.Background(), user, service.ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := gin.New()
+	r.Use(func(c *gin.Context) {
+		c.Request = c.Request.WithContext(tenant.WithOrgID(c.Request.Context(), user.OrgID))
+	})
+	r.Any("/me", AuthRequired(nil, sessions, nil), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	r.POST("/logout", RequireCSRFHeader(sessions), func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		cookie     bool
+		header     bool
+		wantStatus int
+	}{
+		{name: "read with the cookie", method: http.MethodGet, path: "/me", cookie: true, wantStatus: http.StatusOK},
+		{name: "change with the cookie", method: http.MethodPost, path: "/me", cookie: true, wantStatus: http.StatusForbidden},
+		{name: "change with the cookie and header", method: http.MethodDelete, path: "/me", cookie: true, header: true, wantStatus: http.StatusOK},
+		{name: "change without credentials", method: http.MethodPost, path: "/me", wantStatus: http.StatusUnauthorized},
+		{name: "logout with the cookie", method: http.MethodPost, path: "/logout", cookie: true, wantStatus: http.StatusForbidden},
+		{name: "logout with the cookie and header", method: http.MethodPost, path: "/logout", cookie: true, header: true, wantStatus: http.StatusOK},
+		{name: "logout without the cookie", method: http.MethodPost, path: "/logout", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.cookie {
+				req.AddCookie(&http.Cookie{Name: "session", Value: cookie})
+			}
+			if tt.header {
+				req.Header.Set(CSRFHeader, "XMLHttpRequest")
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
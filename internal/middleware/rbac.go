@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+
+	"gin-mongo-aws/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequirePermission allows the request only when one of the roles the caller
+// holds in the request's organization grants permission and, for API keys,
+// the key's scopes include it. Roles are read from the caller's membership,
+// not the token, so revoking a role takes effect at once. It must run after
+// AuthRequired.
+func RequirePermission(roles service.RoleService, permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		allowed, err := hasPermission(c, roles, permission)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !allowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSelfOrPermission allows the request when the route parameter param
+// names the caller's own record, or when the caller holds permission
+func RequireSelfOrPermission(roles service.RoleService, param, permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Param(param) == CurrentUserID(c) {
+			if claims := CurrentClaims(c); claims != nil && !claims.ScopeAllows(permission) {
+				c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		allowed, err := hasPermission(c, roles, permission)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !allowed {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "insufficient permissions"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func hasPermission(c *gin.Context, roles service.RoleService, permission string) (bool, error) {
+	claims := CurrentClaims(c)
+	if claims == nil || !claims.ScopeAllows(permission) {
+		return false, nil
+	}
+	return roles.MemberHasPermission(c.Request.Context(), claims.Subject, permission)
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/service"
+	"gin-mongo-aws/internal/tenant"
+	"gin-mongo-aws/internal/testutil"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestRequirePermissionChecksRolesInTheRequestOrganization(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	users := testutil.NewUserRepository()
+	roles := service.NewRoleService(nil, users)
+
+	acme, globex := primitive.NewObjectID(), primitive.NewObjectID()
+	admin := &models.User{Name: "Admin", Email: "admin@example.com", Roles: []string{models.RoleAdmin}}
+	if err := users.Create(tenant.WithOrgID(context.Background(), acme), admin); err != nil {
+		t.Fatal(err)
+	}
+	demoted := &models.User{Name: "Demoted", Email: "demoted@example.com", Roles: []string{models.RoleAdmin}}
+	if err := users.Create(tenant.WithOrgID(context.Background(), acme), demoted); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetRoles(tenant.WithOrgID(context.Background(), acme), demoted.ID.Hex(), []string{models.RoleUser}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		subject    string
+		org        primitive.ObjectID
+		wantStatus int
+	}{
+		{name: "admin of the organization", subject: admin.ID.Hex(), org: acme, wantStatus: http.StatusOK},
+		{name: "admin of another organization", subject: admin.ID.Hex(), org: globex, wantStatus: http.StatusForbidden},
+		{name: "token issued before a demotion", subject: demoted.ID.Hex(), org: acme, wantStatus: http.StatusForbidden},
+		{name: "OAuth client", subject: "client-id", org: acme, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every token claims the admin role; only the membership counts
+			claims := &service.Claims{
+				RegisteredClaims: jwt.RegisteredClaims{Subject: tt.subject},
+				Roles:            []string{models.RoleAdmin},
+				OrgID:            tt.org.Hex(),
+			}
+			r := gin.New()
+			r.GET("/users", func(c *gin.Context) {
+				c.Request = c.Request.WithContext(tenant.WithOrgID(c.Request.Context(), tt.org))
+				c.Set(ContextUserIDKey, claims.Subject)
+				c.Set(ContextClaimsKey, claims)
+			}, RequirePermission(roles, models.PermUsersList), func(c *gin.Context) {
+				c.Status(http.StatusOK)
+			})
+
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/users", nil))
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+		})
+	}
+}
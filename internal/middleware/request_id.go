@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"regexp"
+
+	"gin-mongo-aws/internal/service"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	RequestIDHeader     = "X-Request-ID"
+	ContextRequestIDKey = "requestID"
+)
+
+// validRequestID limits the request IDs accepted from clients and proxies to
+// ones that are safe to log and echo back
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,64}$`)
+
+// RequestID gives every request an ID, keeping one sent in X-Request-ID, and
+// returns it in the response. The ID, client IP and user agent are attached to
+// the request context for the audit log.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+
+		c.Set(ContextRequestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		c.Request = c.Request.WithContext(service.WithRequestMetadata(c.Request.Context(), service.RequestMetadata{
+			RequestID: id,
+			IP:        c.ClientIP(),
+			UserAgent: c.Request.UserAgent(),
+		}))
+		c.Next()
+	}
+}
+
+// CurrentRequestID returns the ID set by RequestID
+func CurrentRequestID(c *gin.Context) string {
+	return c.GetString(ContextRequestIDKey)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
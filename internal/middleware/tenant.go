@@ -0,0 +1,107 @@
+package middleware
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/service"
+	"gin-mongo-aws/internal/tenant"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	errUnknownOrganization   = errors.New("unknown organization")
+	errTokenOrganization     = errors.New("token does not name an organization; sign in again")
+	errOrganizationMismatch  = errors.New("token was issued for a different organization")
+	errOrganizationForbidden = errors.New("this endpoint is only available in the default organization")
+)
+
+// contextOrgNamedKey is set when the request named its organization itself
+const contextOrgNamedKey = "orgNamed"
+
+// Tenant resolves the organization a request acts in from the configured
+// header or, when a base domain is set, the subdomain of the host. Requests
+// that name neither act in the default organization until AuthRequired
+// moves them to the organization in their token.
+func Tenant(orgs service.OrganizationService, cfg config.TenancyConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		slug := strings.ToLower(strings.TrimSpace(c.GetHeader(cfg.Header)))
+		if slug == "" {
+			slug = subdomain(c.Request.Host, cfg.BaseDomain)
+		}
+		named := slug != ""
+		if !named {
+			slug = cfg.DefaultOrganization
+		}
+
+		org, err := orgs.Resolve(c.Request.Context(), slug)
+		if errors.Is(err, service.ErrOrganizationNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": errUnknownOrganization.Error()})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Set(contextOrgNamedKey, named)
+		c.Request = c.Request.WithContext(tenant.WithOrgID(c.Request.Context(), org.ID))
+		c.Next()
+	}
+}
+
+// RequireOrganization limits a route to requests acting in the organization
+// with the given slug. Deployment-wide settings are managed from the default
+// organization, so an admin of any other organization cannot change them.
+func RequireOrganization(orgs service.OrganizationService, slug string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		org, err := orgs.Resolve(c.Request.Context(), slug)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if current, err := tenant.OrgID(c.Request.Context()); err != nil || current != org.ID {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": errOrganizationForbidden.Error()})
+			return
+		}
+		c.Next()
+	}
+}
+
+// bindTokenOrganization makes the request act in the organization the token
+// was issued in. A request that named another organization is refused rather
+// than letting a token from one tenant act in another.
+func bindTokenOrganization(c *gin.Context, claims *service.Claims) error {
+	orgID, err := primitive.ObjectIDFromHex(claims.OrgID)
+	if err != nil || orgID.IsZero() {
+		return errTokenOrganization
+	}
+
+	ctx := c.Request.Context()
+	if current, err := tenant.OrgID(ctx); err == nil && c.GetBool(contextOrgNamedKey) && current != orgID {
+		return errOrganizationMismatch
+	}
+	c.Request = c.Request.WithContext(tenant.WithOrgID(ctx, orgID))
+	return nil
+}
+
+// subdomain returns the label host has below baseDomain, or "" when host is
+// not a direct subdomain of it
+func subdomain(host, baseDomain string) string {
+	if baseDomain == "" {
+		return ""
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	label, ok := strings.CutSuffix(strings.ToLower(host), "."+strings.ToLower(baseDomain))
+	if !ok || label == "" || strings.Contains(label, ".") {
+		return ""
+	}
+	return label
+}
@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/service"
+	"gin-mongo-aws/internal/tenant"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeOrganizations resolves a fixed set of organizations
+type fakeOrganizations map[string]*models.Organization
+
+func newFakeOrganizations(slugs ...string) fakeOrganizations {
+	orgs := fakeOrganizations{}
+	for _, slug := range slugs {
+		orgs[slug] = &models.Organization{ID: primitive.NewObjectID(), Slug: slug}
+	}
+	return orgs
+}
+
+func (f fakeOrganizations) Create(ctx context.Context, req *models.CreateOrganizationRequest) (*models.OrganizationCreated, error) {
+	return nil, nil
+}
+
+func (f fakeOrganizations) List(ctx context.Context) ([]models.Organization, error) {
+	return nil, nil
+}
+
+func (f fakeOrganizations) Resolve(ctx context.Context, slug string) (*models.Organization, error) {
+	org, ok := f[slug]
+	if !ok {
+		return nil, service.ErrOrganizationNotFound
+	}
+	return org, nil
+}
+
+func TestTenantResolvesTheOrganization(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	orgs := newFakeOrganizations("default", "acme")
+	cfg := config.TenancyConfig{DefaultOrganization: "default", Header: "X-Organization", BaseDomain: "example.com"}
+
+	tests := []struct {
+		name       string
+		host       string
+		header     string
+		wantStatus int
+		wantOrg    string
+	}{
+		{name: "nothing named", host: "example.com", wantStatus: http.StatusOK, wantOrg: "default"},
+		{name: "header", host: "example.com", header: "ACME", wantStatus: http.StatusOK, wantOrg: "acme"},
+		{name: "subdomain", host: "acme.example.com:8080", wantStatus: http.StatusOK, wantOrg: "acme"},
+		{name: "header before subdomain", host: "globex.example.com", header: "acme", wantStatus: http.StatusOK, wantOrg: "acme"},
+		{name: "nested subdomain", host: "www.acme.example.com", wantStatus: http.StatusOK, wantOrg: "default"},
+		{name: "unknown organization", host: "globex.example.com", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got primitive.ObjectID
+			r := gin.New()
+			r.GET("/", Tenant(orgs, cfg), func(c *gin.Context) {
+				got, _ = tenant.OrgID(c.Request.Context())
+				c.Status(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.Host = tt.host
+			if tt.header != "" {
+				req.Header.Set(cfg.Header, tt.header)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body.String())
+			}
+			if tt.wantOrg != "" && got != orgs[tt.wantOrg].ID {
+				t.Errorf("acted in %s, want %s", got.Hex(), tt.wantOrg)
+			}
+		})
+	}
+}
+
+func TestBindTokenOrganization(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	acme, globex := primitive.NewObjectID(), primitive.NewObjectID()
+
+	tests := []struct {
+		name    string
+		named   bool
+		claim   string
+		wantErr error
+	}{
+		{name: "default organization moves to the token's", claim: acme.Hex()},
+		{name: "named organization matches", named: true, claim: globex.Hex()},
+		{name: "named organization differs", named: true, claim: acme.Hex(), wantErr: errOrganizationMismatch},
+		{name: "token without organization", claim: "", wantErr: errTokenOrganization},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+			c.Request = c.Request.WithContext(tenant.WithOrgID(c.Request.Context(), globex))
+			c.Set(contextOrgNamedKey, tt.named)
+
+			err := bindTokenOrganization(c, &service.Claims{OrgID: tt.claim})
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil {
+				if got, _ := tenant.OrgID(c.Request.Context()); got.Hex() != tt.claim {
+					t.Errorf("acting in %s, want %s", got.Hex(), tt.claim)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKey is a user-owned credential for scripts and jobs. The full key is
+// "gma_<prefix>_<secret>"; the prefix is stored for lookup and only a hash
+// of the secret is kept.
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID     primitive.ObjectID `bson:"user_id" json:"user_id"`
+	OrgID      primitive.ObjectID `bson:"org_id" json:"-"` // the owner's organization, which the key acts in
+	Name       string             `bson:"name" json:"name"`
+	Prefix     string             `bson:"prefix" json:"prefix"`
+	SecretHash string             `bson:"secret_hash" json:"-"`
+	Scopes     []string           `bson:"scopes" json:"scopes"`
+	AllowedIPs []string           `bson:"allowed_ips,omitempty" json:"allowed_ips,omitempty"`
+	ExpiresAt  *time.Time         `bson:"expires_at,omitempty" json:"expires_at,omitempty"`
+	LastUsedAt *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	LastUsedIP string             `bson:"last_used_ip,omitempty" json:"last_used_ip,omitempty"`
+	CreatedAt  time.Time          `bson:"created_at" json:"created_at"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name       string     `json:"name" binding:"required"`
+	Scopes     []string   `json:"scopes" binding:"required,min=1"`
+	AllowedIPs []string   `json:"allowed_ips"` // IPs or CIDR ranges; empty allows any
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+// APIKeyCreated is returned once when a key is created
+type APIKeyCreated struct {
+	APIKey *APIKey `json:"api_key"`
+	Key    string  `json:"key"`
+}
@@ -0,0 +1,18 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Group is a named set of users, typically pushed by a provisioning system
+type Group struct {
+	ID          primitive.ObjectID   `bson:"_id,omitempty" json:"id"`
+	OrgID       primitive.ObjectID   `bson:"org_id" json:"-"`
+	DisplayName string               `bson:"display_name" json:"display_name"`
+	ExternalID  string               `bson:"external_id,omitempty" json:"external_id,omitempty"`
+	Members     []primitive.ObjectID `bson:"members" json:"members"`
+	CreatedAt   time.Time            `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time            `bson:"updated_at" json:"updated_at"`
+}
@@ -0,0 +1,29 @@
+package models
+
+import "time"
+
+// LinkedIdentity ties a user to an account at an external identity provider
+type LinkedIdentity struct {
+	Key      string    `bson:"key" json:"-"` // "<provider>|<subject>", unique across users
+	Provider string    `bson:"provider" json:"provider"`
+	Subject  string    `bson:"subject" json:"subject"`
+	Email    string    `bson:"email,omitempty" json:"email,omitempty"`
+	LinkedAt time.Time `bson:"linked_at" json:"linked_at"`
+}
+
+// LinkedIdentityKey identifies an external account across providers
+func LinkedIdentityKey(provider, subject string) string {
+	return provider + "|" + subject
+}
+
+// IdentityProviderInfo is the public description of a configured provider
+type IdentityProviderInfo struct {
+	Name        string `json:"name"`
+	DisplayName string `json:"display_name"`
+	LoginURL    string `json:"login_url"`
+}
+
+// ExternalAuthURL is returned when a signed-in user starts linking a provider
+type ExternalAuthURL struct {
+	AuthorizationURL string `json:"authorization_url"`
+}
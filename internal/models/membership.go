@@ -0,0 +1,19 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Membership holds the roles a user has in one organization. Permission
+// checks read the caller's membership in the request's organization, so
+// role changes apply at once instead of when the caller's token expires.
+type Membership struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID    primitive.ObjectID `bson:"user_id" json:"user_id"`
+	OrgID     primitive.ObjectID `bson:"org_id" json:"org_id"`
+	Roles     []string           `bson:"roles" json:"roles"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
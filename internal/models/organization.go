@@ -0,0 +1,38 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Organization is a tenant of the deployment. Every user account belongs to
+// exactly one organization and its roles apply only there; the same email
+// can hold separate accounts in several organizations.
+type Organization struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Slug      string             `bson:"slug" json:"slug"` // subdomain and X-Organization header value
+	Name      string             `bson:"name" json:"name"`
+	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// CreateOrganizationRequest creates an organization together with its first
+// administrator, who can then manage the organization's other members
+type CreateOrganizationRequest struct {
+	Slug  string                  `json:"slug" binding:"required"`
+	Name  string                  `json:"name" binding:"required"`
+	Owner CreateOrganizationOwner `json:"owner" binding:"required"`
+}
+
+type CreateOrganizationOwner struct {
+	Name     string `json:"name" binding:"required"`
+	Email    string `json:"email" binding:"required,email"`
+	Password string `json:"password" binding:"required,min=8,max=72"`
+}
+
+// OrganizationCreated is returned when an organization is created
+type OrganizationCreated struct {
+	Organization *Organization `json:"organization"`
+	Owner        *User         `json:"owner"`
+}
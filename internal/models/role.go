@@ -0,0 +1,52 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Built-in role names
+const (
+	RoleAdmin = "admin"
+	RoleUser  = "user"
+)
+
+// Permissions checked by the user and role routes
+const (
+	PermUsersCreate      = "users:create"
+	PermUsersList        = "users:list"
+	PermUsersRead        = "users:read"
+	PermUsersUpdate      = "users:update"
+	PermUsersDelete      = "users:delete"
+	PermUsersRoles       = "users:roles"
+	PermUsersUnlock      = "users:unlock"
+	PermRolesManage      = "roles:manage"
+	PermOAuthClients     = "oauth:clients"
+	PermKeysManage       = "keys:manage"
+	PermUsersImpersonate = "users:impersonate"
+	PermAuditRead        = "audit:read"
+	PermOrgsManage       = "organizations:manage"
+)
+
+// BuiltinRoles are always available and cannot be modified through the API.
+// Regular users get no global permissions; ownership rules let them read and
+// update their own record.
+var BuiltinRoles = map[string][]string{
+	RoleAdmin: {"*"},
+	RoleUser:  {},
+}
+
+type Role struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Name        string             `bson:"name" json:"name" binding:"required"`
+	Description string             `bson:"description" json:"description"`
+	Permissions []string           `bson:"permissions" json:"permissions" binding:"required"`
+	CreatedAt   time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt   time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// SetRolesRequest replaces the roles assigned to a user
+type SetRolesRequest struct {
+	Roles []string `json:"roles" binding:"required"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Session types reported by the active sessions API
+const (
+	SessionTypeRefreshToken = "refresh_token"
+	SessionTypeCookie       = "session"
+)
+
+// ActiveSession describes one signed-in device of a user
+type ActiveSession struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	Device     string    `json:"device"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	ClientID   string    `json:"client_id,omitempty"` // OAuth client the login was granted to
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	Current    bool      `json:"current"`
+}
@@ -0,0 +1,26 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SigningKey is a managed token signing key. The private key is stored
+// encrypted; the public half is published in the JWKS from creation until
+// the key retires.
+type SigningKey struct {
+	ID           primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	Kid          string             `bson:"kid" json:"kid"`
+	Algorithm    string             `bson:"algorithm" json:"algorithm"`
+	EncryptedKey []byte             `bson:"encrypted_key" json:"-"`
+	PublicKey    JSONWebKey         `bson:"public_key" json:"public_key"`
+	CreatedAt    time.Time          `bson:"created_at" json:"created_at"`
+	ActivatesAt  time.Time          `bson:"activates_at" json:"activates_at"`
+	RetiresAt    *time.Time         `bson:"retires_at,omitempty" json:"retires_at,omitempty"`
+}
+
+// Usable reports whether tokens signed with the key may still be verified
+func (k *SigningKey) Usable(now time.Time) bool {
+	return k.RetiresAt == nil || now.Before(*k.RetiresAt)
+}
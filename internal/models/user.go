@@ -7,9 +7,32 @@ import (
 )
 
 type User struct {
-	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id"`
-	Name      string             `bson:"name" json:"name" binding:"required"`
-	Email     string             `bson:"email" json:"email" binding:"required,email"`
-	CreatedAt time.Time          `bson:"created_at" json:"created_at"`
-	UpdatedAt time.Time          `bson:"updated_at" json:"updated_at"`
+	ID            primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	OrgID         primitive.ObjectID `bson:"org_id" json:"org_id"` // the organization the account belongs to
+	Name          string             `bson:"name" json:"name" binding:"required"`
+	GivenName     string             `bson:"given_name,omitempty" json:"given_name,omitempty"`
+	FamilyName    string             `bson:"family_name,omitempty" json:"family_name,omitempty"`
+	Email         string             `bson:"email" json:"email" binding:"required,email"`
+	PasswordHash  string             `bson:"password_hash,omitempty" json:"-"`
+	EmailVerified bool               `bson:"email_verified" json:"email_verified"`
+	Roles         []string           `bson:"roles" json:"roles"`
+	MFAEnabled    bool               `bson:"mfa_enabled" json:"mfa_enabled"`
+	MFASecret     string             `bson:"mfa_secret,omitempty" json:"-"`
+	MFAPending    string             `bson:"mfa_pending_secret,omitempty" json:"-"`
+	RecoveryCodes []string           `bson:"recovery_codes,omitempty" json:"-"` // SHA-256 hashes
+	Identities    []LinkedIdentity   `bson:"linked_identities,omitempty" json:"linked_identities,omitempty"`
+	AuthSource    string             `bson:"auth_source,omitempty" json:"auth_source,omitempty"` // "ldap" for directory users
+	ExternalID    string             `bson:"external_id,omitempty" json:"external_id,omitempty"` // ID in the provisioning system
+	Disabled      bool               `bson:"disabled,omitempty" json:"disabled,omitempty"`       // deactivated users cannot sign in
+	CreatedAt     time.Time          `bson:"created_at" json:"created_at"`
+	UpdatedAt     time.Time          `bson:"updated_at" json:"updated_at"`
+}
+
+// AuthSourceLDAP marks users provisioned from an LDAP directory
+const AuthSourceLDAP = "ldap"
+
+// DirectoryManaged reports whether the user's password and roles are owned
+// by an external directory
+func (u *User) DirectoryManaged() bool {
+	return u.AuthSource != ""
 }
@@ -0,0 +1,49 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WebAuthnCredential is a passkey registered by a user. The credential ID and
+// public key come from the authenticator; only the name is chosen by the user.
+type WebAuthnCredential struct {
+	ID              primitive.ObjectID `bson:"_id,omitempty" json:"id"`
+	UserID          primitive.ObjectID `bson:"user_id" json:"user_id"`
+	OrgID           primitive.ObjectID `bson:"org_id" json:"-"` // the owner's organization
+	Name            string             `bson:"name" json:"name"`
+	CredentialID    []byte             `bson:"credential_id" json:"-"`
+	PublicKey       []byte             `bson:"public_key" json:"-"` // COSE encoded
+	AttestationType string             `bson:"attestation_type" json:"-"`
+	AAGUID          []byte             `bson:"aaguid,omitempty" json:"-"`
+	SignCount       uint32             `bson:"sign_count" json:"-"`
+	Transports      []string           `bson:"transports,omitempty" json:"transports,omitempty"`
+	BackupEligible  bool               `bson:"backup_eligible" json:"backup_eligible"`
+	BackupState     bool               `bson:"backup_state" json:"backup_state"` // synced to other devices
+	LastUsedAt      *time.Time         `bson:"last_used_at,omitempty" json:"last_used_at,omitempty"`
+	CreatedAt       time.Time          `bson:"created_at" json:"created_at"`
+}
+
+// WebAuthnCeremony carries the options for navigator.credentials.create() or
+// .get() and the ID the finish request must send back
+type WebAuthnCeremony struct {
+	CeremonyID string      `json:"ceremony_id"`
+	Options    interface{} `json:"options"`
+}
+
+type FinishPasskeyRegistrationRequest struct {
+	CeremonyID string          `json:"ceremony_id" binding:"required"`
+	Name       string          `json:"name" binding:"required,max=64"`
+	Credential json.RawMessage `json:"credential" binding:"required"` // PublicKeyCredential as JSON
+}
+
+type FinishPasskeyLoginRequest struct {
+	CeremonyID string          `json:"ceremony_id" binding:"required"`
+	Credential json.RawMessage `json:"credential" binding:"required"`
+}
+
+type RenamePasskeyRequest struct {
+	Name string `json:"name" binding:"required,max=64"`
+}
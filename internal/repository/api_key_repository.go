@@ -0,0 +1,117 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// APIKeyRepository stores API keys in the organization of their owner. Keys
+// are presented without naming an organization, so FindByPrefix looks in all
+// of them and the caller acts in the key's organization from then on.
+type APIKeyRepository interface {
+	Create(ctx context.Context, key *models.APIKey) error
+	FindByPrefix(ctx context.Context, prefix string) (*models.APIKey, error)
+	FindByUser(ctx context.Context, userID string) ([]models.APIKey, error)
+	Touch(ctx context.Context, id primitive.ObjectID, at time.Time, ip string) error
+	Delete(ctx context.Context, userID, id string) error
+}
+
+type apiKeyRepository struct {
+	collection *mongo.Collection
+}
+
+func NewAPIKeyRepository(dbName string) APIKeyRepository {
+	return &apiKeyRepository{
+		collection: database.GetCollection(dbName, "api_keys"),
+	}
+}
+
+// Create adds the key to the organization in ctx
+func (r *apiKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return err
+	}
+	key.OrgID = orgID
+	key.CreatedAt = time.Now()
+	result, err := r.collection.InsertOne(ctx, key)
+	if err != nil {
+		return err
+	}
+	key.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *apiKeyRepository) FindByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	var key models.APIKey
+	err := r.collection.FindOne(ctx, bson.M{"prefix": prefix}).Decode(&key)
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+func (r *apiKeyRepository) FindByUser(ctx context.Context, userID string) ([]models.APIKey, error) {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := scoped(ctx, bson.M{"user_id": objID})
+	if err != nil {
+		return nil, err
+	}
+
+	keys := []models.APIKey{}
+	cursor, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (r *apiKeyRepository) Touch(ctx context.Context, id primitive.ObjectID, at time.Time, ip string) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"last_used_at": at, "last_used_ip": ip},
+	})
+	return err
+}
+
+// Delete removes a key, scoped to its owner so users cannot revoke others' keys
+func (r *apiKeyRepository) Delete(ctx context.Context, userID, id string) error {
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return mongo.ErrNoDocuments
+	}
+
+	filter, err := scoped(ctx, bson.M{"_id": objID, "user_id": userObjID})
+	if err != nil {
+		return err
+	}
+
+	result, err := r.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
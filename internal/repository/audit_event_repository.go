@@ -0,0 +1,136 @@
+package repository
+
+import (
+	"context"
+	"errors"
+
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// AuditEventRepository stores the audit log. Events are only ever appended.
+type AuditEventRepository interface {
+	Create(ctx context.Context, event *models.AuditEvent) error
+	Search(ctx context.Context, filter bson.M, skip, limit int64) ([]models.AuditEvent, int64, error)
+	Last(ctx context.Context) (*models.AuditEvent, error)
+	FindBySequence(ctx context.Context, sequence int64) (*models.AuditEvent, error)
+	Walk(ctx context.Context, fn func(*models.AuditEvent) error) error
+	CountUnchained(ctx context.Context) (int64, error)
+	BackfilledThrough(ctx context.Context) (int64, error)
+}
+
+// chainedEvents matches the events that are part of the hash chain
+var chainedEvents = bson.M{"sequence": bson.M{"$exists": true}}
+
+type auditEventRepository struct {
+	collection *mongo.Collection
+	backfills  *mongo.Collection
+}
+
+func NewAuditEventRepository(dbName string) AuditEventRepository {
+	return &auditEventRepository{
+		collection: database.GetCollection(dbName, "audit_events"),
+		backfills:  database.GetCollection(dbName, "audit_backfills"),
+	}
+}
+
+// Create inserts an event. Sequence numbers are unique, so two writers
+// appending after the same event get a duplicate key error for one of them.
+func (r *auditEventRepository) Create(ctx context.Context, event *models.AuditEvent) error {
+	result, err := r.collection.InsertOne(ctx, event)
+	if err != nil {
+		return err
+	}
+	event.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+// Last returns the chained event with the highest sequence number
+func (r *auditEventRepository) Last(ctx context.Context) (*models.AuditEvent, error) {
+	var event models.AuditEvent
+	opts := options.FindOne().SetSort(bson.D{{Key: "sequence", Value: -1}})
+	if err := r.collection.FindOne(ctx, chainedEvents, opts).Decode(&event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+func (r *auditEventRepository) FindBySequence(ctx context.Context, sequence int64) (*models.AuditEvent, error) {
+	var event models.AuditEvent
+	if err := r.collection.FindOne(ctx, bson.M{"sequence": sequence}).Decode(&event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
+
+// Walk calls fn for every chained event in sequence order, stopping at the
+// first error
+func (r *auditEventRepository) Walk(ctx context.Context, fn func(*models.AuditEvent) error) error {
+	cursor, err := r.collection.Find(ctx, chainedEvents, options.Find().SetSort(bson.D{{Key: "sequence", Value: 1}}))
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var event models.AuditEvent
+		if err := cursor.Decode(&event); err != nil {
+			return err
+		}
+		if err := fn(&event); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// CountUnchained counts events recorded before the audit log was hash chained
+func (r *auditEventRepository) CountUnchained(ctx context.Context) (int64, error) {
+	return r.collection.CountDocuments(ctx, bson.M{"sequence": bson.M{"$exists": false}})
+}
+
+// BackfilledThrough returns the highest sequence number among the events
+// migration 013 gave an organization, or 0 when it backfilled none
+func (r *auditEventRepository) BackfilledThrough(ctx context.Context) (int64, error) {
+	var backfill struct {
+		MaxSequence int64 `bson:"max_sequence"`
+	}
+	opts := options.FindOne().SetSort(bson.D{{Key: "max_sequence", Value: -1}})
+	err := r.backfills.FindOne(ctx, bson.M{}, opts).Decode(&backfill)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return backfill.MaxSequence, nil
+}
+
+// Search returns the matching events newest first with the total number of matches
+func (r *auditEventRepository) Search(ctx context.Context, filter bson.M, skip, limit int64) ([]models.AuditEvent, int64, error) {
+	total, err := r.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	events := []models.AuditEvent{}
+	opts := options.Find().
+		SetSort(bson.D{{Key: "created_at", Value: -1}, {Key: "_id", Value: -1}}).
+		SetSkip(skip).
+		SetLimit(limit)
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &events); err != nil {
+		return nil, 0, err
+	}
+	return events, total, nil
+}
@@ -0,0 +1,204 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type GroupRepository interface {
+	Create(ctx context.Context, group *models.Group) error
+	FindByID(ctx context.Context, id string) (*models.Group, error)
+	FindByMember(ctx context.Context, userID primitive.ObjectID) ([]models.Group, error)
+	Search(ctx context.Context, filter bson.M, skip, limit int64) ([]models.Group, int64, error)
+	Update(ctx context.Context, id string, group *models.Group) error
+	AddMembers(ctx context.Context, id string, members []primitive.ObjectID) error
+	RemoveMembers(ctx context.Context, id string, members []primitive.ObjectID) error
+	RemoveMemberFromAll(ctx context.Context, userID primitive.ObjectID) error
+	Delete(ctx context.Context, id string) error
+}
+
+type groupRepository struct {
+	collection *mongo.Collection
+}
+
+func NewGroupRepository(dbName string) GroupRepository {
+	return &groupRepository{
+		collection: database.GetCollection(dbName, "groups"),
+	}
+}
+
+// Create adds the group to the organization in ctx
+func (r *groupRepository) Create(ctx context.Context, group *models.Group) error {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return err
+	}
+	group.OrgID = orgID
+	group.CreatedAt = time.Now()
+	group.UpdatedAt = group.CreatedAt
+	if group.Members == nil {
+		group.Members = []primitive.ObjectID{}
+	}
+	result, err := r.collection.InsertOne(ctx, group)
+	if err != nil {
+		return err
+	}
+	group.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *groupRepository) FindByID(ctx context.Context, id string) (*models.Group, error) {
+	filter, err := r.idFilter(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	var group models.Group
+	if err := r.collection.FindOne(ctx, filter).Decode(&group); err != nil {
+		return nil, err
+	}
+	return &group, nil
+}
+
+func (r *groupRepository) FindByMember(ctx context.Context, userID primitive.ObjectID) ([]models.Group, error) {
+	filter, err := scoped(ctx, bson.M{"members": userID})
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := r.collection.Find(ctx, filter,
+		options.Find().SetProjection(bson.M{"display_name": 1}).SetSort(bson.M{"display_name": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	groups := []models.Group{}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// Search returns one page of the groups matching filter, oldest first, and
+// the total number of matches
+func (r *groupRepository) Search(ctx context.Context, filter bson.M, skip, limit int64) ([]models.Group, int64, error) {
+	filter, err := scoped(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := r.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	groups := []models.Group{}
+	if limit == 0 {
+		return groups, total, nil
+	}
+
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}).SetSkip(skip).SetLimit(limit))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, 0, err
+	}
+	return groups, total, nil
+}
+
+func (r *groupRepository) Update(ctx context.Context, id string, group *models.Group) error {
+	if group.Members == nil {
+		group.Members = []primitive.ObjectID{}
+	}
+	group.UpdatedAt = time.Now()
+	return r.updateFields(ctx, id, bson.M{
+		"$set": bson.M{
+			"display_name": group.DisplayName,
+			"external_id":  group.ExternalID,
+			"members":      group.Members,
+			"updated_at":   group.UpdatedAt,
+		},
+	})
+}
+
+func (r *groupRepository) AddMembers(ctx context.Context, id string, members []primitive.ObjectID) error {
+	return r.updateFields(ctx, id, bson.M{
+		"$addToSet": bson.M{"members": bson.M{"$each": members}},
+		"$set":      bson.M{"updated_at": time.Now()},
+	})
+}
+
+func (r *groupRepository) RemoveMembers(ctx context.Context, id string, members []primitive.ObjectID) error {
+	return r.updateFields(ctx, id, bson.M{
+		"$pull": bson.M{"members": bson.M{"$in": members}},
+		"$set":  bson.M{"updated_at": time.Now()},
+	})
+}
+
+func (r *groupRepository) RemoveMemberFromAll(ctx context.Context, userID primitive.ObjectID) error {
+	filter, err := scoped(ctx, bson.M{"members": userID})
+	if err != nil {
+		return err
+	}
+
+	_, err = r.collection.UpdateMany(ctx, filter, bson.M{
+		"$pull": bson.M{"members": userID},
+		"$set":  bson.M{"updated_at": time.Now()},
+	})
+	return err
+}
+
+func (r *groupRepository) Delete(ctx context.Context, id string) error {
+	filter, err := r.idFilter(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// idFilter matches the group with the given ID in the organization in ctx.
+// An ID that is not an ObjectID matches no group.
+func (r *groupRepository) idFilter(ctx context.Context, id string) (bson.M, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	return scoped(ctx, bson.M{"_id": objID})
+}
+
+func (r *groupRepository) updateFields(ctx context.Context, id string, update bson.M) error {
+	filter, err := r.idFilter(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MembershipRepository reads the roles users hold in the organization in
+// ctx. Memberships are written by the user repository together with the
+// users and roles they mirror.
+type MembershipRepository interface {
+	FindRoles(ctx context.Context, userID string) ([]string, error)
+}
+
+type membershipRepository struct {
+	collection *mongo.Collection
+}
+
+func NewMembershipRepository(dbName string) MembershipRepository {
+	return newMembershipRepository(dbName)
+}
+
+func newMembershipRepository(dbName string) *membershipRepository {
+	return &membershipRepository{
+		collection: database.GetCollection(dbName, "memberships"),
+	}
+}
+
+// FindRoles returns mongo.ErrNoDocuments if userID is not a member of the
+// organization, which includes subjects that are not users such as OAuth
+// clients
+func (r *membershipRepository) FindRoles(ctx context.Context, userID string) ([]string, error) {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	filter, err := scoped(ctx, bson.M{"user_id": objID})
+	if err != nil {
+		return nil, err
+	}
+
+	var membership models.Membership
+	if err := r.collection.FindOne(ctx, filter).Decode(&membership); err != nil {
+		return nil, err
+	}
+	return membership.Roles, nil
+}
+
+// set gives the user roles in the organization in ctx, creating the
+// membership if needed
+func (r *membershipRepository) set(ctx context.Context, userID primitive.ObjectID, roles []string) error {
+	filter, err := scoped(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return err
+	}
+	if roles == nil {
+		roles = []string{}
+	}
+
+	now := time.Now()
+	_, err = r.collection.UpdateOne(ctx, filter, bson.M{
+		"$set":         bson.M{"roles": roles, "updated_at": now},
+		"$setOnInsert": bson.M{"created_at": now},
+	}, options.Update().SetUpsert(true))
+	return err
+}
+
+func (r *membershipRepository) delete(ctx context.Context, userID primitive.ObjectID) error {
+	filter, err := scoped(ctx, bson.M{"user_id": userID})
+	if err != nil {
+		return err
+	}
+	_, err = r.collection.DeleteOne(ctx, filter)
+	return err
+}
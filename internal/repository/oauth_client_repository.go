@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// OAuthClientRepository stores OAuth clients in the organization they were
+// registered in. The token endpoint authenticates clients before it knows the
+// organization, so FindByClientID looks in all of them.
+type OAuthClientRepository interface {
+	Create(ctx context.Context, client *models.OAuthClient) error
+	FindAll(ctx context.Context) ([]models.OAuthClient, error)
+	FindByClientID(ctx context.Context, clientID string) (*models.OAuthClient, error)
+	Delete(ctx context.Context, clientID string) error
+}
+
+type oauthClientRepository struct {
+	collection *mongo.Collection
+}
+
+func NewOAuthClientRepository(dbName string) OAuthClientRepository {
+	return &oauthClientRepository{
+		collection: database.GetCollection(dbName, "oauth_clients"),
+	}
+}
+
+// Create adds the client to the organization in ctx
+func (r *oauthClientRepository) Create(ctx context.Context, client *models.OAuthClient) error {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return err
+	}
+	client.OrgID = orgID
+	client.CreatedAt = time.Now()
+	client.UpdatedAt = time.Now()
+	result, err := r.collection.InsertOne(ctx, client)
+	if err != nil {
+		return err
+	}
+	client.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *oauthClientRepository) FindAll(ctx context.Context) ([]models.OAuthClient, error) {
+	filter, err := scoped(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	var clients []models.OAuthClient
+	cursor, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, &clients); err != nil {
+		return nil, err
+	}
+	return clients, nil
+}
+
+func (r *oauthClientRepository) FindByClientID(ctx context.Context, clientID string) (*models.OAuthClient, error) {
+	var client models.OAuthClient
+	err := r.collection.FindOne(ctx, bson.M{"client_id": clientID}).Decode(&client)
+	if err != nil {
+		return nil, err
+	}
+	return &client, nil
+}
+
+func (r *oauthClientRepository) Delete(ctx context.Context, clientID string) error {
+	filter, err := scoped(ctx, bson.M{"client_id": clientID})
+	if err != nil {
+		return err
+	}
+
+	result, err := r.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type OrganizationRepository interface {
+	Create(ctx context.Context, org *models.Organization) error
+	FindAll(ctx context.Context) ([]models.Organization, error)
+	FindBySlug(ctx context.Context, slug string) (*models.Organization, error)
+	Delete(ctx context.Context, id primitive.ObjectID) error
+}
+
+type organizationRepository struct {
+	collection *mongo.Collection
+}
+
+func NewOrganizationRepository(dbName string) OrganizationRepository {
+	return &organizationRepository{
+		collection: database.GetCollection(dbName, "organizations"),
+	}
+}
+
+func (r *organizationRepository) Create(ctx context.Context, org *models.Organization) error {
+	org.CreatedAt = time.Now()
+	org.UpdatedAt = org.CreatedAt
+	result, err := r.collection.InsertOne(ctx, org)
+	if err != nil {
+		return err
+	}
+	org.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *organizationRepository) FindAll(ctx context.Context) ([]models.Organization, error) {
+	cursor, err := r.collection.Find(ctx, bson.M{}, options.Find().SetSort(bson.M{"slug": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	orgs := []models.Organization{}
+	if err := cursor.All(ctx, &orgs); err != nil {
+		return nil, err
+	}
+	return orgs, nil
+}
+
+func (r *organizationRepository) FindBySlug(ctx context.Context, slug string) (*models.Organization, error) {
+	var org models.Organization
+	if err := r.collection.FindOne(ctx, bson.M{"slug": slug}).Decode(&org); err != nil {
+		return nil, err
+	}
+	return &org, nil
+}
+
+func (r *organizationRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	_, err := r.collection.DeleteOne(ctx, bson.M{"_id": id})
+	return err
+}
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+type RoleRepository interface {
+	Create(ctx context.Context, role *models.Role) error
+	FindAll(ctx context.Context) ([]models.Role, error)
+	FindByName(ctx context.Context, name string) (*models.Role, error)
+	FindByNames(ctx context.Context, names []string) ([]models.Role, error)
+	Update(ctx context.Context, name string, role *models.Role) error
+	Delete(ctx context.Context, name string) error
+}
+
+type roleRepository struct {
+	collection *mongo.Collection
+}
+
+func NewRoleRepository(dbName string) RoleRepository {
+	return &roleRepository{
+		collection: database.GetCollection(dbName, "roles"),
+	}
+}
+
+func (r *roleRepository) Create(ctx context.Context, role *models.Role) error {
+	role.CreatedAt = time.Now()
+	role.UpdatedAt = time.Now()
+	result, err := r.collection.InsertOne(ctx, role)
+	if err != nil {
+		return err
+	}
+	role.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *roleRepository) FindAll(ctx context.Context) ([]models.Role, error) {
+	var roles []models.Role
+	cursor, err := r.collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (r *roleRepository) FindByName(ctx context.Context, name string) (*models.Role, error) {
+	var role models.Role
+	err := r.collection.FindOne(ctx, bson.M{"name": name}).Decode(&role)
+	if err != nil {
+		return nil, err
+	}
+	return &role, nil
+}
+
+func (r *roleRepository) FindByNames(ctx context.Context, names []string) ([]models.Role, error) {
+	var roles []models.Role
+	cursor, err := r.collection.Find(ctx, bson.M{"name": bson.M{"$in": names}})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, &roles); err != nil {
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (r *roleRepository) Update(ctx context.Context, name string, role *models.Role) error {
+	role.UpdatedAt = time.Now()
+	update := bson.M{
+		"$set": bson.M{
+			"description": role.Description,
+			"permissions": role.Permissions,
+			"updated_at":  role.UpdatedAt,
+		},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, bson.M{"name": name}, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *roleRepository) Delete(ctx context.Context, name string) error {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"name": name})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
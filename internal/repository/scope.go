@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"context"
+
+	"gin-mongo-aws/internal/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// scoped returns a copy of filter restricted to the organization in ctx. Any
+// org_id already in filter is replaced, so callers cannot widen the scope.
+func scoped(ctx context.Context, filter bson.M) (bson.M, error) {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return and(filter, bson.M{"org_id": orgID}), nil
+}
+
+// and returns a copy of filter with the conditions in extra added, replacing
+// any condition on the same field
+func and(filter, extra bson.M) bson.M {
+	out := make(bson.M, len(filter)+len(extra))
+	for key, value := range filter {
+		out[key] = value
+	}
+	for key, value := range extra {
+		out[key] = value
+	}
+	return out
+}
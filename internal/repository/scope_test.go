@@ -0,0 +1,60 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gin-mongo-aws/internal/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestScopedReplacesTheCallersOrganization(t *testing.T) {
+	orgID, other := primitive.NewObjectID(), primitive.NewObjectID()
+	ctx := tenant.WithOrgID(context.Background(), orgID)
+
+	filter := bson.M{"email": "user@example.com", "org_id": other}
+	got, err := scoped(ctx, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["org_id"] != orgID || got["email"] != "user@example.com" || len(got) != 2 {
+		t.Errorf("scoped = %v, want the email in %s", got, orgID.Hex())
+	}
+	if filter["org_id"] != other {
+		t.Errorf("the caller's filter was changed to %v", filter)
+	}
+
+	// Widening the scope with an operator is replaced as well
+	got, err = scoped(ctx, bson.M{"org_id": bson.M{"$in": []primitive.ObjectID{orgID, other}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got["org_id"] != orgID {
+		t.Errorf("org_id = %v, want %s", got["org_id"], orgID.Hex())
+	}
+}
+
+func TestScopedRequiresAnOrganization(t *testing.T) {
+	if _, err := scoped(context.Background(), bson.M{}); !errors.Is(err, tenant.ErrNoOrganization) {
+		t.Errorf("err = %v, want ErrNoOrganization", err)
+	}
+}
+
+func TestAndReplacesConditionsOnTheSameField(t *testing.T) {
+	filter := bson.M{"_id": 1, "provider": "github"}
+	got := and(filter, bson.M{"provider": bson.M{"$ne": "github"}, "org_id": 2})
+
+	want := bson.M{"_id": 1, "provider": bson.M{"$ne": "github"}, "org_id": 2}
+	if len(got) != len(want) || got["_id"] != 1 || got["org_id"] != 2 {
+		t.Errorf("and = %v, want %v", got, want)
+	}
+	if ne, ok := got["provider"].(bson.M); !ok || ne["$ne"] != "github" {
+		t.Errorf("provider = %v, want the added condition", got["provider"])
+	}
+	if len(filter) != 2 || filter["provider"] != "github" {
+		t.Errorf("the first filter was changed to %v", filter)
+	}
+}
@@ -0,0 +1,84 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+type SigningKeyRepository interface {
+	Create(ctx context.Context, key *models.SigningKey) error
+	FindAll(ctx context.Context) ([]models.SigningKey, error)
+	FindUsable(ctx context.Context, now time.Time) ([]models.SigningKey, error)
+	ScheduleRetirement(ctx context.Context, exceptKid string, retiresAt time.Time) error
+}
+
+type signingKeyRepository struct {
+	collection *mongo.Collection
+}
+
+func NewSigningKeyRepository(dbName string) SigningKeyRepository {
+	return &signingKeyRepository{
+		collection: database.GetCollection(dbName, "signing_keys"),
+	}
+}
+
+func (r *signingKeyRepository) Create(ctx context.Context, key *models.SigningKey) error {
+	key.CreatedAt = time.Now()
+	result, err := r.collection.InsertOne(ctx, key)
+	if err != nil {
+		return err
+	}
+	key.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *signingKeyRepository) FindAll(ctx context.Context) ([]models.SigningKey, error) {
+	return r.find(ctx, bson.M{})
+}
+
+// FindUsable returns the keys that have not retired yet, oldest activation first
+func (r *signingKeyRepository) FindUsable(ctx context.Context, now time.Time) ([]models.SigningKey, error) {
+	return r.find(ctx, bson.M{"$or": bson.A{
+		bson.M{"retires_at": nil},
+		bson.M{"retires_at": bson.M{"$gt": now}},
+	}})
+}
+
+// ScheduleRetirement sets the retirement date of every key that does not
+// have one yet or would retire later, except the key that replaces them
+func (r *signingKeyRepository) ScheduleRetirement(ctx context.Context, exceptKid string, retiresAt time.Time) error {
+	_, err := r.collection.UpdateMany(ctx,
+		bson.M{
+			"kid": bson.M{"$ne": exceptKid},
+			"$or": bson.A{
+				bson.M{"retires_at": nil},
+				bson.M{"retires_at": bson.M{"$gt": retiresAt}},
+			},
+		},
+		bson.M{"$set": bson.M{"retires_at": retiresAt}},
+	)
+	return err
+}
+
+func (r *signingKeyRepository) find(ctx context.Context, filter bson.M) ([]models.SigningKey, error) {
+	var keys []models.SigningKey
+	opts := options.Find().SetSort(bson.D{{Key: "activates_at", Value: 1}})
+	cursor, err := r.collection.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
@@ -6,31 +6,58 @@ import (
 
 	"gin-mongo-aws/internal/database"
 	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/tenant"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 type UserRepository interface {
 	Create(ctx context.Context, user *models.User) error
 	FindAll(ctx context.Context) ([]models.User, error)
 	FindByID(ctx context.Context, id string) (*models.User, error)
+	FindByEmail(ctx context.Context, email string) (*models.User, error)
+	Search(ctx context.Context, filter bson.M, skip, limit int64) ([]models.User, int64, error)
 	Update(ctx context.Context, id string, user *models.User) error
+	ReplaceProfile(ctx context.Context, id string, user *models.User) error
+	SetEmailVerified(ctx context.Context, id, email string) error
+	UpdatePassword(ctx context.Context, id string, passwordHash string) error
+	SetRoles(ctx context.Context, id string, roles []string) error
+	SetPendingMFASecret(ctx context.Context, id string, secret string) error
+	EnableMFA(ctx context.Context, id string, secret string, recoveryCodes []string) error
+	DisableMFA(ctx context.Context, id string) error
+	ConsumeRecoveryCode(ctx context.Context, id string, codeHash string) (bool, error)
+	FindByLinkedIdentity(ctx context.Context, provider, subject string) (*models.User, error)
+	AddLinkedIdentity(ctx context.Context, id string, identity models.LinkedIdentity) error
+	RemoveLinkedIdentity(ctx context.Context, id string, provider string) error
+	SetDirectoryProfile(ctx context.Context, id string, source, name string, roles []string) error
 	Delete(ctx context.Context, id string) error
 }
 
+// userRepository mirrors each user's roles into the memberships collection,
+// which permission checks read
 type userRepository struct {
-	collection *mongo.Collection
+	collection  *mongo.Collection
+	memberships *membershipRepository
 }
 
 func NewUserRepository(dbName string) UserRepository {
 	return &userRepository{
-		collection: database.GetCollection(dbName, "users"),
+		collection:  database.GetCollection(dbName, "users"),
+		memberships: newMembershipRepository(dbName),
 	}
 }
 
+// Create adds the user to the organization in ctx, with a membership for
+// their roles
 func (r *userRepository) Create(ctx context.Context, user *models.User) error {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return err
+	}
+	user.OrgID = orgID
 	user.CreatedAt = time.Now()
 	user.UpdatedAt = time.Now()
 	result, err := r.collection.InsertOne(ctx, user)
@@ -38,12 +65,23 @@ func (r *userRepository) Create(ctx context.Context, user *models.User) error {
 		return err
 	}
 	user.ID = result.InsertedID.(primitive.ObjectID)
+
+	if err := r.memberships.set(ctx, user.ID, user.Roles); err != nil {
+		// Do not leave a user behind that holds no roles
+		r.collection.DeleteOne(ctx, bson.M{"_id": user.ID})
+		return err
+	}
 	return nil
 }
 
 func (r *userRepository) FindAll(ctx context.Context) ([]models.User, error) {
+	filter, err := scoped(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
 	var users []models.User
-	cursor, err := r.collection.Find(ctx, bson.M{})
+	cursor, err := r.collection.Find(ctx, filter)
 	if err != nil {
 		return nil, err
 	}
@@ -56,21 +94,65 @@ func (r *userRepository) FindAll(ctx context.Context) ([]models.User, error) {
 }
 
 func (r *userRepository) FindByID(ctx context.Context, id string) (*models.User, error) {
-	objID, err := primitive.ObjectIDFromHex(id)
+	filter, err := r.idFilter(ctx, id)
 	if err != nil {
 		return nil, err
 	}
 
 	var user models.User
-	err = r.collection.FindOne(ctx, bson.M{"_id": objID}).Decode(&user)
+	err = r.collection.FindOne(ctx, filter).Decode(&user)
 	if err != nil {
 		return nil, err
 	}
 	return &user, nil
 }
 
+func (r *userRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	filter, err := scoped(ctx, bson.M{"email": email})
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	err = r.collection.FindOne(ctx, filter).Decode(&user)
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// Search returns one page of the users matching filter, oldest first, and
+// the total number of matches
+func (r *userRepository) Search(ctx context.Context, filter bson.M, skip, limit int64) ([]models.User, int64, error) {
+	filter, err := scoped(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	total, err := r.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	users := []models.User{}
+	if limit == 0 {
+		return users, total, nil
+	}
+
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetSort(bson.M{"_id": 1}).SetSkip(skip).SetLimit(limit))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer cursor.Close(ctx)
+
+	if err := cursor.All(ctx, &users); err != nil {
+		return nil, 0, err
+	}
+	return users, total, nil
+}
+
 func (r *userRepository) Update(ctx context.Context, id string, user *models.User) error {
-	objID, err := primitive.ObjectIDFromHex(id)
+	filter, err := r.idFilter(ctx, id)
 	if err != nil {
 		return err
 	}
@@ -78,22 +160,256 @@ func (r *userRepository) Update(ctx context.Context, id string, user *models.Use
 	user.UpdatedAt = time.Now()
 	update := bson.M{
 		"$set": bson.M{
-			"name":       user.Name,
-			"email":      user.Email,
-			"updated_at": user.UpdatedAt,
+			"name":           user.Name,
+			"email":          user.Email,
+			"email_verified": user.EmailVerified,
+			"updated_at":     user.UpdatedAt,
 		},
 	}
 
-	_, err = r.collection.UpdateOne(ctx, bson.M{"_id": objID}, update)
+	_, err = r.collection.UpdateOne(ctx, filter, update)
 	return err
 }
 
-func (r *userRepository) Delete(ctx context.Context, id string) error {
+// SetEmailVerified marks email verified if it is still the user's address
+func (r *userRepository) SetEmailVerified(ctx context.Context, id, email string) error {
+	filter, err := r.idFilter(ctx, id)
+	if err != nil {
+		return err
+	}
+	filter["email"] = email
+
+	update := bson.M{
+		"$set": bson.M{
+			"email_verified": true,
+			"updated_at":     time.Now(),
+		},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *userRepository) UpdatePassword(ctx context.Context, id string, passwordHash string) error {
+	filter, err := r.idFilter(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	update := bson.M{
+		"$set": bson.M{
+			"password_hash": passwordHash,
+			"updated_at":    time.Now(),
+		},
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *userRepository) SetRoles(ctx context.Context, id string, roles []string) error {
+	if err := r.updateFields(ctx, id, bson.M{
+		"$set": bson.M{"roles": roles, "updated_at": time.Now()},
+	}); err != nil {
+		return err
+	}
+	return r.setMembership(ctx, id, roles)
+}
+
+func (r *userRepository) SetPendingMFASecret(ctx context.Context, id string, secret string) error {
+	return r.updateFields(ctx, id, bson.M{
+		"$set": bson.M{"mfa_pending_secret": secret, "updated_at": time.Now()},
+	})
+}
+
+func (r *userRepository) EnableMFA(ctx context.Context, id string, secret string, recoveryCodes []string) error {
+	return r.updateFields(ctx, id, bson.M{
+		"$set": bson.M{
+			"mfa_enabled":    true,
+			"mfa_secret":     secret,
+			"recovery_codes": recoveryCodes,
+			"updated_at":     time.Now(),
+		},
+		"$unset": bson.M{"mfa_pending_secret": ""},
+	})
+}
+
+func (r *userRepository) DisableMFA(ctx context.Context, id string) error {
+	return r.updateFields(ctx, id, bson.M{
+		"$set": bson.M{"mfa_enabled": false, "updated_at": time.Now()},
+		"$unset": bson.M{
+			"mfa_secret":         "",
+			"mfa_pending_secret": "",
+			"recovery_codes":     "",
+		},
+	})
+}
+
+// ConsumeRecoveryCode atomically removes a recovery code and reports whether it was present
+func (r *userRepository) ConsumeRecoveryCode(ctx context.Context, id string, codeHash string) (bool, error) {
+	filter, err := r.idFilter(ctx, id)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := r.collection.UpdateOne(ctx,
+		and(filter, bson.M{"recovery_codes": codeHash}),
+		bson.M{"$pull": bson.M{"recovery_codes": codeHash}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return result.ModifiedCount == 1, nil
+}
+
+func (r *userRepository) FindByLinkedIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	filter, err := scoped(ctx, bson.M{"linked_identities.key": models.LinkedIdentityKey(provider, subject)})
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	if err := r.collection.FindOne(ctx, filter).Decode(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// AddLinkedIdentity links an external account unless the user already has one
+// from the same provider, in which case it returns mongo.ErrNoDocuments
+func (r *userRepository) AddLinkedIdentity(ctx context.Context, id string, identity models.LinkedIdentity) error {
+	filter, err := r.idFilter(ctx, id)
+	if err != nil {
+		return err
+	}
+	identity.Key = models.LinkedIdentityKey(identity.Provider, identity.Subject)
+
+	result, err := r.collection.UpdateOne(ctx,
+		and(filter, bson.M{"linked_identities.provider": bson.M{"$ne": identity.Provider}}),
+		bson.M{
+			"$push": bson.M{"linked_identities": identity},
+			"$set":  bson.M{"updated_at": time.Now()},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *userRepository) RemoveLinkedIdentity(ctx context.Context, id string, provider string) error {
+	filter, err := r.idFilter(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.collection.UpdateOne(ctx,
+		and(filter, bson.M{"linked_identities.provider": provider}),
+		bson.M{
+			"$pull": bson.M{"linked_identities": bson.M{"provider": provider}},
+			"$set":  bson.M{"updated_at": time.Now()},
+		},
+	)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// ReplaceProfile overwrites the fields a provisioning system manages
+func (r *userRepository) ReplaceProfile(ctx context.Context, id string, user *models.User) error {
+	user.UpdatedAt = time.Now()
+	return r.updateFields(ctx, id, bson.M{
+		"$set": bson.M{
+			"name":        user.Name,
+			"given_name":  user.GivenName,
+			"family_name": user.FamilyName,
+			"email":       user.Email,
+			"external_id": user.ExternalID,
+			"disabled":    user.Disabled,
+			"updated_at":  user.UpdatedAt,
+		},
+	})
+}
+
+// SetDirectoryProfile hands an account over to an external directory, which
+// then owns its name and roles. Any local password is removed.
+func (r *userRepository) SetDirectoryProfile(ctx context.Context, id string, source, name string, roles []string) error {
+	if err := r.updateFields(ctx, id, bson.M{
+		"$set": bson.M{
+			"auth_source":    source,
+			"name":           name,
+			"roles":          roles,
+			"email_verified": true,
+			"updated_at":     time.Now(),
+		},
+		"$unset": bson.M{"password_hash": ""},
+	}); err != nil {
+		return err
+	}
+	return r.setMembership(ctx, id, roles)
+}
+
+// setMembership mirrors roles just written to the user with the given ID
+func (r *userRepository) setMembership(ctx context.Context, id string, roles []string) error {
 	objID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return err
 	}
+	return r.memberships.set(ctx, objID, roles)
+}
 
-	_, err = r.collection.DeleteOne(ctx, bson.M{"_id": objID})
-	return err
+// idFilter matches the user with the given ID in the organization in ctx
+func (r *userRepository) idFilter(ctx context.Context, id string) (bson.M, error) {
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	return scoped(ctx, bson.M{"_id": objID})
+}
+
+func (r *userRepository) updateFields(ctx context.Context, id string, update bson.M) error {
+	filter, err := r.idFilter(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func (r *userRepository) Delete(ctx context.Context, id string) error {
+	filter, err := r.idFilter(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if _, err := r.collection.DeleteOne(ctx, filter); err != nil {
+		return err
+	}
+	return r.memberships.delete(ctx, filter["_id"].(primitive.ObjectID))
 }
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestUserRepositoryScopesQueries(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	orgID, other := primitive.NewObjectID(), primitive.NewObjectID()
+	ctx := tenant.WithOrgID(context.Background(), orgID)
+
+	mt.Run("search", func(mt *mtest.T) {
+		repo := &userRepository{collection: mt.Coll}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch, bson.D{{Key: "n", Value: 1}}))
+
+		if _, _, err := repo.Search(ctx, bson.M{"org_id": other, "active": true}, 0, 0); err != nil {
+			mt.Fatal(err)
+		}
+		match := mt.GetStartedEvent().Command.Lookup("pipeline").Array().Index(0).Value().Document().Lookup("$match").Document()
+		if got := match.Lookup("org_id").ObjectID(); got != orgID {
+			mt.Errorf("searched org_id %s, want %s", got.Hex(), orgID.Hex())
+		}
+		if !match.Lookup("active").Boolean() {
+			mt.Errorf("the caller's conditions were dropped: %v", match)
+		}
+	})
+
+	mt.Run("find by ID", func(mt *mtest.T) {
+		repo := &userRepository{collection: mt.Coll}
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "db.users", mtest.FirstBatch))
+
+		id := primitive.NewObjectID()
+		if _, err := repo.FindByID(ctx, id.Hex()); !errors.Is(err, mongo.ErrNoDocuments) {
+			mt.Fatalf("err = %v, want ErrNoDocuments", err)
+		}
+		filter := mt.GetStartedEvent().Command.Lookup("filter").Document()
+		if filter.Lookup("_id").ObjectID() != id || filter.Lookup("org_id").ObjectID() != orgID {
+			mt.Errorf("filter = %v, want the user in %s", filter, orgID.Hex())
+		}
+	})
+
+	mt.Run("create", func(mt *mtest.T) {
+		repo := &userRepository{collection: mt.Coll, memberships: &membershipRepository{collection: mt.Coll}}
+		mt.AddMockResponses(mtest.CreateSuccessResponse(), mtest.CreateSuccessResponse())
+
+		// An organization set by the caller is overwritten
+		if err := repo.Create(ctx, &models.User{Email: "user@example.com", OrgID: other}); err != nil {
+			mt.Fatal(err)
+		}
+		inserted := mt.GetStartedEvent().Command.Lookup("documents").Array().Index(0).Value().Document()
+		if got := inserted.Lookup("org_id").ObjectID(); got != orgID {
+			mt.Errorf("inserted into %s, want %s", got.Hex(), orgID.Hex())
+		}
+		membership := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document().Lookup("q").Document()
+		if got := membership.Lookup("org_id").ObjectID(); got != orgID {
+			mt.Errorf("membership in %s, want %s", got.Hex(), orgID.Hex())
+		}
+	})
+
+	mt.Run("no organization", func(mt *mtest.T) {
+		repo := &userRepository{collection: mt.Coll}
+		if _, _, err := repo.Search(context.Background(), bson.M{}, 0, 10); !errors.Is(err, tenant.ErrNoOrganization) {
+			mt.Errorf("err = %v, want ErrNoOrganization", err)
+		}
+		if started := mt.GetStartedEvent(); started != nil {
+			mt.Errorf("sent %s without an organization", started.CommandName)
+		}
+	})
+}
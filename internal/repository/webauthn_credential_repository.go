@@ -0,0 +1,141 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// WebAuthnCredentialRepository stores passkeys in the organization of their
+// owner. A passkey login names no organization, so FindByCredentialID looks
+// in all of them and the login acts in the passkey's organization.
+type WebAuthnCredentialRepository interface {
+	Create(ctx context.Context, credential *models.WebAuthnCredential) error
+	FindByCredentialID(ctx context.Context, credentialID []byte) (*models.WebAuthnCredential, error)
+	FindByUser(ctx context.Context, userID string) ([]models.WebAuthnCredential, error)
+	RecordUse(ctx context.Context, id primitive.ObjectID, signCount uint32, backupState bool, at time.Time) error
+	Rename(ctx context.Context, userID, id, name string) error
+	Delete(ctx context.Context, userID, id string) error
+}
+
+type webAuthnCredentialRepository struct {
+	collection *mongo.Collection
+}
+
+func NewWebAuthnCredentialRepository(dbName string) WebAuthnCredentialRepository {
+	return &webAuthnCredentialRepository{
+		collection: database.GetCollection(dbName, "webauthn_credentials"),
+	}
+}
+
+// Create adds the passkey to the organization in ctx
+func (r *webAuthnCredentialRepository) Create(ctx context.Context, credential *models.WebAuthnCredential) error {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return err
+	}
+	credential.OrgID = orgID
+	credential.CreatedAt = time.Now()
+	result, err := r.collection.InsertOne(ctx, credential)
+	if err != nil {
+		return err
+	}
+	credential.ID = result.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (r *webAuthnCredentialRepository) FindByCredentialID(ctx context.Context, credentialID []byte) (*models.WebAuthnCredential, error) {
+	var credential models.WebAuthnCredential
+	err := r.collection.FindOne(ctx, bson.M{"credential_id": credentialID}).Decode(&credential)
+	if err != nil {
+		return nil, err
+	}
+	return &credential, nil
+}
+
+func (r *webAuthnCredentialRepository) FindByUser(ctx context.Context, userID string) ([]models.WebAuthnCredential, error) {
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := scoped(ctx, bson.M{"user_id": objID})
+	if err != nil {
+		return nil, err
+	}
+
+	credentials := []models.WebAuthnCredential{}
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if err = cursor.All(ctx, &credentials); err != nil {
+		return nil, err
+	}
+	return credentials, nil
+}
+
+// RecordUse stores the authenticator's new signature counter and backup state
+// after a successful login
+func (r *webAuthnCredentialRepository) RecordUse(ctx context.Context, id primitive.ObjectID, signCount uint32, backupState bool, at time.Time) error {
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$set": bson.M{"sign_count": signCount, "backup_state": backupState, "last_used_at": at},
+	})
+	return err
+}
+
+// Rename changes a passkey's name, scoped to its owner
+func (r *webAuthnCredentialRepository) Rename(ctx context.Context, userID, id, name string) error {
+	filter, err := ownedCredentialFilter(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"name": name}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+// Delete removes a passkey, scoped to its owner
+func (r *webAuthnCredentialRepository) Delete(ctx context.Context, userID, id string) error {
+	filter, err := ownedCredentialFilter(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := r.collection.DeleteOne(ctx, filter)
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+	return nil
+}
+
+func ownedCredentialFilter(ctx context.Context, userID, id string) (bson.M, error) {
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	return scoped(ctx, bson.M{"_id": objID, "user_id": userObjID})
+}
@@ -0,0 +1,54 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gin-mongo-aws/internal/config"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestNewEngineClientIP(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name    string
+		proxies []string
+		remote  string
+		want    string
+	}{
+		{name: "spoofed header without trusted proxies", remote: "203.0.113.7:41000", want: "203.0.113.7"},
+		{name: "spoofed header from untrusted peer", proxies: []string{"10.0.0.0/8"}, remote: "203.0.113.7:41000", want: "203.0.113.7"},
+		{name: "header from trusted proxy", proxies: []string{"10.0.0.0/8"}, remote: "10.1.2.3:41000", want: "198.51.100.9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newEngine(config.ServerConfig{TrustedProxies: tt.proxies})
+			if err != nil {
+				t.Fatalf("newEngine: %v", err)
+			}
+			r.GET("/ip", func(c *gin.Context) {
+				c.String(http.StatusOK, c.ClientIP())
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+			req.RemoteAddr = tt.remote
+			req.Header.Set("X-Forwarded-For", "198.51.100.9")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if got := w.Body.String(); got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewEngineRejectsInvalidProxy(t *testing.T) {
+	if _, err := newEngine(config.ServerConfig{TrustedProxies: []string{"not-an-ip"}}); err == nil {
+		t.Fatal("expected an error for an invalid trusted proxy")
+	}
+}
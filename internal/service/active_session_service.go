@@ -0,0 +1,151 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+
+	"gin-mongo-aws/internal/models"
+)
+
+var ErrSessionNotFound = errors.New("session not found")
+
+// ActiveSessionService lists and revokes a user's logins across the refresh
+// token and cookie session stores
+type ActiveSessionService interface {
+	List(ctx context.Context, userID, currentID string) ([]models.ActiveSession, error)
+	Revoke(ctx context.Context, userID, id string) error
+	RevokeOthers(ctx context.Context, userID, currentID string) (int, error)
+}
+
+type activeSessionService struct {
+	refresh  RefreshTokenService
+	sessions SessionService
+}
+
+func NewActiveSessionService(refresh RefreshTokenService, sessions SessionService) ActiveSessionService {
+	return &activeSessionService{refresh: refresh, sessions: sessions}
+}
+
+func (s *activeSessionService) List(ctx context.Context, userID, currentID string) ([]models.ActiveSession, error) {
+	families, err := s.refresh.ListForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := s.sessions.ListForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	active := make([]models.ActiveSession, 0, len(families)+len(sessions))
+	for _, f := range families {
+		active = append(active, models.ActiveSession{
+			ID:         f.ID,
+			Type:       models.SessionTypeRefreshToken,
+			Device:     describeDevice(f.UserAgent),
+			IP:         f.IP,
+			UserAgent:  f.UserAgent,
+			ClientID:   f.ClientID,
+			CreatedAt:  f.CreatedAt,
+			LastUsedAt: f.LastUsedAt,
+			Current:    f.ID == currentID,
+		})
+	}
+	for _, sess := range sessions {
+		active = append(active, models.ActiveSession{
+			ID:         sess.ID,
+			Type:       models.SessionTypeCookie,
+			Device:     describeDevice(sess.UserAgent),
+			IP:         sess.IP,
+			UserAgent:  sess.UserAgent,
+			CreatedAt:  sess.CreatedAt,
+			LastUsedAt: sess.LastSeenAt,
+			Current:    sess.ID == currentID,
+		})
+	}
+
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].LastUsedAt.After(active[j].LastUsedAt)
+	})
+	return active, nil
+}
+
+func (s *activeSessionService) Revoke(ctx context.Context, userID, id string) error {
+	active, err := s.List(ctx, userID, "")
+	if err != nil {
+		return err
+	}
+
+	for _, a := range active {
+		if a.ID == id {
+			return s.revoke(ctx, a)
+		}
+	}
+	return ErrSessionNotFound
+}
+
+func (s *activeSessionService) RevokeOthers(ctx context.Context, userID, currentID string) (int, error) {
+	active, err := s.List(ctx, userID, currentID)
+	if err != nil {
+		return 0, err
+	}
+
+	revoked := 0
+	for _, a := range active {
+		if a.Current {
+			continue
+		}
+		if err := s.revoke(ctx, a); err != nil {
+			return revoked, err
+		}
+		revoked++
+	}
+	return revoked, nil
+}
+
+func (s *activeSessionService) revoke(ctx context.Context, a models.ActiveSession) error {
+	if a.Type == models.SessionTypeCookie {
+		return s.sessions.RevokeByID(ctx, a.ID)
+	}
+	return s.refresh.RevokeFamily(ctx, a.ID)
+}
+
+// describeDevice turns a user agent into a short "Browser on OS" label
+func describeDevice(userAgent string) string {
+	ua := strings.ToLower(userAgent)
+
+	browser := "Unknown browser"
+	switch {
+	case strings.Contains(ua, "edg/"):
+		browser = "Edge"
+	case strings.Contains(ua, "opr/") || strings.Contains(ua, "opera"):
+		browser = "Opera"
+	case strings.Contains(ua, "firefox/"):
+		browser = "Firefox"
+	case strings.Contains(ua, "chrome/"):
+		browser = "Chrome"
+	case strings.Contains(ua, "safari/"):
+		browser = "Safari"
+	case strings.Contains(ua, "curl/"):
+		browser = "curl"
+	case strings.Contains(ua, "go-http-client"):
+		browser = "Go HTTP client"
+	}
+
+	os := "unknown OS"
+	switch {
+	case strings.Contains(ua, "android"):
+		os = "Android"
+	case strings.Contains(ua, "iphone") || strings.Contains(ua, "ipad"):
+		os = "iOS"
+	case strings.Contains(ua, "windows"):
+		os = "Windows"
+	case strings.Contains(ua, "mac os"):
+		os = "macOS"
+	case strings.Contains(ua, "linux"):
+		os = "Linux"
+	}
+
+	return browser + " on " + os
+}
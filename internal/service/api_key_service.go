@@ -0,0 +1,222 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"gin-mongo-aws/internal/logger"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/repository"
+	"gin-mongo-aws/internal/tenant"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidAPIKey        = errors.New("invalid or expired api key")
+	ErrAPIKeyNotFound       = errors.New("api key not found")
+	ErrInvalidAPIKeyRequest = errors.New("invalid api key request")
+)
+
+const (
+	apiKeyPrefix = "gma_"
+	// lastUsedResolution limits how often a busy key writes its last-used time
+	lastUsedResolution = time.Minute
+)
+
+// APIKeyService manages user-owned API keys. A key authenticates as its
+// owner, but only for the permissions listed in its scopes.
+type APIKeyService interface {
+	Create(ctx context.Context, userID string, req *models.CreateAPIKeyRequest) (*models.APIKeyCreated, error)
+	List(ctx context.Context, userID string) ([]models.APIKey, error)
+	Revoke(ctx context.Context, userID, id string) error
+	Authenticate(ctx context.Context, key, ip string) (*Claims, error)
+}
+
+type apiKeyService struct {
+	repo  repository.APIKeyRepository
+	users repository.UserRepository
+}
+
+func NewAPIKeyService(repo repository.APIKeyRepository, users repository.UserRepository) APIKeyService {
+	return &apiKeyService{repo: repo, users: users}
+}
+
+func (s *apiKeyService) Create(ctx context.Context, userID string, req *models.CreateAPIKeyRequest) (*models.APIKeyCreated, error) {
+	ownerID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := dedupe(req.Scopes)
+	for _, scope := range scopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\n") {
+			return nil, fmt.Errorf("%w: invalid scope %q", ErrInvalidAPIKeyRequest, scope)
+		}
+	}
+	allowed, err := normalizeAllowedIPs(req.AllowedIPs)
+	if err != nil {
+		return nil, err
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return nil, fmt.Errorf("%w: expires_at must be in the future", ErrInvalidAPIKeyRequest)
+	}
+
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	prefix := hex.EncodeToString(b)
+	secret, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &models.APIKey{
+		UserID:     ownerID,
+		Name:       req.Name,
+		Prefix:     prefix,
+		SecretHash: hashToken(secret),
+		Scopes:     scopes,
+		AllowedIPs: allowed,
+		ExpiresAt:  req.ExpiresAt,
+	}
+	if err := s.repo.Create(ctx, key); err != nil {
+		return nil, err
+	}
+
+	logger.Log.Info("API key created", zap.String("user_id", userID), zap.String("prefix", prefix))
+	return &models.APIKeyCreated{APIKey: key, Key: apiKeyPrefix + prefix + "_" + secret}, nil
+}
+
+func (s *apiKeyService) List(ctx context.Context, userID string) ([]models.APIKey, error) {
+	return s.repo.FindByUser(ctx, userID)
+}
+
+func (s *apiKeyService) Revoke(ctx context.Context, userID, id string) error {
+	err := s.repo.Delete(ctx, userID, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrAPIKeyNotFound
+	}
+	if err == nil {
+		logger.Log.Info("API key revoked", zap.String("user_id", userID), zap.String("api_key_id", id))
+	}
+	return err
+}
+
+// Authenticate checks a presented key and returns claims for its owner. The
+// owner's roles are loaded fresh so removing a role also limits their keys.
+// The key is looked up in every organization and its owner in the key's own,
+// whichever organization the request named.
+func (s *apiKeyService) Authenticate(ctx context.Context, presented, ip string) (*Claims, error) {
+	rest, ok := strings.CutPrefix(presented, apiKeyPrefix)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || prefix == "" || secret == "" {
+		return nil, ErrInvalidAPIKey
+	}
+
+	key, err := s.repo.FindByPrefix(ctx, prefix)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(key.SecretHash)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	now := time.Now()
+	if key.ExpiresAt != nil && !now.Before(*key.ExpiresAt) {
+		return nil, ErrInvalidAPIKey
+	}
+	if !ipAllowed(key.AllowedIPs, ip) {
+		logger.Log.Warn("API key used from a disallowed IP", zap.String("prefix", prefix), zap.String("ip", ip))
+		return nil, ErrInvalidAPIKey
+	}
+
+	if key.OrgID.IsZero() {
+		return nil, ErrInvalidAPIKey
+	}
+	user, err := s.users.FindByID(tenant.WithOrgID(ctx, key.OrgID), key.UserID.Hex())
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, ErrInvalidAPIKey
+	}
+
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= lastUsedResolution || key.LastUsedIP != ip {
+		if err := s.repo.Touch(ctx, key.ID, now, ip); err != nil {
+			logger.Log.Error("Failed to record API key use", zap.Error(err))
+		}
+	}
+
+	return &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:      key.ID.Hex(),
+			Subject: user.ID.Hex(),
+		},
+		Email:    user.Email,
+		Roles:    user.Roles,
+		Scope:    strings.Join(key.Scopes, " "),
+		OrgID:    user.OrgID.Hex(),
+		APIKeyID: key.ID.Hex(),
+	}, nil
+}
+
+// normalizeAllowedIPs accepts single addresses and CIDR ranges and stores
+// every entry as a CIDR
+func normalizeAllowedIPs(entries []string) ([]string, error) {
+	out := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if _, network, err := net.ParseCIDR(entry); err == nil {
+			out = append(out, network.String())
+			continue
+		}
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, fmt.Errorf("%w: %q is not an IP address or CIDR range", ErrInvalidAPIKeyRequest, entry)
+		}
+		if ip.To4() != nil {
+			out = append(out, ip.String()+"/32")
+		} else {
+			out = append(out, ip.String()+"/128")
+		}
+	}
+	return dedupe(out), nil
+}
+
+func ipAllowed(allowed []string, ip string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, cidr := range allowed {
+		if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/tenant"
+	"gin-mongo-aws/internal/testutil"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAPIKeyAuthenticatesInItsOrganization(t *testing.T) {
+	users := testutil.NewUserRepository()
+	keys := testutil.NewAPIKeyRepository()
+	svc := NewAPIKeyService(keys, users)
+
+	acme := tenant.WithOrgID(context.Background(), primitive.NewObjectID())
+	globex := tenant.WithOrgID(context.Background(), primitive.NewObjectID())
+	owner := &models.User{Name: "Script Owner", Email: "owner@example.com", Roles: []string{models.RoleUser}}
+	if err := users.Create(acme, owner); err != nil {
+		t.Fatal(err)
+	}
+	created, err := svc.Create(acme, owner.ID.Hex(), &models.CreateAPIKeyRequest{Name: "ci", Scopes: []string{"users:read"}})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+
+	// The key is presented without naming its organization, or naming another
+	for name, ctx := range map[string]context.Context{"its organization": acme, "another organization": globex} {
+		claims, err := svc.Authenticate(ctx, created.Key, "192.0.2.1")
+		if err != nil {
+			t.Fatalf("%s: Authenticate: %v", name, err)
+		}
+		if claims.Subject != owner.ID.Hex() || claims.OrgID != owner.OrgID.Hex() {
+			t.Errorf("%s: claims = %+v, want the owner in their organization", name, claims)
+		}
+	}
+
+	// Listing and revoking stay within the organization
+	if listed, err := svc.List(globex, owner.ID.Hex()); err != nil || len(listed) != 0 {
+		t.Errorf("another organization listed %v (err %v)", listed, err)
+	}
+	if err := svc.Revoke(globex, owner.ID.Hex(), created.APIKey.ID.Hex()); !errors.Is(err, ErrAPIKeyNotFound) {
+		t.Errorf("revoking from another organization: err = %v, want ErrAPIKeyNotFound", err)
+	}
+	if err := svc.Revoke(acme, owner.ID.Hex(), created.APIKey.ID.Hex()); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if _, err := svc.Authenticate(acme, created.Key, "192.0.2.1"); !errors.Is(err, ErrInvalidAPIKey) {
+		t.Errorf("revoked key: err = %v, want ErrInvalidAPIKey", err)
+	}
+}
@@ -0,0 +1,295 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gin-mongo-aws/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrAuditCheckpointsDisabled = errors.New("audit checkpoints are disabled; set audit.checkpoint_key_file")
+	ErrAuditLogEmpty            = errors.New("the audit log has no chained events")
+
+	errAuditChainBroken = errors.New("audit chain broken")
+)
+
+// auditAppendAttempts bounds the retries when another instance appends to
+// the chain between reading its head and inserting
+const auditAppendAttempts = 5
+
+// auditCheckpointClaims are the claims of a signed audit checkpoint
+type auditCheckpointClaims struct {
+	jwt.RegisteredClaims
+	Sequence int64  `json:"seq"`
+	Hash     string `json:"hash"`
+	EventID  string `json:"event_id"`
+}
+
+// appendEvent links the event to the head of the chain and inserts it. The
+// mutex orders appends within this instance; the unique sequence index
+// orders them across instances.
+func (s *auditService) appendEvent(ctx context.Context, event *models.AuditEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for attempt := 1; ; attempt++ {
+		last, err := s.repo.Last(ctx)
+		switch {
+		case errors.Is(err, mongo.ErrNoDocuments):
+			event.Sequence, event.PrevHash = 1, ""
+		case err != nil:
+			return err
+		default:
+			event.Sequence, event.PrevHash = last.Sequence+1, last.Hash
+		}
+
+		sealed, err := sealAuditEvent(event)
+		if err != nil {
+			return err
+		}
+		err = s.repo.Create(ctx, sealed)
+		if mongo.IsDuplicateKeyError(err) && attempt < auditAppendAttempts {
+			continue
+		}
+		return err
+	}
+}
+
+// Verify walks the chain from the first event and reports the first event
+// whose content does not match its hash or that does not link to the event
+// before it. Each checkpoint must then match the event it was signed for,
+// which catches a chain that was truncated or rewritten from some point on.
+func (s *auditService) Verify(ctx context.Context, checkpoints []string) (*models.AuditVerification, error) {
+	unchained, err := s.repo.CountUnchained(ctx)
+	if err != nil {
+		return nil, err
+	}
+	backfilledThrough, err := s.repo.BackfilledThrough(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result := &models.AuditVerification{Unchained: unchained}
+
+	var previous *models.AuditEvent
+	err = s.repo.Walk(ctx, func(event *models.AuditEvent) error {
+		reason, err := auditLinkError(previous, event, backfilledThrough)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			result.Broken = &models.AuditBrokenLink{Sequence: event.Sequence, EventID: event.ID.Hex(), Reason: reason}
+			return errAuditChainBroken
+		}
+
+		result.Events++
+		result.HeadSequence = event.Sequence
+		result.HeadHash = event.Hash
+		previous = event
+		return nil
+	})
+	if err != nil && !errors.Is(err, errAuditChainBroken) {
+		return nil, err
+	}
+	if result.Broken != nil {
+		return result, nil
+	}
+
+	for _, token := range checkpoints {
+		broken, err := s.verifyCheckpoint(ctx, token)
+		if err != nil {
+			return nil, err
+		}
+		if broken != nil {
+			result.Broken = broken
+			return result, nil
+		}
+		result.Checkpoints++
+	}
+	return result, nil
+}
+
+// Checkpoint signs the sequence number and hash of the newest event
+func (s *auditService) Checkpoint(ctx context.Context) (*models.AuditCheckpoint, error) {
+	if s.checkpointKey == nil {
+		return nil, ErrAuditCheckpointsDisabled
+	}
+
+	head, err := s.repo.Last(ctx)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrAuditLogEmpty
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Refuse to vouch for a head event that was modified
+	backfilledThrough, err := s.repo.BackfilledThrough(ctx)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := auditEventHash(head, backfilledThrough)
+	if err != nil {
+		return nil, err
+	}
+	if hash != head.Hash {
+		return nil, fmt.Errorf("%w: event %d does not match its hash", errAuditChainBroken, head.Sequence)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, auditCheckpointClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:   s.issuer,
+			Subject:  "audit_events",
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+		},
+		Sequence: head.Sequence,
+		Hash:     head.Hash,
+		EventID:  head.ID.Hex(),
+	})
+	token.Header["kid"] = s.checkpointKid
+	signed, err := token.SignedString(s.checkpointKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.AuditCheckpoint{
+		Sequence:  head.Sequence,
+		Hash:      head.Hash,
+		EventID:   head.ID.Hex(),
+		CreatedAt: head.CreatedAt,
+		Token:     signed,
+	}, nil
+}
+
+// verifyCheckpoint checks a checkpoint's signature and that the event it
+// names still has the hash it was signed with
+func (s *auditService) verifyCheckpoint(ctx context.Context, token string) (*models.AuditBrokenLink, error) {
+	if s.checkpointKey == nil {
+		return nil, ErrAuditCheckpointsDisabled
+	}
+
+	claims := &auditCheckpointClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (interface{}, error) {
+		return s.checkpointKey.Public(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodEdDSA.Alg()}), jwt.WithIssuer(s.issuer))
+	if err != nil {
+		return nil, fmt.Errorf("invalid audit checkpoint: %w", err)
+	}
+
+	event, err := s.repo.FindBySequence(ctx, claims.Sequence)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return &models.AuditBrokenLink{
+			Sequence: claims.Sequence,
+			EventID:  claims.EventID,
+			Reason:   "event named by a checkpoint is missing; the log was truncated",
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if event.Hash != claims.Hash || event.ID.Hex() != claims.EventID {
+		return &models.AuditBrokenLink{
+			Sequence: claims.Sequence,
+			EventID:  event.ID.Hex(),
+			Reason:   "event does not match the checkpoint signed for it; the log was rewritten",
+		}, nil
+	}
+	return nil, nil
+}
+
+// auditLinkError describes why event does not follow previous in the chain,
+// or returns "" when it does
+func auditLinkError(previous, event *models.AuditEvent, backfilledThrough int64) (string, error) {
+	hash, err := auditEventHash(event, backfilledThrough)
+	if err != nil {
+		return "", err
+	}
+	if hash != event.Hash {
+		return "event content does not match its hash; the event was modified", nil
+	}
+
+	expectedSequence, expectedPrevHash := int64(1), ""
+	if previous != nil {
+		expectedSequence, expectedPrevHash = previous.Sequence+1, previous.Hash
+	}
+	if event.Sequence != expectedSequence {
+		return fmt.Sprintf("expected sequence %d; events before this one were removed", expectedSequence), nil
+	}
+	if event.PrevHash != expectedPrevHash {
+		return "prev_hash does not match the previous event; an earlier event was replaced", nil
+	}
+	return "", nil
+}
+
+// sealAuditEvent gives the event an ID and its hash. The event is first
+// round-tripped through BSON so it is hashed exactly as it will be read back,
+// with times at millisecond precision and nested values in stored order.
+func sealAuditEvent(event *models.AuditEvent) (*models.AuditEvent, error) {
+	event.ID = primitive.NewObjectID()
+	event.Hash = ""
+	data, err := bson.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+
+	var sealed models.AuditEvent
+	if err := bson.Unmarshal(data, &sealed); err != nil {
+		return nil, err
+	}
+	if sealed.Hash, err = auditEventHash(&sealed, 0); err != nil {
+		return nil, err
+	}
+	return &sealed, nil
+}
+
+// auditEventHash is the SHA-256 of the event's BSON encoding without its
+// hash. An organization added by migration 013 is left out, so the event
+// hashes as it was recorded; only events up to backfilledThrough, the last
+// sequence the migration backfilled, are exempt. On any later event the
+// backfilled flag is hashed like other fields and cannot be added unnoticed.
+func auditEventHash(event *models.AuditEvent, backfilledThrough int64) (string, error) {
+	unsealed := *event
+	unsealed.Hash = ""
+	if unsealed.Backfilled && unsealed.Sequence > 0 && unsealed.Sequence <= backfilledThrough {
+		unsealed.OrgID = ""
+		unsealed.Backfilled = false
+	}
+	data, err := bson.Marshal(&unsealed)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// loadCheckpointKey reads the Ed25519 key checkpoints are signed with
+func loadCheckpointKey(path string) (ed25519.PrivateKey, string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read audit checkpoint key: %w", err)
+	}
+	parsed, err := jwt.ParseEdPrivateKeyFromPEM(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to parse audit checkpoint key: %w", err)
+	}
+	key, ok := parsed.(ed25519.PrivateKey)
+	if !ok {
+		return nil, "", errors.New("audit.checkpoint_key_file is not an Ed25519 key")
+	}
+
+	jwk, err := publicJWK(key.Public(), jwt.SigningMethodEdDSA.Alg())
+	if err != nil {
+		return nil, "", err
+	}
+	return key, jwk.Kid, nil
+}
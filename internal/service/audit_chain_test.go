@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"testing"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fakeAuditEventRepository keeps the audit chain in memory, in sequence order
+type fakeAuditEventRepository struct {
+	mu                sync.Mutex
+	events            []*models.AuditEvent
+	backfilledThrough int64
+}
+
+func (r *fakeAuditEventRepository) Create(ctx context.Context, event *models.AuditEvent) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored := *event
+	r.events = append(r.events, &stored)
+	sort.Slice(r.events, func(i, j int) bool { return r.events[i].Sequence < r.events[j].Sequence })
+	return nil
+}
+
+func (r *fakeAuditEventRepository) Search(ctx context.Context, filter bson.M, skip, limit int64) ([]models.AuditEvent, int64, error) {
+	return nil, 0, nil
+}
+
+func (r *fakeAuditEventRepository) Last(ctx context.Context) (*models.AuditEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.events) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	last := *r.events[len(r.events)-1]
+	return &last, nil
+}
+
+func (r *fakeAuditEventRepository) FindBySequence(ctx context.Context, sequence int64) (*models.AuditEvent, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, event := range r.events {
+		if event.Sequence == sequence {
+			found := *event
+			return &found, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (r *fakeAuditEventRepository) Walk(ctx context.Context, fn func(*models.AuditEvent) error) error {
+	r.mu.Lock()
+	events := append([]*models.AuditEvent{}, r.events...)
+	r.mu.Unlock()
+	for _, event := range events {
+		walked := *event
+		if err := fn(&walked); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *fakeAuditEventRepository) CountUnchained(ctx context.Context) (int64, error) {
+	return 0, nil
+}
+
+func (r *fakeAuditEventRepository) BackfilledThrough(ctx context.Context) (int64, error) {
+	return r.backfilledThrough, nil
+}
+
+func TestVerifyExemptsOnlyBackfilledEvents(t *testing.T) {
+	repo := &fakeAuditEventRepository{}
+	audit, err := NewAuditService(repo, config.AuditConfig{}, "https://auth.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Two events from before organizations, then migration 013
+	audit.Record(context.Background(), models.AuditUserCreate, models.AuditTargetUser, "first", nil, nil)
+	audit.Record(context.Background(), models.AuditUserCreate, models.AuditTargetUser, "second", nil, nil)
+	defaultOrg := primitive.NewObjectID().Hex()
+	for _, event := range repo.events {
+		event.OrgID, event.Backfilled = defaultOrg, true
+	}
+	repo.backfilledThrough = 2
+
+	// A later event recorded outside any organization
+	audit.Record(context.Background(), models.AuditUserDelete, models.AuditTargetUser, "third", nil, nil)
+
+	result, err := audit.Verify(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Broken != nil || result.Events != 3 {
+		t.Fatalf("verification of the migrated log = %+v, broken %+v", result, result.Broken)
+	}
+
+	// Claiming the exemption would slip it into an organization's log
+	repo.events[2].OrgID, repo.events[2].Backfilled = primitive.NewObjectID().Hex(), true
+	result, err = audit.Verify(context.Background(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Broken == nil || result.Broken.Sequence != 3 {
+		t.Errorf("forged backfill on event 3: broken = %+v", result.Broken)
+	}
+}
@@ -0,0 +1,269 @@
+package service
+
+import (
+	"context"
+	"crypto/ed25519"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/logger"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/repository"
+	"gin-mongo-aws/internal/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.uber.org/zap"
+)
+
+const auditRedacted = "[redacted]"
+
+// auditRedactedFields hold secrets; changes to them are recorded without values
+var auditRedactedFields = map[string]bool{
+	"password_hash":      true,
+	"mfa_secret":         true,
+	"mfa_pending_secret": true,
+	"recovery_codes":     true,
+}
+
+// auditIgnoredFields change on every write and say nothing about the change
+var auditIgnoredFields = map[string]bool{
+	"_id":        true,
+	"updated_at": true,
+}
+
+// AuditService keeps the audit log as a hash chain. The actor and request
+// details of an event are taken from the RequestMetadata in the context.
+type AuditService interface {
+	Record(ctx context.Context, action, targetType, targetID string, before, after interface{})
+	List(ctx context.Context, query *models.AuditQuery) (*models.AuditEventPage, error)
+	Verify(ctx context.Context, checkpoints []string) (*models.AuditVerification, error)
+	Checkpoint(ctx context.Context) (*models.AuditCheckpoint, error)
+}
+
+type auditService struct {
+	repo          repository.AuditEventRepository
+	mu            sync.Mutex // serializes appends to the chain
+	checkpointKey ed25519.PrivateKey
+	checkpointKid string
+	issuer        string
+}
+
+// NewAuditService signs checkpoints with the key in audit.checkpoint_key_file,
+// naming issuer as their iss
+func NewAuditService(repo repository.AuditEventRepository, cfg config.AuditConfig, issuer string) (AuditService, error) {
+	s := &auditService{repo: repo, issuer: issuer}
+	if cfg.CheckpointKeyFile != "" {
+		key, kid, err := loadCheckpointKey(cfg.CheckpointKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		s.checkpointKey, s.checkpointKid = key, kid
+	}
+	return s, nil
+}
+
+// Record appends an event with the fields that differ between before and
+// after, either of which may be nil. By the time it is called the change has
+// been made, so a failure to record it is logged rather than returned.
+func (s *auditService) Record(ctx context.Context, action, targetType, targetID string, before, after interface{}) {
+	// Record the event even when the client has gone away
+	ctx = context.WithoutCancel(ctx)
+
+	changes, err := auditChanges(before, after)
+	if err != nil {
+		logger.Log.Error("Failed to compute audit changes", zap.String("action", action), zap.Error(err))
+	}
+
+	md := RequestMetadataFrom(ctx)
+	event := &models.AuditEvent{
+		Action:     action,
+		Actor:      md.Actor,
+		TargetType: targetType,
+		TargetID:   targetID,
+		Changes:    changes,
+		IP:         md.IP,
+		UserAgent:  md.UserAgent,
+		RequestID:  md.RequestID,
+		CreatedAt:  time.Now(),
+	}
+	if event.Actor.Type == "" {
+		event.Actor.Type = models.AuditActorSystem
+	}
+	if orgID, err := tenant.OrgID(ctx); err == nil {
+		event.OrgID = orgID.Hex()
+	}
+
+	if err := s.appendEvent(ctx, event); err != nil {
+		logger.Log.Error("Failed to record audit event",
+			zap.String("action", action),
+			zap.String("target_id", targetID),
+			zap.String("request_id", md.RequestID),
+			zap.Error(err),
+		)
+	}
+}
+
+// List returns events of the organization in ctx only
+func (s *auditService) List(ctx context.Context, query *models.AuditQuery) (*models.AuditEventPage, error) {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := bson.M{"org_id": orgID.Hex()}
+	if query.ActorID != "" {
+		filter["actor.id"] = query.ActorID
+	}
+	if query.Action != "" {
+		filter["action"] = query.Action
+	}
+	if query.TargetType != "" {
+		filter["target_type"] = query.TargetType
+	}
+	if query.TargetID != "" {
+		filter["target_id"] = query.TargetID
+	}
+	if query.Field != "" {
+		filter["changes.field"] = query.Field
+	}
+	if query.RequestID != "" {
+		filter["request_id"] = query.RequestID
+	}
+	created := bson.M{}
+	if !query.Since.IsZero() {
+		created["$gte"] = query.Since
+	}
+	if !query.Until.IsZero() {
+		created["$lt"] = query.Until
+	}
+	if len(created) > 0 {
+		filter["created_at"] = created
+	}
+
+	events, total, err := s.repo.Search(ctx, filter, (query.Page-1)*query.PerPage, query.PerPage)
+	if err != nil {
+		return nil, err
+	}
+	return &models.AuditEventPage{Events: events, Total: total, Page: query.Page, PerPage: query.PerPage}, nil
+}
+
+// auditChanges compares the stored form of two documents field by field
+func auditChanges(before, after interface{}) ([]models.AuditChange, error) {
+	old, err := auditDocument(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := auditDocument(after)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make([]string, 0, len(old)+len(updated))
+	for field := range old {
+		fields = append(fields, field)
+	}
+	for field := range updated {
+		if _, ok := old[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	changes := []models.AuditChange{}
+	for _, field := range fields {
+		if auditIgnoredFields[field] {
+			continue
+		}
+		oldValue, hadValue := old[field]
+		newValue, hasValue := updated[field]
+		if hadValue == hasValue && reflect.DeepEqual(oldValue, newValue) {
+			continue
+		}
+
+		if auditRedactedFields[field] {
+			oldValue, newValue = redactAuditValue(hadValue), redactAuditValue(hasValue)
+		}
+		changes = append(changes, models.AuditChange{Field: field, Before: oldValue, After: newValue})
+	}
+	return changes, nil
+}
+
+// auditDocument converts a model to the document MongoDB stores for it
+func auditDocument(v interface{}) (bson.M, error) {
+	doc := bson.M{}
+	value := reflect.ValueOf(v)
+	if !value.IsValid() || (value.Kind() == reflect.Ptr && value.IsNil()) {
+		return doc, nil
+	}
+
+	data, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := bson.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func redactAuditValue(present bool) interface{} {
+	if !present {
+		return nil
+	}
+	return auditRedacted
+}
+
+type requestMetadataKey struct{}
+
+// RequestMetadata describes the API request a change is made in. Middleware
+// attaches it to the request context so services can audit changes without
+// extra parameters.
+type RequestMetadata struct {
+	RequestID string
+	IP        string
+	UserAgent string
+	Actor     models.AuditActor
+}
+
+func WithRequestMetadata(ctx context.Context, md RequestMetadata) context.Context {
+	return context.WithValue(ctx, requestMetadataKey{}, md)
+}
+
+// RequestMetadataFrom returns the metadata attached to ctx, or the zero value
+func RequestMetadataFrom(ctx context.Context) RequestMetadata {
+	md, _ := ctx.Value(requestMetadataKey{}).(RequestMetadata)
+	return md
+}
+
+// WithAuditActor replaces the actor in the context's request metadata
+func WithAuditActor(ctx context.Context, actor models.AuditActor) context.Context {
+	md := RequestMetadataFrom(ctx)
+	md.Actor = actor
+	return WithRequestMetadata(ctx, md)
+}
+
+// auditAsUser attributes the changes made in ctx to user when the request
+// has no authenticated actor, as in sign-ups, emailed links and external
+// logins, where the user is acting on their own account
+func auditAsUser(ctx context.Context, user *models.User) context.Context {
+	if RequestMetadataFrom(ctx).Actor.Type != "" {
+		return ctx
+	}
+	return WithAuditActor(ctx, models.AuditActor{Type: models.AuditActorUser, ID: user.ID.Hex(), Email: user.Email})
+}
+
+// AuditActorFromClaims describes the caller behind access token claims
+func AuditActorFromClaims(claims *Claims) models.AuditActor {
+	actor := models.AuditActor{Type: models.AuditActorUser, ID: claims.Subject, Email: claims.Email}
+	if claims.APIKeyID != "" {
+		actor.Type = models.AuditActorAPIKey
+		actor.APIKeyID = claims.APIKeyID
+	}
+	if claims.IsImpersonation() {
+		actor.ImpersonatorID = claims.Actor.Subject
+	}
+	return actor
+}
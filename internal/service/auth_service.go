@@ -0,0 +1,566 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/logger"
+	"gin-mongo-aws/internal/mailer"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/repository"
+	"gin-mongo-aws/internal/tenant"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrEmailTaken         = errors.New("email is already registered")
+	ErrInvalidCredentials = errors.New("invalid email or password")
+	ErrEmailNotVerified   = errors.New("email address has not been verified")
+	ErrInvalidResetToken  = errors.New("invalid or expired password reset token")
+	ErrAccountDisabled    = errors.New("account is disabled")
+)
+
+const (
+	purposeVerifyEmail = "verify_email"
+	purposeMagicLink   = "magic_link"
+)
+
+// pendingUser names the user a reset token or MFA challenge was issued for,
+// with the organization the request that redeems it acts in
+type pendingUser struct {
+	UserID string `json:"user_id"`
+	OrgID  string `json:"org_id"`
+}
+
+func newPendingUser(user *models.User) pendingUser {
+	return pendingUser{UserID: user.ID.Hex(), OrgID: user.OrgID.Hex()}
+}
+
+// parsePendingUser decodes a stored pendingUser and returns a copy of ctx
+// acting in its organization
+func parsePendingUser(ctx context.Context, data string) (context.Context, string, bool) {
+	var pending pendingUser
+	if err := json.Unmarshal([]byte(data), &pending); err != nil || pending.UserID == "" {
+		return nil, "", false
+	}
+	ctx, err := tenant.WithOrgHex(ctx, pending.OrgID)
+	if err != nil {
+		return nil, "", false
+	}
+	return ctx, pending.UserID, true
+}
+
+type AuthService interface {
+	Register(ctx context.Context, req *models.RegisterRequest) (*models.User, error)
+	Login(ctx context.Context, req *models.LoginRequest, ip string) (*models.User, error)
+	UnlockUser(ctx context.Context, id string) error
+	LinkDirectory(ctx context.Context, id string) error
+	GetUser(ctx context.Context, id string) (*models.User, error)
+	SendVerificationEmail(ctx context.Context, user *models.User) error
+	ResendVerificationEmail(ctx context.Context, email string) error
+	VerifyEmail(ctx context.Context, token string) error
+	ForgotPassword(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, req *models.ResetPasswordRequest) error
+	SendMagicLink(ctx context.Context, email string) error
+	MagicLinkLogin(ctx context.Context, token string) (*models.User, error)
+}
+
+type authService struct {
+	repo           repository.UserRepository
+	refresh        RefreshTokenService
+	sessions       SessionService
+	guard          LoginGuard
+	authenticators []Authenticator
+	mailer         mailer.Mailer
+	links          *linkSigner
+	audit          AuditService
+	cfg            config.AuthConfig
+}
+
+func NewAuthService(repo repository.UserRepository, refresh RefreshTokenService, sessions SessionService, guard LoginGuard, authenticators []Authenticator, m mailer.Mailer, audit AuditService, cfg config.AuthConfig) (AuthService, error) {
+	if cfg.LinkSecret == "" {
+		return nil, errors.New("auth.link_secret is required")
+	}
+	if len(authenticators) == 0 {
+		return nil, errors.New("at least one authenticator is required")
+	}
+
+	return &authService{
+		repo:           repo,
+		refresh:        refresh,
+		sessions:       sessions,
+		guard:          guard,
+		authenticators: authenticators,
+		mailer:         m,
+		audit:          audit,
+		links:          newLinkSigner(cfg.LinkSecret),
+		cfg:            cfg,
+	}, nil
+}
+
+func (s *authService) Register(ctx context.Context, req *models.RegisterRequest) (*models.User, error) {
+	hash, err := HashPassword(req.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	user := &models.User{
+		Name:         strings.TrimSpace(req.Name),
+		Email:        NormalizeEmail(req.Email),
+		PasswordHash: hash,
+		Roles:        []string{models.RoleUser},
+	}
+
+	if err := s.repo.Create(ctx, user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrEmailTaken
+		}
+		return nil, err
+	}
+
+	s.audit.Record(auditAsUser(ctx, user), models.AuditUserCreate, models.AuditTargetUser, user.ID.Hex(), nil, user)
+
+	if err := s.SendVerificationEmail(ctx, user); err != nil {
+		logger.Log.Error("Failed to send verification email", zap.String("user_id", user.ID.Hex()), zap.Error(err))
+	}
+
+	return user, nil
+}
+
+func (s *authService) Login(ctx context.Context, req *models.LoginRequest, ip string) (*models.User, error) {
+	login := req.Email
+	if login == "" {
+		login = req.Username
+	}
+	// Lockout counters are keyed by the organization and the normalized
+	// login, email or username
+	key := tenant.Key(ctx, NormalizeEmail(login))
+
+	wait, err := s.guard.Check(ctx, key, ip)
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 {
+		return nil, &LockedOutError{RetryAfter: wait}
+	}
+
+	user, err := s.authenticate(ctx, strings.TrimSpace(login), req.Password)
+	// A directory that cannot be reached must not become a way around the
+	// lockout
+	if errors.Is(err, ErrInvalidCredentials) || errors.Is(err, ErrDirectoryUnavailable) {
+		if err := s.guard.RecordFailure(ctx, key, ip); err != nil {
+			logger.Log.Error("Failed to record login failure", zap.Error(err))
+		}
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if user.MFAEnabled {
+		// The counters are only reset once the second factor succeeds, and a
+		// lock earned by wrong codes holds whichever login the user gave
+		wait, err := s.guard.Check(ctx, mfaLockoutKey(ctx, user), ip)
+		if err != nil {
+			return nil, err
+		}
+		if wait > 0 {
+			return nil, &LockedOutError{RetryAfter: wait}
+		}
+	} else if err := s.guard.RecordSuccess(ctx, key, ip); err != nil {
+		logger.Log.Error("Failed to reset login failures", zap.Error(err))
+	}
+
+	if s.cfg.RequireVerifiedEmail && !user.EmailVerified {
+		return nil, ErrEmailNotVerified
+	}
+
+	return user, nil
+}
+
+// authenticate tries each authenticator in turn until one accepts the login.
+// An authenticator that fails for another reason, such as an unreachable
+// directory, is logged and skipped.
+func (s *authService) authenticate(ctx context.Context, login, password string) (*models.User, error) {
+	var lastErr error
+	for _, authenticator := range s.authenticators {
+		user, err := authenticator.Authenticate(ctx, login, password)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, ErrInvalidCredentials) {
+			logger.Log.Error("Authenticator failed", zap.String("authenticator", authenticator.Name()), zap.Error(err))
+			lastErr = err
+		}
+	}
+
+	if lastErr != nil {
+		return nil, lastErr
+	}
+	return nil, ErrInvalidCredentials
+}
+
+func (s *authService) UnlockUser(ctx context.Context, id string) error {
+	user, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.guard.Unlock(ctx, tenant.Key(ctx, user.Email)); err != nil {
+		return err
+	}
+	s.audit.Record(ctx, models.AuditUserUnlock, models.AuditTargetUser, id, nil, nil)
+
+	logger.Log.Info("Account unlocked", zap.String("user_id", id))
+	return nil
+}
+
+// LinkDirectory hands a local account over to the directory, so the next
+// directory login with its email signs in to it instead of being refused. The
+// local password is removed and the user is signed out everywhere.
+func (s *authService) LinkDirectory(ctx context.Context, id string) error {
+	enabled := false
+	for _, authenticator := range s.authenticators {
+		enabled = enabled || authenticator.Name() == models.AuthSourceLDAP
+	}
+	if !enabled {
+		return ErrDirectoryNotConfigured
+	}
+
+	before, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if before.AuthSource == models.AuthSourceLDAP {
+		return nil
+	}
+
+	if err := s.repo.SetDirectoryProfile(ctx, id, models.AuthSourceLDAP, before.Name, before.Roles); err != nil {
+		return err
+	}
+	// Invalidate cache
+	database.RedisClient.Del(ctx, "user:"+id)
+
+	after, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	s.audit.Record(ctx, models.AuditUserUpdate, models.AuditTargetUser, id, before, after)
+
+	if err := s.refresh.RevokeAllForUser(ctx, id); err != nil {
+		return err
+	}
+	if err := s.sessions.RevokeAllForUser(ctx, id); err != nil {
+		return err
+	}
+
+	logger.Log.Info("Account linked to the directory", zap.String("user_id", id))
+	return nil
+}
+
+func (s *authService) GetUser(ctx context.Context, id string) (*models.User, error) {
+	return s.repo.FindByID(ctx, id)
+}
+
+func (s *authService) SendVerificationEmail(ctx context.Context, user *models.User) error {
+	token, err := s.links.sign(ctx, purposeVerifyEmail, verificationSubject(user.ID.Hex(), user.Email), s.cfg.EmailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := s.cfg.EmailVerificationURL + "?token=" + url.QueryEscape(token)
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your email address",
+		Body: fmt.Sprintf("Hi %s,\n\nPlease confirm your email address by opening the link below:\n\n%s\n\nThe link expires in %s.\n",
+			user.Name, link, s.cfg.EmailVerificationTTL),
+	})
+}
+
+// verificationSubject binds a verification link to the address it was sent
+// to. Emails cannot contain spaces.
+func verificationSubject(userID, email string) string {
+	return userID + " " + email
+}
+
+func (s *authService) ResendVerificationEmail(ctx context.Context, email string) error {
+	user, err := s.repo.FindByEmail(ctx, NormalizeEmail(email))
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return err
+	}
+
+	if user.EmailVerified {
+		return nil
+	}
+	return s.SendVerificationEmail(ctx, user)
+}
+
+func (s *authService) VerifyEmail(ctx context.Context, token string) error {
+	ctx, subject, err := s.links.verify(ctx, token, purposeVerifyEmail)
+	if err != nil {
+		return err
+	}
+	userID, email, ok := strings.Cut(subject, " ")
+	if !ok {
+		return ErrInvalidLink
+	}
+
+	user, err := s.repo.FindByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrInvalidLink
+		}
+		return err
+	}
+
+	// A link sent to an address the user has since changed matches nothing
+	if err := s.repo.SetEmailVerified(ctx, userID, email); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrInvalidLink
+		}
+		return err
+	}
+
+	// Invalidate cache
+	database.RedisClient.Del(ctx, "user:"+userID)
+
+	if !user.EmailVerified {
+		after := *user
+		after.EmailVerified = true
+		s.audit.Record(auditAsUser(ctx, user), models.AuditUserUpdate, models.AuditTargetUser, userID, user, &after)
+	}
+	return nil
+}
+
+func (s *authService) ForgotPassword(ctx context.Context, email string) error {
+	user, err := s.repo.FindByEmail(ctx, NormalizeEmail(email))
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		return err
+	}
+	// Directory users change their password in the directory
+	if user.DirectoryManaged() {
+		return nil
+	}
+
+	token, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(newPendingUser(user))
+	if err != nil {
+		return err
+	}
+	if err := database.RedisClient.Set(ctx, passwordResetKey(token), data, s.cfg.PasswordResetTTL).Err(); err != nil {
+		return err
+	}
+
+	link := s.cfg.PasswordResetURL + "?token=" + url.QueryEscape(token)
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("Hi %s,\n\nWe received a request to reset your password. Open the link below to choose a new one:\n\n%s\n\nThe link expires in %s. If you did not request this, you can ignore this email.\n",
+			user.Name, link, s.cfg.PasswordResetTTL),
+	})
+}
+
+func (s *authService) ResetPassword(ctx context.Context, req *models.ResetPasswordRequest) error {
+	// GETDEL makes the token single-use even under concurrent requests
+	data, err := database.RedisClient.GetDel(ctx, passwordResetKey(req.Token)).Result()
+	if errors.Is(err, redis.Nil) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	// The reset acts in the organization the token was issued in
+	ctx, userID, ok := parsePendingUser(ctx, data)
+	if !ok {
+		return ErrInvalidResetToken
+	}
+
+	user, err := s.repo.FindByID(ctx, userID)
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+
+	hash, err := HashPassword(req.Password)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.UpdatePassword(ctx, userID, hash); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return ErrInvalidResetToken
+		}
+		return err
+	}
+
+	after := *user
+	after.PasswordHash = hash
+	s.audit.Record(auditAsUser(ctx, user), models.AuditUserUpdate, models.AuditTargetUser, userID, user, &after)
+
+	// Sign the user out everywhere
+	if err := s.refresh.RevokeAllForUser(ctx, userID); err != nil {
+		return err
+	}
+	if err := s.sessions.RevokeAllForUser(ctx, userID); err != nil {
+		return err
+	}
+
+	logger.Log.Info("Password reset completed", zap.String("user_id", userID))
+	return nil
+}
+
+// SendMagicLink emails a single-use sign-in link. Unknown emails only get one
+// when auth.magic_link_auto_create is on; the account is created when the
+// link is used, so mistyped addresses never produce accounts.
+func (s *authService) SendMagicLink(ctx context.Context, email string) error {
+	email = NormalizeEmail(email)
+
+	user, err := s.repo.FindByEmail(ctx, email)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	if user == nil && !s.cfg.MagicLinkAutoCreate {
+		return nil
+	}
+	// Directory users must sign in against the directory
+	if user != nil && user.DirectoryManaged() {
+		return nil
+	}
+
+	token, err := s.links.sign(ctx, purposeMagicLink, email, s.cfg.MagicLinkTTL)
+	if err != nil {
+		return err
+	}
+
+	greeting := "Hi"
+	if user != nil {
+		greeting = "Hi " + user.Name
+	}
+	link := s.cfg.MagicLinkURL + "?token=" + url.QueryEscape(token)
+	return s.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Your sign-in link",
+		Body: fmt.Sprintf("%s,\n\nOpen the link below to sign in:\n\n%s\n\nThe link expires in %s and can only be used once. If you did not request it, you can ignore this email.\n",
+			greeting, link, s.cfg.MagicLinkTTL),
+	})
+}
+
+// MagicLinkLogin redeems a sign-in link. Opening the link proves the user
+// owns the address, so the email is marked verified.
+func (s *authService) MagicLinkLogin(ctx context.Context, token string) (*models.User, error) {
+	ctx, email, err := s.links.verify(ctx, token, purposeMagicLink)
+	if err != nil {
+		return nil, err
+	}
+
+	// The marker outlives the link, so a replayed link is always rejected
+	fresh, err := database.RedisClient.SetNX(ctx, magicLinkUsedKey(token), 1, s.cfg.MagicLinkTTL).Result()
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, ErrInvalidLink
+	}
+
+	user, err := s.repo.FindByEmail(ctx, email)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if !s.cfg.MagicLinkAutoCreate {
+			return nil, ErrInvalidLink
+		}
+		return s.createPasswordlessUser(ctx, email)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.DirectoryManaged() {
+		return nil, ErrInvalidLink
+	}
+
+	if !user.EmailVerified {
+		userID := user.ID.Hex()
+		if err := s.repo.SetEmailVerified(ctx, userID, user.Email); err != nil {
+			return nil, err
+		}
+		before := *user
+		user.EmailVerified = true
+
+		// Invalidate cache
+		database.RedisClient.Del(ctx, "user:"+userID)
+
+		s.audit.Record(auditAsUser(ctx, user), models.AuditUserUpdate, models.AuditTargetUser, userID, &before, user)
+	}
+
+	logger.Log.Info("Magic link login", zap.String("user_id", user.ID.Hex()))
+	return user, nil
+}
+
+func (s *authService) createPasswordlessUser(ctx context.Context, email string) (*models.User, error) {
+	name, _, _ := strings.Cut(email, "@")
+	user := &models.User{
+		Name:          name,
+		Email:         email,
+		EmailVerified: true,
+		Roles:         []string{models.RoleUser},
+	}
+
+	if err := s.repo.Create(ctx, user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// Created by a concurrent login with another link
+			return s.repo.FindByEmail(ctx, email)
+		}
+		return nil, err
+	}
+
+	s.audit.Record(auditAsUser(ctx, user), models.AuditUserCreate, models.AuditTargetUser, user.ID.Hex(), nil, user)
+	logger.Log.Info("Account created from magic link", zap.String("user_id", user.ID.Hex()))
+	return user, nil
+}
+
+// HashPassword returns the bcrypt hash of a plaintext password
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword reports whether password matches the stored bcrypt hash
+func CheckPassword(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func passwordResetKey(token string) string {
+	return "pwreset:" + hashToken(token)
+}
+
+func magicLinkUsedKey(token string) string {
+	return "magiclink:used:" + hashToken(token)
+}
+
+// NormalizeEmail lowercases and trims an email so lookups are consistent
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,262 @@
+package service
+
+import (
+	"context"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/mailer"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/tenant"
+	"gin-mongo-aws/internal/testutil"
+
+	"github.com/pquerna/otp/totp"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// outbox is a mailer that keeps what it sends
+type outbox struct {
+	mu       sync.Mutex
+	messages []mailer.Message
+}
+
+func (o *outbox) Send(ctx context.Context, msg mailer.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.messages = append(o.messages, msg)
+	return nil
+}
+
+var linkToken = regexp.MustCompile(`\?token=(\S+)`)
+
+// lastToken returns the token in the link of the last email sent
+func (o *outbox) lastToken(t *testing.T) string {
+	t.Helper()
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if len(o.messages) == 0 {
+		t.Fatal("no email was sent")
+	}
+	match := linkToken.FindStringSubmatch(o.messages[len(o.messages)-1].Body)
+	if match == nil {
+		t.Fatal("the email has no link")
+	}
+	token, err := url.QueryUnescape(match[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+type authFixture struct {
+	ctx   context.Context
+	users *testutil.UserRepository
+	audit *fakeAuditService
+	mail  *outbox
+	cfg   config.AuthConfig
+	auth  AuthService
+}
+
+func newAuthFixture(t *testing.T) *authFixture {
+	t.Helper()
+	testutil.StartRedis(t)
+
+	f := &authFixture{
+		ctx:   tenant.WithOrgID(context.Background(), primitive.NewObjectID()),
+		users: testutil.NewUserRepository(),
+		audit: &fakeAuditService{},
+		mail:  &outbox{},
+		cfg: config.AuthConfig{
+			LinkSecret:           "test-link-secret",
+			EmailVerificationURL: "https://app.example.com/verify",
+			EmailVerificationTTL: time.Hour,
+			PasswordResetURL:     "https://app.example.com/reset",
+			PasswordResetTTL:     time.Hour,
+			MFAIssuer:            "Example",
+			MFAChallengeTTL:      time.Minute,
+			Lockout:              config.LockoutConfig{MaxAttempts: 5, IPMaxAttempts: 20, Window: time.Minute, Duration: time.Minute},
+			Session:              config.SessionConfig{IdleTTL: time.Hour, AbsoluteTTL: time.Hour},
+		},
+	}
+	auth, err := NewAuthService(f.users, NewRefreshTokenService(time.Hour), NewSessionService(f.cfg.Session), NewLoginGuard(f.cfg.Lockout),
+		[]Authenticator{NewPasswordAuthenticator(f.users)}, f.mail, f.audit, f.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.auth = auth
+	return f
+}
+
+// changedFields returns the fields the event changed
+func changedFields(event recordedEvent) map[string]models.AuditChange {
+	fields := map[string]models.AuditChange{}
+	for _, change := range event.Changes {
+		fields[change.Field] = change
+	}
+	return fields
+}
+
+func TestSelfServiceChangesAreAudited(t *testing.T) {
+	f := newAuthFixture(t)
+
+	user, err := f.auth.Register(f.ctx, &models.RegisterRequest{Name: "New User", Email: "new@example.com", Password: "correct horse battery"})
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if err := f.auth.VerifyEmail(f.ctx, f.mail.lastToken(t)); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	if err := f.auth.ForgotPassword(f.ctx, user.Email); err != nil {
+		t.Fatalf("ForgotPassword: %v", err)
+	}
+	if err := f.auth.ResetPassword(f.ctx, &models.ResetPasswordRequest{Token: f.mail.lastToken(t), Password: "another horse battery"}); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+
+	if len(f.audit.events) != 3 {
+		t.Fatalf("audited %v, want the sign-up, the verification and the reset", f.audit.actions())
+	}
+	created, verified, reset := f.audit.events[0], f.audit.events[1], f.audit.events[2]
+	if created.Action != models.AuditUserCreate || created.TargetID != user.ID.Hex() {
+		t.Errorf("sign-up audited as %+v", created)
+	}
+	// Nobody is signed in, so the changes are the user's own
+	for _, event := range f.audit.events {
+		if event.Actor.Type != models.AuditActorUser || event.Actor.ID != user.ID.Hex() {
+			t.Errorf("%s attributed to %+v, want the user", event.Action, event.Actor)
+		}
+	}
+	if change, ok := changedFields(verified)["email_verified"]; verified.Action != models.AuditUserUpdate || !ok || change.After != true {
+		t.Errorf("verification audited as %+v", verified)
+	}
+	// The new hash is recorded as changed without its value
+	if change, ok := changedFields(reset)["password_hash"]; reset.Action != models.AuditUserUpdate || !ok || change.After != auditRedacted {
+		t.Errorf("reset audited as %+v", reset)
+	}
+}
+
+func TestMFAEnableAndDisableAreAudited(t *testing.T) {
+	f := newAuthFixture(t)
+	user := &models.User{Name: "MFA User", Email: "mfa@example.com", EmailVerified: true}
+	if err := f.users.Create(f.ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	mfa := NewMFAService(f.users, NewLoginGuard(f.cfg.Lockout), f.audit, f.cfg)
+	userID := user.ID.Hex()
+
+	enrollment, err := mfa.Enroll(f.ctx, userID)
+	if err != nil {
+		t.Fatalf("Enroll: %v", err)
+	}
+	code, err := totp.GenerateCode(enrollment.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoveryCodes, err := mfa.Confirm(f.ctx, userID, code)
+	if err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+	if err := mfa.Disable(f.ctx, userID, &models.MFACodeRequest{RecoveryCode: recoveryCodes[0]}); err != nil {
+		t.Fatalf("Disable: %v", err)
+	}
+
+	if len(f.audit.events) != 2 {
+		t.Fatalf("audited %v, want the enabling and the disabling", f.audit.actions())
+	}
+	enabled, disabled := changedFields(f.audit.events[0]), changedFields(f.audit.events[1])
+	if enabled["mfa_enabled"].After != true || disabled["mfa_enabled"].After != false {
+		t.Errorf("mfa_enabled changes = %+v, %+v", enabled["mfa_enabled"], disabled["mfa_enabled"])
+	}
+	// The secret is recorded as set and removed without its value
+	if secret := enabled["mfa_secret"]; secret.Before != nil || secret.After != auditRedacted {
+		t.Errorf("enabling changed mfa_secret %+v", secret)
+	}
+	if secret := disabled["mfa_secret"]; secret.Before != auditRedacted || secret.After != nil {
+		t.Errorf("disabling changed mfa_secret %+v", secret)
+	}
+}
+
+func TestEmailLinksActInTheirOrganization(t *testing.T) {
+	f := newAuthFixture(t)
+	user, err := f.auth.Register(f.ctx, &models.RegisterRequest{Name: "New User", Email: "new@example.com", Password: "correct horse battery"})
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+
+	// The links are opened without naming the organization
+	elsewhere := tenant.WithOrgID(context.Background(), primitive.NewObjectID())
+	if err := f.auth.VerifyEmail(elsewhere, f.mail.lastToken(t)); err != nil {
+		t.Fatalf("VerifyEmail: %v", err)
+	}
+	if err := f.auth.ForgotPassword(f.ctx, user.Email); err != nil {
+		t.Fatalf("ForgotPassword: %v", err)
+	}
+	if err := f.auth.ResetPassword(elsewhere, &models.ResetPasswordRequest{Token: f.mail.lastToken(t), Password: "another horse battery"}); err != nil {
+		t.Fatalf("ResetPassword: %v", err)
+	}
+
+	stored, err := f.users.FindByID(f.ctx, user.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stored.EmailVerified || !CheckPassword(stored.PasswordHash, "another horse battery") {
+		t.Errorf("the links did not change the account: verified %v", stored.EmailVerified)
+	}
+}
+
+func TestMFAChallengeActsInTheUsersOrganization(t *testing.T) {
+	f := newAuthFixture(t)
+	user := &models.User{Name: "MFA User", Email: "mfa@example.com", EmailVerified: true}
+	if err := f.users.Create(f.ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	mfa := NewMFAService(f.users, NewLoginGuard(f.cfg.Lockout), f.audit, f.cfg)
+	enrollment, err := mfa.Enroll(f.ctx, user.ID.Hex())
+	if err != nil {
+		t.Fatalf("Enroll: %v", err)
+	}
+	code, err := totp.GenerateCode(enrollment.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoveryCodes, err := mfa.Confirm(f.ctx, user.ID.Hex(), code)
+	if err != nil {
+		t.Fatalf("Confirm: %v", err)
+	}
+
+	token, err := mfa.StartChallenge(f.ctx, user)
+	if err != nil {
+		t.Fatalf("StartChallenge: %v", err)
+	}
+	elsewhere := tenant.WithOrgID(context.Background(), primitive.NewObjectID())
+	signedIn, err := mfa.VerifyChallenge(elsewhere, &models.MFAVerifyRequest{MFAToken: token, RecoveryCode: recoveryCodes[0]}, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("VerifyChallenge: %v", err)
+	}
+	if signedIn.ID != user.ID {
+		t.Errorf("signed in %s, want %s", signedIn.ID.Hex(), user.ID.Hex())
+	}
+}
+
+func TestRefreshFamilyRecordsOrganization(t *testing.T) {
+	testutil.StartRedis(t)
+	refresh := NewRefreshTokenService(time.Hour)
+	user := &models.User{ID: primitive.NewObjectID(), OrgID: primitive.NewObjectID()}
+
+	_, token, err := refresh.Issue(context.Background(), user, ClientInfo{})
+	if err != nil {
+		t.Fatalf("Issue: %v", err)
+	}
+	family, _, err := refresh.Rotate(context.Background(), token, "", ClientInfo{})
+	if err != nil {
+		t.Fatalf("Rotate: %v", err)
+	}
+	if family.UserID != user.ID.Hex() || family.OrgID != user.OrgID.Hex() {
+		t.Errorf("family = %+v, want user %s in organization %s", family, user.ID.Hex(), user.OrgID.Hex())
+	}
+}
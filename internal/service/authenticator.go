@@ -0,0 +1,90 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/repository"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Authenticator checks a login and password against one credential store.
+// It returns ErrInvalidCredentials when the store does not know the login or
+// the password is wrong, so the next authenticator can be tried.
+type Authenticator interface {
+	Name() string
+	Authenticate(ctx context.Context, login, password string) (*models.User, error)
+}
+
+// NewAuthenticators builds the authenticators listed in auth.authenticators,
+// in the order logins should try them
+func NewAuthenticators(cfg config.AuthConfig, defaultOrganization string, orgs OrganizationService, users repository.UserRepository, roles RoleService, audit AuditService) ([]Authenticator, error) {
+	names := cfg.Authenticators
+	if len(names) == 0 {
+		names = []string{"password"}
+	}
+
+	authenticators := make([]Authenticator, 0, len(names))
+	for _, name := range names {
+		switch name {
+		case "password":
+			authenticators = append(authenticators, NewPasswordAuthenticator(users))
+		case models.AuthSourceLDAP:
+			ldapCfg := cfg.LDAP
+			if ldapCfg.Organization == "" {
+				ldapCfg.Organization = defaultOrganization
+			}
+			ldap, err := NewLDAPAuthenticator(ldapCfg, orgs, users, roles, audit)
+			if err != nil {
+				return nil, err
+			}
+			authenticators = append(authenticators, ldap)
+		default:
+			return nil, fmt.Errorf("unknown authenticator %q in auth.authenticators", name)
+		}
+	}
+	return authenticators, nil
+}
+
+// passwordAuthenticator checks the bcrypt hashes stored on users
+type passwordAuthenticator struct {
+	users     repository.UserRepository
+	dummyHash []byte
+}
+
+func NewPasswordAuthenticator(users repository.UserRepository) Authenticator {
+	// Hash used to keep login timing uniform when the email is unknown
+	dummyHash, _ := bcrypt.GenerateFromPassword([]byte("not-a-real-password"), bcrypt.DefaultCost)
+	return &passwordAuthenticator{users: users, dummyHash: dummyHash}
+}
+
+func (a *passwordAuthenticator) Name() string {
+	return "password"
+}
+
+// Authenticate spends the same bcrypt work whether or not the account exists
+func (a *passwordAuthenticator) Authenticate(ctx context.Context, login, password string) (*models.User, error) {
+	user, err := a.users.FindByEmail(ctx, NormalizeEmail(login))
+	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			bcrypt.CompareHashAndPassword(a.dummyHash, []byte(password))
+			return nil, ErrInvalidCredentials
+		}
+		return nil, err
+	}
+
+	if user.PasswordHash == "" {
+		bcrypt.CompareHashAndPassword(a.dummyHash, []byte(password))
+		return nil, ErrInvalidCredentials
+	}
+
+	if !CheckPassword(user.PasswordHash, password) {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
@@ -0,0 +1,338 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/logger"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/repository"
+	"gin-mongo-aws/internal/tenant"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrUnknownProvider         = errors.New("unknown identity provider")
+	ErrInvalidProviderState    = errors.New("invalid or expired external login")
+	ErrExternalLoginFailed     = errors.New("external login failed")
+	ErrProviderEmailUnverified = errors.New("the identity provider did not return a verified email")
+	ErrExternalSignupDisabled  = errors.New("no account is linked to this external login")
+	ErrAccountLinkRequired     = errors.New("an account with this email already exists; sign in and link the provider from your profile")
+	ErrIdentityAlreadyLinked   = errors.New("this external account is linked to another user")
+	ErrProviderAlreadyLinked   = errors.New("another account from this provider is already linked")
+	ErrIdentityNotFound        = errors.New("no account from this provider is linked")
+)
+
+const (
+	externalStateTTL    = 10 * time.Minute
+	externalHTTPTimeout = 10 * time.Second
+)
+
+// ExternalLoginService signs users in through the providers configured under
+// identity_providers. An external account is matched by its linked identity,
+// then by verified email; an account is only created when the provider
+// allows signups.
+type ExternalLoginService interface {
+	Providers() []models.IdentityProviderInfo
+	Start(ctx context.Context, provider, linkUserID string) (authURL, state string, err error)
+	Complete(ctx context.Context, provider, state, code string) (user *models.User, linked bool, err error)
+	ListIdentities(ctx context.Context, userID string) ([]models.LinkedIdentity, error)
+	Unlink(ctx context.Context, userID, provider string) error
+}
+
+// externalLoginState is kept in Redis between the redirect to the provider
+// and the callback, which acts in the organization the login started in
+type externalLoginState struct {
+	Provider   string `json:"provider"`
+	OrgID      string `json:"org_id"`
+	Nonce      string `json:"nonce"`
+	Verifier   string `json:"verifier"`
+	LinkUserID string `json:"link_user_id,omitempty"`
+}
+
+type externalLoginService struct {
+	accountLinker
+	cfg       map[string]config.IdentityProviderConfig
+	providers map[string]identityProvider
+}
+
+// accountLinker matches an external identity to a local account, linking or
+// creating the account when allowed. External and SAML logins share it.
+type accountLinker struct {
+	users repository.UserRepository
+	audit AuditService
+}
+
+func NewExternalLoginService(users repository.UserRepository, audit AuditService, cfg map[string]config.IdentityProviderConfig) (ExternalLoginService, error) {
+	client := &http.Client{Timeout: externalHTTPTimeout}
+
+	providers := make(map[string]identityProvider, len(cfg))
+	for name, providerCfg := range cfg {
+		provider, err := newIdentityProvider(name, providerCfg, client)
+		if err != nil {
+			return nil, err
+		}
+		providers[name] = provider
+	}
+	return &externalLoginService{accountLinker: accountLinker{users: users, audit: audit}, cfg: cfg, providers: providers}, nil
+}
+
+func (s *externalLoginService) Providers() []models.IdentityProviderInfo {
+	infos := make([]models.IdentityProviderInfo, 0, len(s.cfg))
+	for name, cfg := range s.cfg {
+		displayName := cfg.DisplayName
+		if displayName == "" {
+			displayName = name
+		}
+		infos = append(infos, models.IdentityProviderInfo{
+			Name:        name,
+			DisplayName: displayName,
+			LoginURL:    "/api/v1/auth/providers/" + name + "/login",
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Start returns the provider URL to send the user to and the state that the
+// callback must present. With a linkUserID the callback links the external
+// account to that user instead of signing in.
+func (s *externalLoginService) Start(ctx context.Context, name, linkUserID string) (string, string, error) {
+	provider, ok := s.providers[name]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+
+	state, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	nonce, err := randomToken(16)
+	if err != nil {
+		return "", "", err
+	}
+	verifier := oauth2.GenerateVerifier()
+
+	authURL, err := provider.authCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		return "", "", err
+	}
+
+	data, err := json.Marshal(externalLoginState{Provider: name, OrgID: tenant.OrgHex(ctx), Nonce: nonce, Verifier: verifier, LinkUserID: linkUserID})
+	if err != nil {
+		return "", "", err
+	}
+	if err := database.RedisClient.Set(ctx, externalStateKey(state), data, externalStateTTL).Err(); err != nil {
+		return "", "", err
+	}
+	return authURL, state, nil
+}
+
+// Complete handles the provider callback. linked reports that the flow was
+// started to link an account, so the caller is already signed in.
+func (s *externalLoginService) Complete(ctx context.Context, name, state, code string) (*models.User, bool, error) {
+	provider, ok := s.providers[name]
+	if !ok {
+		return nil, false, ErrUnknownProvider
+	}
+
+	// GETDEL makes each state single-use
+	data, err := database.RedisClient.GetDel(ctx, externalStateKey(state)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, false, ErrInvalidProviderState
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	var pending externalLoginState
+	if err := json.Unmarshal(data, &pending); err != nil || pending.Provider != name {
+		return nil, false, ErrInvalidProviderState
+	}
+	if ctx, err = tenant.WithOrgHex(ctx, pending.OrgID); err != nil {
+		return nil, false, ErrInvalidProviderState
+	}
+
+	identity, err := provider.exchange(ctx, code, pending.Nonce, pending.Verifier)
+	if err != nil {
+		logger.Log.Warn("External login failed", zap.String("provider", name), zap.Error(err))
+		return nil, false, ErrExternalLoginFailed
+	}
+	identity.Email = NormalizeEmail(identity.Email)
+
+	if pending.LinkUserID != "" {
+		user, err := s.link(ctx, pending.LinkUserID, name, identity)
+		return user, true, err
+	}
+
+	user, err := s.login(ctx, name, identity, s.cfg[name].AllowSignup)
+	return user, false, err
+}
+
+func (s accountLinker) login(ctx context.Context, name string, identity *externalIdentity, allowSignup bool) (*models.User, error) {
+	user, err := s.users.FindByLinkedIdentity(ctx, name, identity.Subject)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	// Accounts are only matched or created by email when the provider vouches for it
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, ErrProviderEmailUnverified
+	}
+
+	user, err = s.users.FindByEmail(ctx, identity.Email)
+	if err == nil {
+		// Whoever registered an unverified account may not own the email, and
+		// directory users must not bypass the directory, so the owner has to
+		// sign in and link the provider explicitly
+		if !user.EmailVerified || user.DirectoryManaged() {
+			return nil, ErrAccountLinkRequired
+		}
+		return s.link(ctx, user.ID.Hex(), name, identity)
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	if !allowSignup {
+		return nil, ErrExternalSignupDisabled
+	}
+	return s.createUser(ctx, name, identity)
+}
+
+func (s accountLinker) createUser(ctx context.Context, name string, identity *externalIdentity) (*models.User, error) {
+	displayName := strings.TrimSpace(identity.Name)
+	if displayName == "" {
+		displayName, _, _ = strings.Cut(identity.Email, "@")
+	}
+
+	user := &models.User{
+		Name:          displayName,
+		GivenName:     identity.GivenName,
+		FamilyName:    identity.FamilyName,
+		Email:         identity.Email,
+		EmailVerified: true,
+		Roles:         []string{models.RoleUser},
+		Identities:    []models.LinkedIdentity{newLinkedIdentity(name, identity)},
+	}
+	if err := s.users.Create(ctx, user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			// A concurrent login created the account or claimed the email first
+			return nil, ErrAccountLinkRequired
+		}
+		return nil, err
+	}
+
+	s.audit.Record(auditAsUser(ctx, user), models.AuditUserCreate, models.AuditTargetUser, user.ID.Hex(), nil, user)
+	logger.Log.Info("Account created from external login",
+		zap.String("user_id", user.ID.Hex()),
+		zap.String("provider", name),
+	)
+	return user, nil
+}
+
+func (s accountLinker) link(ctx context.Context, userID, name string, identity *externalIdentity) (*models.User, error) {
+	owner, err := s.users.FindByLinkedIdentity(ctx, name, identity.Subject)
+	if err == nil {
+		if owner.ID.Hex() != userID {
+			return nil, ErrIdentityAlreadyLinked
+		}
+		return owner, nil
+	}
+	if !errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, err
+	}
+
+	before, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	err = s.users.AddLinkedIdentity(ctx, userID, newLinkedIdentity(name, identity))
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrIdentityAlreadyLinked
+	}
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrProviderAlreadyLinked
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Invalidate cache
+	database.RedisClient.Del(ctx, "user:"+userID)
+
+	logger.Log.Info("External identity linked", zap.String("user_id", userID), zap.String("provider", name))
+	user, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	s.audit.Record(auditAsUser(ctx, user), models.AuditUserUpdate, models.AuditTargetUser, userID, before, user)
+	return user, nil
+}
+
+func (s *externalLoginService) ListIdentities(ctx context.Context, userID string) ([]models.LinkedIdentity, error) {
+	user, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Identities == nil {
+		return []models.LinkedIdentity{}, nil
+	}
+	return user.Identities, nil
+}
+
+func (s *externalLoginService) Unlink(ctx context.Context, userID, name string) error {
+	before, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	err = s.users.RemoveLinkedIdentity(ctx, userID, name)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrIdentityNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	// Invalidate cache
+	database.RedisClient.Del(ctx, "user:"+userID)
+
+	after := *before
+	after.Identities = make([]models.LinkedIdentity, 0, len(before.Identities))
+	for _, identity := range before.Identities {
+		if identity.Provider != name {
+			after.Identities = append(after.Identities, identity)
+		}
+	}
+	s.audit.Record(ctx, models.AuditUserUpdate, models.AuditTargetUser, userID, before, &after)
+
+	logger.Log.Info("External identity unlinked", zap.String("user_id", userID), zap.String("provider", name))
+	return nil
+}
+
+func newLinkedIdentity(provider string, identity *externalIdentity) models.LinkedIdentity {
+	return models.LinkedIdentity{
+		Key:      models.LinkedIdentityKey(provider, identity.Subject),
+		Provider: provider,
+		Subject:  identity.Subject,
+		Email:    identity.Email,
+		LinkedAt: time.Now(),
+	}
+}
+
+func externalStateKey(state string) string {
+	return "external-login:" + hashToken(state)
+}
@@ -0,0 +1,321 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/tenant"
+	"gin-mongo-aws/internal/testutil"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const stubClientID = "test-client"
+
+// stubOIDCProvider is an OpenID Connect provider that issues an ID token for
+// whichever claims the test registered against an authorization code
+type stubOIDCProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]stubGrant
+}
+
+type stubGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newStubOIDCProvider(t *testing.T) *stubOIDCProvider {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &stubOIDCProvider{key: key, codes: map[string]stubGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "stub",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p.mu.Lock()
+		grant, ok := p.codes[r.PostForm.Get("code")]
+		delete(p.codes, r.PostForm.Get("code"))
+		p.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			writeJSON(w, map[string]string{"error": "invalid_grant"})
+			return
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, grant.claims)
+		token.Header["kid"] = "stub"
+		idToken, err := token.SignedString(key)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, map[string]interface{}{
+			"access_token": "stub-access-token",
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+			"id_token":     idToken,
+		})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// authorize plays the user signing in at the provider: it registers a code
+// for the authorization URL the service redirected to and returns it
+func (p *stubOIDCProvider) authorize(t *testing.T, authURL, subject, email string, emailVerified bool) string {
+	t.Helper()
+
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" {
+		t.Fatalf("authorization URL does not use PKCE: %s", authURL)
+	}
+
+	now := time.Now()
+	code := "code-" + subject + "-" + query.Get("state")[:8]
+	p.mu.Lock()
+	p.codes[code] = stubGrant{
+		challenge: query.Get("code_challenge"),
+		claims: jwt.MapClaims{
+			"iss":            p.URL,
+			"sub":            subject,
+			"aud":            stubClientID,
+			"iat":            now.Unix(),
+			"exp":            now.Add(time.Minute).Unix(),
+			"nonce":          query.Get("nonce"),
+			"email":          email,
+			"email_verified": emailVerified,
+			"name":           "Test User",
+		},
+	}
+	p.mu.Unlock()
+	return code
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+type externalLoginFixture struct {
+	ctx      context.Context
+	provider *stubOIDCProvider
+	users    *testutil.UserRepository
+	audit    *fakeAuditService
+	service  ExternalLoginService
+}
+
+func newExternalLoginFixture(t *testing.T, allowSignup bool) *externalLoginFixture {
+	t.Helper()
+	testutil.StartRedis(t)
+
+	provider := newStubOIDCProvider(t)
+	users := testutil.NewUserRepository()
+	audit := &fakeAuditService{}
+	svc, err := NewExternalLoginService(users, audit, map[string]config.IdentityProviderConfig{
+		"stub": {
+			Issuer:      provider.URL,
+			ClientID:    stubClientID,
+			RedirectURL: "http://localhost/api/v1/auth/providers/stub/callback",
+			AllowSignup: allowSignup,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &externalLoginFixture{
+		ctx:      tenant.WithOrgID(context.Background(), primitive.NewObjectID()),
+		provider: provider,
+		users:    users,
+		audit:    audit,
+		service:  svc,
+	}
+}
+
+// signIn runs a login through the provider and returns the callback result
+func (f *externalLoginFixture) signIn(t *testing.T, linkUserID, subject, email string, emailVerified bool) (*models.User, bool, error) {
+	t.Helper()
+
+	authURL, state, err := f.service.Start(f.ctx, "stub", linkUserID)
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	code := f.provider.authorize(t, authURL, subject, email, emailVerified)
+	return f.service.Complete(f.ctx, "stub", state, code)
+}
+
+func TestExternalLoginCallbackCreatesAccount(t *testing.T) {
+	f := newExternalLoginFixture(t, true)
+
+	user, linked, err := f.signIn(t, "", "subject-1", "New.User@Example.com", true)
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if linked {
+		t.Error("a login was reported as an account link")
+	}
+	if user.Email != "new.user@example.com" || !user.EmailVerified {
+		t.Errorf("created user has email %q verified=%v", user.Email, user.EmailVerified)
+	}
+	if len(user.Identities) != 1 || user.Identities[0].Subject != "subject-1" {
+		t.Errorf("created user identities = %+v", user.Identities)
+	}
+
+	// The next login finds the account by its linked identity
+	again, _, err := f.signIn(t, "", "subject-1", "new.user@example.com", true)
+	if err != nil {
+		t.Fatalf("second Complete: %v", err)
+	}
+	if again.ID != user.ID {
+		t.Errorf("second login signed in %s, want %s", again.ID.Hex(), user.ID.Hex())
+	}
+	if actions := f.audit.actions(); len(actions) != 1 || actions[0] != models.AuditUserCreate {
+		t.Errorf("audited %v, want the account creation", actions)
+	}
+}
+
+func TestExternalLoginRejectsStateMismatch(t *testing.T) {
+	f := newExternalLoginFixture(t, true)
+
+	authURL, state, err := f.service.Start(f.ctx, "stub", "")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	code := f.provider.authorize(t, authURL, "subject-1", "user@example.com", true)
+
+	if _, _, err := f.service.Complete(f.ctx, "stub", "forged-state", code); !errors.Is(err, ErrInvalidProviderState) {
+		t.Errorf("forged state: err = %v, want ErrInvalidProviderState", err)
+	}
+	if _, _, err := f.service.Complete(f.ctx, "stub", state, code); err != nil {
+		t.Fatalf("genuine state: %v", err)
+	}
+	if _, _, err := f.service.Complete(f.ctx, "stub", state, code); !errors.Is(err, ErrInvalidProviderState) {
+		t.Errorf("replayed state: err = %v, want ErrInvalidProviderState", err)
+	}
+}
+
+func TestExternalLoginRejectsUnverifiedEmail(t *testing.T) {
+	f := newExternalLoginFixture(t, true)
+
+	if _, _, err := f.signIn(t, "", "subject-1", "user@example.com", false); !errors.Is(err, ErrProviderEmailUnverified) {
+		t.Fatalf("err = %v, want ErrProviderEmailUnverified", err)
+	}
+	if users, _ := f.users.FindAll(f.ctx); len(users) != 0 {
+		t.Errorf("an account was created for an unverified email: %+v", users)
+	}
+}
+
+func TestExternalLoginLinksByVerifiedEmail(t *testing.T) {
+	f := newExternalLoginFixture(t, false)
+
+	verified := &models.User{Name: "Verified", Email: "verified@example.com", EmailVerified: true}
+	unverified := &models.User{Name: "Unverified", Email: "unverified@example.com"}
+	for _, user := range []*models.User{verified, unverified} {
+		if err := f.users.Create(f.ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	user, _, err := f.signIn(t, "", "subject-1", "verified@example.com", true)
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if user.ID != verified.ID || len(user.Identities) != 1 {
+		t.Errorf("login did not link the verified account: %+v", user)
+	}
+
+	// Someone who registered an address without verifying it may not own it
+	if _, _, err := f.signIn(t, "", "subject-2", "unverified@example.com", true); !errors.Is(err, ErrAccountLinkRequired) {
+		t.Errorf("unverified account: err = %v, want ErrAccountLinkRequired", err)
+	}
+
+	// Without signups, an unknown email is refused
+	if _, _, err := f.signIn(t, "", "subject-3", "stranger@example.com", true); !errors.Is(err, ErrExternalSignupDisabled) {
+		t.Errorf("unknown email: err = %v, want ErrExternalSignupDisabled", err)
+	}
+}
+
+func TestExternalLoginExplicitLink(t *testing.T) {
+	f := newExternalLoginFixture(t, false)
+
+	owner := &models.User{Name: "Owner", Email: "owner@example.com"}
+	if err := f.users.Create(f.ctx, owner); err != nil {
+		t.Fatal(err)
+	}
+
+	// A signed-in user links an account whose email differs from theirs
+	user, linked, err := f.signIn(t, owner.ID.Hex(), "subject-1", "other@example.com", false)
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if !linked || user.ID != owner.ID {
+		t.Errorf("linked=%v user=%s, want the owner linked", linked, user.ID.Hex())
+	}
+
+	if err := f.service.Unlink(f.ctx, owner.ID.Hex(), "stub"); err != nil {
+		t.Fatalf("Unlink: %v", err)
+	}
+	if err := f.service.Unlink(f.ctx, owner.ID.Hex(), "stub"); !errors.Is(err, ErrIdentityNotFound) {
+		t.Errorf("second Unlink: err = %v, want ErrIdentityNotFound", err)
+	}
+
+	// The link and the unlink are both audited as changes to the identities
+	if len(f.audit.events) != 2 {
+		t.Fatalf("audited %v, want the link and the unlink", f.audit.actions())
+	}
+	for _, event := range f.audit.events {
+		if event.Action != models.AuditUserUpdate || len(event.Changes) != 1 || event.Changes[0].Field != "linked_identities" {
+			t.Errorf("audited %+v, want a change to the identities", event)
+		}
+	}
+}
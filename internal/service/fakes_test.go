@@ -0,0 +1,116 @@
+package service
+
+import (
+	"context"
+	"sync"
+
+	"gin-mongo-aws/internal/models"
+)
+
+// fakeRoleService knows the built-in roles and the custom roles it is given
+type fakeRoleService struct {
+	custom map[string]bool
+}
+
+func newFakeRoleService(custom ...string) *fakeRoleService {
+	s := &fakeRoleService{custom: map[string]bool{}}
+	for _, name := range custom {
+		s.custom[name] = true
+	}
+	return s
+}
+
+func (s *fakeRoleService) CreateRole(ctx context.Context, role *models.Role) error {
+	s.custom[role.Name] = true
+	return nil
+}
+
+func (s *fakeRoleService) GetAllRoles(ctx context.Context) ([]models.Role, error) {
+	return nil, nil
+}
+
+func (s *fakeRoleService) UpdateRole(ctx context.Context, name string, role *models.Role) error {
+	return nil
+}
+
+func (s *fakeRoleService) DeleteRole(ctx context.Context, name string) error {
+	delete(s.custom, name)
+	return nil
+}
+
+func (s *fakeRoleService) ValidateRoles(ctx context.Context, names []string) error {
+	for _, name := range names {
+		if _, ok := models.BuiltinRoles[name]; !ok && !s.custom[name] {
+			return ErrUnknownRole
+		}
+	}
+	return nil
+}
+
+func (s *fakeRoleService) HasPermission(ctx context.Context, roles []string, permission string) (bool, error) {
+	for _, role := range roles {
+		if role == models.RoleAdmin {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *fakeRoleService) MemberHasPermission(ctx context.Context, userID, permission string) (bool, error) {
+	return false, nil
+}
+
+// recordedEvent is an audit event as a fakeAuditService saw it
+type recordedEvent struct {
+	Action   string
+	Actor    models.AuditActor
+	TargetID string
+	Changes  []models.AuditChange
+}
+
+// after maps the changed fields of the event to their new values
+func (e recordedEvent) after() map[string]interface{} {
+	values := map[string]interface{}{}
+	for _, change := range e.Changes {
+		values[change.Field] = change.After
+	}
+	return values
+}
+
+// fakeAuditService keeps recorded events in memory
+type fakeAuditService struct {
+	mu     sync.Mutex
+	events []recordedEvent
+}
+
+func (s *fakeAuditService) Record(ctx context.Context, action, targetType, targetID string, before, after interface{}) {
+	changes, _ := auditChanges(before, after)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, recordedEvent{Action: action, Actor: RequestMetadataFrom(ctx).Actor, TargetID: targetID, Changes: changes})
+}
+
+func (s *fakeAuditService) List(ctx context.Context, query *models.AuditQuery) (*models.AuditEventPage, error) {
+	return &models.AuditEventPage{}, nil
+}
+
+func (s *fakeAuditService) Verify(ctx context.Context, checkpoints []string) (*models.AuditVerification, error) {
+	return &models.AuditVerification{}, nil
+}
+
+func (s *fakeAuditService) Checkpoint(ctx context.Context) (*models.AuditCheckpoint, error) {
+	return nil, ErrAuditCheckpointsDisabled
+}
+
+// actions returns the actions recorded so far, oldest first
+func (s *fakeAuditService) actions() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	actions := make([]string, len(s.events))
+	for i, event := range s.events {
+		actions[i] = event.Action
+	}
+	return actions
+}
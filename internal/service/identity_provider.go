@@ -0,0 +1,276 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"gin-mongo-aws/internal/config"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// externalIdentity is what a provider reports about the user who signed in
+type externalIdentity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+	GivenName     string
+	FamilyName    string
+}
+
+// identityProvider sends users to an external provider and turns the
+// authorization code it returns into the identity of the user
+type identityProvider interface {
+	authCodeURL(ctx context.Context, state, nonce, verifier string) (string, error)
+	exchange(ctx context.Context, code, nonce, verifier string) (*externalIdentity, error)
+}
+
+func newIdentityProvider(name string, cfg config.IdentityProviderConfig, client *http.Client) (identityProvider, error) {
+	if cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("identity_providers.%s: client_id and redirect_url are required", name)
+	}
+
+	if cfg.Issuer != "" {
+		return &oidcProvider{cfg: cfg, client: client}, nil
+	}
+
+	if cfg.AuthURL == "" || cfg.TokenURL == "" || cfg.UserInfoURL == "" {
+		return nil, fmt.Errorf("identity_providers.%s: set issuer, or auth_url, token_url and userinfo_url", name)
+	}
+	return &oauth2Provider{
+		cfg:    cfg,
+		client: client,
+		oauth2: &oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     oauth2.Endpoint{AuthURL: cfg.AuthURL, TokenURL: cfg.TokenURL},
+			Scopes:       cfg.Scopes,
+		},
+	}, nil
+}
+
+// oidcProvider signs in with an OpenID Connect provider. Discovery happens on
+// first use so a provider that is down does not stop the API from starting.
+type oidcProvider struct {
+	cfg    config.IdentityProviderConfig
+	client *http.Client
+
+	mu       sync.Mutex
+	provider *oidc.Provider
+	oauth2   *oauth2.Config
+}
+
+func (p *oidcProvider) discover(ctx context.Context) (*oidc.Provider, *oauth2.Config, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.provider != nil {
+		return p.provider, p.oauth2, nil
+	}
+
+	provider, err := oidc.NewProvider(oidc.ClientContext(ctx, p.client), p.cfg.Issuer)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	scopes := p.cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{oidc.ScopeOpenID, "email", "profile"}
+	}
+	p.provider = provider
+	p.oauth2 = &oauth2.Config{
+		ClientID:     p.cfg.ClientID,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       scopes,
+	}
+	return p.provider, p.oauth2, nil
+}
+
+func (p *oidcProvider) authCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	_, cfg, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return cfg.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+func (p *oidcProvider) exchange(ctx context.Context, code, nonce, verifier string) (*externalIdentity, error) {
+	provider, cfg, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = oidc.ClientContext(ctx, p.client)
+	token, err := cfg.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return nil, errors.New("token response has no id_token")
+	}
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: p.cfg.ClientID}).Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, err
+	}
+	if idToken.Nonce != nonce {
+		return nil, errors.New("id_token nonce does not match")
+	}
+
+	var claims struct {
+		Email         string    `json:"email"`
+		EmailVerified claimBool `json:"email_verified"`
+		Name          string    `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+
+	// Some providers only put the email in the userinfo response
+	if claims.Email == "" && provider.UserInfoEndpoint() != "" {
+		info, err := provider.UserInfo(ctx, oauth2.StaticTokenSource(token))
+		if err != nil {
+			return nil, err
+		}
+		if info.Subject != idToken.Subject {
+			return nil, errors.New("userinfo subject does not match id_token")
+		}
+		if err := info.Claims(&claims); err != nil {
+			return nil, err
+		}
+	}
+
+	return &externalIdentity{
+		Subject:       idToken.Subject,
+		Email:         claims.Email,
+		EmailVerified: bool(claims.EmailVerified),
+		Name:          claims.Name,
+	}, nil
+}
+
+// oauth2Provider signs in with a provider that only speaks OAuth2, such as
+// GitHub, and reads the user from its userinfo endpoint
+type oauth2Provider struct {
+	cfg    config.IdentityProviderConfig
+	client *http.Client
+	oauth2 *oauth2.Config
+}
+
+func (p *oauth2Provider) authCodeURL(_ context.Context, state, _, verifier string) (string, error) {
+	return p.oauth2.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier)), nil
+}
+
+func (p *oauth2Provider) exchange(ctx context.Context, code, _, verifier string) (*externalIdentity, error) {
+	ctx = context.WithValue(ctx, oauth2.HTTPClient, p.client)
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, err
+	}
+	client := p.oauth2.Client(ctx, token)
+
+	var info map[string]interface{}
+	if err := getJSON(ctx, client, p.cfg.UserInfoURL, &info); err != nil {
+		return nil, err
+	}
+
+	subjectClaim := p.cfg.SubjectClaim
+	if subjectClaim == "" {
+		subjectClaim = "sub"
+	}
+	identity := &externalIdentity{
+		Subject:       claimString(info[subjectClaim]),
+		Email:         claimString(info["email"]),
+		EmailVerified: claimTrue(info["email_verified"]),
+		Name:          claimString(info["name"]),
+	}
+	if identity.Subject == "" {
+		return nil, fmt.Errorf("userinfo response has no %q", subjectClaim)
+	}
+	if identity.Name == "" {
+		identity.Name = claimString(info["login"])
+	}
+
+	if p.cfg.EmailsURL != "" {
+		var emails []struct {
+			Email    string `json:"email"`
+			Primary  bool   `json:"primary"`
+			Verified bool   `json:"verified"`
+		}
+		if err := getJSON(ctx, client, p.cfg.EmailsURL, &emails); err != nil {
+			return nil, err
+		}
+		identity.Email, identity.EmailVerified = "", false
+		for _, e := range emails {
+			if e.Verified && (identity.Email == "" || e.Primary) {
+				identity.Email, identity.EmailVerified = e.Email, true
+			}
+		}
+	}
+
+	return identity, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s: %s", url, resp.Status, body)
+	}
+
+	decoder := json.NewDecoder(io.LimitReader(resp.Body, 1<<20))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// claimBool accepts booleans sent as JSON strings, which some providers use
+type claimBool bool
+
+func (b *claimBool) UnmarshalJSON(data []byte) error {
+	*b = claimBool(strings.Trim(string(data), `"`) == "true")
+	return nil
+}
+
+func claimString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	default:
+		return ""
+	}
+}
+
+func claimTrue(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case string:
+		return v == "true"
+	default:
+		return false
+	}
+}
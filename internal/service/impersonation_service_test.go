@@ -0,0 +1,76 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/tenant"
+	"gin-mongo-aws/internal/testutil"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestImpersonationAuditRecordsExpiryAndEnd(t *testing.T) {
+	testutil.StartRedis(t)
+	ctx := tenant.WithOrgID(context.Background(), primitive.NewObjectID())
+	users := testutil.NewUserRepository()
+	admin := &models.User{Name: "Support", Email: "support@example.com", Roles: []string{models.RoleAdmin}}
+	customer := &models.User{Name: "Customer", Email: "customer@example.com", Roles: []string{models.RoleUser}}
+	for _, user := range []*models.User{admin, customer} {
+		if err := users.Create(ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tokens, err := NewTokenService(config.JWTConfig{Algorithm: "HS256", Secret: "test-secret", ImpersonationTTL: 15 * time.Minute,
+		Issuer: "https://auth.example.com", Audience: "api"}, nil, NewTokenDenylist())
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit := &fakeAuditService{}
+	svc := NewImpersonationService(users, newFakeRoleService(), tokens, audit)
+
+	started := time.Now()
+	resp, err := svc.Start(ctx, admin.ID.Hex(), customer.ID.Hex(), ClientInfo{})
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	claims, err := tokens.ParseAccessToken(ctx, resp.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := svc.Stop(ctx, claims, ClientInfo{}); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	stopped := time.Now()
+
+	if len(audit.events) != 2 {
+		t.Fatalf("recorded %v, want a start and a stop", audit.actions())
+	}
+	start, stop := audit.events[0].after(), audit.events[1].after()
+	if audit.events[0].Action != models.AuditImpersonationStart || audit.events[1].Action != models.AuditImpersonationStop {
+		t.Fatalf("recorded %v", audit.actions())
+	}
+	for _, changes := range []map[string]interface{}{start, stop} {
+		if id, _ := changes["token_id"].(string); id != claims.ID {
+			t.Errorf("token_id = %v, want %s", changes["token_id"], claims.ID)
+		}
+	}
+
+	// The token expires after jwt.impersonation_token_ttl
+	expiresAt, _ := start["expires_at"].(primitive.DateTime)
+	if expiry := started.Add(15 * time.Minute); expiresAt.Time().Before(expiry.Add(-time.Second)) || expiresAt.Time().After(expiry.Add(time.Minute)) {
+		t.Errorf("expires_at = %s, want the token expiry around %s", expiresAt.Time(), expiry)
+	}
+	if endedAt, ok := start["ended_at"]; ok {
+		t.Errorf("start recorded ended_at = %v before the impersonation ended", endedAt)
+	}
+
+	endedAt, _ := stop["ended_at"].(primitive.DateTime)
+	if endedAt.Time().Before(started.Add(-time.Second)) || endedAt.Time().After(stopped.Add(time.Second)) {
+		t.Errorf("ended_at = %s, want the stop around %s", endedAt.Time(), stopped)
+	}
+}
@@ -0,0 +1,299 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/logger"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/repository"
+	"gin-mongo-aws/internal/tenant"
+
+	"github.com/go-ldap/ldap/v3"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrDirectoryUnavailable   = errors.New("sign-in is temporarily unavailable, try again later")
+	ErrDirectoryLinkRequired  = errors.New("an account with this email already exists; an administrator must link it to the directory")
+	ErrDirectoryNotConfigured = errors.New("the ldap authenticator is not enabled")
+)
+
+// ldapAuthenticator binds to an LDAP directory or Active Directory with the
+// user's password. Users are provisioned into Mongo on their first login and
+// their name and roles are refreshed from the directory on every login. The
+// directory serves one organization; logins to any other are not sent to it.
+type ldapAuthenticator struct {
+	cfg        config.LDAPConfig
+	orgs       OrganizationService
+	users      repository.UserRepository
+	roles      RoleService
+	audit      AuditService
+	tls        *tls.Config
+	groupRoles []ldapGroupRoles
+}
+
+type ldapGroupRoles struct {
+	group *ldap.DN
+	roles []string
+}
+
+func NewLDAPAuthenticator(cfg config.LDAPConfig, orgs OrganizationService, users repository.UserRepository, roles RoleService, audit AuditService) (Authenticator, error) {
+	if cfg.URL == "" || cfg.BaseDN == "" {
+		return nil, errors.New("auth.ldap.url and auth.ldap.base_dn are required for the ldap authenticator")
+	}
+	if cfg.Organization == "" {
+		return nil, errors.New("auth.ldap.organization is required for the ldap authenticator")
+	}
+	if !strings.Contains(cfg.UserFilter, "{login}") {
+		return nil, errors.New("auth.ldap.user_filter must contain {login}")
+	}
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid auth.ldap.url: %w", err)
+	}
+
+	a := &ldapAuthenticator{
+		cfg:   cfg,
+		orgs:  orgs,
+		users: users,
+		roles: roles,
+		audit: audit,
+		tls:   &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: cfg.InsecureSkipVerify},
+	}
+	for _, mapping := range cfg.GroupRoles {
+		group, err := ldap.ParseDN(mapping.Group)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group %q in auth.ldap.group_roles: %w", mapping.Group, err)
+		}
+		a.groupRoles = append(a.groupRoles, ldapGroupRoles{group: group, roles: mapping.Roles})
+	}
+	return a, nil
+}
+
+func (a *ldapAuthenticator) Name() string {
+	return models.AuthSourceLDAP
+}
+
+func (a *ldapAuthenticator) Authenticate(ctx context.Context, login, password string) (*models.User, error) {
+	// An empty password would be an unauthenticated bind, which succeeds
+	if login == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	if err := a.checkOrganization(ctx); err != nil {
+		return nil, err
+	}
+
+	entry, err := a.bind(login, password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		return nil, err
+	}
+	if err != nil {
+		// The directory's own error may name hosts and accounts, so it is
+		// only logged
+		logger.Log.Error("Directory login failed", zap.Error(err))
+		return nil, ErrDirectoryUnavailable
+	}
+
+	email := NormalizeEmail(entry.GetAttributeValue(a.cfg.EmailAttribute))
+	if email == "" {
+		logger.Log.Warn("Directory user has no email", zap.String("dn", entry.DN))
+		return nil, ErrInvalidCredentials
+	}
+	name := entry.GetAttributeValue(a.cfg.NameAttribute)
+	if name == "" {
+		name, _, _ = strings.Cut(email, "@")
+	}
+
+	roles, err := a.knownRoles(ctx, a.rolesFor(entry.GetAttributeValues(a.cfg.GroupAttribute)))
+	if err != nil {
+		return nil, err
+	}
+	return a.provision(ctx, email, name, roles)
+}
+
+// checkOrganization refuses logins to any organization but the directory's,
+// so its users, admins included, cannot be provisioned into another tenant
+func (a *ldapAuthenticator) checkOrganization(ctx context.Context) error {
+	org, err := a.orgs.Resolve(ctx, a.cfg.Organization)
+	if err != nil {
+		logger.Log.Error("Directory organization unavailable", zap.String("organization", a.cfg.Organization), zap.Error(err))
+		return ErrDirectoryUnavailable
+	}
+	if current, err := tenant.OrgID(ctx); err != nil || current != org.ID {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// bind finds the user's entry with the service account and then binds as
+// that entry to check the password
+func (a *ldapAuthenticator) bind(login, password string) (*ldap.Entry, error) {
+	conn, err := ldap.DialURL(a.cfg.URL,
+		ldap.DialWithTLSConfig(a.tls),
+		ldap.DialWithDialer(&net.Dialer{Timeout: a.cfg.Timeout}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ldap: %w", err)
+	}
+	defer conn.Close()
+	conn.SetTimeout(a.cfg.Timeout)
+
+	if a.cfg.StartTLS {
+		if err := conn.StartTLS(a.tls); err != nil {
+			return nil, fmt.Errorf("ldap: %w", err)
+		}
+	}
+
+	if a.cfg.BindDN != "" {
+		err = conn.Bind(a.cfg.BindDN, a.cfg.BindPassword)
+	} else {
+		err = conn.UnauthenticatedBind("")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("ldap service bind: %w", err)
+	}
+
+	result, err := conn.Search(ldap.NewSearchRequest(
+		a.cfg.BaseDN,
+		ldap.ScopeWholeSubtree, ldap.NeverDerefAliases,
+		2, int(a.cfg.Timeout.Seconds()), false,
+		strings.ReplaceAll(a.cfg.UserFilter, "{login}", ldap.EscapeFilter(login)),
+		[]string{a.cfg.EmailAttribute, a.cfg.NameAttribute, a.cfg.GroupAttribute},
+		nil,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("ldap search: %w", err)
+	}
+	// An ambiguous login is refused rather than guessed
+	if len(result.Entries) != 1 {
+		return nil, ErrInvalidCredentials
+	}
+	entry := result.Entries[0]
+
+	if err := conn.Bind(entry.DN, password); err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, ErrInvalidCredentials
+		}
+		return nil, fmt.Errorf("ldap bind: %w", err)
+	}
+	return entry, nil
+}
+
+// rolesFor maps the user's group DNs to roles; DNs compare case-insensitively
+func (a *ldapAuthenticator) rolesFor(groups []string) []string {
+	roles := append([]string{}, a.cfg.DefaultRoles...)
+	for _, group := range groups {
+		dn, err := ldap.ParseDN(group)
+		if err != nil {
+			continue
+		}
+		for _, mapping := range a.groupRoles {
+			if mapping.group.EqualFold(dn) {
+				roles = append(roles, mapping.roles...)
+			}
+		}
+	}
+	return dedupe(roles)
+}
+
+// knownRoles drops mapped roles that do not exist in the role store, so a
+// mistyped mapping or a deleted role cannot be granted
+func (a *ldapAuthenticator) knownRoles(ctx context.Context, roles []string) ([]string, error) {
+	known := make([]string, 0, len(roles))
+	for _, role := range roles {
+		err := a.roles.ValidateRoles(ctx, []string{role})
+		if errors.Is(err, ErrUnknownRole) {
+			logger.Log.Warn("Ignoring unknown role mapped from the directory", zap.String("role", role))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		known = append(known, role)
+	}
+	return known, nil
+}
+
+// provision creates the user on first login and otherwise brings their name
+// and roles in line with the directory. An existing local account with the
+// same email is refused until an administrator links it to the directory, or
+// anyone able to create a directory entry could take it over.
+func (a *ldapAuthenticator) provision(ctx context.Context, email, name string, roles []string) (*models.User, error) {
+	user, err := a.users.FindByEmail(ctx, email)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		user = &models.User{
+			Name:          name,
+			Email:         email,
+			EmailVerified: true,
+			Roles:         roles,
+			AuthSource:    models.AuthSourceLDAP,
+		}
+		if err := a.users.Create(ctx, user); err != nil {
+			if !mongo.IsDuplicateKeyError(err) {
+				return nil, err
+			}
+			// Provisioned by a concurrent login
+			return a.users.FindByEmail(ctx, email)
+		}
+		a.audit.Record(auditAsUser(ctx, user), models.AuditUserCreate, models.AuditTargetUser, user.ID.Hex(), nil, user)
+		logger.Log.Info("Provisioned directory user", zap.String("user_id", user.ID.Hex()), zap.Strings("roles", roles))
+		return user, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	userID := user.ID.Hex()
+	if user.AuthSource != models.AuthSourceLDAP {
+		logger.Log.Warn("Directory login refused for a local account", zap.String("user_id", userID))
+		return nil, ErrDirectoryLinkRequired
+	}
+	if user.Name == name && sameRoles(user.Roles, roles) {
+		return user, nil
+	}
+
+	if err := a.users.SetDirectoryProfile(ctx, userID, models.AuthSourceLDAP, name, roles); err != nil {
+		return nil, err
+	}
+
+	// Invalidate cache
+	database.RedisClient.Del(ctx, "user:"+userID)
+
+	before := *user
+	user.Name = name
+	user.Roles = roles
+	user.EmailVerified = true
+	user.PasswordHash = ""
+	action := models.AuditUserUpdate
+	if !sameRoles(before.Roles, roles) {
+		action = models.AuditUserRoles
+	}
+	a.audit.Record(auditAsUser(ctx, user), action, models.AuditTargetUser, userID, &before, user)
+	return user, nil
+}
+
+func sameRoles(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := make(map[string]bool, len(a))
+	for _, role := range a {
+		seen[role] = true
+	}
+	for _, role := range b {
+		if !seen[role] {
+			return false
+		}
+	}
+	return true
+}
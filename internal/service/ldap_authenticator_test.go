@@ -0,0 +1,398 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/tenant"
+	"gin-mongo-aws/internal/testutil"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+const (
+	ldapServiceDN       = "cn=service,dc=example,dc=com"
+	ldapServicePassword = "service-secret"
+	ldapEditorsGroup    = "cn=editors,ou=groups,dc=example,dc=com"
+	ldapGhostsGroup     = "cn=ghosts,ou=groups,dc=example,dc=com"
+)
+
+type ldapTestEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// stubLDAPServer answers binds and searches over a real socket, enough for
+// the go-ldap client: simple binds against the service account and entry
+// passwords, and searches whose filter names an entry's uid
+type stubLDAPServer struct {
+	listener net.Listener
+	entries  []ldapTestEntry
+}
+
+func newStubLDAPServer(t *testing.T, entries ...ldapTestEntry) *stubLDAPServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &stubLDAPServer{listener: listener, entries: entries}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.handle(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return s
+}
+
+func (s *stubLDAPServer) URL() string {
+	return "ldap://" + s.listener.Addr().String()
+}
+
+func (s *stubLDAPServer) handle(conn net.Conn) {
+	defer conn.Close()
+	for {
+		packet, err := ber.ReadPacket(conn)
+		if err != nil || len(packet.Children) < 2 {
+			return
+		}
+		id, _ := packet.Children[0].Value.(int64)
+		op := packet.Children[1]
+
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			code := uint16(ldap.LDAPResultInvalidCredentials)
+			if s.bind(op.Children[1].Data.String(), op.Children[2].Data.String()) {
+				code = ldap.LDAPResultSuccess
+			}
+			conn.Write(ldapResult(id, ldap.ApplicationBindResponse, code).Bytes())
+		case ldap.ApplicationSearchRequest:
+			filter, err := ldap.DecompileFilter(op.Children[6])
+			if err != nil {
+				conn.Write(ldapResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultProtocolError).Bytes())
+				continue
+			}
+			for _, entry := range s.entries {
+				if strings.Contains(filter, "(uid="+entry.attrs["uid"][0]+")") {
+					conn.Write(ldapSearchEntry(id, entry).Bytes())
+				}
+			}
+			conn.Write(ldapResult(id, ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess).Bytes())
+		default:
+			return
+		}
+	}
+}
+
+func (s *stubLDAPServer) bind(dn, password string) bool {
+	if dn == ldapServiceDN {
+		return password == ldapServicePassword
+	}
+	for _, entry := range s.entries {
+		if entry.dn == dn {
+			return password != "" && password == entry.password
+		}
+	}
+	return false
+}
+
+// ldapMessage wraps a protocol operation in an LDAPMessage. asn1-ber
+// serializes a child when it is appended, so op must be complete.
+func ldapMessage(id int64, op *ber.Packet) *ber.Packet {
+	envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, id, ""))
+	envelope.AppendChild(op)
+	return envelope
+}
+
+func ldapResult(id int64, tag ber.Tag, code uint16) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "")
+	op.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, int64(code), ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", ""))
+	return ldapMessage(id, op)
+}
+
+func ldapSearchEntry(id int64, entry ldapTestEntry) *ber.Packet {
+	op := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "")
+	op.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, entry.dn, ""))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+	for name, values := range entry.attrs {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, ""))
+		set := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "")
+		for _, value := range values {
+			set.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, value, ""))
+		}
+		attr.AppendChild(set)
+		attrs.AppendChild(attr)
+	}
+	op.AppendChild(attrs)
+	return ldapMessage(id, op)
+}
+
+func ldapTestConfig(url string) config.LDAPConfig {
+	return config.LDAPConfig{
+		URL:            url,
+		BindDN:         ldapServiceDN,
+		BindPassword:   ldapServicePassword,
+		BaseDN:         "dc=example,dc=com",
+		UserFilter:     "(&(objectClass=person)(uid={login}))",
+		EmailAttribute: "mail",
+		NameAttribute:  "cn",
+		GroupAttribute: "memberOf",
+		GroupRoles: []config.LDAPGroupRoles{
+			{Group: ldapEditorsGroup, Roles: []string{"editor"}},
+			{Group: ldapGhostsGroup, Roles: []string{"ghost"}},
+		},
+		DefaultRoles: []string{models.RoleUser},
+		Timeout:      5 * time.Second,
+	}
+}
+
+var ldapAlice = ldapTestEntry{
+	dn:       "uid=alice,ou=people,dc=example,dc=com",
+	password: "alice-password",
+	attrs: map[string][]string{
+		"uid":      {"alice"},
+		"mail":     {"Alice@Example.com"},
+		"cn":       {"Alice Directory"},
+		"memberOf": {"CN=Editors,OU=Groups,DC=example,DC=com", ldapGhostsGroup},
+	},
+}
+
+type ldapFixture struct {
+	ctx    context.Context
+	orgs   *fakeOrganizationRepository
+	users  *testutil.UserRepository
+	audit  *fakeAuditService
+	ldap   Authenticator
+	server *stubLDAPServer
+}
+
+func newLDAPFixture(t *testing.T, cfg func(*config.LDAPConfig)) *ldapFixture {
+	t.Helper()
+	testutil.StartRedis(t)
+
+	f := &ldapFixture{
+		orgs:   newFakeOrganizationRepository(),
+		users:  testutil.NewUserRepository(),
+		audit:  &fakeAuditService{},
+		server: newStubLDAPServer(t, ldapAlice),
+	}
+	org := &models.Organization{Slug: "default", Name: "Default"}
+	if err := f.orgs.Create(context.Background(), org); err != nil {
+		t.Fatal(err)
+	}
+	f.ctx = tenant.WithOrgID(context.Background(), org.ID)
+
+	ldapCfg := ldapTestConfig(f.server.URL())
+	ldapCfg.Organization = org.Slug
+	if cfg != nil {
+		cfg(&ldapCfg)
+	}
+	orgs := NewOrganizationService(f.orgs, f.users, f.audit)
+	authenticator, err := NewLDAPAuthenticator(ldapCfg, orgs, f.users, newFakeRoleService("editor"), f.audit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.ldap = authenticator
+	return f
+}
+
+func TestLDAPProvisionsUserWithKnownRoles(t *testing.T) {
+	f := newLDAPFixture(t, nil)
+
+	user, err := f.ldap.Authenticate(f.ctx, "alice", "alice-password")
+	if err != nil {
+		t.Fatalf("Authenticate: %v", err)
+	}
+	if user.Email != "alice@example.com" || user.Name != "Alice Directory" || user.AuthSource != models.AuthSourceLDAP {
+		t.Errorf("provisioned user = %+v", user)
+	}
+	// The ghost role is mapped but does not exist
+	if !sameRoles(user.Roles, []string{models.RoleUser, "editor"}) {
+		t.Errorf("roles = %v, want user and editor", user.Roles)
+	}
+	if got := f.audit.actions(); len(got) != 1 || got[0] != models.AuditUserCreate {
+		t.Errorf("audit actions = %v, want [%s]", got, models.AuditUserCreate)
+	}
+
+	// A later login with the same profile changes nothing
+	if _, err := f.ldap.Authenticate(f.ctx, "alice", "alice-password"); err != nil {
+		t.Fatalf("second Authenticate: %v", err)
+	}
+	if got := f.audit.actions(); len(got) != 1 {
+		t.Errorf("audit actions after unchanged login = %v", got)
+	}
+}
+
+func TestLDAPRejectsWrongPassword(t *testing.T) {
+	f := newLDAPFixture(t, nil)
+
+	for _, tc := range []struct{ login, password string }{
+		{"alice", "wrong"},
+		{"alice", ""},
+		{"nobody", "alice-password"},
+	} {
+		if _, err := f.ldap.Authenticate(f.ctx, tc.login, tc.password); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("Authenticate(%q, %q): err = %v, want ErrInvalidCredentials", tc.login, tc.password, err)
+		}
+	}
+}
+
+func TestLDAPRefusesOtherOrganizations(t *testing.T) {
+	f := newLDAPFixture(t, nil)
+
+	acme := &models.Organization{Slug: "acme", Name: "Acme"}
+	if err := f.orgs.Create(context.Background(), acme); err != nil {
+		t.Fatal(err)
+	}
+	for name, ctx := range map[string]context.Context{
+		"another organization": tenant.WithOrgID(context.Background(), acme.ID),
+		"no organization":      context.Background(),
+	} {
+		if _, err := f.ldap.Authenticate(ctx, "alice", "alice-password"); !errors.Is(err, ErrInvalidCredentials) {
+			t.Errorf("%s: err = %v, want ErrInvalidCredentials", name, err)
+		}
+	}
+	if _, err := f.users.FindByEmail(tenant.WithOrgID(context.Background(), acme.ID), "alice@example.com"); err == nil {
+		t.Error("directory user was provisioned into another organization")
+	}
+	if got := f.audit.actions(); len(got) != 0 {
+		t.Errorf("audit actions = %v, want none", got)
+	}
+}
+
+func TestLDAPRefusesUnlinkedLocalAccount(t *testing.T) {
+	f := newLDAPFixture(t, nil)
+
+	local := &models.User{Name: "Local Alice", Email: "alice@example.com", PasswordHash: "local-hash", Roles: []string{models.RoleUser}}
+	if err := f.users.Create(f.ctx, local); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := f.ldap.Authenticate(f.ctx, "alice", "alice-password"); !errors.Is(err, ErrDirectoryLinkRequired) {
+		t.Fatalf("err = %v, want ErrDirectoryLinkRequired", err)
+	}
+	stored, _ := f.users.FindByID(f.ctx, local.ID.Hex())
+	if stored.PasswordHash != "local-hash" || stored.AuthSource != "" {
+		t.Errorf("local account was modified: %+v", stored)
+	}
+
+	// Once an administrator links it, the directory manages the account
+	if err := f.users.SetDirectoryProfile(f.ctx, local.ID.Hex(), models.AuthSourceLDAP, local.Name, local.Roles); err != nil {
+		t.Fatal(err)
+	}
+	user, err := f.ldap.Authenticate(f.ctx, "alice", "alice-password")
+	if err != nil {
+		t.Fatalf("Authenticate after link: %v", err)
+	}
+	if user.ID != local.ID || user.Name != "Alice Directory" || !sameRoles(user.Roles, []string{models.RoleUser, "editor"}) {
+		t.Errorf("linked user = %+v", user)
+	}
+	if got := f.audit.actions(); len(got) != 1 || got[0] != models.AuditUserRoles {
+		t.Errorf("audit actions = %v, want [%s]", got, models.AuditUserRoles)
+	}
+}
+
+func TestLDAPErrorsAreGeneric(t *testing.T) {
+	t.Run("service bind refused", func(t *testing.T) {
+		f := newLDAPFixture(t, func(cfg *config.LDAPConfig) { cfg.BindPassword = "wrong" })
+		_, err := f.ldap.Authenticate(f.ctx, "alice", "alice-password")
+		if !errors.Is(err, ErrDirectoryUnavailable) || err.Error() != ErrDirectoryUnavailable.Error() {
+			t.Errorf("err = %v, want ErrDirectoryUnavailable without details", err)
+		}
+	})
+
+	t.Run("directory unreachable", func(t *testing.T) {
+		f := newLDAPFixture(t, func(cfg *config.LDAPConfig) { cfg.URL = closedLDAPURL(t) })
+		_, err := f.ldap.Authenticate(f.ctx, "alice", "alice-password")
+		if !errors.Is(err, ErrDirectoryUnavailable) || err.Error() != ErrDirectoryUnavailable.Error() {
+			t.Errorf("err = %v, want ErrDirectoryUnavailable without details", err)
+		}
+	})
+}
+
+func TestLoginCountsUnavailableDirectoryAsFailure(t *testing.T) {
+	f := newLDAPFixture(t, func(cfg *config.LDAPConfig) { cfg.URL = closedLDAPURL(t) })
+	auth := newLDAPAuthService(t, f)
+
+	_, err := auth.Login(f.ctx, &models.LoginRequest{Username: "alice", Password: "alice-password"}, "192.0.2.1")
+	if !errors.Is(err, ErrDirectoryUnavailable) {
+		t.Fatalf("err = %v, want ErrDirectoryUnavailable", err)
+	}
+
+	failures, err := database.RedisClient.Get(f.ctx, lockoutKey("fail:acct", tenant.Key(f.ctx, "alice"))).Result()
+	if err != nil || failures != "1" {
+		t.Errorf("account failures = %q (%v), want 1", failures, err)
+	}
+}
+
+func TestLinkDirectoryHandsAccountToDirectory(t *testing.T) {
+	f := newLDAPFixture(t, nil)
+	auth := newLDAPAuthService(t, f)
+
+	local := &models.User{Name: "Local Alice", Email: "alice@example.com", PasswordHash: "local-hash", Roles: []string{models.RoleUser}}
+	if err := f.users.Create(f.ctx, local); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := auth.LinkDirectory(f.ctx, local.ID.Hex()); err != nil {
+		t.Fatalf("LinkDirectory: %v", err)
+	}
+	stored, _ := f.users.FindByID(f.ctx, local.ID.Hex())
+	if stored.AuthSource != models.AuthSourceLDAP || stored.PasswordHash != "" {
+		t.Errorf("linked account = %+v", stored)
+	}
+
+	user, err := auth.Login(f.ctx, &models.LoginRequest{Username: "alice", Password: "alice-password"}, "192.0.2.1")
+	if err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if user.ID != local.ID {
+		t.Errorf("signed in %s, want %s", user.ID.Hex(), local.ID.Hex())
+	}
+}
+
+func newLDAPAuthService(t *testing.T, f *ldapFixture) AuthService {
+	t.Helper()
+
+	cfg := config.AuthConfig{
+		LinkSecret: "test-link-secret",
+		Lockout:    config.LockoutConfig{MaxAttempts: 5, IPMaxAttempts: 20, Window: time.Minute, Duration: time.Minute},
+		Session:    config.SessionConfig{IdleTTL: time.Hour, AbsoluteTTL: time.Hour},
+	}
+	auth, err := NewAuthService(f.users, NewRefreshTokenService(time.Hour), NewSessionService(cfg.Session), NewLoginGuard(cfg.Lockout), []Authenticator{f.ldap}, nil, f.audit, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return auth
+}
+
+// closedLDAPURL returns a URL nothing listens on
+func closedLDAPURL(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return "ldap://" + addr
+}
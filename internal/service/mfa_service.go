@@ -0,0 +1,323 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image/png"
+	"strings"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/logger"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/repository"
+	"gin-mongo-aws/internal/tenant"
+
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrMFAAlreadyEnabled   = errors.New("multi-factor authentication is already enabled")
+	ErrMFANotEnabled       = errors.New("multi-factor authentication is not enabled")
+	ErrMFANotEnrolling     = errors.New("no pending multi-factor enrollment")
+	ErrInvalidMFACode      = errors.New("invalid verification code")
+	ErrInvalidMFAChallenge = errors.New("invalid or expired mfa token")
+)
+
+const (
+	recoveryCodeCount    = 10
+	maxMFAChallengeTries = 5
+	totpValidationSkew   = 1
+	totpCodeReplayWindow = 2 * time.Minute
+	qrCodeSize           = 256
+)
+
+type MFAService interface {
+	Enroll(ctx context.Context, userID string) (*models.MFAEnrollment, error)
+	Confirm(ctx context.Context, userID, code string) ([]string, error)
+	Disable(ctx context.Context, userID string, req *models.MFACodeRequest) error
+	StartChallenge(ctx context.Context, user *models.User) (string, error)
+	VerifyChallenge(ctx context.Context, req *models.MFAVerifyRequest, ip string) (*models.User, error)
+}
+
+type mfaService struct {
+	repo  repository.UserRepository
+	guard LoginGuard
+	audit AuditService
+	cfg   config.AuthConfig
+}
+
+func NewMFAService(repo repository.UserRepository, guard LoginGuard, audit AuditService, cfg config.AuthConfig) MFAService {
+	return &mfaService{repo: repo, guard: guard, audit: audit, cfg: cfg}
+}
+
+func (s *mfaService) Enroll(ctx context.Context, userID string) (*models.MFAEnrollment, error) {
+	user, err := s.repo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+
+	key, err := totp.Generate(totp.GenerateOpts{
+		Issuer:      s.cfg.MFAIssuer,
+		AccountName: user.Email,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.SetPendingMFASecret(ctx, userID, key.Secret()); err != nil {
+		return nil, err
+	}
+
+	qr, err := qrCodeDataURI(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.MFAEnrollment{
+		Secret:     key.Secret(),
+		OTPAuthURI: key.URL(),
+		QRCodePNG:  qr,
+	}, nil
+}
+
+func (s *mfaService) Confirm(ctx context.Context, userID, code string) ([]string, error) {
+	user, err := s.repo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.MFAEnabled {
+		return nil, ErrMFAAlreadyEnabled
+	}
+	if user.MFAPending == "" {
+		return nil, ErrMFANotEnrolling
+	}
+
+	if !s.validateTOTP(ctx, userID, user.MFAPending, code) {
+		return nil, ErrInvalidMFACode
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.repo.EnableMFA(ctx, userID, user.MFAPending, hashes); err != nil {
+		return nil, err
+	}
+
+	// Invalidate cache
+	database.RedisClient.Del(ctx, "user:"+userID)
+
+	after := *user
+	after.MFAEnabled = true
+	after.MFASecret, after.MFAPending, after.RecoveryCodes = user.MFAPending, "", hashes
+	s.audit.Record(ctx, models.AuditUserUpdate, models.AuditTargetUser, userID, user, &after)
+
+	logger.Log.Info("MFA enabled", zap.String("user_id", userID))
+	return codes, nil
+}
+
+func (s *mfaService) Disable(ctx context.Context, userID string, req *models.MFACodeRequest) error {
+	user, err := s.repo.FindByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if !user.MFAEnabled {
+		return ErrMFANotEnabled
+	}
+
+	ok, err := s.verifySecondFactor(ctx, user, req.Code, req.RecoveryCode)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidMFACode
+	}
+
+	if err := s.repo.DisableMFA(ctx, userID); err != nil {
+		return err
+	}
+
+	// Invalidate cache
+	database.RedisClient.Del(ctx, "user:"+userID)
+
+	after := *user
+	after.MFAEnabled = false
+	after.MFASecret, after.MFAPending, after.RecoveryCodes = "", "", nil
+	s.audit.Record(ctx, models.AuditUserUpdate, models.AuditTargetUser, userID, user, &after)
+
+	logger.Log.Info("MFA disabled", zap.String("user_id", userID))
+	return nil
+}
+
+// StartChallenge asks for the second factor of a user who passed the first.
+// The challenge acts in the user's organization when it is answered.
+func (s *mfaService) StartChallenge(ctx context.Context, user *models.User) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(newPendingUser(user))
+	if err != nil {
+		return "", err
+	}
+	if err := database.RedisClient.Set(ctx, mfaChallengeKey(token), data, s.cfg.MFAChallengeTTL).Err(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// VerifyChallenge completes a login with the second factor. Wrong codes count
+// as failed logins of the account, so a caller who knows the password still
+// gets locked out instead of starting new challenges to keep guessing.
+func (s *mfaService) VerifyChallenge(ctx context.Context, req *models.MFAVerifyRequest, ip string) (*models.User, error) {
+	key := mfaChallengeKey(req.MFAToken)
+
+	data, err := database.RedisClient.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidMFAChallenge
+	}
+	if err != nil {
+		return nil, err
+	}
+	ctx, userID, valid := parsePendingUser(ctx, data)
+	if !valid {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	user, err := s.repo.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	lockout := mfaLockoutKey(ctx, user)
+	wait, err := s.guard.Check(ctx, lockout, ip)
+	if err != nil {
+		return nil, err
+	}
+	if wait > 0 {
+		return nil, &LockedOutError{RetryAfter: wait}
+	}
+
+	ok, err := s.verifySecondFactor(ctx, user, req.Code, req.RecoveryCode)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if err := s.guard.RecordFailure(ctx, lockout, ip); err != nil {
+			logger.Log.Error("Failed to record MFA failure", zap.Error(err))
+		}
+
+		// Bound the number of guesses per challenge
+		attemptsKey := key + ":attempts"
+		attempts, err := database.RedisClient.Incr(ctx, attemptsKey).Result()
+		if err != nil {
+			return nil, err
+		}
+		database.RedisClient.Expire(ctx, attemptsKey, s.cfg.MFAChallengeTTL)
+		if attempts >= maxMFAChallengeTries {
+			database.RedisClient.Del(ctx, key, attemptsKey)
+		}
+		return nil, ErrInvalidMFACode
+	}
+
+	// The challenge is single-use; losing the race means another request won
+	deleted, err := database.RedisClient.Del(ctx, key, key+":attempts").Result()
+	if err != nil {
+		return nil, err
+	}
+	if deleted == 0 {
+		return nil, ErrInvalidMFAChallenge
+	}
+
+	if err := s.guard.RecordSuccess(ctx, lockout, ip); err != nil {
+		logger.Log.Error("Failed to reset login failures", zap.Error(err))
+	}
+
+	return user, nil
+}
+
+// mfaLockoutKey is the lockout key of the account, the same one a password
+// login with its email and UnlockUser use
+func mfaLockoutKey(ctx context.Context, user *models.User) string {
+	return tenant.Key(ctx, user.Email)
+}
+
+func (s *mfaService) verifySecondFactor(ctx context.Context, user *models.User, code, recoveryCode string) (bool, error) {
+	if recoveryCode != "" {
+		return s.repo.ConsumeRecoveryCode(ctx, user.ID.Hex(), hashRecoveryCode(recoveryCode))
+	}
+	return s.validateTOTP(ctx, user.ID.Hex(), user.MFASecret, code), nil
+}
+
+// validateTOTP checks a code and rejects replays of a code already accepted
+func (s *mfaService) validateTOTP(ctx context.Context, userID, secret, code string) bool {
+	code = strings.TrimSpace(code)
+	valid, err := totp.ValidateCustom(code, secret, time.Now(), totp.ValidateOpts{
+		Period:    30,
+		Skew:      totpValidationSkew,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	})
+	if err != nil || !valid {
+		return false
+	}
+
+	fresh, err := database.RedisClient.SetNX(ctx, "mfa:used:"+userID+":"+code, 1, totpCodeReplayWindow).Result()
+	return err == nil && fresh
+}
+
+func generateRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		raw := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+		code := fmt.Sprintf("%s-%s-%s-%s", raw[0:4], raw[4:8], raw[8:12], raw[12:16])
+		codes[i] = code
+		hashes[i] = hashRecoveryCode(code)
+	}
+
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	return hashToken(normalized)
+}
+
+func qrCodeDataURI(key *otp.Key) (string, error) {
+	img, err := key.Image(qrCodeSize, qrCodeSize)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return "", err
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func mfaChallengeKey(token string) string {
+	return "mfa:challenge:" + hashToken(token)
+}
@@ -0,0 +1,727 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/logger"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/repository"
+	"gin-mongo-aws/internal/tenant"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrOAuthClientNotFound   = errors.New("oauth client not found")
+	ErrInvalidClientMetadata = errors.New("invalid client metadata")
+)
+
+// OAuthError is an RFC 6749 error reported to OAuth clients
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string {
+	return e.Code + ": " + e.Description
+}
+
+func oauthError(code, description string) *OAuthError {
+	return &OAuthError{Code: code, Description: description}
+}
+
+// RedirectError is an authorization error reported by redirecting back to
+// the client, which is only safe once the redirect URI has been verified
+type RedirectError struct {
+	Err         *OAuthError
+	RedirectURI string
+	State       string
+}
+
+func (e *RedirectError) Error() string { return e.Err.Error() }
+
+func (e *RedirectError) Unwrap() error { return e.Err }
+
+// Location is the redirect URI with the error parameters appended
+func (e *RedirectError) Location() string {
+	return appendQuery(e.RedirectURI, map[string]string{
+		"error":             e.Err.Code,
+		"error_description": e.Err.Description,
+		"state":             e.State,
+	})
+}
+
+// OAuthService lets registered clients obtain tokens for users of this API.
+// Authorization codes and pending consents live in Redis; refresh tokens
+// reuse the refresh token families of first-party logins. A client belongs to
+// the organization it was registered in and only serves that organization's
+// users.
+type OAuthService interface {
+	RegisterClient(ctx context.Context, req *models.CreateOAuthClientRequest) (*models.OAuthClientCredentials, error)
+	ListClients(ctx context.Context) ([]models.OAuthClient, error)
+	DeleteClient(ctx context.Context, clientID string) error
+	AuthenticateClient(ctx context.Context, clientID, secret string) (*models.OAuthClient, error)
+	Authorize(ctx context.Context, userID string, authTime time.Time, req *models.AuthorizeRequest) (*models.ConsentPrompt, error)
+	Consent(ctx context.Context, userID string, req *models.ConsentRequest) (string, error)
+	Exchange(ctx context.Context, client *models.OAuthClient, req *models.TokenRequest, info ClientInfo) (*models.OAuthTokenResponse, error)
+	Revoke(ctx context.Context, client *models.OAuthClient, token, hint string) error
+	Introspect(ctx context.Context, client *models.OAuthClient, token, hint string) (*models.IntrospectionResponse, error)
+	RevokedTokens(ctx context.Context, client *models.OAuthClient) ([]RevokedToken, error)
+	UserInfo(ctx context.Context, claims *Claims) (*models.UserInfo, error)
+}
+
+// authorizationGrant is what a user approved; it is stored first as a pending
+// consent and then under the authorization code
+type authorizationGrant struct {
+	UserID        string `json:"user_id"`
+	OrgID         string `json:"org_id"`
+	ClientID      string `json:"client_id"`
+	RedirectURI   string `json:"redirect_uri"`
+	Scope         string `json:"scope"`
+	State         string `json:"state,omitempty"`
+	CodeChallenge string `json:"code_challenge"`
+	Nonce         string `json:"nonce,omitempty"`
+	AuthTime      int64  `json:"auth_time,omitempty"`
+}
+
+type oauthService struct {
+	clients  repository.OAuthClientRepository
+	users    repository.UserRepository
+	tokens   TokenService
+	refresh  RefreshTokenService
+	denylist TokenDenylist
+	cfg      config.OAuthConfig
+}
+
+func NewOAuthService(clients repository.OAuthClientRepository, users repository.UserRepository, tokens TokenService, refresh RefreshTokenService, denylist TokenDenylist, cfg config.OAuthConfig) OAuthService {
+	return &oauthService{clients: clients, users: users, tokens: tokens, refresh: refresh, denylist: denylist, cfg: cfg}
+}
+
+func (s *oauthService) RegisterClient(ctx context.Context, req *models.CreateOAuthClientRequest) (*models.OAuthClientCredentials, error) {
+	client := &models.OAuthClient{
+		Name:         req.Name,
+		RedirectURIs: dedupe(req.RedirectURIs),
+		GrantTypes:   dedupe(req.GrantTypes),
+		Scopes:       dedupe(req.Scopes),
+		Public:       req.Public,
+	}
+	if err := validateClient(client); err != nil {
+		return nil, err
+	}
+
+	clientID, err := randomToken(16)
+	if err != nil {
+		return nil, err
+	}
+	client.ClientID = clientID
+
+	var secret string
+	if !client.Public {
+		if secret, err = randomToken(32); err != nil {
+			return nil, err
+		}
+		client.SecretHash = hashToken(secret)
+	}
+
+	if err := s.clients.Create(ctx, client); err != nil {
+		return nil, err
+	}
+
+	logger.Log.Info("OAuth client registered", zap.String("client_id", client.ClientID), zap.String("name", client.Name))
+	return &models.OAuthClientCredentials{Client: client, ClientSecret: secret}, nil
+}
+
+func validateClient(client *models.OAuthClient) error {
+	if client.Public && client.AllowsGrant(models.GrantClientCredentials) {
+		return fmt.Errorf("%w: public clients cannot use client_credentials", ErrInvalidClientMetadata)
+	}
+	if client.AllowsGrant(models.GrantRefreshToken) && !client.AllowsGrant(models.GrantAuthorizationCode) {
+		return fmt.Errorf("%w: refresh_token requires authorization_code", ErrInvalidClientMetadata)
+	}
+	if client.AllowsGrant(models.GrantAuthorizationCode) && len(client.RedirectURIs) == 0 {
+		return fmt.Errorf("%w: authorization_code requires at least one redirect URI", ErrInvalidClientMetadata)
+	}
+
+	for _, uri := range client.RedirectURIs {
+		u, err := url.Parse(uri)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.Fragment != "" {
+			return fmt.Errorf("%w: redirect URI %q must be absolute and have no fragment", ErrInvalidClientMetadata, uri)
+		}
+	}
+	for _, scope := range client.Scopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\n") {
+			return fmt.Errorf("%w: invalid scope %q", ErrInvalidClientMetadata, scope)
+		}
+	}
+	return nil
+}
+
+func (s *oauthService) ListClients(ctx context.Context) ([]models.OAuthClient, error) {
+	return s.clients.FindAll(ctx)
+}
+
+func (s *oauthService) DeleteClient(ctx context.Context, clientID string) error {
+	err := s.clients.Delete(ctx, clientID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrOAuthClientNotFound
+	}
+	return err
+}
+
+// AuthenticateClient checks client credentials. Public clients authenticate
+// with their client ID alone and rely on PKCE instead.
+func (s *oauthService) AuthenticateClient(ctx context.Context, clientID, secret string) (*models.OAuthClient, error) {
+	invalid := oauthError("invalid_client", "client authentication failed")
+	if clientID == "" {
+		return nil, invalid
+	}
+
+	client, err := s.clients.FindByClientID(ctx, clientID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, invalid
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if client.Public {
+		if secret != "" {
+			return nil, invalid
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(hashToken(secret)), []byte(client.SecretHash)) != 1 {
+		return nil, invalid
+	}
+	return client, nil
+}
+
+// Authorize validates an authorization request and stores it until the user
+// approves or denies it. authTime is when the user signed in and ends up in
+// the ID token.
+func (s *oauthService) Authorize(ctx context.Context, userID string, authTime time.Time, req *models.AuthorizeRequest) (*models.ConsentPrompt, error) {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	client, err := s.clients.FindByClientID(ctx, req.ClientID)
+	// Clients of other organizations are as unknown as ones never registered
+	if errors.Is(err, mongo.ErrNoDocuments) || (err == nil && client.OrgID != orgID) {
+		return nil, oauthError("invalid_request", "unknown client_id")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !client.HasRedirectURI(req.RedirectURI) {
+		return nil, oauthError("invalid_request", "redirect_uri is not registered for this client")
+	}
+
+	// From here on errors are reported to the client through the redirect
+	redirect := func(code, description string) error {
+		return &RedirectError{Err: oauthError(code, description), RedirectURI: req.RedirectURI, State: req.State}
+	}
+
+	if req.ResponseType != "code" {
+		return nil, redirect("unsupported_response_type", "only the code response type is supported")
+	}
+	if !client.AllowsGrant(models.GrantAuthorizationCode) {
+		return nil, redirect("unauthorized_client", "client is not allowed to use the authorization code grant")
+	}
+	if req.CodeChallenge == "" {
+		return nil, redirect("invalid_request", "code_challenge is required")
+	}
+	if req.CodeChallengeMethod != "S256" {
+		return nil, redirect("invalid_request", "code_challenge_method must be S256")
+	}
+	scope, ok := grantScope(client.Scopes, req.Scope)
+	if !ok {
+		return nil, redirect("invalid_scope", "requested scope exceeds the scope registered for this client")
+	}
+
+	consentID, err := randomToken(32)
+	if err != nil {
+		return nil, err
+	}
+	grant := authorizationGrant{
+		UserID:        userID,
+		OrgID:         orgID.Hex(),
+		ClientID:      client.ClientID,
+		RedirectURI:   req.RedirectURI,
+		Scope:         scope,
+		State:         req.State,
+		CodeChallenge: req.CodeChallenge,
+		Nonce:         req.Nonce,
+	}
+	if !authTime.IsZero() {
+		grant.AuthTime = authTime.Unix()
+	}
+	if err := saveGrant(ctx, oauthConsentKey(consentID), &grant, s.cfg.ConsentTTL); err != nil {
+		return nil, err
+	}
+
+	return &models.ConsentPrompt{
+		ConsentID:  consentID,
+		ClientID:   client.ClientID,
+		ClientName: client.Name,
+		Scopes:     strings.Fields(scope),
+		ExpiresIn:  int64(s.cfg.ConsentTTL.Seconds()),
+	}, nil
+}
+
+// Consent records the user's decision on a pending authorization request and
+// returns the URI to send the user back to
+func (s *oauthService) Consent(ctx context.Context, userID string, req *models.ConsentRequest) (string, error) {
+	grant, err := takeGrant(ctx, oauthConsentKey(req.ConsentID))
+	if err != nil {
+		return "", err
+	}
+	if grant == nil || grant.UserID != userID {
+		return "", oauthError("invalid_request", "unknown or expired consent_id")
+	}
+
+	if !req.Approve {
+		return (&RedirectError{
+			Err:         oauthError("access_denied", "the user denied the request"),
+			RedirectURI: grant.RedirectURI,
+			State:       grant.State,
+		}).Location(), nil
+	}
+
+	code, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	if err := saveGrant(ctx, oauthCodeKey(code), grant, s.cfg.CodeTTL); err != nil {
+		return "", err
+	}
+
+	logger.Log.Info("OAuth authorization granted",
+		zap.String("user_id", userID),
+		zap.String("client_id", grant.ClientID),
+		zap.String("scope", grant.Scope),
+	)
+	return appendQuery(grant.RedirectURI, map[string]string{"code": code, "state": grant.State}), nil
+}
+
+func (s *oauthService) Exchange(ctx context.Context, client *models.OAuthClient, req *models.TokenRequest, info ClientInfo) (*models.OAuthTokenResponse, error) {
+	switch req.GrantType {
+	case models.GrantAuthorizationCode, models.GrantRefreshToken, models.GrantClientCredentials:
+	case "":
+		return nil, oauthError("invalid_request", "grant_type is required")
+	default:
+		return nil, oauthError("unsupported_grant_type", "grant type is not supported")
+	}
+	if !client.AllowsGrant(req.GrantType) {
+		return nil, oauthError("unauthorized_client", "client is not allowed to use this grant type")
+	}
+
+	// The request names no organization of its own; it acts in the client's
+	ctx = tenant.WithOrgID(ctx, client.OrgID)
+
+	switch req.GrantType {
+	case models.GrantAuthorizationCode:
+		return s.exchangeCode(ctx, client, req, info)
+	case models.GrantRefreshToken:
+		return s.exchangeRefreshToken(ctx, client, req, info)
+	default:
+		return s.exchangeClientCredentials(client, req)
+	}
+}
+
+func (s *oauthService) exchangeCode(ctx context.Context, client *models.OAuthClient, req *models.TokenRequest, info ClientInfo) (*models.OAuthTokenResponse, error) {
+	invalid := oauthError("invalid_grant", "invalid or expired authorization code")
+
+	// Codes are single-use: taking one deletes it
+	grant, err := takeGrant(ctx, oauthCodeKey(req.Code))
+	if err != nil {
+		return nil, err
+	}
+	if grant == nil || grant.ClientID != client.ClientID || grant.OrgID != client.OrgID.Hex() || grant.RedirectURI != req.RedirectURI {
+		return nil, invalid
+	}
+	if !verifyCodeChallenge(grant.CodeChallenge, req.CodeVerifier) {
+		return nil, oauthError("invalid_grant", "code_verifier does not match the code challenge")
+	}
+
+	user, err := s.users.FindByID(ctx, grant.UserID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, invalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, invalid
+	}
+
+	var familyID, refreshToken string
+	if client.AllowsGrant(models.GrantRefreshToken) {
+		family, token, err := s.refresh.IssueForClient(ctx, user, client.ClientID, grant.Scope, info)
+		if err != nil {
+			return nil, err
+		}
+		familyID, refreshToken = family.ID, token
+	}
+
+	resp, err := s.delegatedTokenResponse(user, familyID, client.ClientID, grant.Scope, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if hasScope(grant.Scope, models.ScopeOpenID) {
+		var authTime time.Time
+		if grant.AuthTime > 0 {
+			authTime = time.Unix(grant.AuthTime, 0)
+		}
+		if resp.IDToken, err = s.tokens.IssueIDToken(user, client.ClientID, grant.Scope, grant.Nonce, authTime); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (s *oauthService) exchangeRefreshToken(ctx context.Context, client *models.OAuthClient, req *models.TokenRequest, info ClientInfo) (*models.OAuthTokenResponse, error) {
+	// A refresh may narrow the scope of the access token but never widen it.
+	// The scope is checked before rotating, so a refused request leaves the
+	// refresh token usable.
+	family, err := s.refresh.Get(ctx, req.RefreshToken)
+	if errors.Is(err, ErrInvalidRefreshToken) || (err == nil && family.ClientID != client.ClientID) {
+		return nil, oauthError("invalid_grant", ErrInvalidRefreshToken.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+	scope, ok := grantScope(strings.Fields(family.Scope), req.Scope)
+	if !ok {
+		return nil, oauthError("invalid_scope", "requested scope exceeds the original grant")
+	}
+
+	family, refreshToken, err := s.refresh.Rotate(ctx, req.RefreshToken, client.ClientID, info)
+	if errors.Is(err, ErrInvalidRefreshToken) || errors.Is(err, ErrRefreshTokenReused) {
+		return nil, oauthError("invalid_grant", err.Error())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.users.FindByID(ctx, family.UserID)
+	if err != nil || user.Disabled {
+		s.refresh.RevokeFamily(ctx, family.ID)
+		return nil, oauthError("invalid_grant", ErrInvalidRefreshToken.Error())
+	}
+
+	resp, err := s.delegatedTokenResponse(user, family.ID, client.ClientID, scope, refreshToken)
+	if err != nil {
+		return nil, err
+	}
+
+	if hasScope(scope, models.ScopeOpenID) {
+		if resp.IDToken, err = s.tokens.IssueIDToken(user, client.ClientID, scope, "", time.Time{}); err != nil {
+			return nil, err
+		}
+	}
+	return resp, nil
+}
+
+func (s *oauthService) exchangeClientCredentials(client *models.OAuthClient, req *models.TokenRequest) (*models.OAuthTokenResponse, error) {
+	scope, ok := grantScope(client.Scopes, req.Scope)
+	if !ok {
+		return nil, oauthError("invalid_scope", "requested scope exceeds the scope registered for this client")
+	}
+
+	accessToken, expiresAt, err := s.tokens.IssueClientToken(client.ClientID, scope)
+	if err != nil {
+		return nil, err
+	}
+	return &models.OAuthTokenResponse{
+		AccessToken: accessToken,
+		TokenType:   "Bearer",
+		ExpiresIn:   int64(time.Until(expiresAt).Seconds()),
+		Scope:       scope,
+	}, nil
+}
+
+func (s *oauthService) delegatedTokenResponse(user *models.User, familyID, clientID, scope, refreshToken string) (*models.OAuthTokenResponse, error) {
+	accessToken, expiresAt, err := s.tokens.IssueDelegatedToken(user, familyID, clientID, scope)
+	if err != nil {
+		return nil, err
+	}
+	return &models.OAuthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(time.Until(expiresAt).Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        scope,
+	}, nil
+}
+
+// Revoke implements RFC 7009. Refresh tokens revoke their family; access
+// tokens are denylisted until they expire. Unknown tokens and tokens issued
+// to other clients are ignored so the response reveals nothing.
+func (s *oauthService) Revoke(ctx context.Context, client *models.OAuthClient, token, hint string) error {
+	if hint != "refresh_token" {
+		if claims, err := s.tokens.ParseAccessToken(ctx, token); err == nil {
+			if claims.ClientID != client.ClientID {
+				return nil
+			}
+			if claims.OrgID == "" {
+				// A client credentials token belongs to the client's organization
+				claims.OrgID = client.OrgID.Hex()
+			}
+			return s.tokens.RevokeAccessToken(ctx, claims)
+		}
+	}
+
+	family, err := s.refresh.Get(ctx, token)
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if family.ClientID != client.ClientID {
+		return nil
+	}
+	return s.refresh.RevokeFamily(ctx, family.ID)
+}
+
+// Introspect implements RFC 7662 for confidential clients. Any such client,
+// typically a resource server, may introspect the access tokens of its
+// organization; refresh tokens are only reported active to the client they
+// were issued to.
+func (s *oauthService) Introspect(ctx context.Context, client *models.OAuthClient, token, hint string) (*models.IntrospectionResponse, error) {
+	if client.Public {
+		return nil, oauthError("invalid_client", "public clients cannot introspect tokens")
+	}
+
+	inactive := &models.IntrospectionResponse{Active: false}
+
+	if hint != "refresh_token" {
+		claims, err := s.tokens.ParseAccessToken(ctx, token)
+		if err == nil {
+			// Tokens of another organization are not reported
+			orgID, err := s.accessTokenOrg(ctx, claims)
+			if err != nil {
+				return nil, err
+			}
+			if orgID != client.OrgID.Hex() {
+				return inactive, nil
+			}
+			return accessTokenIntrospection(claims), nil
+		}
+		if !errors.Is(err, ErrInvalidToken) {
+			return nil, err
+		}
+	}
+
+	family, err := s.refresh.Get(ctx, token)
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		return inactive, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if family.ClientID != client.ClientID {
+		return inactive, nil
+	}
+
+	return &models.IntrospectionResponse{
+		Active:    true,
+		Scope:     family.Scope,
+		ClientID:  family.ClientID,
+		TokenType: "refresh_token",
+		Iat:       family.CreatedAt.Unix(),
+		Sub:       family.UserID,
+		SessionID: family.ID,
+	}, nil
+}
+
+// accessTokenOrg returns the organization an access token belongs to: that of
+// its user, or for client credentials tokens, which name none, that of the
+// client they were issued to. It is "" when that client no longer exists.
+func (s *oauthService) accessTokenOrg(ctx context.Context, claims *Claims) (string, error) {
+	if claims.OrgID != "" || claims.ClientID == "" {
+		return claims.OrgID, nil
+	}
+	issuer, err := s.clients.FindByClientID(ctx, claims.ClientID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return issuer.OrgID.Hex(), nil
+}
+
+func accessTokenIntrospection(claims *Claims) *models.IntrospectionResponse {
+	resp := &models.IntrospectionResponse{
+		Active:    true,
+		Scope:     claims.Scope,
+		ClientID:  claims.ClientID,
+		Username:  claims.Email,
+		TokenType: "Bearer",
+		Sub:       claims.Subject,
+		Aud:       claims.Audience,
+		Iss:       claims.Issuer,
+		Jti:       claims.ID,
+		Roles:     claims.Roles,
+		SessionID: claims.SessionID,
+	}
+	if claims.ExpiresAt != nil {
+		resp.Exp = claims.ExpiresAt.Unix()
+	}
+	if claims.IssuedAt != nil {
+		resp.Iat = claims.IssuedAt.Unix()
+	}
+	if claims.NotBefore != nil {
+		resp.Nbf = claims.NotBefore.Unix()
+	}
+	return resp
+}
+
+// RevokedTokens lists the denylisted access tokens of the client's
+// organization that have not expired, so resource servers that verify tokens
+// locally can honour revocations
+func (s *oauthService) RevokedTokens(ctx context.Context, client *models.OAuthClient) ([]RevokedToken, error) {
+	if client.Public {
+		return nil, oauthError("invalid_client", "public clients cannot read the revocation list")
+	}
+	return s.denylist.List(ctx, client.OrgID.Hex())
+}
+
+// UserInfo returns the claims of the user an OAuth access token was issued
+// for, limited to what its scope allows
+func (s *oauthService) UserInfo(ctx context.Context, claims *Claims) (*models.UserInfo, error) {
+	// Client credentials tokens have the client itself as subject
+	if !claims.IsOAuth() || claims.Subject == claims.ClientID {
+		return nil, oauthError("invalid_token", "token was not issued for a user")
+	}
+	if !hasScope(claims.Scope, models.ScopeOpenID) {
+		return nil, oauthError("insufficient_scope", "the openid scope is required")
+	}
+
+	user, err := s.users.FindByID(ctx, claims.Subject)
+	if err != nil {
+		return nil, oauthError("invalid_token", "user no longer exists")
+	}
+
+	info := userInfoForScope(user, claims.Scope)
+	return &info, nil
+}
+
+// userInfoForScope releases profile and email claims only for their scopes
+func userInfoForScope(user *models.User, scope string) models.UserInfo {
+	info := models.UserInfo{Subject: user.ID.Hex()}
+	if hasScope(scope, models.ScopeEmail) {
+		verified := user.EmailVerified
+		info.Email = user.Email
+		info.EmailVerified = &verified
+	}
+	if hasScope(scope, models.ScopeProfile) {
+		info.Name = user.Name
+	}
+	return info
+}
+
+func hasScope(scope, want string) bool {
+	for _, s := range strings.Fields(scope) {
+		if s == want {
+			return true
+		}
+	}
+	return false
+}
+
+// grantScope resolves a requested scope against the allowed scopes. An empty
+// request grants everything allowed.
+func grantScope(allowed []string, requested string) (string, bool) {
+	if strings.TrimSpace(requested) == "" {
+		return strings.Join(allowed, " "), true
+	}
+
+	permitted := make(map[string]struct{}, len(allowed))
+	for _, s := range allowed {
+		permitted[s] = struct{}{}
+	}
+
+	scopes := dedupe(strings.Fields(requested))
+	for _, s := range scopes {
+		if _, ok := permitted[s]; !ok {
+			return "", false
+		}
+	}
+	return strings.Join(scopes, " "), true
+}
+
+// verifyCodeChallenge checks a PKCE verifier against an S256 challenge
+func verifyCodeChallenge(challenge, verifier string) bool {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	computed := base64.RawURLEncoding.EncodeToString(sum[:])
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(challenge)) == 1
+}
+
+func saveGrant(ctx context.Context, key string, grant *authorizationGrant, ttl time.Duration) error {
+	data, err := json.Marshal(grant)
+	if err != nil {
+		return err
+	}
+	return database.RedisClient.Set(ctx, key, data, ttl).Err()
+}
+
+// takeGrant atomically reads and deletes a stored grant, returning nil when
+// it does not exist
+func takeGrant(ctx context.Context, key string) (*authorizationGrant, error) {
+	val, err := database.RedisClient.GetDel(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var grant authorizationGrant
+	if err := json.Unmarshal([]byte(val), &grant); err != nil {
+		return nil, nil
+	}
+	return &grant, nil
+}
+
+// appendQuery adds the non-empty params to a URI's query string
+func appendQuery(uri string, params map[string]string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	q := u.Query()
+	for k, v := range params {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func oauthConsentKey(id string) string {
+	return "oauth:consent:" + hashToken(id)
+}
+
+func oauthCodeKey(code string) string {
+	return "oauth:code:" + hashToken(code)
+}
@@ -0,0 +1,239 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/tenant"
+	"gin-mongo-aws/internal/testutil"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestOAuthClientServesOnlyItsOrganization(t *testing.T) {
+	testutil.StartRedis(t)
+	users := testutil.NewUserRepository()
+	denylist := NewTokenDenylist()
+	tokens, err := NewTokenService(config.JWTConfig{Algorithm: "HS256", Secret: "test-secret", AccessTokenTTL: time.Minute, Issuer: "https://auth.example.com", Audience: "api"}, nil, denylist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewOAuthService(testutil.NewOAuthClientRepository(), users, tokens, NewRefreshTokenService(time.Hour), denylist,
+		config.OAuthConfig{CodeTTL: time.Minute, ConsentTTL: time.Minute})
+
+	acme := tenant.WithOrgID(context.Background(), primitive.NewObjectID())
+	globex := tenant.WithOrgID(context.Background(), primitive.NewObjectID())
+	registered, err := svc.RegisterClient(acme, &models.CreateOAuthClientRequest{
+		Name:         "Acme app",
+		RedirectURIs: []string{"https://app.acme.example/callback"},
+		GrantTypes:   []string{models.GrantAuthorizationCode, models.GrantRefreshToken},
+		Scopes:       []string{models.ScopeOpenID},
+	})
+	if err != nil {
+		t.Fatalf("RegisterClient: %v", err)
+	}
+	client := registered.Client
+
+	verifier := strings.Repeat("v", 43)
+	sum := sha256.Sum256([]byte(verifier))
+	authorize := &models.AuthorizeRequest{
+		ResponseType:        "code",
+		ClientID:            client.ClientID,
+		RedirectURI:         "https://app.acme.example/callback",
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+		CodeChallengeMethod: "S256",
+	}
+
+	// Another organization's users do not see the client
+	outsider := &models.User{Name: "Outsider", Email: "outsider@example.com"}
+	if err := users.Create(globex, outsider); err != nil {
+		t.Fatal(err)
+	}
+	var oauthErr *OAuthError
+	if _, err := svc.Authorize(globex, outsider.ID.Hex(), time.Now(), authorize); !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_request" {
+		t.Errorf("authorizing another organization's client: err = %v, want unknown client_id", err)
+	}
+	if clients, err := svc.ListClients(globex); err != nil || len(clients) != 0 {
+		t.Errorf("another organization listed %v (err %v)", clients, err)
+	}
+
+	member := &models.User{Name: "Member", Email: "member@example.com"}
+	if err := users.Create(acme, member); err != nil {
+		t.Fatal(err)
+	}
+	prompt, err := svc.Authorize(acme, member.ID.Hex(), time.Now(), authorize)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	location, err := svc.Consent(acme, member.ID.Hex(), &models.ConsentRequest{ConsentID: prompt.ConsentID, Approve: true})
+	if err != nil {
+		t.Fatalf("Consent: %v", err)
+	}
+	redirect, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The token endpoint names no organization and acts in the client's
+	issued, err := svc.Exchange(globex, client, &models.TokenRequest{
+		GrantType:    models.GrantAuthorizationCode,
+		Code:         redirect.Query().Get("code"),
+		RedirectURI:  authorize.RedirectURI,
+		CodeVerifier: verifier,
+	}, ClientInfo{})
+	if err != nil {
+		t.Fatalf("code exchange: %v", err)
+	}
+	refreshed, err := svc.Exchange(globex, client, &models.TokenRequest{GrantType: models.GrantRefreshToken, RefreshToken: issued.RefreshToken}, ClientInfo{})
+	if err != nil {
+		t.Fatalf("refresh exchange: %v", err)
+	}
+
+	claims, err := tokens.ParseAccessToken(context.Background(), refreshed.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != member.ID.Hex() || claims.OrgID != member.OrgID.Hex() {
+		t.Errorf("token issued for %s in %s, want the member in their organization", claims.Subject, claims.OrgID)
+	}
+}
+
+func TestRefreshWithWiderScopeKeepsTheToken(t *testing.T) {
+	testutil.StartRedis(t)
+	users := testutil.NewUserRepository()
+	denylist := NewTokenDenylist()
+	tokens, err := NewTokenService(config.JWTConfig{Algorithm: "HS256", Secret: "test-secret", AccessTokenTTL: time.Minute, Issuer: "https://auth.example.com", Audience: "api"}, nil, denylist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewOAuthService(testutil.NewOAuthClientRepository(), users, tokens, NewRefreshTokenService(time.Hour), denylist,
+		config.OAuthConfig{CodeTTL: time.Minute, ConsentTTL: time.Minute})
+
+	ctx := tenant.WithOrgID(context.Background(), primitive.NewObjectID())
+	registered, err := svc.RegisterClient(ctx, &models.CreateOAuthClientRequest{
+		Name:         "App",
+		RedirectURIs: []string{"https://app.example.com/callback"},
+		GrantTypes:   []string{models.GrantAuthorizationCode, models.GrantRefreshToken},
+		Scopes:       []string{models.ScopeOpenID, models.ScopeProfile, models.ScopeEmail},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := registered.Client
+	member := &models.User{Name: "Member", Email: "member@example.com"}
+	if err := users.Create(ctx, member); err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := strings.Repeat("v", 43)
+	sum := sha256.Sum256([]byte(verifier))
+	authorize := &models.AuthorizeRequest{
+		ResponseType:        "code",
+		ClientID:            client.ClientID,
+		RedirectURI:         "https://app.example.com/callback",
+		Scope:               "openid profile",
+		CodeChallenge:       base64.RawURLEncoding.EncodeToString(sum[:]),
+		CodeChallengeMethod: "S256",
+	}
+	prompt, err := svc.Authorize(ctx, member.ID.Hex(), time.Now(), authorize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	location, err := svc.Consent(ctx, member.ID.Hex(), &models.ConsentRequest{ConsentID: prompt.ConsentID, Approve: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	redirect, err := url.Parse(location)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issued, err := svc.Exchange(ctx, client, &models.TokenRequest{
+		GrantType:    models.GrantAuthorizationCode,
+		Code:         redirect.Query().Get("code"),
+		RedirectURI:  authorize.RedirectURI,
+		CodeVerifier: verifier,
+	}, ClientInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// email was never granted
+	var oauthErr *OAuthError
+	_, err = svc.Exchange(ctx, client, &models.TokenRequest{GrantType: models.GrantRefreshToken, RefreshToken: issued.RefreshToken, Scope: "openid email"}, ClientInfo{})
+	if !errors.As(err, &oauthErr) || oauthErr.Code != "invalid_scope" {
+		t.Fatalf("err = %v, want invalid_scope", err)
+	}
+
+	// The refused request did not use up the token
+	refreshed, err := svc.Exchange(ctx, client, &models.TokenRequest{GrantType: models.GrantRefreshToken, RefreshToken: issued.RefreshToken, Scope: "openid"}, ClientInfo{})
+	if err != nil {
+		t.Fatalf("refresh after the refused one: %v", err)
+	}
+	if refreshed.Scope != "openid" {
+		t.Errorf("scope = %q, want openid", refreshed.Scope)
+	}
+}
+
+func TestClientTokensStayInTheirOrganization(t *testing.T) {
+	testutil.StartRedis(t)
+	denylist := NewTokenDenylist()
+	tokens, err := NewTokenService(config.JWTConfig{Algorithm: "HS256", Secret: "test-secret", AccessTokenTTL: time.Minute, Issuer: "https://auth.example.com", Audience: "api"}, nil, denylist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := NewOAuthService(testutil.NewOAuthClientRepository(), testutil.NewUserRepository(), tokens, NewRefreshTokenService(time.Hour), denylist,
+		config.OAuthConfig{CodeTTL: time.Minute, ConsentTTL: time.Minute})
+
+	acme := tenant.WithOrgID(context.Background(), primitive.NewObjectID())
+	globex := tenant.WithOrgID(context.Background(), primitive.NewObjectID())
+	register := func(ctx context.Context, name string) *models.OAuthClient {
+		registered, err := svc.RegisterClient(ctx, &models.CreateOAuthClientRequest{
+			Name:       name,
+			GrantTypes: []string{models.GrantClientCredentials},
+			Scopes:     []string{models.ScopeProfile},
+		})
+		if err != nil {
+			t.Fatalf("RegisterClient: %v", err)
+		}
+		return registered.Client
+	}
+	worker := register(acme, "Acme worker")
+	acmeAPI := register(acme, "Acme API")
+	globexAPI := register(globex, "Globex API")
+
+	issued, err := svc.Exchange(context.Background(), worker, &models.TokenRequest{GrantType: models.GrantClientCredentials}, ClientInfo{})
+	if err != nil {
+		t.Fatalf("client credentials exchange: %v", err)
+	}
+
+	// The token names no organization; it belongs to the worker's
+	if resp, err := svc.Introspect(context.Background(), globexAPI, issued.AccessToken, ""); err != nil || resp.Active {
+		t.Errorf("another organization introspected %+v (err %v)", resp, err)
+	}
+	resp, err := svc.Introspect(context.Background(), acmeAPI, issued.AccessToken, "")
+	if err != nil || !resp.Active {
+		t.Fatalf("introspecting in the organization: %+v (err %v)", resp, err)
+	}
+
+	if err := svc.Revoke(context.Background(), worker, issued.AccessToken, ""); err != nil {
+		t.Fatal(err)
+	}
+	if revoked, err := svc.RevokedTokens(context.Background(), globexAPI); err != nil || len(revoked) != 0 {
+		t.Errorf("another organization listed %v (err %v)", revoked, err)
+	}
+	revoked, err := svc.RevokedTokens(context.Background(), acmeAPI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 1 || revoked[0].JTI != resp.Jti {
+		t.Errorf("revoked = %v, want only %s", revoked, resp.Jti)
+	}
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gin-mongo-aws/internal/logger"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/repository"
+	"gin-mongo-aws/internal/tenant"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrOrganizationNotFound    = errors.New("organization not found")
+	ErrOrganizationExists      = errors.New("organization already exists")
+	ErrInvalidOrganizationSlug = errors.New("slug must be a DNS label of lowercase letters, digits and hyphens")
+)
+
+// organizationSlugPattern keeps slugs usable as a subdomain
+var organizationSlugPattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// OrganizationService manages the tenants of the deployment. Every request
+// resolves its organization by slug, so resolved organizations are cached in
+// memory; slugs never change once created.
+type OrganizationService interface {
+	Create(ctx context.Context, req *models.CreateOrganizationRequest) (*models.OrganizationCreated, error)
+	List(ctx context.Context) ([]models.Organization, error)
+	Resolve(ctx context.Context, slug string) (*models.Organization, error)
+}
+
+type organizationService struct {
+	repo   repository.OrganizationRepository
+	users  repository.UserRepository
+	audit  AuditService
+	mu     sync.RWMutex
+	bySlug map[string]*models.Organization
+}
+
+func NewOrganizationService(repo repository.OrganizationRepository, users repository.UserRepository, audit AuditService) OrganizationService {
+	return &organizationService{repo: repo, users: users, audit: audit, bySlug: map[string]*models.Organization{}}
+}
+
+// Create adds an organization and its owner, an admin of the new
+// organization only. The organization is removed again if its owner cannot
+// be created, so a failed request can be retried with the same slug.
+func (s *organizationService) Create(ctx context.Context, req *models.CreateOrganizationRequest) (*models.OrganizationCreated, error) {
+	slug := strings.ToLower(strings.TrimSpace(req.Slug))
+	if !organizationSlugPattern.MatchString(slug) {
+		return nil, ErrInvalidOrganizationSlug
+	}
+	hash, err := HashPassword(req.Owner.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	org := &models.Organization{Slug: slug, Name: strings.TrimSpace(req.Name)}
+	if err := s.repo.Create(ctx, org); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrOrganizationExists
+		}
+		return nil, err
+	}
+
+	orgCtx := tenant.WithOrgID(ctx, org.ID)
+	owner := &models.User{
+		Name:         strings.TrimSpace(req.Owner.Name),
+		Email:        NormalizeEmail(req.Owner.Email),
+		PasswordHash: hash,
+		// The operator creating the organization vouches for the address
+		EmailVerified: true,
+		Roles:         []string{models.RoleAdmin},
+	}
+	if err := s.users.Create(orgCtx, owner); err != nil {
+		s.rollback(ctx, org)
+		return nil, err
+	}
+	s.audit.Record(orgCtx, models.AuditUserCreate, models.AuditTargetUser, owner.ID.Hex(), nil, owner)
+
+	logger.Log.Info("Organization created",
+		zap.String("org_id", org.ID.Hex()),
+		zap.String("slug", org.Slug),
+		zap.String("owner_id", owner.ID.Hex()),
+	)
+	return &models.OrganizationCreated{Organization: org, Owner: owner}, nil
+}
+
+// rollback removes an organization whose owner could not be created
+func (s *organizationService) rollback(ctx context.Context, org *models.Organization) {
+	if err := s.repo.Delete(ctx, org.ID); err != nil {
+		logger.Log.Error("Failed to remove organization without owner",
+			zap.String("org_id", org.ID.Hex()),
+			zap.String("slug", org.Slug),
+			zap.Error(err),
+		)
+		return
+	}
+
+	// A request may have resolved the slug in the meantime
+	s.mu.Lock()
+	delete(s.bySlug, org.Slug)
+	s.mu.Unlock()
+}
+
+func (s *organizationService) List(ctx context.Context) ([]models.Organization, error) {
+	return s.repo.FindAll(ctx)
+}
+
+func (s *organizationService) Resolve(ctx context.Context, slug string) (*models.Organization, error) {
+	s.mu.RLock()
+	org, ok := s.bySlug[slug]
+	s.mu.RUnlock()
+	if ok {
+		return org, nil
+	}
+
+	org, err := s.repo.FindBySlug(ctx, slug)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrOrganizationNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.bySlug[slug] = org
+	s.mu.Unlock()
+	return org, nil
+}
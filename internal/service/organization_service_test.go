@@ -0,0 +1,112 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/testutil"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// fakeOrganizationRepository keeps organizations in memory with unique slugs
+type fakeOrganizationRepository struct {
+	mu   sync.Mutex
+	orgs map[string]*models.Organization
+}
+
+func newFakeOrganizationRepository() *fakeOrganizationRepository {
+	return &fakeOrganizationRepository{orgs: map[string]*models.Organization{}}
+}
+
+func (r *fakeOrganizationRepository) Create(ctx context.Context, org *models.Organization) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.orgs[org.Slug]; ok {
+		return mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "duplicate key"}}}
+	}
+	org.ID = primitive.NewObjectID()
+	org.CreatedAt = time.Now()
+	org.UpdatedAt = org.CreatedAt
+	stored := *org
+	r.orgs[org.Slug] = &stored
+	return nil
+}
+
+func (r *fakeOrganizationRepository) FindAll(ctx context.Context) ([]models.Organization, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	orgs := []models.Organization{}
+	for _, org := range r.orgs {
+		orgs = append(orgs, *org)
+	}
+	return orgs, nil
+}
+
+func (r *fakeOrganizationRepository) FindBySlug(ctx context.Context, slug string) (*models.Organization, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	org, ok := r.orgs[slug]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	found := *org
+	return &found, nil
+}
+
+func (r *fakeOrganizationRepository) Delete(ctx context.Context, id primitive.ObjectID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for slug, org := range r.orgs {
+		if org.ID == id {
+			delete(r.orgs, slug)
+		}
+	}
+	return nil
+}
+
+// failingUserRepository fails to create users while fail is set
+type failingUserRepository struct {
+	*testutil.UserRepository
+	fail bool
+}
+
+func (r *failingUserRepository) Create(ctx context.Context, user *models.User) error {
+	if r.fail {
+		return errors.New("write failed")
+	}
+	return r.UserRepository.Create(ctx, user)
+}
+
+func TestCreateOrganizationRollsBackWithoutOwner(t *testing.T) {
+	orgs := newFakeOrganizationRepository()
+	users := &failingUserRepository{UserRepository: testutil.NewUserRepository(), fail: true}
+	svc := NewOrganizationService(orgs, users, &fakeAuditService{})
+
+	req := &models.CreateOrganizationRequest{
+		Slug:  "acme",
+		Name:  "Acme",
+		Owner: models.CreateOrganizationOwner{Name: "Owner", Email: "owner@acme.example", Password: "correct horse"},
+	}
+	if _, err := svc.Create(context.Background(), req); err == nil {
+		t.Fatal("Create succeeded although the owner could not be created")
+	}
+	if _, err := svc.Resolve(context.Background(), "acme"); !errors.Is(err, ErrOrganizationNotFound) {
+		t.Fatalf("organization without owner was kept: err = %v", err)
+	}
+
+	// The slug is free again
+	users.fail = false
+	created, err := svc.Create(context.Background(), req)
+	if err != nil {
+		t.Fatalf("retrying Create: %v", err)
+	}
+	if created.Owner.OrgID != created.Organization.ID {
+		t.Errorf("owner created in %s, want %s", created.Owner.OrgID.Hex(), created.Organization.ID.Hex())
+	}
+}
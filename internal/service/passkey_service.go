@@ -0,0 +1,379 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/logger"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/repository"
+	"gin-mongo-aws/internal/tenant"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidPasskeyCeremony = errors.New("invalid or expired passkey ceremony")
+	ErrInvalidPasskey         = errors.New("passkey verification failed")
+	ErrPasskeyNotFound        = errors.New("passkey not found")
+	ErrPasskeyAlreadyExists   = errors.New("this passkey is already registered")
+	ErrPasskeysNotAllowed     = errors.New("directory accounts cannot use passkeys")
+)
+
+// PasskeyService runs the WebAuthn registration and authentication
+// ceremonies. Passkeys are discoverable credentials that verify the user
+// (PIN or biometrics), so a passkey login counts as multi-factor.
+type PasskeyService interface {
+	BeginRegistration(ctx context.Context, userID string) (*models.WebAuthnCeremony, error)
+	FinishRegistration(ctx context.Context, userID string, req *models.FinishPasskeyRegistrationRequest) (*models.WebAuthnCredential, error)
+	BeginLogin(ctx context.Context) (*models.WebAuthnCeremony, error)
+	FinishLogin(ctx context.Context, req *models.FinishPasskeyLoginRequest) (*models.User, error)
+	List(ctx context.Context, userID string) ([]models.WebAuthnCredential, error)
+	Rename(ctx context.Context, userID, id, name string) error
+	Delete(ctx context.Context, userID, id string) error
+}
+
+// passkeyCeremony is kept in Redis between the begin and finish requests.
+// UserID is empty for logins, where the user is only known at the end.
+type passkeyCeremony struct {
+	UserID  string               `json:"user_id,omitempty"`
+	Session webauthn.SessionData `json:"session"`
+}
+
+// passkeyAudit is what the audit log records about a passkey, leaving out
+// the key material
+type passkeyAudit struct {
+	PasskeyID string `bson:"passkey_id"`
+	Name      string `bson:"name"`
+}
+
+type passkeyService struct {
+	users       repository.UserRepository
+	credentials repository.WebAuthnCredentialRepository
+	audit       AuditService
+	webauthn    *webauthn.WebAuthn
+	ttl         time.Duration
+}
+
+func NewPasskeyService(users repository.UserRepository, credentials repository.WebAuthnCredentialRepository, audit AuditService, cfg config.WebAuthnConfig) (PasskeyService, error) {
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          cfg.RPID,
+		RPDisplayName: cfg.RPDisplayName,
+		RPOrigins:     cfg.RPOrigins,
+		AuthenticatorSelection: protocol.AuthenticatorSelection{
+			ResidentKey:      protocol.ResidentKeyRequirementRequired,
+			UserVerification: protocol.VerificationRequired,
+		},
+		Timeouts: webauthn.TimeoutsConfig{
+			Login:        webauthn.TimeoutConfig{Enforce: true, Timeout: cfg.ChallengeTTL, TimeoutUVD: cfg.ChallengeTTL},
+			Registration: webauthn.TimeoutConfig{Enforce: true, Timeout: cfg.ChallengeTTL, TimeoutUVD: cfg.ChallengeTTL},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &passkeyService{users: users, credentials: credentials, audit: audit, webauthn: w, ttl: cfg.ChallengeTTL}, nil
+}
+
+// BeginRegistration returns the options for navigator.credentials.create().
+// The user's existing passkeys are excluded so an authenticator is not
+// registered twice.
+func (s *passkeyService) BeginRegistration(ctx context.Context, userID string) (*models.WebAuthnCeremony, error) {
+	user, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.DirectoryManaged() {
+		return nil, ErrPasskeysNotAllowed
+	}
+	owner, err := s.webAuthnUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	exclusions := make([]protocol.CredentialDescriptor, 0, len(owner.credentials))
+	for _, credential := range owner.WebAuthnCredentials() {
+		exclusions = append(exclusions, credential.Descriptor())
+	}
+	creation, session, err := s.webauthn.BeginRegistration(owner, webauthn.WithExclusions(exclusions))
+	if err != nil {
+		return nil, err
+	}
+
+	ceremonyID, err := s.saveCeremony(ctx, "registration", passkeyCeremony{UserID: userID, Session: *session})
+	if err != nil {
+		return nil, err
+	}
+	return &models.WebAuthnCeremony{CeremonyID: ceremonyID, Options: creation}, nil
+}
+
+func (s *passkeyService) FinishRegistration(ctx context.Context, userID string, req *models.FinishPasskeyRegistrationRequest) (*models.WebAuthnCredential, error) {
+	ceremony, err := s.takeCeremony(ctx, "registration", req.CeremonyID)
+	if err != nil {
+		return nil, err
+	}
+	// A ceremony started by another user cannot be finished by this one
+	if ceremony.UserID != userID {
+		return nil, ErrInvalidPasskeyCeremony
+	}
+
+	user, err := s.users.FindByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	owner, err := s.webAuthnUser(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBody(bytes.NewReader(req.Credential))
+	if err != nil {
+		return nil, passkeyError("Passkey registration rejected", userID, err)
+	}
+	credential, err := s.webauthn.CreateCredential(owner, ceremony.Session, parsed)
+	if err != nil {
+		return nil, passkeyError("Passkey registration rejected", userID, err)
+	}
+
+	transports := make([]string, 0, len(credential.Transport))
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+	stored := &models.WebAuthnCredential{
+		UserID:          user.ID,
+		Name:            req.Name,
+		CredentialID:    credential.ID,
+		PublicKey:       credential.PublicKey,
+		AttestationType: credential.AttestationType,
+		AAGUID:          credential.Authenticator.AAGUID,
+		SignCount:       credential.Authenticator.SignCount,
+		Transports:      transports,
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}
+	if err := s.credentials.Create(ctx, stored); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrPasskeyAlreadyExists
+		}
+		return nil, err
+	}
+
+	s.audit.Record(ctx, models.AuditPasskeyCreate, models.AuditTargetUser, userID, nil,
+		passkeyAudit{PasskeyID: stored.ID.Hex(), Name: stored.Name})
+	logger.Log.Info("Passkey registered", zap.String("user_id", userID), zap.String("passkey_id", stored.ID.Hex()))
+	return stored, nil
+}
+
+// BeginLogin returns the options for navigator.credentials.get(). No user is
+// named: the authenticator offers the passkeys it holds for this site.
+func (s *passkeyService) BeginLogin(ctx context.Context) (*models.WebAuthnCeremony, error) {
+	assertion, session, err := s.webauthn.BeginDiscoverableLogin()
+	if err != nil {
+		return nil, err
+	}
+
+	ceremonyID, err := s.saveCeremony(ctx, "login", passkeyCeremony{Session: *session})
+	if err != nil {
+		return nil, err
+	}
+	return &models.WebAuthnCeremony{CeremonyID: ceremonyID, Options: assertion}, nil
+}
+
+// FinishLogin verifies the assertion against the stored public key and
+// returns the passkey's owner. The user handle the authenticator returns must
+// match the owner, and a signature counter that went backwards, a sign of a
+// cloned authenticator, fails the login. The owner is looked up in the
+// passkey's organization, whichever organization the request named.
+func (s *passkeyService) FinishLogin(ctx context.Context, req *models.FinishPasskeyLoginRequest) (*models.User, error) {
+	ceremony, err := s.takeCeremony(ctx, "login", req.CeremonyID)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialRequestResponseBody(bytes.NewReader(req.Credential))
+	if err != nil {
+		return nil, passkeyError("Passkey login rejected", "", err)
+	}
+
+	var owner *webAuthnUser
+	var stored *models.WebAuthnCredential
+	credential, err := s.webauthn.ValidateDiscoverableLogin(func(rawID, userHandle []byte) (webauthn.User, error) {
+		stored, err = s.credentials.FindByCredentialID(ctx, rawID)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(userHandle, stored.UserID[:]) {
+			return nil, errors.New("user handle does not match the passkey owner")
+		}
+		orgCtx := tenant.WithOrgID(ctx, stored.OrgID)
+		user, err := s.users.FindByID(orgCtx, stored.UserID.Hex())
+		if err != nil {
+			return nil, err
+		}
+		owner, err = s.webAuthnUser(orgCtx, user)
+		return owner, err
+	}, ceremony.Session, parsed)
+	if err != nil {
+		return nil, passkeyError("Passkey login rejected", "", err)
+	}
+
+	userID := owner.user.ID.Hex()
+	if credential.Authenticator.CloneWarning {
+		logger.Log.Warn("Passkey signature counter did not increase; possible cloned authenticator",
+			zap.String("user_id", userID),
+			zap.String("passkey_id", stored.ID.Hex()),
+		)
+		return nil, ErrInvalidPasskey
+	}
+	if owner.user.DirectoryManaged() {
+		return nil, ErrPasskeysNotAllowed
+	}
+
+	if err := s.credentials.RecordUse(ctx, stored.ID, credential.Authenticator.SignCount, credential.Flags.BackupState, time.Now()); err != nil {
+		logger.Log.Error("Failed to record passkey use", zap.Error(err))
+	}
+	return owner.user, nil
+}
+
+func (s *passkeyService) List(ctx context.Context, userID string) ([]models.WebAuthnCredential, error) {
+	return s.credentials.FindByUser(ctx, userID)
+}
+
+func (s *passkeyService) Rename(ctx context.Context, userID, id, name string) error {
+	err := s.credentials.Rename(ctx, userID, id, name)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrPasskeyNotFound
+	}
+	return err
+}
+
+func (s *passkeyService) Delete(ctx context.Context, userID, id string) error {
+	passkeys, err := s.credentials.FindByUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+	removed := passkeyAudit{PasskeyID: id}
+	for _, passkey := range passkeys {
+		if passkey.ID.Hex() == id {
+			removed.Name = passkey.Name
+		}
+	}
+
+	err = s.credentials.Delete(ctx, userID, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrPasskeyNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, models.AuditPasskeyDelete, models.AuditTargetUser, userID, removed, nil)
+	logger.Log.Info("Passkey removed", zap.String("user_id", userID), zap.String("passkey_id", id))
+	return nil
+}
+
+func (s *passkeyService) saveCeremony(ctx context.Context, kind string, ceremony passkeyCeremony) (string, error) {
+	ceremonyID, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+	data, err := json.Marshal(ceremony)
+	if err != nil {
+		return "", err
+	}
+	if err := database.RedisClient.Set(ctx, passkeyCeremonyKey(kind, ceremonyID), data, s.ttl).Err(); err != nil {
+		return "", err
+	}
+	return ceremonyID, nil
+}
+
+// takeCeremony loads and deletes a ceremony so each challenge is single-use
+func (s *passkeyService) takeCeremony(ctx context.Context, kind, ceremonyID string) (*passkeyCeremony, error) {
+	data, err := database.RedisClient.GetDel(ctx, passkeyCeremonyKey(kind, ceremonyID)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidPasskeyCeremony
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ceremony passkeyCeremony
+	if err := json.Unmarshal(data, &ceremony); err != nil {
+		return nil, ErrInvalidPasskeyCeremony
+	}
+	return &ceremony, nil
+}
+
+func (s *passkeyService) webAuthnUser(ctx context.Context, user *models.User) (*webAuthnUser, error) {
+	credentials, err := s.credentials.FindByUser(ctx, user.ID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	return &webAuthnUser{user: user, credentials: credentials}, nil
+}
+
+func passkeyError(message, userID string, err error) error {
+	logger.Log.Warn(message, zap.String("user_id", userID), zap.Error(err))
+	return ErrInvalidPasskey
+}
+
+func passkeyCeremonyKey(kind, ceremonyID string) string {
+	return "webauthn:" + kind + ":" + hashToken(ceremonyID)
+}
+
+// webAuthnUser presents a user and their passkeys to the webauthn library.
+// The user handle is the ObjectID, which carries no personal data.
+type webAuthnUser struct {
+	user        *models.User
+	credentials []models.WebAuthnCredential
+}
+
+func (u *webAuthnUser) WebAuthnID() []byte {
+	return u.user.ID[:]
+}
+
+func (u *webAuthnUser) WebAuthnName() string {
+	return u.user.Email
+}
+
+func (u *webAuthnUser) WebAuthnDisplayName() string {
+	return u.user.Name
+}
+
+func (u *webAuthnUser) WebAuthnIcon() string {
+	return ""
+}
+
+func (u *webAuthnUser) WebAuthnCredentials() []webauthn.Credential {
+	credentials := make([]webauthn.Credential, 0, len(u.credentials))
+	for _, stored := range u.credentials {
+		transports := make([]protocol.AuthenticatorTransport, 0, len(stored.Transports))
+		for _, transport := range stored.Transports {
+			transports = append(transports, protocol.AuthenticatorTransport(transport))
+		}
+		credentials = append(credentials, webauthn.Credential{
+			ID:              stored.CredentialID,
+			PublicKey:       stored.PublicKey,
+			AttestationType: stored.AttestationType,
+			Transport:       transports,
+			Flags: webauthn.CredentialFlags{
+				BackupEligible: stored.BackupEligible,
+				BackupState:    stored.BackupState,
+			},
+			Authenticator: webauthn.Authenticator{
+				AAGUID:    stored.AAGUID,
+				SignCount: stored.SignCount,
+			},
+		})
+	}
+	return credentials
+}
@@ -0,0 +1,272 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/tenant"
+	"gin-mongo-aws/internal/testutil"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+type passkeyFixture struct {
+	ctx         context.Context
+	users       *testutil.UserRepository
+	credentials *testutil.WebAuthnCredentialRepository
+	audit       *fakeAuditService
+	service     PasskeyService
+}
+
+func newPasskeyFixture(t *testing.T) *passkeyFixture {
+	t.Helper()
+	testutil.StartRedis(t)
+
+	users := testutil.NewUserRepository()
+	credentials := testutil.NewWebAuthnCredentialRepository()
+	audit := &fakeAuditService{}
+	svc, err := NewPasskeyService(users, credentials, audit, config.WebAuthnConfig{
+		RPID:          testRPID,
+		RPDisplayName: "Example",
+		RPOrigins:     []string{testOrigin},
+		ChallengeTTL:  5 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &passkeyFixture{
+		ctx:         tenant.WithOrgID(context.Background(), primitive.NewObjectID()),
+		users:       users,
+		credentials: credentials,
+		audit:       audit,
+		service:     svc,
+	}
+}
+
+func (f *passkeyFixture) createUser(t *testing.T, email string) *models.User {
+	t.Helper()
+
+	user := &models.User{Name: "Passkey User", Email: email, EmailVerified: true}
+	if err := f.users.Create(f.ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// register runs the registration ceremony for user with authenticator
+func (f *passkeyFixture) register(t *testing.T, user *models.User, authenticator *testutil.SoftAuthenticator) (*models.WebAuthnCredential, error) {
+	t.Helper()
+
+	ceremony, err := f.service.BeginRegistration(f.ctx, user.ID.Hex())
+	if err != nil {
+		t.Fatalf("BeginRegistration: %v", err)
+	}
+	return f.service.FinishRegistration(f.ctx, user.ID.Hex(), &models.FinishPasskeyRegistrationRequest{
+		CeremonyID: ceremony.CeremonyID,
+		Name:       "Laptop",
+		Credential: authenticator.Create(t, ceremony.Options),
+	})
+}
+
+// login runs the login ceremony with authenticator
+func (f *passkeyFixture) login(t *testing.T, authenticator *testutil.SoftAuthenticator) (*models.User, error) {
+	t.Helper()
+
+	ceremony, err := f.service.BeginLogin(f.ctx)
+	if err != nil {
+		t.Fatalf("BeginLogin: %v", err)
+	}
+	return f.service.FinishLogin(f.ctx, &models.FinishPasskeyLoginRequest{
+		CeremonyID: ceremony.CeremonyID,
+		Credential: authenticator.Get(t, ceremony.Options),
+	})
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	f := newPasskeyFixture(t)
+	user := f.createUser(t, "passkey@example.com")
+	authenticator := testutil.NewSoftAuthenticator(t, testRPID, testOrigin)
+
+	stored, err := f.register(t, user, authenticator)
+	if err != nil {
+		t.Fatalf("FinishRegistration: %v", err)
+	}
+	if stored.UserID != user.ID || stored.Name != "Laptop" || string(stored.CredentialID) != string(authenticator.CredentialID) {
+		t.Errorf("stored passkey = %+v", stored)
+	}
+
+	signedIn, err := f.login(t, authenticator)
+	if err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if signedIn.ID != user.ID {
+		t.Errorf("login signed in %s, want %s", signedIn.ID.Hex(), user.ID.Hex())
+	}
+
+	passkeys, err := f.service.List(f.ctx, user.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(passkeys) != 1 || passkeys[0].SignCount != 1 || passkeys[0].LastUsedAt == nil {
+		t.Errorf("the login was not recorded: %+v", passkeys)
+	}
+
+	// The same authenticator cannot be registered twice
+	if _, err := f.register(t, user, authenticator); !errors.Is(err, ErrPasskeyAlreadyExists) {
+		t.Errorf("second registration: err = %v, want ErrPasskeyAlreadyExists", err)
+	}
+}
+
+func TestPasskeyRegistrationRejectsForeignCeremony(t *testing.T) {
+	f := newPasskeyFixture(t)
+	owner := f.createUser(t, "owner@example.com")
+	other := f.createUser(t, "other@example.com")
+
+	ceremony, err := f.service.BeginRegistration(f.ctx, owner.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.service.FinishRegistration(f.ctx, other.ID.Hex(), &models.FinishPasskeyRegistrationRequest{
+		CeremonyID: ceremony.CeremonyID,
+		Credential: testutil.NewSoftAuthenticator(t, testRPID, testOrigin).Create(t, ceremony.Options),
+	})
+	if !errors.Is(err, ErrInvalidPasskeyCeremony) {
+		t.Errorf("err = %v, want ErrInvalidPasskeyCeremony", err)
+	}
+}
+
+func TestPasskeyRegistrationRejectsWrongOrigin(t *testing.T) {
+	f := newPasskeyFixture(t)
+	user := f.createUser(t, "passkey@example.com")
+
+	authenticator := testutil.NewSoftAuthenticator(t, testRPID, testOrigin)
+	authenticator.Origin = "https://evil.example.net"
+	if _, err := f.register(t, user, authenticator); !errors.Is(err, ErrInvalidPasskey) {
+		t.Errorf("err = %v, want ErrInvalidPasskey", err)
+	}
+}
+
+func TestPasskeyLoginRejectsReplayedCeremony(t *testing.T) {
+	f := newPasskeyFixture(t)
+	authenticator := testutil.NewSoftAuthenticator(t, testRPID, testOrigin)
+	if _, err := f.register(t, f.createUser(t, "passkey@example.com"), authenticator); err != nil {
+		t.Fatal(err)
+	}
+
+	ceremony, err := f.service.BeginLogin(f.ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := &models.FinishPasskeyLoginRequest{CeremonyID: ceremony.CeremonyID, Credential: authenticator.Get(t, ceremony.Options)}
+	if _, err := f.service.FinishLogin(f.ctx, req); err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if _, err := f.service.FinishLogin(f.ctx, req); !errors.Is(err, ErrInvalidPasskeyCeremony) {
+		t.Errorf("replayed login: err = %v, want ErrInvalidPasskeyCeremony", err)
+	}
+}
+
+func TestPasskeyLoginRejectsClonedAuthenticator(t *testing.T) {
+	f := newPasskeyFixture(t)
+	authenticator := testutil.NewSoftAuthenticator(t, testRPID, testOrigin)
+	if _, err := f.register(t, f.createUser(t, "passkey@example.com"), authenticator); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.login(t, authenticator); err != nil {
+		t.Fatalf("first login: %v", err)
+	}
+	if _, err := f.login(t, authenticator); err != nil {
+		t.Fatalf("second login: %v", err)
+	}
+
+	// A copy of the key still at the first counter value signs in next
+	authenticator.SignCount = 0
+	if _, err := f.login(t, authenticator); !errors.Is(err, ErrInvalidPasskey) {
+		t.Errorf("err = %v, want ErrInvalidPasskey", err)
+	}
+}
+
+func TestPasskeyLoginRejectsMismatchedUserHandle(t *testing.T) {
+	f := newPasskeyFixture(t)
+	authenticator := testutil.NewSoftAuthenticator(t, testRPID, testOrigin)
+	if _, err := f.register(t, f.createUser(t, "passkey@example.com"), authenticator); err != nil {
+		t.Fatal(err)
+	}
+	other := f.createUser(t, "other@example.com")
+
+	// The authenticator claims the passkey belongs to someone else
+	authenticator.UserHandle = other.ID[:]
+	if _, err := f.login(t, authenticator); !errors.Is(err, ErrInvalidPasskey) {
+		t.Errorf("err = %v, want ErrInvalidPasskey", err)
+	}
+}
+
+func TestPasskeyLoginActsInThePasskeysOrganization(t *testing.T) {
+	f := newPasskeyFixture(t)
+	user := f.createUser(t, "passkey@example.com")
+	authenticator := testutil.NewSoftAuthenticator(t, testRPID, testOrigin)
+	stored, err := f.register(t, user, authenticator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A login names no organization, so it may arrive acting in another one
+	home := f.ctx
+	f.ctx = tenant.WithOrgID(context.Background(), primitive.NewObjectID())
+	signedIn, err := f.login(t, authenticator)
+	if err != nil {
+		t.Fatalf("FinishLogin: %v", err)
+	}
+	if signedIn.ID != user.ID || signedIn.OrgID != user.OrgID {
+		t.Errorf("login signed in %+v, want %s", signedIn, user.ID.Hex())
+	}
+
+	// Managing passkeys stays within the organization
+	if passkeys, err := f.service.List(f.ctx, user.ID.Hex()); err != nil || len(passkeys) != 0 {
+		t.Errorf("another organization listed %v (err %v)", passkeys, err)
+	}
+	if err := f.service.Rename(f.ctx, user.ID.Hex(), stored.ID.Hex(), "Stolen"); !errors.Is(err, ErrPasskeyNotFound) {
+		t.Errorf("renaming from another organization: err = %v, want ErrPasskeyNotFound", err)
+	}
+	if passkeys, err := f.service.List(home, user.ID.Hex()); err != nil || len(passkeys) != 1 || passkeys[0].Name != "Laptop" {
+		t.Errorf("passkeys = %v (err %v), want the laptop", passkeys, err)
+	}
+}
+
+func TestPasskeyDelete(t *testing.T) {
+	f := newPasskeyFixture(t)
+	user := f.createUser(t, "passkey@example.com")
+	authenticator := testutil.NewSoftAuthenticator(t, testRPID, testOrigin)
+	stored, err := f.register(t, user, authenticator)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := f.createUser(t, "other@example.com")
+	if err := f.service.Delete(f.ctx, other.ID.Hex(), stored.ID.Hex()); !errors.Is(err, ErrPasskeyNotFound) {
+		t.Errorf("deleting another user's passkey: err = %v, want ErrPasskeyNotFound", err)
+	}
+	if err := f.service.Delete(f.ctx, user.ID.Hex(), stored.ID.Hex()); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, err := f.login(t, authenticator); !errors.Is(err, ErrInvalidPasskey) {
+		t.Errorf("login with a removed passkey: err = %v, want ErrInvalidPasskey", err)
+	}
+
+	if actions := f.audit.actions(); len(actions) != 2 || actions[0] != models.AuditPasskeyCreate || actions[1] != models.AuditPasskeyDelete {
+		t.Fatalf("audited %v, want the registration and the removal", actions)
+	}
+	removed := f.audit.events[1]
+	if removed.TargetID != user.ID.Hex() || len(removed.Changes) != 2 {
+		t.Errorf("removal audited as %+v", removed)
+	}
+}
@@ -0,0 +1,295 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/logger"
+	"gin-mongo-aws/internal/models"
+
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// RefreshTokenService issues opaque refresh tokens grouped into families.
+// Every use rotates the token; presenting an already rotated token revokes
+// the whole family. Families issued to an OAuth client can only be rotated
+// by that client; first-party logins pass an empty client ID. A family
+// records the user's organization, which refreshing it acts in.
+type RefreshTokenService interface {
+	Issue(ctx context.Context, user *models.User, client ClientInfo) (*RefreshFamily, string, error)
+	IssueForClient(ctx context.Context, user *models.User, clientID, scope string, client ClientInfo) (*RefreshFamily, string, error)
+	Rotate(ctx context.Context, token, clientID string, client ClientInfo) (*RefreshFamily, string, error)
+	Get(ctx context.Context, token string) (*RefreshFamily, error)
+	Revoke(ctx context.Context, token string) error
+	RevokeFamily(ctx context.Context, familyID string) error
+	RevokeAllForUser(ctx context.Context, userID string) error
+	ListForUser(ctx context.Context, userID string) ([]RefreshFamily, error)
+}
+
+// ClientInfo describes the device a login was made from
+type ClientInfo struct {
+	IP        string
+	UserAgent string
+}
+
+// RefreshFamily tracks the chain of refresh tokens created from one login
+type RefreshFamily struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	OrgID      string    `json:"org_id,omitempty"` // unset on families issued before organizations
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	ClientID   string    `json:"client_id,omitempty"`
+	Scope      string    `json:"scope,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+}
+
+type refreshTokenRecord struct {
+	UserID   string `json:"user_id"`
+	FamilyID string `json:"family_id"`
+}
+
+type refreshTokenService struct {
+	ttl time.Duration
+}
+
+func NewRefreshTokenService(ttl time.Duration) RefreshTokenService {
+	return &refreshTokenService{ttl: ttl}
+}
+
+func (s *refreshTokenService) Issue(ctx context.Context, user *models.User, client ClientInfo) (*RefreshFamily, string, error) {
+	return s.IssueForClient(ctx, user, "", "", client)
+}
+
+func (s *refreshTokenService) IssueForClient(ctx context.Context, user *models.User, clientID, scope string, client ClientInfo) (*RefreshFamily, string, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return nil, "", err
+	}
+
+	userID := user.ID.Hex()
+	now := time.Now()
+	family := &RefreshFamily{
+		ID:         familyID,
+		UserID:     userID,
+		OrgID:      user.OrgID.Hex(),
+		IP:         client.IP,
+		UserAgent:  client.UserAgent,
+		ClientID:   clientID,
+		Scope:      scope,
+		CreatedAt:  now,
+		LastUsedAt: now,
+	}
+	if err := s.saveFamily(ctx, family); err != nil {
+		return nil, "", err
+	}
+
+	userKey := refreshUserKey(userID)
+	pipe := database.RedisClient.TxPipeline()
+	pipe.SAdd(ctx, userKey, familyID)
+	pipe.Expire(ctx, userKey, s.ttl)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, "", err
+	}
+
+	token, err := s.newToken(ctx, userID, familyID)
+	if err != nil {
+		return nil, "", err
+	}
+	return family, token, nil
+}
+
+func (s *refreshTokenService) Rotate(ctx context.Context, token, clientID string, client ClientInfo) (*RefreshFamily, string, error) {
+	record, err := s.getRecord(ctx, token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	family, err := s.getFamily(ctx, record.FamilyID)
+	if err != nil {
+		return nil, "", err
+	}
+	if family.ClientID != clientID {
+		return nil, "", ErrInvalidRefreshToken
+	}
+
+	// Only the first presentation of a token may rotate it
+	first, err := database.RedisClient.SetNX(ctx, refreshUsedKey(token), record.FamilyID, s.ttl).Result()
+	if err != nil {
+		return nil, "", err
+	}
+	if !first {
+		logger.Log.Warn("Refresh token reuse detected, revoking family",
+			zap.String("user_id", record.UserID),
+			zap.String("family_id", record.FamilyID),
+		)
+		if err := s.RevokeFamily(ctx, record.FamilyID); err != nil {
+			return nil, "", err
+		}
+		return nil, "", ErrRefreshTokenReused
+	}
+
+	family.LastUsedAt = time.Now()
+	family.IP = client.IP
+	family.UserAgent = client.UserAgent
+	if err := s.saveFamily(ctx, family); err != nil {
+		return nil, "", err
+	}
+
+	newToken, err := s.newToken(ctx, record.UserID, record.FamilyID)
+	if err != nil {
+		return nil, "", err
+	}
+	return family, newToken, nil
+}
+
+// Get returns the family a refresh token belongs to without rotating it
+func (s *refreshTokenService) Get(ctx context.Context, token string) (*RefreshFamily, error) {
+	record, err := s.getRecord(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	return s.getFamily(ctx, record.FamilyID)
+}
+
+func (s *refreshTokenService) Revoke(ctx context.Context, token string) error {
+	record, err := s.getRecord(ctx, token)
+	if err != nil {
+		return err
+	}
+	return s.RevokeFamily(ctx, record.FamilyID)
+}
+
+func (s *refreshTokenService) RevokeFamily(ctx context.Context, familyID string) error {
+	family, err := s.getFamily(ctx, familyID)
+	if errors.Is(err, ErrInvalidRefreshToken) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	pipe := database.RedisClient.TxPipeline()
+	pipe.Del(ctx, refreshFamilyKey(familyID))
+	pipe.SRem(ctx, refreshUserKey(family.UserID), familyID)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *refreshTokenService) RevokeAllForUser(ctx context.Context, userID string) error {
+	familyIDs, err := database.RedisClient.SMembers(ctx, refreshUserKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{refreshUserKey(userID)}
+	for _, id := range familyIDs {
+		keys = append(keys, refreshFamilyKey(id))
+	}
+	return database.RedisClient.Del(ctx, keys...).Err()
+}
+
+func (s *refreshTokenService) ListForUser(ctx context.Context, userID string) ([]RefreshFamily, error) {
+	familyIDs, err := database.RedisClient.SMembers(ctx, refreshUserKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	families := make([]RefreshFamily, 0, len(familyIDs))
+	for _, id := range familyIDs {
+		family, err := s.getFamily(ctx, id)
+		if errors.Is(err, ErrInvalidRefreshToken) {
+			// Expired family; drop the stale reference
+			database.RedisClient.SRem(ctx, refreshUserKey(userID), id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		families = append(families, *family)
+	}
+	return families, nil
+}
+
+func (s *refreshTokenService) newToken(ctx context.Context, userID, familyID string) (string, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := json.Marshal(refreshTokenRecord{UserID: userID, FamilyID: familyID})
+	if err != nil {
+		return "", err
+	}
+
+	if err := database.RedisClient.Set(ctx, refreshTokenKey(token), data, s.ttl).Err(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+func (s *refreshTokenService) getRecord(ctx context.Context, token string) (*refreshTokenRecord, error) {
+	val, err := database.RedisClient.Get(ctx, refreshTokenKey(token)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var record refreshTokenRecord
+	if err := json.Unmarshal([]byte(val), &record); err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	return &record, nil
+}
+
+func (s *refreshTokenService) getFamily(ctx context.Context, familyID string) (*RefreshFamily, error) {
+	val, err := database.RedisClient.Get(ctx, refreshFamilyKey(familyID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var family RefreshFamily
+	if err := json.Unmarshal([]byte(val), &family); err != nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	return &family, nil
+}
+
+func (s *refreshTokenService) saveFamily(ctx context.Context, family *RefreshFamily) error {
+	data, err := json.Marshal(family)
+	if err != nil {
+		return err
+	}
+	return database.RedisClient.Set(ctx, refreshFamilyKey(family.ID), data, s.ttl).Err()
+}
+
+func refreshTokenKey(token string) string {
+	return "refresh:token:" + hashToken(token)
+}
+
+func refreshUsedKey(token string) string {
+	return "refresh:used:" + hashToken(token)
+}
+
+func refreshFamilyKey(familyID string) string {
+	return "refresh:family:" + familyID
+}
+
+func refreshUserKey(userID string) string {
+	return "refresh:user:" + userID
+}
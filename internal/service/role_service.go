@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/repository"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrRoleNotFound = errors.New("role not found")
+	ErrRoleExists   = errors.New("role already exists")
+	ErrBuiltinRole  = errors.New("built-in roles cannot be modified")
+	ErrUnknownRole  = errors.New("unknown role")
+)
+
+type RoleService interface {
+	CreateRole(ctx context.Context, role *models.Role) error
+	GetAllRoles(ctx context.Context) ([]models.Role, error)
+	UpdateRole(ctx context.Context, name string, role *models.Role) error
+	DeleteRole(ctx context.Context, name string) error
+	ValidateRoles(ctx context.Context, names []string) error
+	HasPermission(ctx context.Context, roles []string, permission string) (bool, error)
+	MemberHasPermission(ctx context.Context, userID, permission string) (bool, error)
+}
+
+type roleService struct {
+	repo        repository.RoleRepository
+	memberships repository.MembershipRepository
+}
+
+func NewRoleService(repo repository.RoleRepository, memberships repository.MembershipRepository) RoleService {
+	return &roleService{repo: repo, memberships: memberships}
+}
+
+func (s *roleService) CreateRole(ctx context.Context, role *models.Role) error {
+	if _, ok := models.BuiltinRoles[role.Name]; ok {
+		return ErrRoleExists
+	}
+
+	if err := s.repo.Create(ctx, role); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return ErrRoleExists
+		}
+		return err
+	}
+	return nil
+}
+
+func (s *roleService) GetAllRoles(ctx context.Context) ([]models.Role, error) {
+	roles := make([]models.Role, 0, len(models.BuiltinRoles))
+	for _, name := range []string{models.RoleAdmin, models.RoleUser} {
+		roles = append(roles, models.Role{Name: name, Description: "Built-in role", Permissions: models.BuiltinRoles[name]})
+	}
+
+	custom, err := s.repo.FindAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return append(roles, custom...), nil
+}
+
+func (s *roleService) UpdateRole(ctx context.Context, name string, role *models.Role) error {
+	if _, ok := models.BuiltinRoles[name]; ok {
+		return ErrBuiltinRole
+	}
+
+	err := s.repo.Update(ctx, name, role)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrRoleNotFound
+	}
+	if err == nil {
+		// Invalidate cache
+		database.RedisClient.Del(ctx, "role:"+name)
+	}
+	return err
+}
+
+func (s *roleService) DeleteRole(ctx context.Context, name string) error {
+	if _, ok := models.BuiltinRoles[name]; ok {
+		return ErrBuiltinRole
+	}
+
+	err := s.repo.Delete(ctx, name)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrRoleNotFound
+	}
+	if err == nil {
+		// Invalidate cache
+		database.RedisClient.Del(ctx, "role:"+name)
+	}
+	return err
+}
+
+func (s *roleService) ValidateRoles(ctx context.Context, names []string) error {
+	var custom []string
+	for _, name := range names {
+		if _, ok := models.BuiltinRoles[name]; !ok {
+			custom = append(custom, name)
+		}
+	}
+	if len(custom) == 0 {
+		return nil
+	}
+
+	found, err := s.repo.FindByNames(ctx, custom)
+	if err != nil {
+		return err
+	}
+	if len(found) != len(dedupe(custom)) {
+		return ErrUnknownRole
+	}
+	return nil
+}
+
+func (s *roleService) HasPermission(ctx context.Context, roles []string, permission string) (bool, error) {
+	for _, name := range roles {
+		granted, err := s.permissions(ctx, name)
+		if err != nil {
+			return false, err
+		}
+		for _, p := range granted {
+			if PermissionMatches(p, permission) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// MemberHasPermission checks the roles the user holds in the organization in
+// ctx. A user without a membership there holds no permissions.
+func (s *roleService) MemberHasPermission(ctx context.Context, userID, permission string) (bool, error) {
+	roles, err := s.memberships.FindRoles(ctx, userID)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return s.HasPermission(ctx, roles, permission)
+}
+
+// permissions returns the permissions granted by a single role
+func (s *roleService) permissions(ctx context.Context, name string) ([]string, error) {
+	if perms, ok := models.BuiltinRoles[name]; ok {
+		return perms, nil
+	}
+
+	// Try to get from cache
+	val, err := database.RedisClient.Get(ctx, "role:"+name).Result()
+	if err == nil {
+		var perms []string
+		if err := json.Unmarshal([]byte(val), &perms); err == nil {
+			return perms, nil
+		}
+	}
+
+	role, err := s.repo.FindByName(ctx, name)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Set to cache
+	data, _ := json.Marshal(role.Permissions)
+	database.RedisClient.Set(ctx, "role:"+name, data, 10*time.Minute)
+
+	return role.Permissions, nil
+}
+
+// PermissionMatches reports whether a granted permission covers the required
+// one. "*" grants everything and "users:*" grants every users permission.
+func PermissionMatches(granted, required string) bool {
+	if granted == "*" || granted == required {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(granted, "*"); ok {
+		return strings.HasPrefix(required, prefix)
+	}
+	return false
+}
+
+func dedupe(values []string) []string {
+	seen := make(map[string]struct{}, len(values))
+	out := values[:0:0]
+	for _, v := range values {
+		if _, ok := seen[v]; !ok {
+			seen[v] = struct{}{}
+			out = append(out, v)
+		}
+	}
+	return out
+}
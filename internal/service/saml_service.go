@@ -0,0 +1,469 @@
+package service
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/logger"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/repository"
+	"gin-mongo-aws/internal/tenant"
+
+	"github.com/crewjam/saml"
+	"github.com/redis/go-redis/v9"
+	dsig "github.com/russellhaering/goxmldsig"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrInvalidSAMLState = errors.New("invalid or expired SAML login")
+	ErrSAMLLoginFailed  = errors.New("SAML login failed")
+	ErrSAMLEmailMissing = errors.New("the identity provider did not send an email address")
+	ErrSAMLEmailDomain  = errors.New("the identity provider may not sign in users with this email domain")
+)
+
+const (
+	samlStateTTL     = 10 * time.Minute
+	samlMetadataSize = 1 << 20
+)
+
+// Attribute names tried when a provider does not configure one. They cover
+// the LDAP-style names, Okta's defaults and the WS-Federation claim URIs
+// used by Entra ID and ADFS.
+var (
+	samlEmailAttributes      = []string{"email", "mail", "emailAddress", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/emailaddress", "urn:oid:0.9.2342.19200300.100.1.3"}
+	samlNameAttributes       = []string{"displayName", "name", "http://schemas.microsoft.com/identity/claims/displayname", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/name", "urn:oid:2.16.840.1.113730.3.1.241", "cn"}
+	samlGivenNameAttributes  = []string{"givenName", "firstName", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/givenname", "urn:oid:2.5.4.42"}
+	samlFamilyNameAttributes = []string{"sn", "surname", "lastName", "http://schemas.xmlsoap.org/ws/2005/05/identity/claims/surname", "urn:oid:2.5.4.4"}
+)
+
+// SAMLService signs users in through SAML 2.0 identity providers, acting as
+// the service provider. Logins are always started here (SP-initiated) so every
+// response can be matched to the request it answers.
+type SAMLService interface {
+	Providers() []models.IdentityProviderInfo
+	Metadata(ctx context.Context, provider string) ([]byte, error)
+	Start(ctx context.Context, provider string) (redirectURL, relayState string, err error)
+	Complete(ctx context.Context, provider, relayState, samlResponse string) (*models.User, error)
+}
+
+// samlLoginState is kept in Redis, keyed by the RelayState, between the
+// redirect to the IdP and the assertion coming back, which acts in the
+// organization the login started in
+type samlLoginState struct {
+	Provider  string `json:"provider"`
+	OrgID     string `json:"org_id"`
+	RequestID string `json:"request_id"`
+}
+
+type samlService struct {
+	accountLinker
+	cfg       map[string]config.SAMLProviderConfig
+	providers map[string]*samlProvider
+}
+
+// samlProvider is the service provider for one IdP. Metadata from a URL is
+// fetched on first use so an IdP that is down does not stop the API from
+// starting.
+type samlProvider struct {
+	mu     sync.Mutex
+	sp     saml.ServiceProvider
+	cfg    config.SAMLProviderConfig
+	client *http.Client
+	loaded bool
+
+	// domains are the email domains the IdP vouches for
+	domains map[string]bool
+}
+
+// NewSAMLService builds a service provider per configured IdP. The SP
+// endpoints live under baseURL, the public URL of the API.
+func NewSAMLService(users repository.UserRepository, audit AuditService, cfg config.SAMLConfig, baseURL string) (SAMLService, error) {
+	key, cert, err := loadSAMLKeyPair(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &http.Client{Timeout: externalHTTPTimeout}
+	providers := make(map[string]*samlProvider, len(cfg.Providers))
+	for name, providerCfg := range cfg.Providers {
+		if (providerCfg.MetadataURL == "") == (providerCfg.MetadataFile == "") {
+			return nil, fmt.Errorf("saml.providers.%s: set exactly one of metadata_url and metadata_file", name)
+		}
+		if len(providerCfg.AllowedDomains) == 0 {
+			return nil, fmt.Errorf("saml.providers.%s: allowed_domains must list the email domains the IdP may sign in", name)
+		}
+		domains := make(map[string]bool, len(providerCfg.AllowedDomains))
+		for _, domain := range providerCfg.AllowedDomains {
+			domains[strings.ToLower(strings.TrimPrefix(strings.TrimSpace(domain), "@"))] = true
+		}
+
+		root := strings.TrimSuffix(baseURL, "/") + "/api/v1/auth/saml/" + name
+		metadataURL, err := url.Parse(root + "/metadata")
+		if err != nil {
+			return nil, fmt.Errorf("saml.providers.%s: %w", name, err)
+		}
+		acsURL, _ := url.Parse(root + "/acs")
+
+		provider := &samlProvider{
+			cfg:     providerCfg,
+			client:  client,
+			domains: domains,
+			sp: saml.ServiceProvider{
+				EntityID:          metadataURL.String(),
+				Key:               key,
+				Certificate:       cert,
+				MetadataURL:       *metadataURL,
+				AcsURL:            *acsURL,
+				AuthnNameIDFormat: saml.UnspecifiedNameIDFormat,
+			},
+		}
+		if key != nil {
+			provider.sp.SignatureMethod = dsig.RSASHA256SignatureMethod
+		}
+		if providerCfg.MetadataFile != "" {
+			data, err := os.ReadFile(providerCfg.MetadataFile)
+			if err != nil {
+				return nil, fmt.Errorf("saml.providers.%s: %w", name, err)
+			}
+			if err := provider.setMetadata(data); err != nil {
+				return nil, fmt.Errorf("saml.providers.%s: %w", name, err)
+			}
+		}
+		providers[name] = provider
+	}
+
+	return &samlService{accountLinker: accountLinker{users: users, audit: audit}, cfg: cfg.Providers, providers: providers}, nil
+}
+
+func loadSAMLKeyPair(cfg config.SAMLConfig) (*rsa.PrivateKey, *x509.Certificate, error) {
+	if cfg.CertificateFile == "" && cfg.KeyFile == "" {
+		return nil, nil, nil
+	}
+
+	pair, err := tls.LoadX509KeyPair(cfg.CertificateFile, cfg.KeyFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("saml key pair: %w", err)
+	}
+	key, ok := pair.PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("saml.key_file must hold an RSA key")
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("saml certificate: %w", err)
+	}
+	return key, cert, nil
+}
+
+func (s *samlService) Providers() []models.IdentityProviderInfo {
+	infos := make([]models.IdentityProviderInfo, 0, len(s.cfg))
+	for name, cfg := range s.cfg {
+		displayName := cfg.DisplayName
+		if displayName == "" {
+			displayName = name
+		}
+		infos = append(infos, models.IdentityProviderInfo{
+			Name:        name,
+			DisplayName: displayName,
+			LoginURL:    "/api/v1/auth/saml/" + name + "/login",
+		})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos
+}
+
+// Metadata returns the SP metadata to register with the IdP
+func (s *samlService) Metadata(ctx context.Context, name string) ([]byte, error) {
+	provider, ok := s.providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	// The SP metadata does not depend on the IdP's, so it is served even
+	// before the IdP metadata could be fetched
+	provider.mu.Lock()
+	sp := provider.sp
+	provider.mu.Unlock()
+
+	data, err := xml.MarshalIndent(sp.Metadata(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), data...), nil
+}
+
+// Start returns the IdP URL to send the browser to, carrying a signed (when
+// a key pair is configured) AuthnRequest, and the RelayState that identifies
+// the login when the assertion comes back
+func (s *samlService) Start(ctx context.Context, name string) (string, string, error) {
+	provider, ok := s.providers[name]
+	if !ok {
+		return "", "", ErrUnknownProvider
+	}
+	sp, err := provider.serviceProvider(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	ssoURL := sp.GetSSOBindingLocation(saml.HTTPRedirectBinding)
+	if ssoURL == "" {
+		return "", "", fmt.Errorf("saml provider %s has no HTTP-Redirect single sign-on endpoint", name)
+	}
+	request, err := sp.MakeAuthenticationRequest(ssoURL, saml.HTTPRedirectBinding, saml.HTTPPostBinding)
+	if err != nil {
+		return "", "", err
+	}
+
+	relayState, err := randomToken(32)
+	if err != nil {
+		return "", "", err
+	}
+	redirectURL, err := request.Redirect(relayState, sp)
+	if err != nil {
+		return "", "", err
+	}
+
+	data, err := json.Marshal(samlLoginState{Provider: name, OrgID: tenant.OrgHex(ctx), RequestID: request.ID})
+	if err != nil {
+		return "", "", err
+	}
+	if err := database.RedisClient.Set(ctx, samlStateKey(relayState), data, samlStateTTL).Err(); err != nil {
+		return "", "", err
+	}
+	return redirectURL.String(), relayState, nil
+}
+
+// Complete validates the SAMLResponse posted to the assertion consumer
+// service: the signature, audience, destination, validity window and that it
+// answers the request made for relayState. The user is then found or
+// provisioned from the assertion's attributes.
+func (s *samlService) Complete(ctx context.Context, name, relayState, samlResponse string) (*models.User, error) {
+	provider, ok := s.providers[name]
+	if !ok {
+		return nil, ErrUnknownProvider
+	}
+
+	// GETDEL makes each login single-use
+	data, err := database.RedisClient.GetDel(ctx, samlStateKey(relayState)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidSAMLState
+	}
+	if err != nil {
+		return nil, err
+	}
+	var pending samlLoginState
+	if err := json.Unmarshal(data, &pending); err != nil || pending.Provider != name {
+		return nil, ErrInvalidSAMLState
+	}
+	if ctx, err = tenant.WithOrgHex(ctx, pending.OrgID); err != nil {
+		return nil, ErrInvalidSAMLState
+	}
+
+	sp, err := provider.serviceProvider(ctx)
+	if err != nil {
+		return nil, err
+	}
+	responseXML, err := base64.StdEncoding.DecodeString(samlResponse)
+	if err != nil {
+		return nil, ErrSAMLLoginFailed
+	}
+	assertion, err := sp.ParseXMLResponse(responseXML, []string{pending.RequestID})
+	if err != nil {
+		var invalid *saml.InvalidResponseError
+		if errors.As(err, &invalid) {
+			err = invalid.PrivateErr
+		}
+		logger.Log.Warn("SAML login failed", zap.String("provider", name), zap.Error(err))
+		return nil, ErrSAMLLoginFailed
+	}
+
+	identity, err := provider.identity(assertion)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.login(ctx, name, identity, provider.cfg.AllowSignup)
+	if err != nil {
+		return nil, err
+	}
+	return s.syncProfile(ctx, user, identity)
+}
+
+// syncProfile keeps the user's name in line with the IdP, which is the
+// source of truth for enterprise accounts
+func (s *samlService) syncProfile(ctx context.Context, user *models.User, identity *externalIdentity) (*models.User, error) {
+	updated := *user
+	if identity.Name != "" {
+		updated.Name = identity.Name
+	}
+	if identity.GivenName != "" {
+		updated.GivenName = identity.GivenName
+	}
+	if identity.FamilyName != "" {
+		updated.FamilyName = identity.FamilyName
+	}
+	if updated.Name == user.Name && updated.GivenName == user.GivenName && updated.FamilyName == user.FamilyName {
+		return user, nil
+	}
+
+	userID := user.ID.Hex()
+	if err := s.users.ReplaceProfile(ctx, userID, &updated); err != nil {
+		return nil, err
+	}
+
+	// Invalidate cache
+	database.RedisClient.Del(ctx, "user:"+userID)
+
+	s.audit.Record(auditAsUser(ctx, user), models.AuditUserUpdate, models.AuditTargetUser, userID, user, &updated)
+	return &updated, nil
+}
+
+func (p *samlProvider) serviceProvider(ctx context.Context) (*saml.ServiceProvider, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.loaded {
+		data, err := p.fetchMetadata(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.setMetadata(data); err != nil {
+			return nil, err
+		}
+	}
+	sp := p.sp
+	return &sp, nil
+}
+
+func (p *samlProvider) fetchMetadata(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.cfg.MetadataURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("saml metadata: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("saml metadata: %s returned %s", p.cfg.MetadataURL, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, samlMetadataSize))
+}
+
+// setMetadata accepts an EntityDescriptor, or an EntitiesDescriptor holding
+// exactly one identity provider
+func (p *samlProvider) setMetadata(data []byte) error {
+	var entity saml.EntityDescriptor
+	if err := xml.Unmarshal(data, &entity); err != nil {
+		var entities saml.EntitiesDescriptor
+		if xml.Unmarshal(data, &entities) != nil {
+			return fmt.Errorf("invalid saml metadata: %w", err)
+		}
+		found := 0
+		for _, e := range entities.EntityDescriptors {
+			if len(e.IDPSSODescriptors) > 0 {
+				entity = e
+				found++
+			}
+		}
+		if found != 1 {
+			return errors.New("saml metadata must describe exactly one identity provider")
+		}
+	}
+	if len(entity.IDPSSODescriptors) == 0 {
+		return errors.New("saml metadata does not describe an identity provider")
+	}
+
+	p.sp.IDPMetadata = &entity
+	p.loaded = true
+	return nil
+}
+
+// identity maps the assertion onto the user fields. The NameID is the
+// subject the account is linked by, so IdPs should send a persistent one.
+// The email is only accepted in the domains the IdP is configured for, since
+// it is used to match existing accounts.
+func (p *samlProvider) identity(assertion *saml.Assertion) (*externalIdentity, error) {
+	if assertion.Subject == nil || assertion.Subject.NameID == nil || assertion.Subject.NameID.Value == "" {
+		logger.Log.Warn("SAML assertion has no NameID", zap.String("issuer", assertion.Issuer.Value))
+		return nil, ErrSAMLLoginFailed
+	}
+	nameID := assertion.Subject.NameID
+
+	email := samlAttribute(assertion, p.cfg.EmailAttribute, samlEmailAttributes)
+	if email == "" && (nameID.Format == string(saml.EmailAddressNameIDFormat) || strings.Contains(nameID.Value, "@")) {
+		email = nameID.Value
+	}
+	email = NormalizeEmail(email)
+	if email == "" {
+		return nil, ErrSAMLEmailMissing
+	}
+	_, domain, _ := strings.Cut(email, "@")
+	if !p.domains[domain] {
+		logger.Log.Warn("SAML assertion for an email outside the allowed domains",
+			zap.String("issuer", assertion.Issuer.Value),
+			zap.String("email", email),
+		)
+		return nil, ErrSAMLEmailDomain
+	}
+
+	identity := &externalIdentity{
+		Subject: nameID.Value,
+		Email:   email,
+		// The IdP is the customer's directory for its domains and vouches
+		// for addresses in them
+		EmailVerified: true,
+		Name:          samlAttribute(assertion, p.cfg.NameAttribute, samlNameAttributes),
+		GivenName:     samlAttribute(assertion, p.cfg.GivenNameAttribute, samlGivenNameAttributes),
+		FamilyName:    samlAttribute(assertion, p.cfg.FamilyNameAttribute, samlFamilyNameAttributes),
+	}
+	if identity.Name == "" {
+		identity.Name = strings.TrimSpace(identity.GivenName + " " + identity.FamilyName)
+	}
+	return identity, nil
+}
+
+// samlAttribute returns the first value of the configured attribute, or of
+// the first default name present when none is configured
+func samlAttribute(assertion *saml.Assertion, configured string, defaults []string) string {
+	names := defaults
+	if configured != "" {
+		names = []string{configured}
+	}
+	for _, name := range names {
+		for _, statement := range assertion.AttributeStatements {
+			for _, attr := range statement.Attributes {
+				if (attr.Name == name || attr.FriendlyName == name) && len(attr.Values) > 0 {
+					if value := strings.TrimSpace(attr.Values[0].Value); value != "" {
+						return value
+					}
+				}
+			}
+		}
+	}
+	return ""
+}
+
+func samlStateKey(relayState string) string {
+	return "saml-login:" + hashToken(relayState)
+}
@@ -0,0 +1,325 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/xml"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/tenant"
+	"gin-mongo-aws/internal/testutil"
+
+	"github.com/crewjam/saml"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// stubIdP is a SAML identity provider with a freshly generated key pair that
+// answers the service's authentication requests with signed assertions
+type stubIdP struct {
+	idp *saml.IdentityProvider
+	sp  *saml.EntityDescriptor
+}
+
+func newStubIdP(t *testing.T) *stubIdP {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "idp.example.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	metadataURL, _ := url.Parse("https://idp.example.com/metadata")
+	ssoURL, _ := url.Parse("https://idp.example.com/sso")
+	s := &stubIdP{}
+	s.idp = &saml.IdentityProvider{
+		Key:                     key,
+		Certificate:             cert,
+		MetadataURL:             *metadataURL,
+		SSOURL:                  *ssoURL,
+		ServiceProviderProvider: s,
+	}
+	return s
+}
+
+// GetServiceProvider returns the metadata of the service under test
+func (s *stubIdP) GetServiceProvider(r *http.Request, serviceProviderID string) (*saml.EntityDescriptor, error) {
+	if s.sp == nil || s.sp.EntityID != serviceProviderID {
+		return nil, os.ErrNotExist
+	}
+	return s.sp, nil
+}
+
+// writeMetadata saves the IdP metadata for the service's metadata_file
+func (s *stubIdP) writeMetadata(t *testing.T) string {
+	t.Helper()
+
+	data, err := xml.Marshal(s.idp.Metadata())
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "idp-metadata.xml")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// respond plays the user signing in at the IdP: it answers the request in
+// redirectURL with a signed assertion for nameID and returns the SAMLResponse
+func (s *stubIdP) respond(t *testing.T, redirectURL, nameID, email, name string) string {
+	t.Helper()
+
+	req, err := saml.NewIdpAuthnRequest(s.idp, httptest.NewRequest(http.MethodGet, redirectURL, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := req.Validate(); err != nil {
+		t.Fatalf("the IdP rejected the authentication request: %v", err)
+	}
+	session := &saml.Session{
+		ID:           "session-" + nameID,
+		CreateTime:   time.Now(),
+		ExpireTime:   time.Now().Add(time.Hour),
+		NameID:       nameID,
+		NameIDFormat: string(saml.PersistentNameIDFormat),
+		CustomAttributes: []saml.Attribute{
+			{Name: "email", Values: []saml.AttributeValue{{Type: "xs:string", Value: email}}},
+			{Name: "displayName", Values: []saml.AttributeValue{{Type: "xs:string", Value: name}}},
+		},
+	}
+	if err := (saml.DefaultAssertionMaker{}).MakeAssertion(req, session); err != nil {
+		t.Fatal(err)
+	}
+	form, err := req.PostBinding()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return form.SAMLResponse
+}
+
+type samlFixture struct {
+	ctx     context.Context
+	idp     *stubIdP
+	users   *testutil.UserRepository
+	audit   *fakeAuditService
+	service SAMLService
+}
+
+func newSAMLFixture(t *testing.T, allowSignup bool) *samlFixture {
+	t.Helper()
+	testutil.StartRedis(t)
+
+	idp := newStubIdP(t)
+	users := testutil.NewUserRepository()
+	audit := &fakeAuditService{}
+	svc, err := NewSAMLService(users, audit, config.SAMLConfig{
+		Providers: map[string]config.SAMLProviderConfig{
+			"corp": {
+				MetadataFile:   idp.writeMetadata(t),
+				AllowedDomains: []string{"Example.com"},
+				AllowSignup:    allowSignup,
+			},
+		},
+	}, "http://localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := tenant.WithOrgID(context.Background(), primitive.NewObjectID())
+	metadata, err := svc.Metadata(ctx, "corp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	idp.sp = &saml.EntityDescriptor{}
+	if err := xml.Unmarshal(metadata, idp.sp); err != nil {
+		t.Fatal(err)
+	}
+
+	return &samlFixture{ctx: ctx, idp: idp, users: users, audit: audit, service: svc}
+}
+
+// signIn runs a login through the IdP and returns the result of the ACS
+func (f *samlFixture) signIn(t *testing.T, idp *stubIdP, nameID, email, name string) (*models.User, error) {
+	t.Helper()
+
+	redirectURL, relayState, err := f.service.Start(f.ctx, "corp")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	response := idp.respond(t, redirectURL, nameID, email, name)
+	return f.service.Complete(f.ctx, "corp", relayState, response)
+}
+
+func TestSAMLLoginProvisionsAndSyncsProfile(t *testing.T) {
+	f := newSAMLFixture(t, true)
+
+	user, err := f.signIn(t, f.idp, "employee-1", "Jane@Example.com", "Jane Doe")
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if user.Email != "jane@example.com" || user.Name != "Jane Doe" || !user.EmailVerified {
+		t.Errorf("provisioned user = %+v", user)
+	}
+	if len(user.Identities) != 1 || user.Identities[0].Subject != "employee-1" {
+		t.Errorf("provisioned user identities = %+v", user.Identities)
+	}
+
+	// The next login finds the account by its NameID and takes the IdP's name
+	again, err := f.signIn(t, f.idp, "employee-1", "jane@example.com", "Jane Smith")
+	if err != nil {
+		t.Fatalf("second Complete: %v", err)
+	}
+	if again.ID != user.ID || again.Name != "Jane Smith" {
+		t.Errorf("second login signed in %s named %q, want %s named Jane Smith", again.ID.Hex(), again.Name, user.ID.Hex())
+	}
+	if actions := f.audit.actions(); len(actions) != 2 || actions[0] != models.AuditUserCreate || actions[1] != models.AuditUserUpdate {
+		t.Errorf("audited %v, want the creation and the name change", actions)
+	}
+}
+
+func TestSAMLLoginActsInTheOrganizationItStartedIn(t *testing.T) {
+	f := newSAMLFixture(t, true)
+
+	redirectURL, relayState, err := f.service.Start(f.ctx, "corp")
+	if err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	response := f.idp.respond(t, redirectURL, "employee-1", "jane@example.com", "Jane Doe")
+
+	// The IdP posts the response back without naming the organization
+	elsewhere := tenant.WithOrgID(context.Background(), primitive.NewObjectID())
+	user, err := f.service.Complete(elsewhere, "corp", relayState, response)
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if _, err := f.users.FindByID(f.ctx, user.ID.Hex()); err != nil {
+		t.Errorf("the user was not provisioned in the organization the login started in: %v", err)
+	}
+	if users, _ := f.users.FindAll(elsewhere); len(users) != 0 {
+		t.Errorf("users provisioned in the other organization: %+v", users)
+	}
+}
+
+func TestSAMLLoginRejectsOtherDomains(t *testing.T) {
+	f := newSAMLFixture(t, true)
+
+	victim := &models.User{Name: "Victim", Email: "victim@other.com", EmailVerified: true}
+	if err := f.users.Create(f.ctx, victim); err != nil {
+		t.Fatal(err)
+	}
+
+	// The IdP may only vouch for its own domains, so it cannot take over an
+	// account elsewhere by asserting the address
+	if _, err := f.signIn(t, f.idp, "attacker", "victim@other.com", "Attacker"); !errors.Is(err, ErrSAMLEmailDomain) {
+		t.Fatalf("err = %v, want ErrSAMLEmailDomain", err)
+	}
+	stored, err := f.users.FindByID(f.ctx, victim.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Identities) != 0 {
+		t.Errorf("the account was linked: %+v", stored.Identities)
+	}
+
+	// Nor can it provision one
+	if _, err := f.signIn(t, f.idp, "stranger", "stranger@other.com", "Stranger"); !errors.Is(err, ErrSAMLEmailDomain) {
+		t.Errorf("unknown address: err = %v, want ErrSAMLEmailDomain", err)
+	}
+	if users, _ := f.users.FindAll(f.ctx); len(users) != 1 {
+		t.Errorf("users = %+v, want only the victim", users)
+	}
+}
+
+func TestSAMLLoginLinksByEmailInAllowedDomain(t *testing.T) {
+	f := newSAMLFixture(t, false)
+
+	verified := &models.User{Name: "Verified", Email: "verified@example.com", EmailVerified: true}
+	unverified := &models.User{Name: "Unverified", Email: "unverified@example.com"}
+	for _, user := range []*models.User{verified, unverified} {
+		if err := f.users.Create(f.ctx, user); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	user, err := f.signIn(t, f.idp, "employee-1", "verified@example.com", "Verified")
+	if err != nil {
+		t.Fatalf("Complete: %v", err)
+	}
+	if user.ID != verified.ID || len(user.Identities) != 1 {
+		t.Errorf("login did not link the verified account: %+v", user)
+	}
+
+	if _, err := f.signIn(t, f.idp, "employee-2", "unverified@example.com", "Unverified"); !errors.Is(err, ErrAccountLinkRequired) {
+		t.Errorf("unverified account: err = %v, want ErrAccountLinkRequired", err)
+	}
+	if _, err := f.signIn(t, f.idp, "employee-3", "new@example.com", "New"); !errors.Is(err, ErrExternalSignupDisabled) {
+		t.Errorf("unknown email: err = %v, want ErrExternalSignupDisabled", err)
+	}
+}
+
+func TestSAMLLoginRejectsForgedResponse(t *testing.T) {
+	f := newSAMLFixture(t, true)
+
+	// Another IdP signs for the same service provider with its own key
+	forger := newStubIdP(t)
+	forger.sp = f.idp.sp
+	if _, err := f.signIn(t, forger, "employee-1", "jane@example.com", "Jane Doe"); !errors.Is(err, ErrSAMLLoginFailed) {
+		t.Fatalf("forged response: err = %v, want ErrSAMLLoginFailed", err)
+	}
+
+	// A genuine response is accepted once, for the login it answers
+	redirectURL, relayState, err := f.service.Start(f.ctx, "corp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := f.idp.respond(t, redirectURL, "employee-1", "jane@example.com", "Jane Doe")
+	if _, err := f.service.Complete(f.ctx, "corp", "forged-relay-state", response); !errors.Is(err, ErrInvalidSAMLState) {
+		t.Errorf("unknown RelayState: err = %v, want ErrInvalidSAMLState", err)
+	}
+	if _, err := f.service.Complete(f.ctx, "corp", relayState, response); err != nil {
+		t.Fatalf("genuine response: %v", err)
+	}
+	if _, err := f.service.Complete(f.ctx, "corp", relayState, response); !errors.Is(err, ErrInvalidSAMLState) {
+		t.Errorf("replayed response: err = %v, want ErrInvalidSAMLState", err)
+	}
+}
+
+func TestNewSAMLServiceRequiresAllowedDomains(t *testing.T) {
+	idp := newStubIdP(t)
+	_, err := NewSAMLService(testutil.NewUserRepository(), &fakeAuditService{}, config.SAMLConfig{
+		Providers: map[string]config.SAMLProviderConfig{
+			"corp": {MetadataFile: idp.writeMetadata(t)},
+		},
+	}, "http://localhost")
+	if err == nil {
+		t.Fatal("a provider without allowed_domains was accepted")
+	}
+}
@@ -0,0 +1,515 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// scimFilter is a parsed SCIM filter expression (RFC 7644 section 3.4.2.2)
+type scimFilter interface {
+	scimFilter()
+}
+
+type scimLogical struct {
+	op          string // and, or
+	left, right scimFilter
+}
+
+type scimNot struct {
+	expr scimFilter
+}
+
+// scimCompare compares an attribute path, lowercased, with a JSON value. The
+// value is nil for the pr operator.
+type scimCompare struct {
+	attr  string
+	op    string
+	value interface{}
+}
+
+func (scimLogical) scimFilter() {}
+func (scimNot) scimFilter()     {}
+func (scimCompare) scimFilter() {}
+
+var scimCompareOps = map[string]bool{
+	"eq": true, "ne": true, "co": true, "sw": true, "ew": true,
+	"gt": true, "ge": true, "lt": true, "le": true,
+}
+
+type scimToken struct {
+	text   string
+	quoted bool // a string literal, already unquoted
+}
+
+// parseSCIMFilter parses filters such as
+// `userName eq "bjensen" and not (emails[type eq "work"] pr)`
+func parseSCIMFilter(input string) (scimFilter, error) {
+	tokens, err := tokenizeSCIMFilter(input)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("%w: empty filter", ErrSCIMInvalidFilter)
+	}
+
+	p := &scimFilterParser{tokens: tokens}
+	filter, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("%w: unexpected %q", ErrSCIMInvalidFilter, p.tokens[p.pos].text)
+	}
+	return filter, nil
+}
+
+func tokenizeSCIMFilter(input string) ([]scimToken, error) {
+	var tokens []scimToken
+	for i := 0; i < len(input); {
+		switch ch := input[i]; {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case strings.IndexByte("()[]", ch) >= 0:
+			tokens = append(tokens, scimToken{text: string(ch)})
+			i++
+		case ch == '"':
+			end := i + 1
+			for ; end < len(input) && input[end] != '"'; end++ {
+				if input[end] == '\\' {
+					end++
+				}
+			}
+			if end >= len(input) {
+				return nil, fmt.Errorf("%w: unterminated string", ErrSCIMInvalidFilter)
+			}
+			var value string
+			if err := json.Unmarshal([]byte(input[i:end+1]), &value); err != nil {
+				return nil, fmt.Errorf("%w: invalid string %s", ErrSCIMInvalidFilter, input[i:end+1])
+			}
+			tokens = append(tokens, scimToken{text: value, quoted: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(input) && strings.IndexByte(" \t\r\n()[]\"", input[end]) < 0 {
+				end++
+			}
+			tokens = append(tokens, scimToken{text: input[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type scimFilterParser struct {
+	tokens []scimToken
+	pos    int
+	prefix string // attribute of the enclosing value path, as in emails[...]
+}
+
+func (p *scimFilterParser) peek() (scimToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return scimToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+// keyword reports whether the next token is the unquoted word kw and consumes it
+func (p *scimFilterParser) keyword(kw string) bool {
+	if t, ok := p.peek(); ok && !t.quoted && strings.EqualFold(t.text, kw) {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *scimFilterParser) expect(text string) error {
+	if !p.keyword(text) {
+		return fmt.Errorf("%w: expected %q", ErrSCIMInvalidFilter, text)
+	}
+	return nil
+}
+
+func (p *scimFilterParser) parseOr() (scimFilter, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("or") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = scimLogical{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *scimFilterParser) parseAnd() (scimFilter, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.keyword("and") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = scimLogical{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *scimFilterParser) parseUnary() (scimFilter, error) {
+	if p.keyword("not") {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return scimNot{expr: expr}, nil
+	}
+
+	if p.keyword("(") {
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	}
+
+	return p.parseAttribute()
+}
+
+func (p *scimFilterParser) parseAttribute() (scimFilter, error) {
+	t, ok := p.peek()
+	if !ok || t.quoted || strings.ContainsAny(t.text, "()[]") {
+		return nil, fmt.Errorf("%w: expected an attribute", ErrSCIMInvalidFilter)
+	}
+	p.pos++
+	attr := p.prefix + normalizeSCIMAttribute(t.text)
+
+	// A value path such as emails[type eq "work"] filters a multi-valued attribute
+	if p.keyword("[") {
+		if p.prefix != "" {
+			return nil, fmt.Errorf("%w: nested value paths are not supported", ErrSCIMInvalidFilter)
+		}
+		p.prefix = attr + "."
+		expr, err := p.parseOr()
+		p.prefix = ""
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return expr, nil
+	}
+
+	opToken, ok := p.peek()
+	if !ok || opToken.quoted {
+		return nil, fmt.Errorf("%w: expected an operator after %q", ErrSCIMInvalidFilter, t.text)
+	}
+	p.pos++
+	op := strings.ToLower(opToken.text)
+	if op == "pr" {
+		return scimCompare{attr: attr, op: op}, nil
+	}
+	if !scimCompareOps[op] {
+		return nil, fmt.Errorf("%w: unknown operator %q", ErrSCIMInvalidFilter, opToken.text)
+	}
+
+	valueToken, ok := p.peek()
+	if !ok {
+		return nil, fmt.Errorf("%w: expected a value after %q", ErrSCIMInvalidFilter, opToken.text)
+	}
+	p.pos++
+	value, err := scimFilterValue(valueToken)
+	if err != nil {
+		return nil, err
+	}
+	return scimCompare{attr: attr, op: op, value: value}, nil
+}
+
+func scimFilterValue(t scimToken) (interface{}, error) {
+	if t.quoted {
+		return t.text, nil
+	}
+	switch strings.ToLower(t.text) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	if n, err := strconv.ParseFloat(t.text, 64); err == nil {
+		return n, nil
+	}
+	return nil, fmt.Errorf("%w: invalid value %q", ErrSCIMInvalidFilter, t.text)
+}
+
+// normalizeSCIMAttribute lowercases an attribute path and strips the core
+// schema URN clients may prefix it with
+func normalizeSCIMAttribute(attr string) string {
+	attr = strings.ToLower(attr)
+	for _, schema := range []string{"urn:ietf:params:scim:schemas:core:2.0:user:", "urn:ietf:params:scim:schemas:core:2.0:group:"} {
+		attr = strings.TrimPrefix(attr, schema)
+	}
+	return attr
+}
+
+type scimAttributeKind int
+
+const (
+	scimString scimAttributeKind = iota
+	scimID
+	scimIDList
+	scimBoolean
+	scimDateTime
+)
+
+// scimAttribute maps a filterable SCIM attribute onto a Mongo field
+type scimAttribute struct {
+	field     string
+	kind      scimAttributeKind
+	caseExact bool // compare strings exactly rather than ignoring case
+	lowercase bool // the field is stored lowercased
+	inverted  bool // booleans stored negated, as active is stored as disabled
+}
+
+// scimFilterBSON translates a parsed filter into a Mongo query using attrs,
+// which lists the attributes that may be filtered on
+func scimFilterBSON(f scimFilter, attrs map[string]scimAttribute) (bson.M, error) {
+	switch f := f.(type) {
+	case scimLogical:
+		left, err := scimFilterBSON(f.left, attrs)
+		if err != nil {
+			return nil, err
+		}
+		right, err := scimFilterBSON(f.right, attrs)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{"$" + f.op: bson.A{left, right}}, nil
+	case scimNot:
+		expr, err := scimFilterBSON(f.expr, attrs)
+		if err != nil {
+			return nil, err
+		}
+		return bson.M{"$nor": bson.A{expr}}, nil
+	case scimCompare:
+		attr, ok := attrs[f.attr]
+		if !ok {
+			return nil, fmt.Errorf("%w: filtering on %q is not supported", ErrSCIMInvalidFilter, f.attr)
+		}
+		return attr.compare(f.op, f.value)
+	default:
+		return nil, fmt.Errorf("%w: unsupported expression", ErrSCIMInvalidFilter)
+	}
+}
+
+// matchNothing is a query no document satisfies
+var matchNothing = bson.M{"_id": bson.M{"$exists": false}}
+
+func (a scimAttribute) compare(op string, value interface{}) (bson.M, error) {
+	if op == "pr" {
+		if a.kind == scimBoolean {
+			return bson.M{}, nil
+		}
+		return bson.M{a.field: bson.M{"$exists": true, "$nin": bson.A{nil, ""}}}, nil
+	}
+
+	switch a.kind {
+	case scimID, scimIDList:
+		s, ok := value.(string)
+		if !ok || (op != "eq" && op != "ne") {
+			return nil, fmt.Errorf("%w: ids only support eq and ne with a string", ErrSCIMInvalidFilter)
+		}
+		id, err := primitive.ObjectIDFromHex(s)
+		if err != nil {
+			if op == "eq" {
+				return matchNothing, nil
+			}
+			return bson.M{}, nil
+		}
+		if op == "ne" {
+			return bson.M{a.field: bson.M{"$ne": id}}, nil
+		}
+		return bson.M{a.field: id}, nil
+
+	case scimBoolean:
+		b, ok := value.(bool)
+		if !ok || (op != "eq" && op != "ne") {
+			return nil, fmt.Errorf("%w: booleans only support eq and ne", ErrSCIMInvalidFilter)
+		}
+		if op == "ne" {
+			b = !b
+		}
+		if a.inverted {
+			b = !b
+		}
+		if b {
+			return bson.M{a.field: true}, nil
+		}
+		return bson.M{a.field: bson.M{"$ne": true}}, nil
+
+	case scimDateTime:
+		s, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: dates must be strings", ErrSCIMInvalidFilter)
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid date %q", ErrSCIMInvalidFilter, s)
+		}
+		switch op {
+		case "eq":
+			return bson.M{a.field: t}, nil
+		case "ne", "gt", "ge", "lt", "le":
+			return bson.M{a.field: bson.M{mongoComparison(op): t}}, nil
+		}
+		return nil, fmt.Errorf("%w: dates do not support %s", ErrSCIMInvalidFilter, op)
+
+	default:
+		s, ok := value.(string)
+		if !ok {
+			if value == nil && (op == "eq" || op == "ne") {
+				// "attr eq null" matches absent attributes
+				query := bson.M{a.field: bson.M{"$in": bson.A{nil, ""}}}
+				if op == "ne" {
+					query = bson.M{a.field: bson.M{"$nin": bson.A{nil, ""}}}
+				}
+				return query, nil
+			}
+			return nil, fmt.Errorf("%w: %q expects a string", ErrSCIMInvalidFilter, a.field)
+		}
+		return a.compareString(op, s), nil
+	}
+}
+
+func (a scimAttribute) compareString(op, s string) bson.M {
+	if a.lowercase {
+		s = strings.ToLower(s)
+	}
+	exact := a.caseExact || a.lowercase
+
+	var pattern string
+	switch op {
+	case "eq", "ne":
+		if exact {
+			if op == "ne" {
+				return bson.M{a.field: bson.M{"$ne": s}}
+			}
+			return bson.M{a.field: s}
+		}
+		pattern = "^" + regexp.QuoteMeta(s) + "$"
+	case "co":
+		pattern = regexp.QuoteMeta(s)
+	case "sw":
+		pattern = "^" + regexp.QuoteMeta(s)
+	case "ew":
+		pattern = regexp.QuoteMeta(s) + "$"
+	default:
+		return bson.M{a.field: bson.M{mongoComparison(op): s}}
+	}
+
+	regex := primitive.Regex{Pattern: pattern}
+	if !exact {
+		regex.Options = "i"
+	}
+	if op == "ne" {
+		return bson.M{a.field: bson.M{"$not": regex}}
+	}
+	return bson.M{a.field: regex}
+}
+
+func mongoComparison(op string) string {
+	switch op {
+	case "gt":
+		return "$gt"
+	case "ge":
+		return "$gte"
+	case "lt":
+		return "$lt"
+	case "le":
+		return "$lte"
+	default:
+		return "$ne"
+	}
+}
+
+// scimPath is a parsed PATCH path: attr, attr.sub, attr[filter] or attr[filter].sub
+type scimPath struct {
+	attr   string
+	sub    string
+	filter scimFilter
+}
+
+func parseSCIMPath(path string) (*scimPath, error) {
+	path = normalizeSCIMAttribute(strings.TrimSpace(path))
+	p := &scimPath{}
+
+	if open := strings.IndexByte(path, '['); open >= 0 {
+		end := strings.LastIndexByte(path, ']')
+		if end < open {
+			return nil, fmt.Errorf("%w: %q", ErrSCIMInvalidPath, path)
+		}
+		filter, err := parseSCIMFilter(path[open+1 : end])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrSCIMInvalidPath, err)
+		}
+		p.attr, p.filter = path[:open], filter
+		if rest := path[end+1:]; rest != "" {
+			sub, ok := strings.CutPrefix(rest, ".")
+			if !ok {
+				return nil, fmt.Errorf("%w: %q", ErrSCIMInvalidPath, path)
+			}
+			p.sub = sub
+		}
+	} else {
+		p.attr, p.sub, _ = strings.Cut(path, ".")
+	}
+
+	if p.attr == "" {
+		return nil, fmt.Errorf("%w: %q", ErrSCIMInvalidPath, path)
+	}
+	return p, nil
+}
+
+// scimFilterValues collects the values a filter compares attr with when it
+// only consists of "attr eq value" terms joined by or, as in
+// members[value eq "1" or value eq "2"]
+func scimFilterValues(f scimFilter, attr string) ([]string, bool) {
+	switch f := f.(type) {
+	case scimLogical:
+		if f.op != "or" {
+			return nil, false
+		}
+		left, ok := scimFilterValues(f.left, attr)
+		if !ok {
+			return nil, false
+		}
+		right, ok := scimFilterValues(f.right, attr)
+		return append(left, right...), ok
+	case scimCompare:
+		s, ok := f.value.(string)
+		return []string{s}, ok && f.op == "eq" && f.attr == attr
+	default:
+		return nil, false
+	}
+}
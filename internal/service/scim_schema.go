@@ -0,0 +1,130 @@
+package service
+
+import "gin-mongo-aws/internal/models"
+
+// ServiceProviderConfig advertises the SCIM features this server supports
+// (RFC 7643 section 5)
+func (s *scimService) ServiceProviderConfig() map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":          []string{models.SCIMSchemaServiceProviderConfig},
+		"documentationUri": "",
+		"patch":            map[string]bool{"supported": true},
+		"bulk":             map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":           map[string]interface{}{"supported": true, "maxResults": s.maxResults},
+		"changePassword":   map[string]bool{"supported": true},
+		"sort":             map[string]bool{"supported": false},
+		"etag":             map[string]bool{"supported": false},
+		"authenticationSchemes": []map[string]interface{}{{
+			"type":        "oauthbearertoken",
+			"name":        "Bearer Token",
+			"description": "Authentication with the token configured as scim.token",
+			"primary":     true,
+		}},
+		"meta": map[string]string{
+			"resourceType": "ServiceProviderConfig",
+			"location":     s.baseURL + "/ServiceProviderConfig",
+		},
+	}
+}
+
+func (s *scimService) ResourceTypes() []map[string]interface{} {
+	return []map[string]interface{}{
+		s.resourceType("User", "/Users", models.SCIMSchemaUser),
+		s.resourceType("Group", "/Groups", models.SCIMSchemaGroup),
+	}
+}
+
+func (s *scimService) resourceType(name, endpoint, schema string) map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":     []string{models.SCIMSchemaResourceType},
+		"id":          name,
+		"name":        name,
+		"endpoint":    endpoint,
+		"description": name,
+		"schema":      schema,
+		"meta": map[string]string{
+			"resourceType": "ResourceType",
+			"location":     s.baseURL + "/ResourceTypes/" + name,
+		},
+	}
+}
+
+func (s *scimService) Schemas() []map[string]interface{} {
+	userAttributes := []map[string]interface{}{
+		scimSchemaAttribute("userName", "string", true, "readWrite", "server"),
+		scimSchemaComplex("name", false,
+			scimSchemaAttribute("formatted", "string", false, "readWrite", "none"),
+			scimSchemaAttribute("givenName", "string", false, "readWrite", "none"),
+			scimSchemaAttribute("familyName", "string", false, "readWrite", "none"),
+		),
+		scimSchemaAttribute("displayName", "string", false, "readWrite", "none"),
+		scimSchemaAttribute("externalId", "string", false, "readWrite", "none"),
+		scimSchemaAttribute("active", "boolean", false, "readWrite", "none"),
+		scimSchemaAttribute("password", "string", false, "writeOnly", "none"),
+		scimSchemaComplex("emails", true,
+			scimSchemaAttribute("value", "string", true, "readWrite", "server"),
+			scimSchemaAttribute("type", "string", false, "readWrite", "none"),
+			scimSchemaAttribute("primary", "boolean", false, "readWrite", "none"),
+		),
+		scimSchemaComplex("groups", true,
+			scimSchemaAttribute("value", "string", false, "readOnly", "none"),
+			scimSchemaAttribute("$ref", "reference", false, "readOnly", "none"),
+			scimSchemaAttribute("display", "string", false, "readOnly", "none"),
+		),
+	}
+	groupAttributes := []map[string]interface{}{
+		scimSchemaAttribute("displayName", "string", true, "readWrite", "none"),
+		scimSchemaAttribute("externalId", "string", false, "readWrite", "none"),
+		scimSchemaComplex("members", true,
+			scimSchemaAttribute("value", "string", false, "immutable", "none"),
+			scimSchemaAttribute("$ref", "reference", false, "immutable", "none"),
+		),
+	}
+
+	return []map[string]interface{}{
+		s.schema(models.SCIMSchemaUser, "User", userAttributes),
+		s.schema(models.SCIMSchemaGroup, "Group", groupAttributes),
+	}
+}
+
+func (s *scimService) schema(id, name string, attributes []map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":    []string{models.SCIMSchemaSchema},
+		"id":         id,
+		"name":       name,
+		"attributes": attributes,
+		"meta": map[string]string{
+			"resourceType": "Schema",
+			"location":     s.baseURL + "/Schemas/" + id,
+		},
+	}
+}
+
+func scimSchemaAttribute(name, typ string, required bool, mutability, uniqueness string) map[string]interface{} {
+	returned := "default"
+	if mutability == "writeOnly" {
+		returned = "never"
+	}
+	return map[string]interface{}{
+		"name":        name,
+		"type":        typ,
+		"multiValued": false,
+		"required":    required,
+		"caseExact":   false,
+		"mutability":  mutability,
+		"returned":    returned,
+		"uniqueness":  uniqueness,
+	}
+}
+
+func scimSchemaComplex(name string, multiValued bool, subAttributes ...map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"name":          name,
+		"type":          "complex",
+		"multiValued":   multiValued,
+		"required":      false,
+		"mutability":    "readWrite",
+		"returned":      "default",
+		"subAttributes": subAttributes,
+	}
+}
@@ -0,0 +1,830 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/logger"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/repository"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrSCIMNotFound      = errors.New("resource not found")
+	ErrSCIMInvalidFilter = errors.New("invalid filter")
+	ErrSCIMInvalidPath   = errors.New("invalid path")
+	ErrSCIMInvalidValue  = errors.New("invalid value")
+	ErrSCIMUniqueness    = errors.New("userName is already in use")
+)
+
+// scimUserAttributes are the user attributes SCIM clients can filter on
+var scimUserAttributes = map[string]scimAttribute{
+	"id":                {field: "_id", kind: scimID},
+	"username":          {field: "email", lowercase: true},
+	"emails":            {field: "email", lowercase: true},
+	"emails.value":      {field: "email", lowercase: true},
+	"externalid":        {field: "external_id", caseExact: true},
+	"displayname":       {field: "name"},
+	"name.formatted":    {field: "name"},
+	"name.givenname":    {field: "given_name"},
+	"name.familyname":   {field: "family_name"},
+	"active":            {field: "disabled", kind: scimBoolean, inverted: true},
+	"meta.created":      {field: "created_at", kind: scimDateTime},
+	"meta.lastmodified": {field: "updated_at", kind: scimDateTime},
+}
+
+// scimGroupAttributes are the group attributes SCIM clients can filter on
+var scimGroupAttributes = map[string]scimAttribute{
+	"id":                {field: "_id", kind: scimID},
+	"displayname":       {field: "display_name"},
+	"externalid":        {field: "external_id", caseExact: true},
+	"members":           {field: "members", kind: scimIDList},
+	"members.value":     {field: "members", kind: scimIDList},
+	"meta.created":      {field: "created_at", kind: scimDateTime},
+	"meta.lastmodified": {field: "updated_at", kind: scimDateTime},
+}
+
+// SCIMService implements SCIM 2.0 provisioning of users and groups. SCIM
+// users are regular users whose userName is their email; groups are stored
+// in the groups collection.
+type SCIMService interface {
+	ListUsers(ctx context.Context, query *models.SCIMListQuery) (*models.SCIMListResponse, error)
+	GetUser(ctx context.Context, id string) (*models.SCIMUser, error)
+	CreateUser(ctx context.Context, req *models.SCIMUser) (*models.SCIMUser, error)
+	ReplaceUser(ctx context.Context, id string, req *models.SCIMUser) (*models.SCIMUser, error)
+	PatchUser(ctx context.Context, id string, req *models.SCIMPatchRequest) (*models.SCIMUser, error)
+	DeleteUser(ctx context.Context, id string) error
+
+	ListGroups(ctx context.Context, query *models.SCIMListQuery, withMembers bool) (*models.SCIMListResponse, error)
+	GetGroup(ctx context.Context, id string) (*models.SCIMGroup, error)
+	CreateGroup(ctx context.Context, req *models.SCIMGroup) (*models.SCIMGroup, error)
+	ReplaceGroup(ctx context.Context, id string, req *models.SCIMGroup) (*models.SCIMGroup, error)
+	PatchGroup(ctx context.Context, id string, req *models.SCIMPatchRequest) (*models.SCIMGroup, error)
+	DeleteGroup(ctx context.Context, id string) error
+
+	ServiceProviderConfig() map[string]interface{}
+	ResourceTypes() []map[string]interface{}
+	Schemas() []map[string]interface{}
+}
+
+type scimService struct {
+	users      repository.UserRepository
+	groups     repository.GroupRepository
+	refresh    RefreshTokenService
+	sessions   SessionService
+	audit      AuditService
+	baseURL    string
+	maxResults int
+}
+
+func NewSCIMService(users repository.UserRepository, groups repository.GroupRepository, refresh RefreshTokenService, sessions SessionService, audit AuditService, baseURL string, maxResults int) SCIMService {
+	return &scimService{
+		users:      users,
+		groups:     groups,
+		refresh:    refresh,
+		sessions:   sessions,
+		audit:      audit,
+		baseURL:    strings.TrimSuffix(baseURL, "/"),
+		maxResults: maxResults,
+	}
+}
+
+func (s *scimService) ListUsers(ctx context.Context, query *models.SCIMListQuery) (*models.SCIMListResponse, error) {
+	filter, skip, limit, err := s.listParams(query, scimUserAttributes)
+	if err != nil {
+		return nil, err
+	}
+
+	users, total, err := s.users.Search(ctx, filter, skip, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]*models.SCIMUser, 0, len(users))
+	for i := range users {
+		resource, err := s.userResource(ctx, &users[i])
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, resource)
+	}
+	return listResponse(total, skip, len(resources), resources), nil
+}
+
+func (s *scimService) GetUser(ctx context.Context, id string) (*models.SCIMUser, error) {
+	user, err := s.findUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.userResource(ctx, user)
+}
+
+func (s *scimService) CreateUser(ctx context.Context, req *models.SCIMUser) (*models.SCIMUser, error) {
+	user := &models.User{
+		EmailVerified: true, // the provisioning system vouches for the address
+		Roles:         []string{models.RoleUser},
+	}
+	if err := applySCIMUser(user, req); err != nil {
+		return nil, err
+	}
+	if req.Password != "" {
+		hash, err := HashPassword(req.Password)
+		if err != nil {
+			return nil, err
+		}
+		user.PasswordHash = hash
+	}
+
+	if err := s.users.Create(ctx, user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrSCIMUniqueness
+		}
+		return nil, err
+	}
+
+	s.audit.Record(ctx, models.AuditUserCreate, models.AuditTargetUser, user.ID.Hex(), nil, user)
+	logger.Log.Info("SCIM user created", zap.String("user_id", user.ID.Hex()))
+	return s.userResource(ctx, user)
+}
+
+func (s *scimService) ReplaceUser(ctx context.Context, id string, req *models.SCIMUser) (*models.SCIMUser, error) {
+	user, err := s.findUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := *user
+	updated.GivenName, updated.FamilyName, updated.ExternalID = "", "", ""
+	if err := applySCIMUser(&updated, req); err != nil {
+		return nil, err
+	}
+	return s.saveUser(ctx, user, &updated, req.Password)
+}
+
+func (s *scimService) PatchUser(ctx context.Context, id string, req *models.SCIMPatchRequest) (*models.SCIMUser, error) {
+	user, err := s.findUser(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	updated := *user
+	patch := &scimUserPatch{user: &updated}
+	for _, op := range req.Operations {
+		if err := patch.apply(op); err != nil {
+			return nil, err
+		}
+	}
+	patch.finish()
+	return s.saveUser(ctx, user, &updated, patch.password)
+}
+
+// saveUser writes a replaced or patched user. Deactivating a user or setting
+// their password signs them out everywhere, as a password change does.
+func (s *scimService) saveUser(ctx context.Context, before, user *models.User, password string) (*models.SCIMUser, error) {
+	userID := user.ID.Hex()
+	if err := s.users.ReplaceProfile(ctx, userID, user); err != nil {
+		if mongo.IsDuplicateKeyError(err) {
+			return nil, ErrSCIMUniqueness
+		}
+		return nil, err
+	}
+
+	if password != "" {
+		hash, err := HashPassword(password)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.users.UpdatePassword(ctx, userID, hash); err != nil {
+			return nil, err
+		}
+		user.PasswordHash = hash
+	}
+	s.audit.Record(ctx, models.AuditUserUpdate, models.AuditTargetUser, userID, before, user)
+
+	deactivated := user.Disabled && !before.Disabled
+	if deactivated || password != "" {
+		if err := s.signOut(ctx, userID); err != nil {
+			return nil, err
+		}
+	}
+	if deactivated {
+		logger.Log.Info("SCIM user deactivated", zap.String("user_id", userID))
+	}
+
+	// Invalidate cache
+	database.RedisClient.Del(ctx, "user:"+userID)
+	return s.userResource(ctx, user)
+}
+
+func (s *scimService) DeleteUser(ctx context.Context, id string) error {
+	user, err := s.findUser(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.users.Delete(ctx, id); err != nil {
+		return err
+	}
+	if err := s.groups.RemoveMemberFromAll(ctx, user.ID); err != nil {
+		return err
+	}
+	if err := s.signOut(ctx, id); err != nil {
+		return err
+	}
+
+	// Invalidate cache
+	database.RedisClient.Del(ctx, "user:"+id)
+
+	s.audit.Record(ctx, models.AuditUserDelete, models.AuditTargetUser, id, user, nil)
+	logger.Log.Info("SCIM user deleted", zap.String("user_id", id))
+	return nil
+}
+
+func (s *scimService) signOut(ctx context.Context, userID string) error {
+	if err := s.refresh.RevokeAllForUser(ctx, userID); err != nil {
+		return err
+	}
+	return s.sessions.RevokeAllForUser(ctx, userID)
+}
+
+func (s *scimService) findUser(ctx context.Context, id string) (*models.User, error) {
+	if _, err := primitive.ObjectIDFromHex(id); err != nil {
+		return nil, ErrSCIMNotFound
+	}
+	user, err := s.users.FindByID(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrSCIMNotFound
+	}
+	return user, err
+}
+
+func (s *scimService) userResource(ctx context.Context, user *models.User) (*models.SCIMUser, error) {
+	groups, err := s.groups.FindByMember(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	id := user.ID.Hex()
+	active := !user.Disabled
+	resource := &models.SCIMUser{
+		Schemas:     []string{models.SCIMSchemaUser},
+		ID:          id,
+		ExternalID:  user.ExternalID,
+		UserName:    user.Email,
+		DisplayName: user.Name,
+		Name: &models.SCIMName{
+			Formatted:  user.Name,
+			GivenName:  user.GivenName,
+			FamilyName: user.FamilyName,
+		},
+		Emails: []models.SCIMEmail{{Value: user.Email, Type: "work", Primary: true}},
+		Active: &active,
+		Meta: &models.SCIMMeta{
+			ResourceType: "User",
+			Created:      &user.CreatedAt,
+			LastModified: &user.UpdatedAt,
+			Location:     s.baseURL + "/Users/" + id,
+		},
+	}
+	for _, group := range groups {
+		groupID := group.ID.Hex()
+		resource.Groups = append(resource.Groups, models.SCIMMember{
+			Value:   groupID,
+			Ref:     s.baseURL + "/Groups/" + groupID,
+			Display: group.DisplayName,
+		})
+	}
+	return resource, nil
+}
+
+// applySCIMUser copies a full SCIM user representation onto user
+func applySCIMUser(user *models.User, req *models.SCIMUser) error {
+	email := req.UserName
+	if email == "" {
+		for _, e := range req.Emails {
+			if e.Primary || email == "" {
+				email = e.Value
+			}
+		}
+	}
+	if err := setSCIMUserName(user, email); err != nil {
+		return err
+	}
+
+	user.ExternalID = req.ExternalID
+	user.Name = req.DisplayName
+	if req.Name != nil {
+		user.GivenName = req.Name.GivenName
+		user.FamilyName = req.Name.FamilyName
+		if user.Name == "" {
+			user.Name = req.Name.Formatted
+		}
+	}
+	if user.Name == "" {
+		user.Name = scimFormattedName(user)
+	}
+	user.Disabled = req.Active != nil && !*req.Active
+	return nil
+}
+
+func setSCIMUserName(user *models.User, userName string) error {
+	email := NormalizeEmail(userName)
+	if !strings.Contains(email, "@") {
+		return fmt.Errorf("%w: userName must be an email address", ErrSCIMInvalidValue)
+	}
+	user.Email = email
+	return nil
+}
+
+// scimFormattedName derives a display name when the client did not send one
+func scimFormattedName(user *models.User) string {
+	if name := strings.TrimSpace(user.GivenName + " " + user.FamilyName); name != "" {
+		return name
+	}
+	name, _, _ := strings.Cut(user.Email, "@")
+	return name
+}
+
+// scimUserPatch applies PATCH operations to a user in memory
+type scimUserPatch struct {
+	user         *models.User
+	password     string
+	nameChanged  bool // givenName or familyName changed
+	nameExplicit bool // displayName or name.formatted was set
+}
+
+func (p *scimUserPatch) apply(op models.SCIMPatchOperation) error {
+	kind := strings.ToLower(op.Op)
+	if kind != "add" && kind != "replace" && kind != "remove" {
+		return fmt.Errorf("%w: unknown op %q", ErrSCIMInvalidValue, op.Op)
+	}
+
+	// Without a path the value is an object of attribute paths and values
+	if op.Path == "" {
+		if kind == "remove" {
+			return fmt.Errorf("%w: remove requires a path", ErrSCIMInvalidPath)
+		}
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &values); err != nil {
+			return fmt.Errorf("%w: value must be an object when path is omitted", ErrSCIMInvalidValue)
+		}
+		for attr, value := range values {
+			if err := p.apply(models.SCIMPatchOperation{Op: kind, Path: attr, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	path, err := parseSCIMPath(op.Path)
+	if err != nil {
+		return err
+	}
+	remove := kind == "remove"
+	user := p.user
+
+	switch path.attr {
+	case "active":
+		if remove {
+			return fmt.Errorf("%w: active cannot be removed", ErrSCIMInvalidValue)
+		}
+		active, err := scimBool(op.Value)
+		if err != nil {
+			return err
+		}
+		user.Disabled = !active
+	case "username":
+		if remove {
+			return fmt.Errorf("%w: userName cannot be removed", ErrSCIMInvalidValue)
+		}
+		value, err := scimStringValue(op.Value)
+		if err != nil {
+			return err
+		}
+		return setSCIMUserName(user, value)
+	case "emails":
+		if remove {
+			return fmt.Errorf("%w: the email cannot be removed", ErrSCIMInvalidValue)
+		}
+		if path.sub == "value" {
+			value, err := scimStringValue(op.Value)
+			if err != nil {
+				return err
+			}
+			return setSCIMUserName(user, value)
+		}
+		var emails []models.SCIMEmail
+		if err := json.Unmarshal(op.Value, &emails); err != nil || len(emails) == 0 {
+			return fmt.Errorf("%w: emails must be a non-empty list", ErrSCIMInvalidValue)
+		}
+		email := emails[0].Value
+		for _, e := range emails {
+			if e.Primary {
+				email = e.Value
+			}
+		}
+		return setSCIMUserName(user, email)
+	case "displayname":
+		value, err := scimOptionalString(op.Value, remove)
+		if err != nil {
+			return err
+		}
+		user.Name = value
+		p.nameExplicit = value != ""
+	case "externalid":
+		value, err := scimOptionalString(op.Value, remove)
+		if err != nil {
+			return err
+		}
+		user.ExternalID = value
+	case "name":
+		return p.applyName(path.sub, op.Value, remove)
+	case "password":
+		if remove {
+			return fmt.Errorf("%w: password cannot be removed", ErrSCIMInvalidValue)
+		}
+		value, err := scimStringValue(op.Value)
+		if err != nil {
+			return err
+		}
+		p.password = value
+	default:
+		return fmt.Errorf("%w: %q is not supported", ErrSCIMInvalidPath, op.Path)
+	}
+	return nil
+}
+
+func (p *scimUserPatch) applyName(sub string, raw json.RawMessage, remove bool) error {
+	user := p.user
+	if sub == "" {
+		var name models.SCIMName
+		if !remove {
+			if err := json.Unmarshal(raw, &name); err != nil {
+				return fmt.Errorf("%w: name must be an object", ErrSCIMInvalidValue)
+			}
+		}
+		user.GivenName, user.FamilyName = name.GivenName, name.FamilyName
+		p.nameChanged = true
+		if name.Formatted != "" {
+			user.Name = name.Formatted
+			p.nameExplicit = true
+		}
+		return nil
+	}
+
+	value, err := scimOptionalString(raw, remove)
+	if err != nil {
+		return err
+	}
+	switch sub {
+	case "givenname":
+		user.GivenName = value
+		p.nameChanged = true
+	case "familyname":
+		user.FamilyName = value
+		p.nameChanged = true
+	case "formatted":
+		user.Name = value
+		p.nameExplicit = value != ""
+	default:
+		return fmt.Errorf("%w: name.%s is not supported", ErrSCIMInvalidPath, sub)
+	}
+	return nil
+}
+
+// finish keeps the display name in step with the name parts when the
+// client changed only the parts
+func (p *scimUserPatch) finish() {
+	if (p.nameChanged && !p.nameExplicit) || p.user.Name == "" {
+		p.user.Name = scimFormattedName(p.user)
+	}
+}
+
+func (s *scimService) ListGroups(ctx context.Context, query *models.SCIMListQuery, withMembers bool) (*models.SCIMListResponse, error) {
+	filter, skip, limit, err := s.listParams(query, scimGroupAttributes)
+	if err != nil {
+		return nil, err
+	}
+
+	groups, total, err := s.groups.Search(ctx, filter, skip, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	resources := make([]*models.SCIMGroup, 0, len(groups))
+	for i := range groups {
+		resource := s.groupResource(&groups[i])
+		if !withMembers {
+			resource.Members = nil
+		}
+		resources = append(resources, resource)
+	}
+	return listResponse(total, skip, len(resources), resources), nil
+}
+
+func (s *scimService) GetGroup(ctx context.Context, id string) (*models.SCIMGroup, error) {
+	group, err := s.findGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.groupResource(group), nil
+}
+
+func (s *scimService) CreateGroup(ctx context.Context, req *models.SCIMGroup) (*models.SCIMGroup, error) {
+	if req.DisplayName == "" {
+		return nil, fmt.Errorf("%w: displayName is required", ErrSCIMInvalidValue)
+	}
+	members, err := s.memberIDs(ctx, req.Members)
+	if err != nil {
+		return nil, err
+	}
+
+	group := &models.Group{DisplayName: req.DisplayName, ExternalID: req.ExternalID, Members: members}
+	if err := s.groups.Create(ctx, group); err != nil {
+		return nil, err
+	}
+
+	logger.Log.Info("SCIM group created", zap.String("group_id", group.ID.Hex()))
+	return s.groupResource(group), nil
+}
+
+func (s *scimService) ReplaceGroup(ctx context.Context, id string, req *models.SCIMGroup) (*models.SCIMGroup, error) {
+	if req.DisplayName == "" {
+		return nil, fmt.Errorf("%w: displayName is required", ErrSCIMInvalidValue)
+	}
+	group, err := s.findGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	members, err := s.memberIDs(ctx, req.Members)
+	if err != nil {
+		return nil, err
+	}
+
+	group.DisplayName, group.ExternalID, group.Members = req.DisplayName, req.ExternalID, members
+	if err := s.groups.Update(ctx, id, group); err != nil {
+		return nil, err
+	}
+	return s.groupResource(group), nil
+}
+
+// PatchGroup applies member changes with atomic adds and removes so that
+// concurrent patches of a large group do not overwrite each other
+func (s *scimService) PatchGroup(ctx context.Context, id string, req *models.SCIMPatchRequest) (*models.SCIMGroup, error) {
+	group, err := s.findGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, op := range req.Operations {
+		if err := s.patchGroup(ctx, group, op); err != nil {
+			return nil, err
+		}
+	}
+
+	group, err = s.findGroup(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.groupResource(group), nil
+}
+
+func (s *scimService) patchGroup(ctx context.Context, group *models.Group, op models.SCIMPatchOperation) error {
+	kind := strings.ToLower(op.Op)
+	if kind != "add" && kind != "replace" && kind != "remove" {
+		return fmt.Errorf("%w: unknown op %q", ErrSCIMInvalidValue, op.Op)
+	}
+	id := group.ID.Hex()
+
+	if op.Path == "" {
+		if kind == "remove" {
+			return fmt.Errorf("%w: remove requires a path", ErrSCIMInvalidPath)
+		}
+		var values map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &values); err != nil {
+			return fmt.Errorf("%w: value must be an object when path is omitted", ErrSCIMInvalidValue)
+		}
+		for attr, value := range values {
+			if err := s.patchGroup(ctx, group, models.SCIMPatchOperation{Op: kind, Path: attr, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	path, err := parseSCIMPath(op.Path)
+	if err != nil {
+		return err
+	}
+
+	switch path.attr {
+	case "displayname", "externalid":
+		value, err := scimOptionalString(op.Value, kind == "remove")
+		if err != nil {
+			return err
+		}
+		if path.attr == "displayname" {
+			if value == "" {
+				return fmt.Errorf("%w: displayName is required", ErrSCIMInvalidValue)
+			}
+			group.DisplayName = value
+		} else {
+			group.ExternalID = value
+		}
+		return s.groups.Update(ctx, id, group)
+
+	case "members":
+		if path.filter != nil {
+			// members[value eq "..."] selects the members to remove
+			values, ok := scimFilterValues(path.filter, "value")
+			if !ok || kind != "remove" {
+				return fmt.Errorf("%w: only remove with members[value eq \"id\"] is supported", ErrSCIMInvalidPath)
+			}
+			return s.groups.RemoveMembers(ctx, id, scimObjectIDs(values))
+		}
+
+		var refs []models.SCIMMember
+		if len(op.Value) > 0 && string(op.Value) != "null" {
+			if err := json.Unmarshal(op.Value, &refs); err != nil {
+				return fmt.Errorf("%w: members must be a list", ErrSCIMInvalidValue)
+			}
+		}
+
+		switch kind {
+		case "add":
+			members, err := s.memberIDs(ctx, refs)
+			if err != nil {
+				return err
+			}
+			return s.groups.AddMembers(ctx, id, members)
+		case "remove":
+			if len(refs) == 0 {
+				group.Members = nil
+				return s.groups.Update(ctx, id, group)
+			}
+			values := make([]string, 0, len(refs))
+			for _, ref := range refs {
+				values = append(values, ref.Value)
+			}
+			return s.groups.RemoveMembers(ctx, id, scimObjectIDs(values))
+		default:
+			members, err := s.memberIDs(ctx, refs)
+			if err != nil {
+				return err
+			}
+			group.Members = members
+			return s.groups.Update(ctx, id, group)
+		}
+	}
+
+	return fmt.Errorf("%w: %q is not supported", ErrSCIMInvalidPath, op.Path)
+}
+
+func (s *scimService) DeleteGroup(ctx context.Context, id string) error {
+	err := s.groups.Delete(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return ErrSCIMNotFound
+	}
+	if err == nil {
+		logger.Log.Info("SCIM group deleted", zap.String("group_id", id))
+	}
+	return err
+}
+
+func (s *scimService) findGroup(ctx context.Context, id string) (*models.Group, error) {
+	group, err := s.groups.FindByID(ctx, id)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrSCIMNotFound
+	}
+	return group, err
+}
+
+// memberIDs resolves member references, which must all be existing users
+func (s *scimService) memberIDs(ctx context.Context, refs []models.SCIMMember) ([]primitive.ObjectID, error) {
+	ids := make([]primitive.ObjectID, 0, len(refs))
+	seen := make(map[primitive.ObjectID]bool, len(refs))
+	for _, ref := range refs {
+		if _, err := s.findUser(ctx, ref.Value); err != nil {
+			if errors.Is(err, ErrSCIMNotFound) {
+				return nil, fmt.Errorf("%w: member %q is not a user", ErrSCIMInvalidValue, ref.Value)
+			}
+			return nil, err
+		}
+		id, _ := primitive.ObjectIDFromHex(ref.Value)
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func (s *scimService) groupResource(group *models.Group) *models.SCIMGroup {
+	id := group.ID.Hex()
+	resource := &models.SCIMGroup{
+		Schemas:     []string{models.SCIMSchemaGroup},
+		ID:          id,
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+		Members:     make([]models.SCIMMember, 0, len(group.Members)),
+		Meta: &models.SCIMMeta{
+			ResourceType: "Group",
+			Created:      &group.CreatedAt,
+			LastModified: &group.UpdatedAt,
+			Location:     s.baseURL + "/Groups/" + id,
+		},
+	}
+	for _, member := range group.Members {
+		memberID := member.Hex()
+		resource.Members = append(resource.Members, models.SCIMMember{Value: memberID, Ref: s.baseURL + "/Users/" + memberID})
+	}
+	return resource
+}
+
+// listParams turns a list query into a Mongo filter and page. startIndex is
+// 1-based and count is capped at scim.max_results.
+func (s *scimService) listParams(query *models.SCIMListQuery, attrs map[string]scimAttribute) (bson.M, int64, int64, error) {
+	filter := bson.M{}
+	if strings.TrimSpace(query.Filter) != "" {
+		parsed, err := parseSCIMFilter(query.Filter)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		if filter, err = scimFilterBSON(parsed, attrs); err != nil {
+			return nil, 0, 0, err
+		}
+	}
+
+	skip := int64(0)
+	if query.StartIndex > 1 {
+		skip = int64(query.StartIndex - 1)
+	}
+	limit := int64(s.maxResults)
+	if query.Count != nil && *query.Count < s.maxResults {
+		limit = int64(max(*query.Count, 0))
+	}
+	return filter, skip, limit, nil
+}
+
+func listResponse(total, skip int64, count int, resources interface{}) *models.SCIMListResponse {
+	return &models.SCIMListResponse{
+		Schemas:      []string{models.SCIMSchemaListResponse},
+		TotalResults: total,
+		StartIndex:   int(skip) + 1,
+		ItemsPerPage: count,
+		Resources:    resources,
+	}
+}
+
+func scimObjectIDs(values []string) []primitive.ObjectID {
+	ids := make([]primitive.ObjectID, 0, len(values))
+	for _, value := range values {
+		if id, err := primitive.ObjectIDFromHex(value); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func scimStringValue(raw json.RawMessage) (string, error) {
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return "", fmt.Errorf("%w: expected a string", ErrSCIMInvalidValue)
+	}
+	return value, nil
+}
+
+func scimOptionalString(raw json.RawMessage, remove bool) (string, error) {
+	if remove {
+		return "", nil
+	}
+	return scimStringValue(raw)
+}
+
+// scimBool also accepts "True" and "False" strings, which some clients send
+func scimBool(raw json.RawMessage) (bool, error) {
+	var value interface{}
+	if err := json.Unmarshal(raw, &value); err == nil {
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			switch strings.ToLower(v) {
+			case "true":
+				return true, nil
+			case "false":
+				return false, nil
+			}
+		}
+	}
+	return false, fmt.Errorf("%w: expected a boolean", ErrSCIMInvalidValue)
+}
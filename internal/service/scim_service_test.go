@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/repository"
+	"gin-mongo-aws/internal/tenant"
+	"gin-mongo-aws/internal/testutil"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// noGroups is a group repository in which nobody is a member of anything
+type noGroups struct {
+	repository.GroupRepository
+}
+
+func (noGroups) FindByMember(ctx context.Context, userID primitive.ObjectID) ([]models.Group, error) {
+	return nil, nil
+}
+
+func TestSCIMPasswordChangeSignsTheUserOut(t *testing.T) {
+	testutil.StartRedis(t)
+	users := testutil.NewUserRepository()
+	sessions := NewSessionService(config.SessionConfig{IdleTTL: time.Hour, AbsoluteTTL: time.Hour})
+	refresh := NewRefreshTokenService(time.Hour)
+	svc := NewSCIMService(users, noGroups{}, refresh, sessions, &fakeAuditService{}, "https://auth.example.com", 100)
+
+	ctx := tenant.WithOrgID(context.Background(), primitive.NewObjectID())
+	user := &models.User{Name: "Member", Email: "member@example.com"}
+	if err := users.Create(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+	id := user.ID.Hex()
+
+	password, _ := json.Marshal("correct horse battery")
+	tests := []struct {
+		name   string
+		change func() error
+	}{
+		{name: "PATCH", change: func() error {
+			_, err := svc.PatchUser(ctx, id, &models.SCIMPatchRequest{Operations: []models.SCIMPatchOperation{
+				{Op: "replace", Path: "password", Value: password},
+			}})
+			return err
+		}},
+		{name: "PUT", change: func() error {
+			_, err := svc.ReplaceUser(ctx, id, &models.SCIMUser{UserName: user.Email, Password: "another horse battery"})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sessionToken, _, err := sessions.Create(ctx, user, ClientInfo{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, refreshToken, err := refresh.Issue(ctx, user, ClientInfo{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tt.change(); err != nil {
+				t.Fatalf("changing the password: %v", err)
+			}
+			if _, err := sessions.Get(ctx, sessionToken); err == nil {
+				t.Error("session survived the password change")
+			}
+			if _, err := refresh.Get(ctx, refreshToken); err == nil {
+				t.Error("refresh token survived the password change")
+			}
+		})
+	}
+}
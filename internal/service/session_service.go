@@ -0,0 +1,227 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+var ErrInvalidSession = errors.New("invalid or expired session")
+
+// Session is a server-side login backed by a cookie
+type Session struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	Email      string    `json:"email"`
+	Roles      []string  `json:"roles"`
+	OrgID      string    `json:"org_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+}
+
+// SessionService stores sessions in Redis. The cookie carries a random token;
+// only its hash, which doubles as the session ID, is stored.
+type SessionService interface {
+	Create(ctx context.Context, user *models.User, client ClientInfo) (string, *Session, error)
+	Get(ctx context.Context, token string) (*Session, error)
+	Revoke(ctx context.Context, token string) error
+	RevokeByID(ctx context.Context, id string) error
+	RevokeAllForUser(ctx context.Context, userID string) error
+	ListForUser(ctx context.Context, userID string) ([]Session, error)
+	CookieName() string
+}
+
+type sessionService struct {
+	cfg config.SessionConfig
+}
+
+func NewSessionService(cfg config.SessionConfig) SessionService {
+	return &sessionService{cfg: cfg}
+}
+
+func (s *sessionService) Create(ctx context.Context, user *models.User, client ClientInfo) (string, *Session, error) {
+	token, err := randomToken(32)
+	if err != nil {
+		return "", nil, err
+	}
+
+	now := time.Now()
+	session := &Session{
+		ID:         hashToken(token),
+		UserID:     user.ID.Hex(),
+		Email:      user.Email,
+		Roles:      user.Roles,
+		OrgID:      user.OrgID.Hex(),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		IP:         client.IP,
+		UserAgent:  client.UserAgent,
+	}
+
+	if err := s.save(ctx, session); err != nil {
+		return "", nil, err
+	}
+
+	userKey := sessionUserKey(session.UserID)
+	pipe := database.RedisClient.TxPipeline()
+	pipe.SAdd(ctx, userKey, session.ID)
+	pipe.Expire(ctx, userKey, s.cfg.AbsoluteTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return "", nil, err
+	}
+
+	return token, session, nil
+}
+
+// Get loads the session for a cookie token and extends its idle expiry
+func (s *sessionService) Get(ctx context.Context, token string) (*Session, error) {
+	session, err := s.load(ctx, hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+
+	session.LastSeenAt = time.Now()
+	if err := s.touch(ctx, session); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+func (s *sessionService) Revoke(ctx context.Context, token string) error {
+	return s.revoke(ctx, hashToken(token))
+}
+
+func (s *sessionService) RevokeByID(ctx context.Context, id string) error {
+	return s.revoke(ctx, id)
+}
+
+func (s *sessionService) ListForUser(ctx context.Context, userID string) ([]Session, error) {
+	ids, err := database.RedisClient.SMembers(ctx, sessionUserKey(userID)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]Session, 0, len(ids))
+	for _, id := range ids {
+		session, err := s.load(ctx, id)
+		if errors.Is(err, ErrInvalidSession) {
+			// Expired session; drop the stale reference
+			database.RedisClient.SRem(ctx, sessionUserKey(userID), id)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, *session)
+	}
+	return sessions, nil
+}
+
+func (s *sessionService) RevokeAllForUser(ctx context.Context, userID string) error {
+	ids, err := database.RedisClient.SMembers(ctx, sessionUserKey(userID)).Result()
+	if err != nil {
+		return err
+	}
+
+	keys := []string{sessionUserKey(userID)}
+	for _, id := range ids {
+		keys = append(keys, sessionKey(id))
+	}
+	return database.RedisClient.Del(ctx, keys...).Err()
+}
+
+func (s *sessionService) CookieName() string {
+	return s.cfg.CookieName
+}
+
+func (s *sessionService) revoke(ctx context.Context, id string) error {
+	session, err := s.load(ctx, id)
+	if errors.Is(err, ErrInvalidSession) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	pipe := database.RedisClient.TxPipeline()
+	pipe.Del(ctx, sessionKey(id))
+	pipe.SRem(ctx, sessionUserKey(session.UserID), id)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *sessionService) load(ctx context.Context, id string) (*Session, error) {
+	val, err := database.RedisClient.Get(ctx, sessionKey(id)).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidSession
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var session Session
+	if err := json.Unmarshal([]byte(val), &session); err != nil {
+		return nil, ErrInvalidSession
+	}
+	return &session, nil
+}
+
+// save stores the session with a sliding idle expiry capped by its absolute lifetime
+func (s *sessionService) save(ctx context.Context, session *Session) error {
+	data, ttl, err := s.encode(ctx, session)
+	if err != nil {
+		return err
+	}
+	return database.RedisClient.Set(ctx, sessionKey(session.ID), data, ttl).Err()
+}
+
+// touch stores a session loaded earlier only if it still exists, so a
+// request racing a logout cannot bring the revoked session back
+func (s *sessionService) touch(ctx context.Context, session *Session) error {
+	data, ttl, err := s.encode(ctx, session)
+	if err != nil {
+		return err
+	}
+	stored, err := database.RedisClient.SetXX(ctx, sessionKey(session.ID), data, ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !stored {
+		return ErrInvalidSession
+	}
+	return nil
+}
+
+func (s *sessionService) encode(ctx context.Context, session *Session) ([]byte, time.Duration, error) {
+	ttl := s.cfg.IdleTTL
+	if remaining := time.Until(session.CreatedAt.Add(s.cfg.AbsoluteTTL)); remaining < ttl {
+		ttl = remaining
+	}
+	if ttl <= 0 {
+		s.revoke(ctx, session.ID)
+		return nil, 0, ErrInvalidSession
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return nil, 0, err
+	}
+	return data, ttl, nil
+}
+
+func sessionKey(id string) string {
+	return "session:" + id
+}
+
+func sessionUserKey(userID string) string {
+	return "session:user:" + userID
+}
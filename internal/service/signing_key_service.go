@@ -0,0 +1,388 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/database"
+	"gin-mongo-aws/internal/logger"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/repository"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+)
+
+var (
+	ErrKeysNotManaged = errors.New("signing keys are not managed; jwt.private_key_file or HS256 is configured")
+	ErrNoSigningKey   = errors.New("no active signing key")
+	ErrUnknownKey     = errors.New("unknown or retired signing key")
+)
+
+const (
+	keyRefreshInterval = time.Minute
+	keyMinReload       = 10 * time.Second
+	rotationLockKey    = "signing-keys:rotation"
+)
+
+// SigningKeyService manages rotating token signing keys stored encrypted in
+// Mongo. New keys are published before they activate, and replaced keys keep
+// verifying tokens until they retire, so rotation never invalidates
+// outstanding tokens. Every instance caches the keys and reloads them
+// periodically.
+type SigningKeyService interface {
+	Managed() bool
+	Load(ctx context.Context) error
+	Rotate(ctx context.Context, immediate bool) (*models.SigningKey, error)
+	List(ctx context.Context) ([]models.SigningKey, error)
+	Run(ctx context.Context)
+}
+
+// managedKey is a decrypted key with its validity window
+type managedKey struct {
+	*signingKey
+	activatesAt time.Time
+	retiresAt   *time.Time
+}
+
+func (k *managedKey) usable(now time.Time) bool {
+	return k.retiresAt == nil || now.Before(*k.retiresAt)
+}
+
+type signingKeyService struct {
+	repo repository.SigningKeyRepository
+	cfg  config.JWTConfig
+	aead cipher.AEAD
+
+	mu       sync.RWMutex
+	keys     []*managedKey // sorted by activation, oldest first
+	loadedAt time.Time
+}
+
+func NewSigningKeyService(repo repository.SigningKeyRepository, cfg config.JWTConfig) (SigningKeyService, error) {
+	s := &signingKeyService{repo: repo, cfg: cfg}
+	if !s.Managed() {
+		return s, nil
+	}
+
+	kek, err := base64.StdEncoding.DecodeString(cfg.KeyEncryptionKey)
+	if err != nil || len(kek) != 32 {
+		return nil, errors.New("jwt.key_encryption_key must be 32 base64-encoded bytes when signing keys are managed")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	if s.aead, err = cipher.NewGCM(block); err != nil {
+		return nil, err
+	}
+
+	if cfg.RetirementGrace < cfg.AccessTokenTTL {
+		return nil, errors.New("jwt.retirement_grace must be at least jwt.access_token_ttl")
+	}
+	return s, nil
+}
+
+// Managed reports whether keys come from Mongo rather than from the config
+func (s *signingKeyService) Managed() bool {
+	return s.cfg.Algorithm != "HS256" && s.cfg.PrivateKeyFile == ""
+}
+
+// Load reads the usable keys and creates the first key when none is active
+func (s *signingKeyService) Load(ctx context.Context) error {
+	if !s.Managed() {
+		return nil
+	}
+	if err := s.reload(ctx); err != nil {
+		return err
+	}
+	if _, err := s.currentKey(); errors.Is(err, ErrNoSigningKey) {
+		now := time.Now()
+		_, err = s.create(ctx, now, now.Add(s.cfg.RetirementGrace))
+		return err
+	}
+	return nil
+}
+
+// Rotate publishes a key that starts signing after the activation delay, as
+// a scheduled rotation does, and schedules the retirement of every key it
+// replaces. An immediate rotation is for a key that has leaked: the new key
+// signs right away and the keys it replaces retire at once, so tokens signed
+// with them stop verifying. Verifiers that cached the JWKS reject the new
+// tokens until they fetch it again.
+func (s *signingKeyService) Rotate(ctx context.Context, immediate bool) (*models.SigningKey, error) {
+	if !s.Managed() {
+		return nil, ErrKeysNotManaged
+	}
+	now := time.Now()
+	if immediate {
+		return s.create(ctx, now, now)
+	}
+	activatesAt := now.Add(s.cfg.ActivationDelay)
+	return s.create(ctx, activatesAt, activatesAt.Add(s.cfg.RetirementGrace))
+}
+
+func (s *signingKeyService) List(ctx context.Context) ([]models.SigningKey, error) {
+	if !s.Managed() {
+		return nil, ErrKeysNotManaged
+	}
+	return s.repo.FindAll(ctx)
+}
+
+// Run reloads keys and performs scheduled rotations until ctx is done
+func (s *signingKeyService) Run(ctx context.Context) {
+	if !s.Managed() {
+		return
+	}
+
+	ticker := time.NewTicker(keyRefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.reload(ctx); err != nil {
+				logger.Log.Error("Failed to reload signing keys", zap.Error(err))
+				continue
+			}
+			if err := s.rotateIfDue(ctx); err != nil {
+				logger.Log.Error("Scheduled signing key rotation failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// rotateIfDue publishes the next key ahead of its activation once the newest
+// key is old enough. A short Redis lock keeps instances from rotating twice.
+func (s *signingKeyService) rotateIfDue(ctx context.Context) error {
+	if s.cfg.RotationInterval <= 0 {
+		return nil
+	}
+
+	s.mu.RLock()
+	var newest *managedKey
+	if len(s.keys) > 0 {
+		newest = s.keys[len(s.keys)-1]
+	}
+	s.mu.RUnlock()
+
+	if newest != nil && time.Now().Before(newest.activatesAt.Add(s.cfg.RotationInterval-s.cfg.ActivationDelay)) {
+		return nil
+	}
+
+	acquired, err := database.RedisClient.SetNX(ctx, rotationLockKey, 1, keyRefreshInterval).Result()
+	if err != nil || !acquired {
+		return err
+	}
+
+	activatesAt := time.Now().Add(s.cfg.ActivationDelay)
+	_, err = s.create(ctx, activatesAt, activatesAt.Add(s.cfg.RetirementGrace))
+	return err
+}
+
+// create stores a key that signs from activatesAt and retires the keys it
+// replaces at retireOthersAt
+func (s *signingKeyService) create(ctx context.Context, activatesAt, retireOthersAt time.Time) (*models.SigningKey, error) {
+	private, err := generateKey(s.cfg.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	jwk, err := publicJWK(private.Public(), s.cfg.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, err
+	}
+	encrypted, err := s.seal(der, jwk.Kid)
+	if err != nil {
+		return nil, err
+	}
+
+	record := &models.SigningKey{
+		Kid:          jwk.Kid,
+		Algorithm:    s.cfg.Algorithm,
+		EncryptedKey: encrypted,
+		PublicKey:    *jwk,
+		ActivatesAt:  activatesAt,
+	}
+	if err := s.repo.Create(ctx, record); err != nil {
+		return nil, err
+	}
+	if err := s.repo.ScheduleRetirement(ctx, record.Kid, retireOthersAt); err != nil {
+		return nil, err
+	}
+
+	logger.Log.Info("Signing key created",
+		zap.String("kid", record.Kid),
+		zap.String("algorithm", record.Algorithm),
+		zap.Time("activates_at", activatesAt),
+	)
+	return record, s.reload(ctx)
+}
+
+func (s *signingKeyService) reload(ctx context.Context) error {
+	records, err := s.repo.FindUsable(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	keys := make([]*managedKey, 0, len(records))
+	for i := range records {
+		key, err := s.decrypt(&records[i])
+		if err != nil {
+			logger.Log.Error("Skipping unreadable signing key", zap.String("kid", records[i].Kid), zap.Error(err))
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.loadedAt = time.Now()
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *signingKeyService) currentKey() (*signingKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		if k := s.keys[i]; !k.activatesAt.After(now) && k.usable(now) {
+			return k.signingKey, nil
+		}
+	}
+	return nil, ErrNoSigningKey
+}
+
+// verificationKey finds a usable key by kid, reloading once when the kid is
+// unknown because another instance may have just rotated
+func (s *signingKeyService) verificationKey(kid string) (*signingKey, error) {
+	if key := s.lookup(kid); key != nil {
+		return key, nil
+	}
+
+	s.mu.RLock()
+	stale := time.Since(s.loadedAt) > keyMinReload
+	s.mu.RUnlock()
+	if stale {
+		if err := s.reload(context.Background()); err != nil {
+			return nil, err
+		}
+		if key := s.lookup(kid); key != nil {
+			return key, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+func (s *signingKeyService) lookup(kid string) *signingKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	for _, k := range s.keys {
+		if k.kid == kid && k.usable(now) {
+			return k.signingKey
+		}
+	}
+	return nil
+}
+
+// publicKeys includes keys that are not active yet so clients can cache them
+// before the first token signed with them appears
+func (s *signingKeyService) publicKeys() []models.JSONWebKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+	keys := make([]models.JSONWebKey, 0, len(s.keys))
+	for _, k := range s.keys {
+		if k.usable(now) {
+			keys = append(keys, *k.jwk)
+		}
+	}
+	return keys
+}
+
+// seal encrypts a private key, binding the ciphertext to its kid
+func (s *signingKeyService) seal(plaintext []byte, kid string) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, plaintext, []byte(kid)), nil
+}
+
+func (s *signingKeyService) decrypt(record *models.SigningKey) (*managedKey, error) {
+	size := s.aead.NonceSize()
+	if len(record.EncryptedKey) < size {
+		return nil, errors.New("encrypted key is truncated")
+	}
+	der, err := s.aead.Open(nil, record.EncryptedKey[:size], record.EncryptedKey[size:], []byte(record.Kid))
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	private, ok := parsed.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", parsed)
+	}
+
+	method := jwt.GetSigningMethod(record.Algorithm)
+	if method == nil {
+		return nil, fmt.Errorf("unsupported algorithm %q", record.Algorithm)
+	}
+
+	jwk := record.PublicKey
+	return &managedKey{
+		signingKey: &signingKey{
+			kid:     record.Kid,
+			method:  method,
+			private: private,
+			public:  private.Public(),
+			jwk:     &jwk,
+		},
+		activatesAt: record.ActivatesAt,
+		retiresAt:   record.RetiresAt,
+	}, nil
+}
+
+func generateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case "RS256":
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case "ES256":
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case "EdDSA":
+		_, private, err := ed25519.GenerateKey(rand.Reader)
+		return private, err
+	default:
+		return nil, fmt.Errorf("cannot generate keys for jwt.algorithm %q", algorithm)
+	}
+}
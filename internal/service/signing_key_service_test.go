@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/models"
+)
+
+// fakeSigningKeyRepository keeps signing keys in memory like the Mongo repository
+type fakeSigningKeyRepository struct {
+	mu   sync.Mutex
+	keys []*models.SigningKey
+}
+
+func (r *fakeSigningKeyRepository) Create(ctx context.Context, key *models.SigningKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key.CreatedAt = time.Now()
+	stored := *key
+	r.keys = append(r.keys, &stored)
+	return nil
+}
+
+func (r *fakeSigningKeyRepository) FindAll(ctx context.Context) ([]models.SigningKey, error) {
+	return r.find(func(*models.SigningKey) bool { return true }), nil
+}
+
+func (r *fakeSigningKeyRepository) FindUsable(ctx context.Context, now time.Time) ([]models.SigningKey, error) {
+	return r.find(func(key *models.SigningKey) bool { return key.Usable(now) }), nil
+}
+
+func (r *fakeSigningKeyRepository) ScheduleRetirement(ctx context.Context, exceptKid string, retiresAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range r.keys {
+		if key.Kid != exceptKid && (key.RetiresAt == nil || key.RetiresAt.After(retiresAt)) {
+			at := retiresAt
+			key.RetiresAt = &at
+		}
+	}
+	return nil
+}
+
+func (r *fakeSigningKeyRepository) find(match func(*models.SigningKey) bool) []models.SigningKey {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := []models.SigningKey{}
+	for _, key := range r.keys {
+		if match(key) {
+			keys = append(keys, *key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ActivatesAt.Before(keys[j].ActivatesAt) })
+	return keys
+}
+
+func TestImmediateRotationRetiresReplacedKeys(t *testing.T) {
+	svc, err := NewSigningKeyService(&fakeSigningKeyRepository{}, config.JWTConfig{
+		Algorithm:        "ES256",
+		KeyEncryptionKey: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))),
+		AccessTokenTTL:   time.Minute,
+		ActivationDelay:  time.Hour,
+		RetirementGrace:  time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := svc.(*signingKeyService)
+	if err := keys.Load(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	leaked, err := keys.currentKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A forced rotation keeps the current key verifying through the grace
+	scheduled, err := keys.Rotate(context.Background(), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.verificationKey(leaked.kid); err != nil {
+		t.Fatalf("replaced key during its grace: %v", err)
+	}
+
+	replacement, err := keys.Rotate(context.Background(), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := keys.currentKey()
+	if err != nil || current.kid != replacement.Kid {
+		t.Fatalf("signing with %v (err %v), want %s", current, err, replacement.Kid)
+	}
+	for _, kid := range []string{leaked.kid, scheduled.Kid} {
+		if _, err := keys.verificationKey(kid); !errors.Is(err, ErrUnknownKey) {
+			t.Errorf("key %s: err = %v, want ErrUnknownKey", kid, err)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"gin-mongo-aws/internal/database"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const revokedTokensKey = "token:revoked"
+
+// RevokedToken is an entry of the access token denylist
+type RevokedToken struct {
+	JTI       string    `json:"jti"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// TokenDenylist records access tokens revoked before their expiry. Each JTI
+// has its own Redis key that expires exactly when the token would have, plus
+// an entry in its organization's sorted set scored by expiry so the list can
+// be published to that organization's resource servers.
+type TokenDenylist interface {
+	Revoke(ctx context.Context, orgID, jti string, expiresAt time.Time) error
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+	List(ctx context.Context, orgID string) ([]RevokedToken, error)
+}
+
+type tokenDenylist struct{}
+
+func NewTokenDenylist() TokenDenylist {
+	return &tokenDenylist{}
+}
+
+func (d *tokenDenylist) Revoke(ctx context.Context, orgID, jti string, expiresAt time.Time) error {
+	if jti == "" || !expiresAt.After(time.Now()) {
+		// Already expired tokens need no entry
+		return nil
+	}
+
+	pipe := database.RedisClient.TxPipeline()
+	pipe.SetArgs(ctx, revokedTokenKey(jti), 1, redis.SetArgs{ExpireAt: expiresAt})
+	pipe.ZAdd(ctx, revokedTokensListKey(orgID), redis.Z{Score: float64(expiresAt.Unix()), Member: jti})
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+func (d *tokenDenylist) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	if jti == "" {
+		return false, nil
+	}
+	n, err := database.RedisClient.Exists(ctx, revokedTokenKey(jti)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// List returns the revoked tokens of an organization that have not expired
+// yet, pruning the rest
+func (d *tokenDenylist) List(ctx context.Context, orgID string) ([]RevokedToken, error) {
+	key := revokedTokensListKey(orgID)
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	if err := database.RedisClient.ZRemRangeByScore(ctx, key, "-inf", "("+now).Err(); err != nil {
+		return nil, err
+	}
+
+	entries, err := database.RedisClient.ZRangeWithScores(ctx, key, 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	revoked := make([]RevokedToken, 0, len(entries))
+	for _, e := range entries {
+		jti, _ := e.Member.(string)
+		revoked = append(revoked, RevokedToken{JTI: jti, ExpiresAt: time.Unix(int64(e.Score), 0)})
+	}
+	return revoked, nil
+}
+
+// revokedTokensListKey is the sorted set of an organization's revoked tokens,
+// prefixed like tenant.Key. Tokens of no organization share the bare key.
+func revokedTokensListKey(orgID string) string {
+	if orgID == "" {
+		return revokedTokensKey
+	}
+	return orgID + ":" + revokedTokensKey
+}
+
+func revokedTokenKey(jti string) string {
+	return "token:revoked:" + jti
+}
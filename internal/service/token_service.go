@@ -0,0 +1,407 @@
+package service
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/models"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+var ErrInvalidToken = errors.New("invalid or expired token")
+
+// Claims are the claims carried by access tokens issued by this API
+type Claims struct {
+	jwt.RegisteredClaims
+	Email     string   `json:"email,omitempty"`
+	Roles     []string `json:"roles,omitempty"`
+	SessionID string   `json:"sid,omitempty"` // refresh token family or server session
+	ClientID  string   `json:"client_id,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	OrgID     string   `json:"org_id,omitempty"` // organization of the subject; unset for client tokens
+	APIKeyID  string   `json:"-"`                // set when the caller authenticated with an API key
+	Actor     *Actor   `json:"act,omitempty"`    // set when an admin is impersonating the subject
+}
+
+// Actor is the party acting on behalf of the subject (RFC 8693 act claim)
+type Actor struct {
+	Subject string `json:"sub"`
+	Email   string `json:"email,omitempty"`
+}
+
+// IDTokenClaims are the claims of an OpenID Connect ID token
+type IDTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce         string           `json:"nonce,omitempty"`
+	AuthTime      *jwt.NumericDate `json:"auth_time,omitempty"`
+	Email         string           `json:"email,omitempty"`
+	EmailVerified *bool            `json:"email_verified,omitempty"`
+	Name          string           `json:"name,omitempty"`
+}
+
+// IsOAuth reports whether the token was issued to an OAuth client rather
+// than to a first-party login
+func (c *Claims) IsOAuth() bool {
+	return c.ClientID != ""
+}
+
+// IsImpersonation reports whether the token was issued to an admin acting as
+// the subject
+func (c *Claims) IsImpersonation() bool {
+	return c.Actor != nil
+}
+
+// ScopeAllows reports whether an API key's scopes cover a permission. Other
+// callers are limited by their roles alone.
+func (c *Claims) ScopeAllows(permission string) bool {
+	if c.APIKeyID == "" {
+		return true
+	}
+	for _, scope := range strings.Fields(c.Scope) {
+		if PermissionMatches(scope, permission) {
+			return true
+		}
+	}
+	return false
+}
+
+// TokenService signs access tokens. First-party tokens carry the user's roles;
+// tokens issued to OAuth clients carry the granted scope instead, and client
+// credentials tokens have the client itself as subject.
+type TokenService interface {
+	IssueAccessToken(user *models.User, sessionID string) (string, time.Time, error)
+	IssueDelegatedToken(user *models.User, sessionID, clientID, scope string) (string, time.Time, error)
+	IssueImpersonationToken(user, actor *models.User) (*Claims, string, error)
+	IssueClientToken(clientID, scope string) (string, time.Time, error)
+	IssueIDToken(user *models.User, clientID, scope, nonce string, authTime time.Time) (string, error)
+	ParseAccessToken(ctx context.Context, token string) (*Claims, error)
+	RevokeAccessToken(ctx context.Context, claims *Claims) error
+	Algorithms() []string
+	JWKS() models.JSONWebKeySet
+}
+
+// signingKey is a key tokens are signed or verified with
+type signingKey struct {
+	kid     string
+	method  jwt.SigningMethod
+	private crypto.PrivateKey
+	public  crypto.PublicKey
+	jwk     *models.JSONWebKey // nil for HS256, whose key cannot be published
+}
+
+// keyProvider supplies the current signing key and every key that tokens may
+// still be verified with
+type keyProvider interface {
+	currentKey() (*signingKey, error)
+	verificationKey(kid string) (*signingKey, error)
+	publicKeys() []models.JSONWebKey
+}
+
+type tokenService struct {
+	cfg      config.JWTConfig
+	keys     keyProvider
+	denylist TokenDenylist
+}
+
+// NewTokenService signs with the key configured under jwt, or with the
+// rotating keys of the signing key service when it manages them. Parsed
+// tokens are checked against the denylist.
+func NewTokenService(cfg config.JWTConfig, managed SigningKeyService, denylist TokenDenylist) (TokenService, error) {
+	if managed != nil && managed.Managed() {
+		provider, ok := managed.(keyProvider)
+		if !ok {
+			return nil, errors.New("signing key service does not provide keys")
+		}
+		return &tokenService{cfg: cfg, keys: provider, denylist: denylist}, nil
+	}
+
+	key, err := loadStaticKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &tokenService{cfg: cfg, keys: &staticKeys{key: key}, denylist: denylist}, nil
+}
+
+// loadStaticKey reads the single key configured by jwt.secret or jwt.private_key_file
+func loadStaticKey(cfg config.JWTConfig) (*signingKey, error) {
+	key := &signingKey{}
+
+	switch cfg.Algorithm {
+	case "HS256":
+		if cfg.Secret == "" {
+			return nil, errors.New("jwt.secret is required for HS256")
+		}
+		key.method = jwt.SigningMethodHS256
+		key.private = []byte(cfg.Secret)
+		key.public = []byte(cfg.Secret)
+		return key, nil
+	case "RS256":
+		pemData, err := readKeyFile(cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM(pemData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse RSA private key: %w", err)
+		}
+		key.method = jwt.SigningMethodRS256
+		key.private = rsaKey
+		key.public = &rsaKey.PublicKey
+	case "ES256":
+		pemData, err := readKeyFile(cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		ecKey, err := jwt.ParseECPrivateKeyFromPEM(pemData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse EC private key: %w", err)
+		}
+		key.method = jwt.SigningMethodES256
+		key.private = ecKey
+		key.public = &ecKey.PublicKey
+	case "EdDSA":
+		pemData, err := readKeyFile(cfg.PrivateKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := jwt.ParseEdPrivateKeyFromPEM(pemData)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse Ed25519 private key: %w", err)
+		}
+		edKey, ok := parsed.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("jwt.private_key_file is not an Ed25519 key")
+		}
+		key.method = jwt.SigningMethodEdDSA
+		key.private = edKey
+		key.public = edKey.Public()
+	default:
+		return nil, fmt.Errorf("unsupported jwt.algorithm %q", cfg.Algorithm)
+	}
+
+	jwk, err := publicJWK(key.public, cfg.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	key.kid = jwk.Kid
+	key.jwk = jwk
+	return key, nil
+}
+
+// staticKeys serves the single key from the configuration file
+type staticKeys struct {
+	key *signingKey
+}
+
+func (k *staticKeys) currentKey() (*signingKey, error) {
+	return k.key, nil
+}
+
+func (k *staticKeys) verificationKey(string) (*signingKey, error) {
+	return k.key, nil
+}
+
+func (k *staticKeys) publicKeys() []models.JSONWebKey {
+	if k.key.jwk == nil {
+		return nil
+	}
+	return []models.JSONWebKey{*k.key.jwk}
+}
+
+func (s *tokenService) IssueAccessToken(user *models.User, sessionID string) (string, time.Time, error) {
+	return s.issue(&Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: user.ID.Hex()},
+		Email:            user.Email,
+		Roles:            user.Roles,
+		SessionID:        sessionID,
+		OrgID:            user.OrgID.Hex(),
+	})
+}
+
+func (s *tokenService) IssueDelegatedToken(user *models.User, sessionID, clientID, scope string) (string, time.Time, error) {
+	return s.issue(&Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: user.ID.Hex()},
+		SessionID:        sessionID,
+		ClientID:         clientID,
+		Scope:            scope,
+		OrgID:            user.OrgID.Hex(),
+	})
+}
+
+// IssueImpersonationToken signs a short-lived token for user that names actor
+// in the act claim. It has no session, so it cannot be refreshed.
+func (s *tokenService) IssueImpersonationToken(user, actor *models.User) (*Claims, string, error) {
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: user.ID.Hex()},
+		Email:            user.Email,
+		Roles:            user.Roles,
+		OrgID:            user.OrgID.Hex(),
+		Actor:            &Actor{Subject: actor.ID.Hex(), Email: actor.Email},
+	}
+	signed, _, err := s.issueWithTTL(claims, s.cfg.ImpersonationTTL)
+	if err != nil {
+		return nil, "", err
+	}
+	return claims, signed, nil
+}
+
+func (s *tokenService) IssueClientToken(clientID, scope string) (string, time.Time, error) {
+	return s.issue(&Claims{
+		RegisteredClaims: jwt.RegisteredClaims{Subject: clientID},
+		ClientID:         clientID,
+		Scope:            scope,
+	})
+}
+
+// IssueIDToken signs an OpenID Connect ID token for a client. Profile and
+// email claims are only included when the matching scope was granted.
+func (s *tokenService) IssueIDToken(user *models.User, clientID, scope, nonce string, authTime time.Time) (string, error) {
+	now := time.Now()
+	info := userInfoForScope(user, scope)
+
+	claims := IDTokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   info.Subject,
+			Issuer:    s.cfg.Issuer,
+			Audience:  jwt.ClaimStrings{clientID},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.cfg.AccessTokenTTL)),
+		},
+		Nonce:         nonce,
+		Email:         info.Email,
+		EmailVerified: info.EmailVerified,
+		Name:          info.Name,
+	}
+	if !authTime.IsZero() {
+		claims.AuthTime = jwt.NewNumericDate(authTime)
+	}
+
+	return s.sign(claims)
+}
+
+// Algorithms lists the algorithms of the keys tokens may be signed with
+func (s *tokenService) Algorithms() []string {
+	keys := s.keys.publicKeys()
+	if len(keys) == 0 {
+		return []string{s.cfg.Algorithm}
+	}
+
+	algs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		algs = append(algs, k.Alg)
+	}
+	return dedupe(algs)
+}
+
+// JWKS returns the public keys clients can verify tokens with
+func (s *tokenService) JWKS() models.JSONWebKeySet {
+	return models.JSONWebKeySet{Keys: append([]models.JSONWebKey{}, s.keys.publicKeys()...)}
+}
+
+// issue fills in the registered claims shared by every access token and signs it
+func (s *tokenService) issue(claims *Claims) (string, time.Time, error) {
+	return s.issueWithTTL(claims, s.cfg.AccessTokenTTL)
+}
+
+func (s *tokenService) issueWithTTL(claims *Claims, ttl time.Duration) (string, time.Time, error) {
+	now := time.Now()
+	expiresAt := now.Add(ttl)
+
+	claims.ID = newTokenID()
+	claims.Issuer = s.cfg.Issuer
+	claims.Audience = jwt.ClaimStrings{s.cfg.Audience}
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.NotBefore = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(expiresAt)
+
+	signed, err := s.sign(claims)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return signed, expiresAt, nil
+}
+
+func (s *tokenService) sign(claims jwt.Claims) (string, error) {
+	key, err := s.keys.currentKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.method, claims)
+	if key.kid != "" {
+		token.Header["kid"] = key.kid
+	}
+	return token.SignedString(key.private)
+}
+
+func (s *tokenService) ParseAccessToken(ctx context.Context, token string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := jwt.ParseWithClaims(token, claims, s.keyFunc,
+		jwt.WithIssuer(s.cfg.Issuer),
+		jwt.WithAudience(s.cfg.Audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	revoked, err := s.denylist.IsRevoked(ctx, claims.ID)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// RevokeAccessToken denylists a token until it expires, listing it for the
+// organization in its claims
+func (s *tokenService) RevokeAccessToken(ctx context.Context, claims *Claims) error {
+	if claims.ExpiresAt == nil {
+		return nil
+	}
+	return s.denylist.Revoke(ctx, claims.OrgID, claims.ID, claims.ExpiresAt.Time)
+}
+
+// keyFunc picks the verification key named by the kid header and rejects
+// tokens whose alg does not match that key
+func (s *tokenService) keyFunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, err := s.keys.verificationKey(kid)
+	if err != nil {
+		return nil, err
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %q", token.Method.Alg())
+	}
+	return key.public, nil
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	if path == "" {
+		return nil, errors.New("jwt.private_key_file is required for asymmetric algorithms")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	return data, nil
+}
+
+// newTokenID returns a random identifier suitable for the jti claim
+func newTokenID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
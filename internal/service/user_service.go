@@ -8,6 +8,7 @@ import (
 	"gin-mongo-aws/internal/database"
 	"gin-mongo-aws/internal/models"
 	"gin-mongo-aws/internal/repository"
+	"gin-mongo-aws/internal/tenant"
 	"gin-mongo-aws/internal/logger"
 
 	"go.uber.org/zap"
@@ -19,18 +20,41 @@ type UserService interface {
 	GetUserByID(ctx context.Context, id string) (*models.User, error)
 	UpdateUser(ctx context.Context, id string, user *models.User) error
 	DeleteUser(ctx context.Context, id string) error
+	SetUserRoles(ctx context.Context, id string, roles []string) error
+}
+
+// VerificationSender emails a user a link to verify their address
+type VerificationSender interface {
+	SendVerificationEmail(ctx context.Context, user *models.User) error
 }
 
 type userService struct {
-	repo repository.UserRepository
+	repo     repository.UserRepository
+	roles    RoleService
+	audit    AuditService
+	verify   VerificationSender
+	sessions SessionService
+	refresh  RefreshTokenService
 }
 
-func NewUserService(repo repository.UserRepository) UserService {
-	return &userService{repo: repo}
+func NewUserService(repo repository.UserRepository, roles RoleService, audit AuditService, verify VerificationSender, sessions SessionService, refresh RefreshTokenService) UserService {
+	return &userService{repo: repo, roles: roles, audit: audit, verify: verify, sessions: sessions, refresh: refresh}
 }
 
 func (s *userService) CreateUser(ctx context.Context, user *models.User) error {
-	return s.repo.Create(ctx, user)
+	// New accounts always start unverified and without a second factor
+	user.EmailVerified = false
+	user.MFAEnabled = false
+	// Roles are only granted through SetUserRoles
+	user.Roles = []string{models.RoleUser}
+	// Stored normalized, so the unique index and logins see one address
+	user.Email = NormalizeEmail(user.Email)
+	if err := s.repo.Create(ctx, user); err != nil {
+		return err
+	}
+
+	s.audit.Record(ctx, models.AuditUserCreate, models.AuditTargetUser, user.ID.Hex(), nil, user)
+	return nil
 }
 
 func (s *userService) GetAllUsers(ctx context.Context) ([]models.User, error) {
@@ -38,11 +62,16 @@ func (s *userService) GetAllUsers(ctx context.Context) ([]models.User, error) {
 }
 
 func (s *userService) GetUserByID(ctx context.Context, id string) (*models.User, error) {
-	// Try to get from cache
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Try to get from cache; the cache is shared by all organizations
 	val, err := database.RedisClient.Get(ctx, "user:"+id).Result()
 	if err == nil {
 		var user models.User
-		if err := json.Unmarshal([]byte(val), &user); err == nil {
+		if err := json.Unmarshal([]byte(val), &user); err == nil && user.OrgID == orgID {
 			logger.Log.Info("Cache hit for user", zap.String("id", id))
 			return &user, nil
 		}
@@ -61,19 +90,84 @@ func (s *userService) GetUserByID(ctx context.Context, id string) (*models.User,
 }
 
 func (s *userService) UpdateUser(ctx context.Context, id string, user *models.User) error {
-	err := s.repo.Update(ctx, id, user)
-	if err == nil {
-		// Invalidate cache
-		database.RedisClient.Del(ctx, "user:"+id)
+	before, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	// A new address has to be verified again before it counts as verified
+	user.Email = NormalizeEmail(user.Email)
+	emailChanged := user.Email != before.Email
+	user.EmailVerified = before.EmailVerified && !emailChanged
+
+	err = s.repo.Update(ctx, id, user)
+	if err != nil {
+		return err
+	}
+	// Invalidate cache
+	database.RedisClient.Del(ctx, "user:"+id)
+	s.recordChange(ctx, models.AuditUserUpdate, id, before)
+
+	if emailChanged {
+		user.ID = before.ID
+		if err := s.verify.SendVerificationEmail(ctx, user); err != nil {
+			logger.Log.Error("Failed to send verification email", zap.String("user_id", id), zap.Error(err))
+		}
+	}
+	return nil
+}
+
+func (s *userService) SetUserRoles(ctx context.Context, id string, roles []string) error {
+	roles = dedupe(roles)
+	if err := s.roles.ValidateRoles(ctx, roles); err != nil {
+		return err
+	}
+
+	before, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.SetRoles(ctx, id, roles); err != nil {
+		return err
 	}
-	return err
+	// Invalidate cache
+	database.RedisClient.Del(ctx, "user:"+id)
+	s.recordChange(ctx, models.AuditUserRoles, id, before)
+
+	// Sessions and refresh tokens would keep granting the old roles
+	return s.signOut(ctx, id)
 }
 
 func (s *userService) DeleteUser(ctx context.Context, id string) error {
-	err := s.repo.Delete(ctx, id)
-	if err == nil {
-		// Invalidate cache
-		database.RedisClient.Del(ctx, "user:"+id)
+	before, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.Delete(ctx, id); err != nil {
+		return err
+	}
+	// Invalidate cache
+	database.RedisClient.Del(ctx, "user:"+id)
+	s.audit.Record(ctx, models.AuditUserDelete, models.AuditTargetUser, id, before, nil)
+
+	return s.signOut(ctx, id)
+}
+
+func (s *userService) signOut(ctx context.Context, userID string) error {
+	if err := s.refresh.RevokeAllForUser(ctx, userID); err != nil {
+		return err
+	}
+	return s.sessions.RevokeAllForUser(ctx, userID)
+}
+
+// recordChange audits a change to a user against the stored record
+func (s *userService) recordChange(ctx context.Context, action, id string, before *models.User) {
+	after, err := s.repo.FindByID(ctx, id)
+	if err != nil {
+		logger.Log.Error("Failed to load user for audit", zap.String("user_id", id), zap.Error(err))
+		return
 	}
-	return err
+	s.audit.Record(ctx, action, models.AuditTargetUser, id, before, after)
 }
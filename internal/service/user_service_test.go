@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gin-mongo-aws/internal/config"
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/tenant"
+	"gin-mongo-aws/internal/testutil"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestUserServiceStaysInItsOrganization(t *testing.T) {
+	testutil.StartRedis(t)
+	users := testutil.NewUserRepository()
+	svc := NewUserService(users, newFakeRoleService(), &fakeAuditService{}, nil,
+		NewSessionService(config.SessionConfig{IdleTTL: time.Hour}), NewRefreshTokenService(time.Hour))
+
+	acme := tenant.WithOrgID(context.Background(), primitive.NewObjectID())
+	globex := tenant.WithOrgID(context.Background(), primitive.NewObjectID())
+	member := &models.User{Name: "Member", Email: "member@example.com"}
+	if err := svc.CreateUser(acme, member); err != nil {
+		t.Fatal(err)
+	}
+	id := member.ID.Hex()
+
+	// Cache the user, which all organizations share
+	if _, err := svc.GetUserByID(acme, id); err != nil {
+		t.Fatalf("GetUserByID: %v", err)
+	}
+	if _, err := svc.GetUserByID(globex, id); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("reading from another organization: err = %v, want ErrNoDocuments", err)
+	}
+	if err := svc.SetUserRoles(globex, id, []string{models.RoleAdmin}); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("granting roles from another organization: err = %v, want ErrNoDocuments", err)
+	}
+	if err := svc.DeleteUser(globex, id); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("deleting from another organization: err = %v, want ErrNoDocuments", err)
+	}
+
+	roles, err := users.FindRoles(acme, id)
+	if err != nil {
+		t.Fatalf("member lost their membership: %v", err)
+	}
+	if len(roles) != 1 || roles[0] != models.RoleUser {
+		t.Errorf("roles = %v, want only %s", roles, models.RoleUser)
+	}
+	if _, err := users.FindRoles(globex, id); !errors.Is(err, mongo.ErrNoDocuments) {
+		t.Errorf("member of another organization: err = %v, want ErrNoDocuments", err)
+	}
+}
+
+func TestCreateUserNormalizesEmail(t *testing.T) {
+	testutil.StartRedis(t)
+	users := testutil.NewUserRepository()
+	svc := NewUserService(users, newFakeRoleService(), &fakeAuditService{}, nil,
+		NewSessionService(config.SessionConfig{IdleTTL: time.Hour}), NewRefreshTokenService(time.Hour))
+	ctx := tenant.WithOrgID(context.Background(), primitive.NewObjectID())
+
+	first := &models.User{Name: "First", Email: " Member@Example.COM "}
+	if err := svc.CreateUser(ctx, first); err != nil {
+		t.Fatal(err)
+	}
+	if first.Email != "member@example.com" {
+		t.Errorf("stored email %q, want it normalized", first.Email)
+	}
+	if _, err := users.FindByEmail(ctx, "member@example.com"); err != nil {
+		t.Errorf("lookup by the normalized address: %v", err)
+	}
+
+	// The same address in another case is the same account
+	if err := svc.CreateUser(ctx, &models.User{Name: "Second", Email: "MEMBER@example.com"}); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("err = %v, want a duplicate key error", err)
+	}
+}
@@ -0,0 +1,64 @@
+// Package tenant carries the organization a request acts in. Repositories of
+// per-organization data scope every query to it, so code that forgets to
+// pass an organization fails instead of reading across tenants.
+package tenant
+
+import (
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrNoOrganization = errors.New("no organization in context")
+
+type orgKey struct{}
+
+// WithOrgID returns a copy of ctx that acts in the organization orgID
+func WithOrgID(ctx context.Context, orgID primitive.ObjectID) context.Context {
+	return context.WithValue(ctx, orgKey{}, orgID)
+}
+
+// OrgID returns the organization ctx acts in
+func OrgID(ctx context.Context) (primitive.ObjectID, error) {
+	orgID, ok := ctx.Value(orgKey{}).(primitive.ObjectID)
+	if !ok || orgID.IsZero() {
+		return primitive.NilObjectID, ErrNoOrganization
+	}
+	return orgID, nil
+}
+
+// Key prefixes a Redis key with the organization in ctx, so counters such as
+// login lockouts for the same email in two organizations stay apart
+func Key(ctx context.Context, key string) string {
+	orgID, err := OrgID(ctx)
+	if err != nil {
+		return key
+	}
+	return orgID.Hex() + ":" + key
+}
+
+// OrgHex returns the hex ID of the organization ctx acts in, to store with
+// state that a later request picks up, or "" when ctx acts in none
+func OrgHex(ctx context.Context) string {
+	orgID, err := OrgID(ctx)
+	if err != nil {
+		return ""
+	}
+	return orgID.Hex()
+}
+
+// WithOrgHex returns a copy of ctx that acts in the organization whose hex ID
+// was stored with state kept between requests, such as email links and
+// refresh tokens. State saved before organizations existed has no ID and
+// leaves ctx acting in the organization of the request.
+func WithOrgHex(ctx context.Context, orgID string) (context.Context, error) {
+	if orgID == "" {
+		return ctx, nil
+	}
+	id, err := primitive.ObjectIDFromHex(orgID)
+	if err != nil || id.IsZero() {
+		return ctx, ErrNoOrganization
+	}
+	return WithOrgID(ctx, id), nil
+}
@@ -0,0 +1,107 @@
+package testutil
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/tenant"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// APIKeyRepository keeps API keys in memory. Like the Mongo repository, keys
+// are kept per organization and only FindByPrefix looks across organizations.
+type APIKeyRepository struct {
+	mu   sync.Mutex
+	keys map[primitive.ObjectID]*models.APIKey
+}
+
+func NewAPIKeyRepository() *APIKeyRepository {
+	return &APIKeyRepository{keys: map[primitive.ObjectID]*models.APIKey{}}
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, key *models.APIKey) error {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key.ID = primitive.NewObjectID()
+	key.OrgID = orgID
+	key.CreatedAt = time.Now()
+	stored := *key
+	r.keys[stored.ID] = &stored
+	return nil
+}
+
+func (r *APIKeyRepository) FindByPrefix(ctx context.Context, prefix string) (*models.APIKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, key := range r.keys {
+		if key.Prefix == prefix {
+			found := *key
+			return &found, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (r *APIKeyRepository) FindByUser(ctx context.Context, userID string) ([]models.APIKey, error) {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	keys := []models.APIKey{}
+	for _, key := range r.keys {
+		if key.OrgID == orgID && key.UserID == objID {
+			keys = append(keys, *key)
+		}
+	}
+	return keys, nil
+}
+
+func (r *APIKeyRepository) Touch(ctx context.Context, id primitive.ObjectID, at time.Time, ip string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if key, ok := r.keys[id]; ok {
+		key.LastUsedAt = &at
+		key.LastUsedIP = ip
+	}
+	return nil
+}
+
+func (r *APIKeyRepository) Delete(ctx context.Context, userID, id string) error {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return err
+	}
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return mongo.ErrNoDocuments
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key, ok := r.keys[objID]
+	if !ok || key.OrgID != orgID || key.UserID != userObjID {
+		return mongo.ErrNoDocuments
+	}
+	delete(r.keys, objID)
+	return nil
+}
@@ -0,0 +1,170 @@
+package testutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/go-webauthn/webauthn/protocol"
+)
+
+// SoftAuthenticator is a platform authenticator in software: one P-256
+// passkey with user verification, counting its signatures. Tests change its
+// fields to act like a cloned key or a phishing page.
+type SoftAuthenticator struct {
+	RPID         string
+	Origin       string
+	CredentialID []byte
+	UserHandle   []byte
+	SignCount    uint32
+	key          *ecdsa.PrivateKey
+}
+
+func NewSoftAuthenticator(t testing.TB, rpID, origin string) *SoftAuthenticator {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credentialID := make([]byte, 16)
+	if _, err := rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+	return &SoftAuthenticator{RPID: rpID, Origin: origin, CredentialID: credentialID, key: key}
+}
+
+// Create answers navigator.credentials.create() with a "none" attestation.
+// options is the *protocol.CredentialCreation of a registration ceremony.
+func (a *SoftAuthenticator) Create(t testing.TB, options interface{}) json.RawMessage {
+	t.Helper()
+
+	creation := options.(*protocol.CredentialCreation)
+	a.UserHandle = userHandle(t, creation.Response.User.ID)
+
+	publicKey, err := cbor.Marshal(map[int]interface{}{
+		1:  2,  // kty: EC2
+		3:  -7, // alg: ES256
+		-1: 1,  // crv: P-256
+		-2: a.key.X.FillBytes(make([]byte, 32)),
+		-3: a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	attested := make([]byte, 16) // zero AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.CredentialID)))
+	attested = append(attested, a.CredentialID...)
+	attested = append(attested, publicKey...)
+
+	attestation, err := cbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authenticatorData(attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    encodeURL(a.clientData(t, "webauthn.create", creation.Response.Challenge)),
+		"attestationObject": encodeURL(attestation),
+	})
+}
+
+// Get answers navigator.credentials.get() with a signed assertion. options is
+// the *protocol.CredentialAssertion of a login ceremony.
+func (a *SoftAuthenticator) Get(t testing.TB, options interface{}) json.RawMessage {
+	t.Helper()
+
+	a.SignCount++
+	assertion := options.(*protocol.CredentialAssertion)
+	clientData := a.clientData(t, "webauthn.get", assertion.Response.Challenge)
+	authData := a.authenticatorData(nil)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a.credential(t, map[string]string{
+		"clientDataJSON":    encodeURL(clientData),
+		"authenticatorData": encodeURL(authData),
+		"signature":         encodeURL(signature),
+		"userHandle":        encodeURL(a.UserHandle),
+	})
+}
+
+// authenticatorData is the RP ID hash, the user present and verified flags,
+// the signature counter and, when registering, the attested credential
+func (a *SoftAuthenticator) authenticatorData(attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.RPID))
+	flags := byte(protocol.FlagUserPresent | protocol.FlagUserVerified)
+	if attested != nil {
+		flags |= byte(protocol.FlagAttestedCredentialData)
+	}
+
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.SignCount)
+	return append(data, attested...)
+}
+
+func (a *SoftAuthenticator) clientData(t testing.TB, ceremony string, challenge []byte) []byte {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]string{
+		"type":      ceremony,
+		"challenge": base64.RawURLEncoding.EncodeToString(challenge),
+		"origin":    a.Origin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func (a *SoftAuthenticator) credential(t testing.TB, response map[string]string) json.RawMessage {
+	t.Helper()
+
+	data, err := json.Marshal(map[string]interface{}{
+		"id":       encodeURL(a.CredentialID),
+		"rawId":    encodeURL(a.CredentialID),
+		"type":     "public-key",
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// userHandle is the user ID from the creation options, which is bytes when
+// the service made them and a base64url string once they went through JSON
+func userHandle(t testing.TB, id interface{}) []byte {
+	t.Helper()
+
+	switch id := id.(type) {
+	case protocol.URLEncodedBase64:
+		return id
+	case string:
+		handle, err := base64.RawURLEncoding.DecodeString(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return handle
+	}
+	t.Fatalf("unexpected user ID %T", id)
+	return nil
+}
+
+func encodeURL(data []byte) string {
+	return base64.RawURLEncoding.EncodeToString(data)
+}
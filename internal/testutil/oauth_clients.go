@@ -0,0 +1,91 @@
+package testutil
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/tenant"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// OAuthClientRepository keeps OAuth clients in memory. Like the Mongo
+// repository, clients are kept per organization and only FindByClientID
+// looks across organizations.
+type OAuthClientRepository struct {
+	mu      sync.Mutex
+	clients map[string]*models.OAuthClient
+}
+
+func NewOAuthClientRepository() *OAuthClientRepository {
+	return &OAuthClientRepository{clients: map[string]*models.OAuthClient{}}
+}
+
+func (r *OAuthClientRepository) Create(ctx context.Context, client *models.OAuthClient) error {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.clients[client.ClientID]; ok {
+		return duplicateKey
+	}
+	client.ID = primitive.NewObjectID()
+	client.OrgID = orgID
+	client.CreatedAt = time.Now()
+	client.UpdatedAt = client.CreatedAt
+	stored := *client
+	r.clients[stored.ClientID] = &stored
+	return nil
+}
+
+func (r *OAuthClientRepository) FindAll(ctx context.Context) ([]models.OAuthClient, error) {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clients := []models.OAuthClient{}
+	for _, client := range r.clients {
+		if client.OrgID == orgID {
+			clients = append(clients, *client)
+		}
+	}
+	sort.Slice(clients, func(i, j int) bool { return clients[i].CreatedAt.Before(clients[j].CreatedAt) })
+	return clients, nil
+}
+
+func (r *OAuthClientRepository) FindByClientID(ctx context.Context, clientID string) (*models.OAuthClient, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client, ok := r.clients[clientID]
+	if !ok {
+		return nil, mongo.ErrNoDocuments
+	}
+	found := *client
+	return &found, nil
+}
+
+func (r *OAuthClientRepository) Delete(ctx context.Context, clientID string) error {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client, ok := r.clients[clientID]
+	if !ok || client.OrgID != orgID {
+		return mongo.ErrNoDocuments
+	}
+	delete(r.clients, clientID)
+	return nil
+}
@@ -0,0 +1,27 @@
+// Package testutil provides in-process stand-ins for the stores the services
+// use, so their tests run without Mongo or Redis.
+package testutil
+
+import (
+	"testing"
+
+	"gin-mongo-aws/internal/database"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// StartRedis starts an empty in-memory Redis and points database.RedisClient
+// at it until the test ends. Tests move its clock with FastForward.
+func StartRedis(t testing.TB) *miniredis.Miniredis {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	previous := database.RedisClient
+	database.RedisClient = redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		database.RedisClient.Close()
+		database.RedisClient = previous
+	})
+	return server
+}
@@ -0,0 +1,313 @@
+package testutil
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/tenant"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// UserRepository keeps users in memory and scopes every call to the
+// organization in the context like the Mongo repository does, including the
+// per-organization unique emails and linked identities
+type UserRepository struct {
+	mu    sync.Mutex
+	users map[primitive.ObjectID]*models.User
+}
+
+func NewUserRepository() *UserRepository {
+	return &UserRepository{users: map[primitive.ObjectID]*models.User{}}
+}
+
+// duplicateKey is recognized by mongo.IsDuplicateKeyError
+var duplicateKey = mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "duplicate key"}}}
+
+func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.users {
+		if existing.OrgID != orgID {
+			continue
+		}
+		if existing.Email == user.Email {
+			return duplicateKey
+		}
+		for _, identity := range user.Identities {
+			if hasIdentity(existing, identity.Key) {
+				return duplicateKey
+			}
+		}
+	}
+
+	user.ID = primitive.NewObjectID()
+	user.OrgID = orgID
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	r.users[user.ID] = copyUser(user)
+	return nil
+}
+
+func (r *UserRepository) FindAll(ctx context.Context) ([]models.User, error) {
+	return r.find(ctx, func(*models.User) bool { return true })
+}
+
+func (r *UserRepository) FindByID(ctx context.Context, id string) (*models.User, error) {
+	var found *models.User
+	err := r.update(ctx, id, func(user *models.User) error {
+		found = copyUser(user)
+		return nil
+	})
+	return found, err
+}
+
+func (r *UserRepository) FindByEmail(ctx context.Context, email string) (*models.User, error) {
+	return r.findOne(ctx, func(user *models.User) bool { return user.Email == email })
+}
+
+func (r *UserRepository) Search(ctx context.Context, filter bson.M, skip, limit int64) ([]models.User, int64, error) {
+	return nil, 0, errors.New("testutil: Search is not supported")
+}
+
+func (r *UserRepository) Update(ctx context.Context, id string, user *models.User) error {
+	return r.update(ctx, id, func(stored *models.User) error {
+		stored.Name = user.Name
+		stored.Email = user.Email
+		stored.EmailVerified = user.EmailVerified
+		return nil
+	})
+}
+
+func (r *UserRepository) ReplaceProfile(ctx context.Context, id string, user *models.User) error {
+	return r.update(ctx, id, func(stored *models.User) error {
+		stored.Name = user.Name
+		stored.GivenName = user.GivenName
+		stored.FamilyName = user.FamilyName
+		stored.Email = user.Email
+		stored.ExternalID = user.ExternalID
+		stored.Disabled = user.Disabled
+		return nil
+	})
+}
+
+func (r *UserRepository) SetEmailVerified(ctx context.Context, id, email string) error {
+	return r.update(ctx, id, func(stored *models.User) error {
+		if stored.Email != email {
+			return mongo.ErrNoDocuments
+		}
+		stored.EmailVerified = true
+		return nil
+	})
+}
+
+func (r *UserRepository) UpdatePassword(ctx context.Context, id string, passwordHash string) error {
+	return r.update(ctx, id, func(stored *models.User) error {
+		stored.PasswordHash = passwordHash
+		return nil
+	})
+}
+
+func (r *UserRepository) SetRoles(ctx context.Context, id string, roles []string) error {
+	return r.update(ctx, id, func(stored *models.User) error {
+		stored.Roles = append([]string(nil), roles...)
+		return nil
+	})
+}
+
+func (r *UserRepository) SetPendingMFASecret(ctx context.Context, id string, secret string) error {
+	return r.update(ctx, id, func(stored *models.User) error {
+		stored.MFAPending = secret
+		return nil
+	})
+}
+
+func (r *UserRepository) EnableMFA(ctx context.Context, id string, secret string, recoveryCodes []string) error {
+	return r.update(ctx, id, func(stored *models.User) error {
+		stored.MFAEnabled = true
+		stored.MFASecret = secret
+		stored.MFAPending = ""
+		stored.RecoveryCodes = append([]string(nil), recoveryCodes...)
+		return nil
+	})
+}
+
+func (r *UserRepository) DisableMFA(ctx context.Context, id string) error {
+	return r.update(ctx, id, func(stored *models.User) error {
+		stored.MFAEnabled = false
+		stored.MFASecret, stored.MFAPending, stored.RecoveryCodes = "", "", nil
+		return nil
+	})
+}
+
+func (r *UserRepository) ConsumeRecoveryCode(ctx context.Context, id string, codeHash string) (bool, error) {
+	consumed := false
+	err := r.update(ctx, id, func(stored *models.User) error {
+		for i, hash := range stored.RecoveryCodes {
+			if hash == codeHash {
+				stored.RecoveryCodes = append(stored.RecoveryCodes[:i:i], stored.RecoveryCodes[i+1:]...)
+				consumed = true
+				return nil
+			}
+		}
+		return nil
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return false, nil
+	}
+	return consumed, err
+}
+
+func (r *UserRepository) FindByLinkedIdentity(ctx context.Context, provider, subject string) (*models.User, error) {
+	key := models.LinkedIdentityKey(provider, subject)
+	return r.findOne(ctx, func(user *models.User) bool { return hasIdentity(user, key) })
+}
+
+func (r *UserRepository) AddLinkedIdentity(ctx context.Context, id string, identity models.LinkedIdentity) error {
+	identity.Key = models.LinkedIdentityKey(identity.Provider, identity.Subject)
+	return r.update(ctx, id, func(stored *models.User) error {
+		for _, other := range r.users {
+			if other.OrgID == stored.OrgID && hasIdentity(other, identity.Key) {
+				return duplicateKey
+			}
+		}
+		for _, existing := range stored.Identities {
+			if existing.Provider == identity.Provider {
+				return mongo.ErrNoDocuments
+			}
+		}
+		stored.Identities = append(stored.Identities, identity)
+		return nil
+	})
+}
+
+func (r *UserRepository) RemoveLinkedIdentity(ctx context.Context, id string, provider string) error {
+	return r.update(ctx, id, func(stored *models.User) error {
+		for i, identity := range stored.Identities {
+			if identity.Provider == provider {
+				stored.Identities = append(stored.Identities[:i:i], stored.Identities[i+1:]...)
+				return nil
+			}
+		}
+		return mongo.ErrNoDocuments
+	})
+}
+
+func (r *UserRepository) SetDirectoryProfile(ctx context.Context, id string, source, name string, roles []string) error {
+	return r.update(ctx, id, func(stored *models.User) error {
+		stored.AuthSource = source
+		stored.Name = name
+		stored.Roles = append([]string(nil), roles...)
+		stored.EmailVerified = true
+		stored.PasswordHash = ""
+		return nil
+	})
+}
+
+func (r *UserRepository) Delete(ctx context.Context, id string) error {
+	err := r.update(ctx, id, func(stored *models.User) error {
+		delete(r.users, stored.ID)
+		return nil
+	})
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil
+	}
+	return err
+}
+
+// FindRoles makes the repository a repository.MembershipRepository. Like the
+// memberships the Mongo repository keeps, each user is a member of their own
+// organization only.
+func (r *UserRepository) FindRoles(ctx context.Context, userID string) ([]string, error) {
+	if _, err := primitive.ObjectIDFromHex(userID); err != nil {
+		return nil, mongo.ErrNoDocuments
+	}
+	var roles []string
+	err := r.update(ctx, userID, func(stored *models.User) error {
+		roles = append([]string(nil), stored.Roles...)
+		return nil
+	})
+	return roles, err
+}
+
+// update runs fn on the stored user with the given ID in the organization in
+// ctx, with the lock held
+func (r *UserRepository) update(ctx context.Context, id string, fn func(*models.User) error) error {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return err
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	user, ok := r.users[objID]
+	if !ok || user.OrgID != orgID {
+		return mongo.ErrNoDocuments
+	}
+	if err := fn(user); err != nil {
+		return err
+	}
+	user.UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *UserRepository) find(ctx context.Context, match func(*models.User) bool) ([]models.User, error) {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	users := []models.User{}
+	for _, user := range r.users {
+		if user.OrgID == orgID && match(user) {
+			users = append(users, *copyUser(user))
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID.Hex() < users[j].ID.Hex() })
+	return users, nil
+}
+
+func (r *UserRepository) findOne(ctx context.Context, match func(*models.User) bool) (*models.User, error) {
+	users, err := r.find(ctx, match)
+	if err != nil {
+		return nil, err
+	}
+	if len(users) == 0 {
+		return nil, mongo.ErrNoDocuments
+	}
+	return &users[0], nil
+}
+
+func hasIdentity(user *models.User, key string) bool {
+	for _, identity := range user.Identities {
+		if identity.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func copyUser(user *models.User) *models.User {
+	out := *user
+	out.Roles = append([]string(nil), user.Roles...)
+	out.RecoveryCodes = append([]string(nil), user.RecoveryCodes...)
+	out.Identities = append([]models.LinkedIdentity(nil), user.Identities...)
+	return &out
+}
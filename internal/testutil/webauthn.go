@@ -0,0 +1,132 @@
+package testutil
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"gin-mongo-aws/internal/models"
+	"gin-mongo-aws/internal/tenant"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// WebAuthnCredentialRepository keeps passkeys in memory, with the unique
+// credential IDs the Mongo repository enforces. Like it, passkeys are kept
+// per organization and only FindByCredentialID looks across organizations.
+type WebAuthnCredentialRepository struct {
+	mu          sync.Mutex
+	credentials map[primitive.ObjectID]*models.WebAuthnCredential
+}
+
+func NewWebAuthnCredentialRepository() *WebAuthnCredentialRepository {
+	return &WebAuthnCredentialRepository{credentials: map[primitive.ObjectID]*models.WebAuthnCredential{}}
+}
+
+func (r *WebAuthnCredentialRepository) Create(ctx context.Context, credential *models.WebAuthnCredential) error {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, existing := range r.credentials {
+		if bytes.Equal(existing.CredentialID, credential.CredentialID) {
+			return duplicateKey
+		}
+	}
+
+	credential.ID = primitive.NewObjectID()
+	credential.OrgID = orgID
+	credential.CreatedAt = time.Now()
+	stored := *credential
+	r.credentials[stored.ID] = &stored
+	return nil
+}
+
+func (r *WebAuthnCredentialRepository) FindByCredentialID(ctx context.Context, credentialID []byte) (*models.WebAuthnCredential, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, credential := range r.credentials {
+		if bytes.Equal(credential.CredentialID, credentialID) {
+			found := *credential
+			return &found, nil
+		}
+	}
+	return nil, mongo.ErrNoDocuments
+}
+
+func (r *WebAuthnCredentialRepository) FindByUser(ctx context.Context, userID string) ([]models.WebAuthnCredential, error) {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	objID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	credentials := []models.WebAuthnCredential{}
+	for _, credential := range r.credentials {
+		if credential.OrgID == orgID && credential.UserID == objID {
+			credentials = append(credentials, *credential)
+		}
+	}
+	sort.Slice(credentials, func(i, j int) bool { return credentials[i].CreatedAt.Before(credentials[j].CreatedAt) })
+	return credentials, nil
+}
+
+func (r *WebAuthnCredentialRepository) RecordUse(ctx context.Context, id primitive.ObjectID, signCount uint32, backupState bool, at time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if credential, ok := r.credentials[id]; ok {
+		credential.SignCount = signCount
+		credential.BackupState = backupState
+		credential.LastUsedAt = &at
+	}
+	return nil
+}
+
+func (r *WebAuthnCredentialRepository) Rename(ctx context.Context, userID, id, name string) error {
+	return r.owned(ctx, userID, id, func(credential *models.WebAuthnCredential) {
+		credential.Name = name
+	})
+}
+
+func (r *WebAuthnCredentialRepository) Delete(ctx context.Context, userID, id string) error {
+	return r.owned(ctx, userID, id, func(credential *models.WebAuthnCredential) {
+		delete(r.credentials, credential.ID)
+	})
+}
+
+// owned runs fn on the passkey with the given ID if userID owns it in the
+// organization in ctx, with the lock held
+func (r *WebAuthnCredentialRepository) owned(ctx context.Context, userID, id string, fn func(*models.WebAuthnCredential)) error {
+	orgID, err := tenant.OrgID(ctx)
+	if err != nil {
+		return err
+	}
+	userObjID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+	objID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return mongo.ErrNoDocuments
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	credential, ok := r.credentials[objID]
+	if !ok || credential.OrgID != orgID || credential.UserID != userObjID {
+		return mongo.ErrNoDocuments
+	}
+	fn(credential)
+	return nil
+}
@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// M003_AddEmailVerified marks existing users as unverified
+type M003_AddEmailVerified struct{}
+
+func (m *M003_AddEmailVerified) Name() string {
+	return "003_add_email_verified"
+}
+
+func (m *M003_AddEmailVerified) Up(ctx context.Context, db *mongo.Database) error {
+	collection := db.Collection("users")
+
+	_, err := collection.UpdateMany(ctx,
+		bson.M{"email_verified": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"email_verified": false}},
+	)
+
+	return err
+}
+
+func (m *M003_AddEmailVerified) Down(ctx context.Context, db *mongo.Database) error {
+	collection := db.Collection("users")
+
+	_, err := collection.UpdateMany(ctx, bson.M{}, bson.M{
+		"$unset": bson.M{"email_verified": ""},
+	})
+
+	return err
+}
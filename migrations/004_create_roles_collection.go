@@ -0,0 +1,45 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// M004_CreateRolesCollection creates the roles collection and gives existing
+// users the default role
+type M004_CreateRolesCollection struct{}
+
+func (m *M004_CreateRolesCollection) Name() string {
+	return "004_create_roles_collection"
+}
+
+func (m *M004_CreateRolesCollection) Up(ctx context.Context, db *mongo.Database) error {
+	// Create unique index on name
+	nameIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "name", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+
+	if _, err := db.Collection("roles").Indexes().CreateOne(ctx, nameIndex); err != nil {
+		return err
+	}
+
+	_, err := db.Collection("users").UpdateMany(ctx,
+		bson.M{"roles": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"roles": bson.A{"user"}}},
+	)
+	return err
+}
+
+func (m *M004_CreateRolesCollection) Down(ctx context.Context, db *mongo.Database) error {
+	if _, err := db.Collection("users").UpdateMany(ctx, bson.M{}, bson.M{
+		"$unset": bson.M{"roles": ""},
+	}); err != nil {
+		return err
+	}
+
+	return db.Collection("roles").Drop(ctx)
+}
@@ -0,0 +1,31 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// M005_CreateOAuthClientsCollection creates the registry of OAuth clients
+type M005_CreateOAuthClientsCollection struct{}
+
+func (m *M005_CreateOAuthClientsCollection) Name() string {
+	return "005_create_oauth_clients_collection"
+}
+
+func (m *M005_CreateOAuthClientsCollection) Up(ctx context.Context, db *mongo.Database) error {
+	// Create unique index on client_id
+	clientIDIndex := mongo.IndexModel{
+		Keys:    bson.D{{Key: "client_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}
+
+	_, err := db.Collection("oauth_clients").Indexes().CreateOne(ctx, clientIDIndex)
+	return err
+}
+
+func (m *M005_CreateOAuthClientsCollection) Down(ctx context.Context, db *mongo.Database) error {
+	return db.Collection("oauth_clients").Drop(ctx)
+}
@@ -0,0 +1,35 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// M006_CreateSigningKeysCollection creates the store of managed token signing keys
+type M006_CreateSigningKeysCollection struct{}
+
+func (m *M006_CreateSigningKeysCollection) Name() string {
+	return "006_create_signing_keys_collection"
+}
+
+func (m *M006_CreateSigningKeysCollection) Up(ctx context.Context, db *mongo.Database) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "kid", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "retires_at", Value: 1}, {Key: "activates_at", Value: 1}},
+		},
+	}
+
+	_, err := db.Collection("signing_keys").Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+func (m *M006_CreateSigningKeysCollection) Down(ctx context.Context, db *mongo.Database) error {
+	return db.Collection("signing_keys").Drop(ctx)
+}
@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// M007_CreateAPIKeysCollection creates the api_keys collection. Expired keys
+// are removed by a TTL index.
+type M007_CreateAPIKeysCollection struct{}
+
+func (m *M007_CreateAPIKeysCollection) Name() string {
+	return "007_create_api_keys_collection"
+}
+
+func (m *M007_CreateAPIKeysCollection) Up(ctx context.Context, db *mongo.Database) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "prefix", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		},
+		{
+			Keys:    bson.D{{Key: "expires_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	}
+
+	_, err := db.Collection("api_keys").Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+func (m *M007_CreateAPIKeysCollection) Down(ctx context.Context, db *mongo.Database) error {
+	return db.Collection("api_keys").Drop(ctx)
+}
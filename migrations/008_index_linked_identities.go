@@ -0,0 +1,40 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// M008_IndexLinkedIdentities makes each external account linkable to a single
+// user. The index is on the combined provider and subject key because a
+// compound index over two fields of the same array would also pair values
+// from different elements. The partial filter leaves users without linked
+// identities out of the unique index.
+type M008_IndexLinkedIdentities struct{}
+
+const linkedIdentityIndex = "linked_identities_key"
+
+func (m *M008_IndexLinkedIdentities) Name() string {
+	return "008_index_linked_identities"
+}
+
+func (m *M008_IndexLinkedIdentities) Up(ctx context.Context, db *mongo.Database) error {
+	index := mongo.IndexModel{
+		Keys: bson.D{{Key: "linked_identities.key", Value: 1}},
+		Options: options.Index().
+			SetName(linkedIdentityIndex).
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"linked_identities.key": bson.M{"$exists": true}}),
+	}
+
+	_, err := db.Collection("users").Indexes().CreateOne(ctx, index)
+	return err
+}
+
+func (m *M008_IndexLinkedIdentities) Down(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("users").Indexes().DropOne(ctx, linkedIdentityIndex)
+	return err
+}
@@ -0,0 +1,50 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// M009_CreateGroupsCollection creates the groups collection used by SCIM and
+// indexes the external IDs that provisioning clients look users and groups
+// up by
+type M009_CreateGroupsCollection struct{}
+
+const userExternalIDIndex = "external_id"
+
+func (m *M009_CreateGroupsCollection) Name() string {
+	return "009_create_groups_collection"
+}
+
+func (m *M009_CreateGroupsCollection) Up(ctx context.Context, db *mongo.Database) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "members", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "external_id", Value: 1}},
+		},
+		{
+			Keys: bson.D{{Key: "display_name", Value: 1}},
+		},
+	}
+	if _, err := db.Collection("groups").Indexes().CreateMany(ctx, indexes); err != nil {
+		return err
+	}
+
+	_, err := db.Collection("users").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "external_id", Value: 1}},
+		Options: options.Index().SetName(userExternalIDIndex),
+	})
+	return err
+}
+
+func (m *M009_CreateGroupsCollection) Down(ctx context.Context, db *mongo.Database) error {
+	if _, err := db.Collection("users").Indexes().DropOne(ctx, userExternalIDIndex); err != nil {
+		return err
+	}
+	return db.Collection("groups").Drop(ctx)
+}
@@ -0,0 +1,37 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// M010_CreateWebAuthnCredentialsCollection creates the webauthn_credentials
+// collection. Credential IDs are unique so a passkey can only belong to one
+// user.
+type M010_CreateWebAuthnCredentialsCollection struct{}
+
+func (m *M010_CreateWebAuthnCredentialsCollection) Name() string {
+	return "010_create_webauthn_credentials_collection"
+}
+
+func (m *M010_CreateWebAuthnCredentialsCollection) Up(ctx context.Context, db *mongo.Database) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "credential_id", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "user_id", Value: 1}},
+		},
+	}
+
+	_, err := db.Collection("webauthn_credentials").Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+func (m *M010_CreateWebAuthnCredentialsCollection) Down(ctx context.Context, db *mongo.Database) error {
+	return db.Collection("webauthn_credentials").Drop(ctx)
+}
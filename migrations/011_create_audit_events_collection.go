@@ -0,0 +1,43 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// M011_CreateAuditEventsCollection creates the audit_events collection with
+// indexes for the filters of the audit log endpoint
+type M011_CreateAuditEventsCollection struct{}
+
+func (m *M011_CreateAuditEventsCollection) Name() string {
+	return "011_create_audit_events_collection"
+}
+
+func (m *M011_CreateAuditEventsCollection) Up(ctx context.Context, db *mongo.Database) error {
+	indexes := []mongo.IndexModel{
+		{
+			Keys: bson.D{{Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "target_type", Value: 1}, {Key: "target_id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "actor.id", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "action", Value: 1}, {Key: "created_at", Value: -1}},
+		},
+		{
+			Keys: bson.D{{Key: "request_id", Value: 1}},
+		},
+	}
+
+	_, err := db.Collection("audit_events").Indexes().CreateMany(ctx, indexes)
+	return err
+}
+
+func (m *M011_CreateAuditEventsCollection) Down(ctx context.Context, db *mongo.Database) error {
+	return db.Collection("audit_events").Drop(ctx)
+}
@@ -0,0 +1,36 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// M012_AddAuditEventSequenceIndex makes audit event sequence numbers unique
+// so concurrent writers cannot fork the hash chain. Events recorded before
+// chaining have no sequence and are left out of the index.
+type M012_AddAuditEventSequenceIndex struct{}
+
+func (m *M012_AddAuditEventSequenceIndex) Name() string {
+	return "012_add_audit_event_sequence_index"
+}
+
+func (m *M012_AddAuditEventSequenceIndex) Up(ctx context.Context, db *mongo.Database) error {
+	index := mongo.IndexModel{
+		Keys: bson.D{{Key: "sequence", Value: 1}},
+		Options: options.Index().
+			SetName("sequence_unique").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.M{"sequence": bson.M{"$exists": true}}),
+	}
+
+	_, err := db.Collection("audit_events").Indexes().CreateOne(ctx, index)
+	return err
+}
+
+func (m *M012_AddAuditEventSequenceIndex) Down(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("audit_events").Indexes().DropOne(ctx, "sequence_unique")
+	return err
+}
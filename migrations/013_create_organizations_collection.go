@@ -0,0 +1,195 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// M013_CreateOrganizationsCollection creates the organizations collection
+// and moves existing users, groups, API keys, passkeys, OAuth clients and
+// audit events into a default organization. Indexes that made emails, linked
+// identities and external IDs unique across the deployment become unique per
+// organization. Audit events are marked as backfilled, so their hashes are
+// checked without the organization they were given, and the highest sequence
+// backfilled is recorded so that no later event can claim the exemption.
+type M013_CreateOrganizationsCollection struct{}
+
+// defaultOrganizationSlug must match tenancy.default_organization
+const defaultOrganizationSlug = "default"
+
+const (
+	orgEmailIndex            = "org_email"
+	orgLinkedIdentityIndex   = "org_linked_identities_key"
+	orgUserExternalIDIndex   = "org_external_id"
+	orgGroupDisplayNameIndex = "org_display_name"
+	orgAuditEventIndex       = "org_created_at"
+)
+
+// auditBackfillsCollection records the highest audit event sequence
+// backfilled with an organization
+const auditBackfillsCollection = "audit_backfills"
+
+// organizationCollections hold documents that belong to one organization
+var organizationCollections = []string{"users", "groups", "api_keys", "webauthn_credentials", "oauth_clients"}
+
+func (m *M013_CreateOrganizationsCollection) Name() string {
+	return "013_create_organizations_collection"
+}
+
+func (m *M013_CreateOrganizationsCollection) Up(ctx context.Context, db *mongo.Database) error {
+	orgs := db.Collection("organizations")
+	if _, err := orgs.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "slug", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if _, err := orgs.UpdateOne(ctx,
+		bson.M{"slug": defaultOrganizationSlug},
+		bson.M{"$setOnInsert": bson.M{"slug": defaultOrganizationSlug, "name": "Default", "created_at": now, "updated_at": now}},
+		options.Update().SetUpsert(true),
+	); err != nil {
+		return err
+	}
+	var org struct {
+		ID primitive.ObjectID `bson:"_id"`
+	}
+	if err := orgs.FindOne(ctx, bson.M{"slug": defaultOrganizationSlug}).Decode(&org); err != nil {
+		return err
+	}
+
+	for _, name := range organizationCollections {
+		if _, err := db.Collection(name).UpdateMany(ctx,
+			bson.M{"org_id": bson.M{"$exists": false}},
+			bson.M{"$set": bson.M{"org_id": org.ID}},
+		); err != nil {
+			return err
+		}
+	}
+	if _, err := db.Collection("audit_events").UpdateMany(ctx,
+		bson.M{"org_id": bson.M{"$exists": false}},
+		bson.M{"$set": bson.M{"org_id": org.ID.Hex(), "backfilled": true}},
+	); err != nil {
+		return err
+	}
+	var lastBackfilled struct {
+		Sequence int64 `bson:"sequence"`
+	}
+	err := db.Collection("audit_events").FindOne(ctx,
+		bson.M{"backfilled": true, "sequence": bson.M{"$exists": true}},
+		options.FindOne().SetSort(bson.D{{Key: "sequence", Value: -1}}),
+	).Decode(&lastBackfilled)
+	if err != nil && !errors.Is(err, mongo.ErrNoDocuments) {
+		return err
+	}
+	if _, err := db.Collection(auditBackfillsCollection).UpdateOne(ctx,
+		bson.M{"_id": m.Name()},
+		bson.M{"$set": bson.M{"max_sequence": lastBackfilled.Sequence}},
+		options.Update().SetUpsert(true),
+	); err != nil {
+		return err
+	}
+
+	users := db.Collection("users").Indexes()
+	for _, index := range []string{"email_1", linkedIdentityIndex, userExternalIDIndex} {
+		if _, err := users.DropOne(ctx, index); err != nil {
+			return err
+		}
+	}
+	if _, err := users.CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "org_id", Value: 1}, {Key: "email", Value: 1}},
+			Options: options.Index().SetName(orgEmailIndex).SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "org_id", Value: 1}, {Key: "linked_identities.key", Value: 1}},
+			Options: options.Index().
+				SetName(orgLinkedIdentityIndex).
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"linked_identities.key": bson.M{"$exists": true}}),
+		},
+		{
+			Keys:    bson.D{{Key: "org_id", Value: 1}, {Key: "external_id", Value: 1}},
+			Options: options.Index().SetName(orgUserExternalIDIndex),
+		},
+	}); err != nil {
+		return err
+	}
+
+	if _, err := db.Collection("groups").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "org_id", Value: 1}, {Key: "display_name", Value: 1}},
+		Options: options.Index().SetName(orgGroupDisplayNameIndex),
+	}); err != nil {
+		return err
+	}
+
+	_, err = db.Collection("audit_events").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "org_id", Value: 1}, {Key: "created_at", Value: -1}, {Key: "_id", Value: -1}},
+		Options: options.Index().SetName(orgAuditEventIndex),
+	})
+	return err
+}
+
+func (m *M013_CreateOrganizationsCollection) Down(ctx context.Context, db *mongo.Database) error {
+	if _, err := db.Collection("audit_events").Indexes().DropOne(ctx, orgAuditEventIndex); err != nil {
+		return err
+	}
+	if _, err := db.Collection("groups").Indexes().DropOne(ctx, orgGroupDisplayNameIndex); err != nil {
+		return err
+	}
+
+	// Recreating the deployment-wide unique indexes fails if two
+	// organizations have since added accounts with the same email
+	users := db.Collection("users").Indexes()
+	for _, index := range []string{orgEmailIndex, orgLinkedIdentityIndex, orgUserExternalIDIndex} {
+		if _, err := users.DropOne(ctx, index); err != nil {
+			return err
+		}
+	}
+	if _, err := users.CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "linked_identities.key", Value: 1}},
+			Options: options.Index().
+				SetName(linkedIdentityIndex).
+				SetUnique(true).
+				SetPartialFilterExpression(bson.M{"linked_identities.key": bson.M{"$exists": true}}),
+		},
+		{
+			Keys:    bson.D{{Key: "external_id", Value: 1}},
+			Options: options.Index().SetName(userExternalIDIndex),
+		},
+	}); err != nil {
+		return err
+	}
+
+	for _, name := range organizationCollections {
+		if _, err := db.Collection(name).UpdateMany(ctx, bson.M{}, bson.M{
+			"$unset": bson.M{"org_id": ""},
+		}); err != nil {
+			return err
+		}
+	}
+	// Events recorded since keep their organization, which their hash covers
+	if _, err := db.Collection("audit_events").UpdateMany(ctx, bson.M{"backfilled": true}, bson.M{
+		"$unset": bson.M{"org_id": "", "backfilled": ""},
+	}); err != nil {
+		return err
+	}
+	if err := db.Collection(auditBackfillsCollection).Drop(ctx); err != nil {
+		return err
+	}
+
+	return db.Collection("organizations").Drop(ctx)
+}
@@ -0,0 +1,53 @@
+package migrations
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// M014_CreateMembershipsCollection creates the memberships collection, which
+// holds the roles each user has in their organization, and fills it from the
+// roles stored on existing users. A user has at most one membership per
+// organization.
+type M014_CreateMembershipsCollection struct{}
+
+func (m *M014_CreateMembershipsCollection) Name() string {
+	return "014_create_memberships_collection"
+}
+
+func (m *M014_CreateMembershipsCollection) Up(ctx context.Context, db *mongo.Database) error {
+	if _, err := db.Collection("memberships").Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "org_id", Value: 1}, {Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	}); err != nil {
+		return err
+	}
+
+	cursor, err := db.Collection("users").Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$project", Value: bson.M{
+			"_id":        0,
+			"user_id":    "$_id",
+			"org_id":     1,
+			"roles":      bson.M{"$ifNull": bson.A{"$roles", bson.A{}}},
+			"created_at": "$$NOW",
+			"updated_at": "$$NOW",
+		}}},
+		{{Key: "$merge", Value: bson.M{
+			"into":           "memberships",
+			"on":             bson.A{"org_id", "user_id"},
+			"whenMatched":    "keepExisting",
+			"whenNotMatched": "insert",
+		}}},
+	})
+	if err != nil {
+		return err
+	}
+	return cursor.Close(ctx)
+}
+
+func (m *M014_CreateMembershipsCollection) Down(ctx context.Context, db *mongo.Database) error {
+	return db.Collection("memberships").Drop(ctx)
+}
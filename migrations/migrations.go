@@ -9,5 +9,17 @@ func GetAll() []migration.Migration {
 	return []migration.Migration{
 		&M001_CreateUsersCollection{},
 		&M002_AddUserFields{},
+		&M003_AddEmailVerified{},
+		&M004_CreateRolesCollection{},
+		&M005_CreateOAuthClientsCollection{},
+		&M006_CreateSigningKeysCollection{},
+		&M007_CreateAPIKeysCollection{},
+		&M008_IndexLinkedIdentities{},
+		&M009_CreateGroupsCollection{},
+		&M010_CreateWebAuthnCredentialsCollection{},
+		&M011_CreateAuditEventsCollection{},
+		&M012_AddAuditEventSequenceIndex{},
+		&M013_CreateOrganizationsCollection{},
+		&M014_CreateMembershipsCollection{},
 	}
 }